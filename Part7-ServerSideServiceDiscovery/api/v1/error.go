@@ -2,41 +2,185 @@ package api_v1
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
+// ErrorDomain은 이 API의 에러에 붙는 ErrorInfo의 Domain이다.
+const ErrorDomain = "log.v1"
+
+// ErrorInfo.Reason 값들. 클라이언트는 메시지 대신 이 값으로 에러를 구분한다.
+// 한 번 내보낸 값은 바꾸지 않는다.
+const (
+	ReasonOffsetOutOfRange = "OFFSET_OUT_OF_RANGE"
+	ReasonRecordExpired    = "RECORD_EXPIRED"
+	ReasonSegmentRolling   = "SEGMENT_ROLLING"
+	ReasonStandby          = "STANDBY"
+	ReasonBatchFailed      = "BATCH_FAILED"
+	ReasonRestoreConflict  = "RESTORE_CONFLICT"
+	ReasonKeyNotFound      = "KEY_NOT_FOUND"
+	ReasonPermissionDenied = "PERMISSION_DENIED"
+	ReasonRecordTooLarge   = "RECORD_TOO_LARGE"
+	ReasonRecordSize       = "RECORD_SIZE_MISMATCH"
+	ReasonAppendFailed     = "APPEND_FAILED"
+	ReasonAppendTimeout    = "APPEND_TIMEOUT"
+	ReasonLogDegraded      = "LOG_DEGRADED"
+	ReasonAppendPending    = "APPEND_PENDING"
+)
+
+// NewStatus는 API 에러의 상태를 만든다. reason과 metadata를 담은 ErrorInfo를
+// 먼저 붙이고 details를 그 뒤에 붙인다. 붙이지 못하면 세부 정보 없는 상태를 리턴한다.
+func NewStatus(
+	c codes.Code,
+	msg, reason string,
+	metadata map[string]string,
+	details ...protoadapt.MessageV1,
+) *status.Status {
+	st := status.New(c, msg)
+	info := &errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	}
+	std, err := st.WithDetails(append([]protoadapt.MessageV1{info}, details...)...)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+// WrapError는 err를 ErrorInfo가 붙은 상태로 바꾼다. err에 이미 이 API의
+// ErrorInfo가 있으면 그대로 리턴한다. err가 상태 에러면 그 코드와 메시지를
+// 쓰고, 아니면 코드 c와 err의 메시지를 쓴다.
+func WrapError(c codes.Code, reason string, err error, metadata map[string]string) *status.Status {
+	st, ok := status.FromError(err)
+	if !ok {
+		return NewStatus(c, err.Error(), reason, metadata)
+	}
+	if ErrorReason(err) != "" {
+		return st
+	}
+	var details []protoadapt.MessageV1
+	for _, d := range st.Details() {
+		if d, ok := d.(protoadapt.MessageV1); ok {
+			details = append(details, d)
+		}
+	}
+	return NewStatus(st.Code(), st.Message(), reason, metadata, details...)
+}
+
+// ErrorReason은 err에 붙은 이 API의 ErrorInfo.Reason을 리턴한다. 없으면 빈 문자열이다.
+func ErrorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == ErrorDomain {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+// ErrOffsetOutOfRange는 로그에 없는 오프셋을 읽을 때 리턴하고 코드는 NotFound다.
+// gRPC는 GRPCStatus 메서드로 코드를 찾으므로 이름이 조금이라도 다르면 클라이언트는
+// Unknown을 받는다.
 type ErrOffsetOutOfRange struct {
 	Offset uint64
 }
 
-func (e ErrOffsetOutOfRange) GRPStatus() *status.Status {
-	st := status.New(
-		codes.Unknown, // 이상하게도 여기서 404나 codes.NotFound를 사용하면 문제가 생긴다. 그러므로 일단 Unknown으로 수정해 놓겠다.
+func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
+	msg := fmt.Sprintf(
+		"The requested offset is outside the log's range: %d",
+		e.Offset,
+	)
+	return NewStatus(
+		codes.NotFound,
 		fmt.Sprintf("offset out of range: %d", e.Offset),
+		ReasonOffsetOutOfRange,
+		map[string]string{"offset": strconv.FormatUint(e.Offset, 10)},
+		&errdetails.LocalizedMessage{
+			Locale:  "en-US",
+			Message: msg,
+		},
 	)
+}
 
+func (e ErrOffsetOutOfRange) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrRecordExpired struct {
+	Offset   uint64
+	ExpireAt int64
+}
+
+func (e ErrRecordExpired) GRPCStatus() *status.Status {
+	expireAt := time.Unix(0, e.ExpireAt).UTC().Format(time.RFC3339Nano)
 	msg := fmt.Sprintf(
-		"The requested offset is outside the log's range: %d",
+		"The requested record expired at %s: %d",
+		expireAt,
 		e.Offset,
 	)
+	return NewStatus(
+		codes.NotFound,
+		fmt.Sprintf("record expired: %d", e.Offset),
+		ReasonRecordExpired,
+		map[string]string{
+			"offset":    strconv.FormatUint(e.Offset, 10),
+			"expire_at": expireAt,
+		},
+		&errdetails.LocalizedMessage{
+			Locale:  "en-US",
+			Message: msg,
+		},
+	)
+}
 
-	d := &errdetails.LocalizedMessage{
-		Locale:  "en-US",
-		Message: msg,
-	}
+func (e ErrRecordExpired) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
 
-	std, err := st.WithDetails(d)
-	if err != nil {
-		return st
-	}
+// ErrKeyNotFound는 키가 Key인 레코드가 로그에 없다는 뜻이다.
+type ErrKeyNotFound struct {
+	Key []byte
+}
 
-	return std
+func (e ErrKeyNotFound) GRPCStatus() *status.Status {
+	return NewStatus(
+		codes.NotFound,
+		fmt.Sprintf("key not found: %q", e.Key),
+		ReasonKeyNotFound,
+		// 키는 UTF-8이 아닐 수 있어 메타데이터에 넣지 않는다.
+		nil,
+	)
+}
 
+func (e ErrKeyNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
 }
 
-func (e ErrOffsetOutOfRange) Error() string {
-	return e.GRPStatus().Err().Error()
+// ErrSegmentRolling은 활성 세그먼트를 새 세그먼트로 바꾸지 못해 지금은
+// 레코드를 추가할 수 없다는 뜻이다. 클라이언트는 RetryAfter 뒤에 다시 시도하면 된다.
+type ErrSegmentRolling struct {
+	RetryAfter time.Duration
+}
+
+func (e ErrSegmentRolling) GRPCStatus() *status.Status {
+	return NewStatus(
+		codes.Unavailable,
+		"segment is rolling",
+		ReasonSegmentRolling,
+		nil,
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(e.RetryAfter),
+		},
+	)
+}
+
+func (e ErrSegmentRolling) Error() string {
+	return e.GRPCStatus().Err().Error()
 }
@@ -0,0 +1,9 @@
+package api_v1
+
+const (
+	// APIVersionHeader는 클라이언트가 따르는 API 버전을 서버에 알리는 메타데이터 키다.
+	APIVersionHeader = "x-api-version"
+	// APIVersion은 이 패키지의 메시지가 따르는 API 버전이다. 헤더가 없는
+	// 요청은 이 버전으로 본다.
+	APIVersion = "1"
+)
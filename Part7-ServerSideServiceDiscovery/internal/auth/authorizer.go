@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/casbin/casbin"
 	"google.golang.org/grpc/codes"
@@ -9,18 +10,38 @@ import (
 )
 
 func New(model, policy string) *Authorizer {
-	enforcer := casbin.NewEnforcer(model, policy)
-	return &Authorizer{
-		enforcer: enforcer,
+	a := &Authorizer{
+		files: []string{policy},
+		load: func() (*casbin.Enforcer, error) {
+			return casbin.NewEnforcerSafe(model, policy)
+		},
 	}
+	a.enforcer.Store(casbin.NewEnforcer(model, policy))
+	return a
 }
 
+// Authorizer는 casbin 정책으로 권한을 확인한다. Reload는 정책을 새 enforcer에
+// 다 읽은 다음 통째로 바꿔 끼우므로 Authorize는 이전 정책이나 새 정책 중
+// 하나만 본다.
 type Authorizer struct {
-	enforcer *casbin.Enforcer
+	enforcer atomic.Pointer[casbin.Enforcer]
+	// load는 정책 파일을 새로 읽어 enforcer를 만들고 files는 그 파일들이다.
+	load  func() (*casbin.Enforcer, error)
+	files []string
+}
+
+// Reload는 정책 파일을 다시 읽는다. 읽지 못하면 이전 정책을 그대로 쓴다.
+func (a *Authorizer) Reload() error {
+	enforcer, err := a.load()
+	if err != nil {
+		return err
+	}
+	a.enforcer.Store(enforcer)
+	return nil
 }
 
 func (a *Authorizer) Authorize(subject, object, action string) error {
-	if !a.enforcer.Enforce(subject, object, action) {
+	if !a.enforcer.Load().Enforce(subject, object, action) {
 		msg := fmt.Sprintf("%s not permitted to %s to %s", subject, action, object)
 
 		st := status.New(codes.PermissionDenied, msg)
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/casbin/casbin"
+)
+
+// NewWithRoles는 New와 같지만 roles 파일에 적힌 "subject, role" 매핑을
+// casbin의 g 정책으로 추가해서 정책을 역할 단위로 쓸 수 있게 한다.
+// "role, role" 줄로 역할끼리도 묶을 수 있고 Authorize는 이를 따라가며 확인한다.
+// model에는 role_definition(g)이 있어야 한다.
+// Reload와 Watch는 roles 파일도 다시 읽는다.
+func NewWithRoles(model, policy, roles string) (*Authorizer, error) {
+	a := &Authorizer{
+		files: []string{policy, roles},
+		load: func() (*casbin.Enforcer, error) {
+			return loadWithRoles(model, policy, roles)
+		},
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func loadWithRoles(model, policy, roles string) (*casbin.Enforcer, error) {
+	enforcer, err := casbin.NewEnforcerSafe(model, policy)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := loadRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mapping {
+		if _, err := enforcer.AddGroupingPolicySafe(m[0], m[1]); err != nil {
+			return nil, err
+		}
+	}
+	return enforcer, nil
+}
+
+func loadRoles(name string) ([][2]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var mapping [][2]string
+	for {
+		line, err := r.Read()
+		if err == io.EOF {
+			return mapping, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) != 2 {
+			return nil, fmt.Errorf("invalid role mapping in %s: %q", name, strings.Join(line, ","))
+		}
+		mapping = append(mapping, [2]string{line[0], line[1]})
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRoles(t *testing.T) {
+	a, err := NewWithRoles(
+		"../../test/rbac_model.conf",
+		"../../test/rbac_policy.csv",
+		"../../test/roles.csv",
+	)
+	require.NoError(t, err)
+
+	// root는 admin이고 admin은 reader이기도 하다.
+	require.NoError(t, a.Authorize("root", "*", "produce"))
+	require.NoError(t, a.Authorize("root", "*", "consume"))
+
+	require.NoError(t, a.Authorize("nobody", "*", "consume"))
+	err = a.Authorize("nobody", "*", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	err = a.Authorize("stranger", "*", "consume")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 정책의 * 객체는 모든 토픽에 맞는다.
+	require.NoError(t, a.Authorize("root", "orders", "produce"))
+	require.NoError(t, a.Authorize("nobody", "orders", "consume"))
+}
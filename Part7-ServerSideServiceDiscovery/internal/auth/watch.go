@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// reloadDelay는 정책 파일이 바뀐 뒤 다시 읽기 전에 기다리는 시간이다.
+// 파일을 여러 번에 나눠 쓰는 동안 반쯤 쓴 정책을 읽지 않도록 마지막 변경
+// 뒤로 이만큼 조용할 때 읽는다.
+const reloadDelay = 100 * time.Millisecond
+
+// Watcher는 정책 파일이 바뀌면 Authorizer를 다시 읽는다. Close로 멈춘다.
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Watch는 정책 파일(NewWithRoles면 roles 파일도)을 지켜보다가 바뀌면 Reload를
+// 부른다. 편집기는 파일을 새로 만들어 이름을 바꾸기도 하므로 파일이 아니라
+// 파일이 있는 디렉터리를 지켜본다. 다시 읽다가 실패하면 로그를 남기고 이전
+// 정책을 그대로 쓴다.
+func (a *Authorizer) Watch() (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	var names, dirs []string
+	for _, f := range a.files {
+		name := filepath.Clean(f)
+		names = append(names, name)
+		if dir := filepath.Dir(name); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		if err := fw.Add(dir); err != nil {
+			fw.Close()
+			return nil, err
+		}
+	}
+
+	w := &Watcher{watcher: fw, done: make(chan struct{})}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run(a, names)
+	}()
+	return w, nil
+}
+
+func (w *Watcher) run(a *Authorizer, names []string) {
+	logger := zap.L().Named("auth")
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if slices.Contains(names, filepath.Clean(event.Name)) &&
+				event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Error("watching policy files failed", zap.Error(err))
+		case <-timer.C:
+			if err := a.Reload(); err != nil {
+				logger.Error("reloading policy failed", zap.Strings("files", names), zap.Error(err))
+				continue
+			}
+			logger.Info("reloaded policy", zap.Strings("files", names))
+		}
+	}
+}
+
+// Close는 지켜보기를 멈추고 고루틴이 끝날 때까지 기다린다.
+func (w *Watcher) Close() error {
+	close(w.done)
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
+}
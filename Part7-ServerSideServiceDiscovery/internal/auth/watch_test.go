@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	model, err := os.ReadFile("../../test/model.conf")
+	require.NoError(t, err)
+	modelFile := filepath.Join(dir, "model.conf")
+	require.NoError(t, os.WriteFile(modelFile, model, 0644))
+	policyFile := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policyFile, []byte("p, root, *, produce\n"), 0644))
+
+	a := New(modelFile, policyFile)
+	w, err := a.Watch()
+	require.NoError(t, err)
+	err = a.Authorize("nobody", "orders", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 정책 파일을 새로 쓰면 다시 시작하지 않아도 새 정책을 따른다.
+	require.NoError(t, os.WriteFile(policyFile, []byte("p, root, *, produce\np, nobody, orders, produce\n"), 0644))
+	require.Eventually(t, func() bool {
+		return a.Authorize("nobody", "orders", "produce") == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, a.Authorize("root", "orders", "produce"))
+
+	// 멈춘 뒤에는 파일이 바뀌어도 그대로다.
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(policyFile, []byte("p, root, *, produce\n"), 0644))
+	time.Sleep(3 * reloadDelay)
+	require.NoError(t, a.Authorize("nobody", "orders", "produce"))
+
+	// 직접 다시 읽을 수도 있다.
+	require.NoError(t, a.Reload())
+	err = a.Authorize("nobody", "orders", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// 다시 읽지 못하면 이전 정책을 그대로 쓴다.
+	require.NoError(t, os.Remove(modelFile))
+	require.Error(t, a.Reload())
+	require.NoError(t, a.Authorize("root", "orders", "produce"))
+}
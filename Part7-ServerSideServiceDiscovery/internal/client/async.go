@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+var ErrProducerClosed = errors.New("async producer is closed")
+
+// AsyncProducerConfig는 AsyncProducer가 레코드를 모았다가 보내는 기준이다.
+// 모인 레코드 값이 MaxBytes 이상이거나 레코드가 MaxRecords개가 되면 바로 보내고,
+// 그보다 적으면 첫 레코드가 들어온 뒤 Linger가 지나면 보낸다.
+type AsyncProducerConfig struct {
+	MaxBytes   int
+	MaxRecords int
+	Linger     time.Duration
+}
+
+// AsyncProducer는 작은 레코드를 모아서 묶음마다 ProduceStream 하나로 보낸다.
+// 레코드마다 왕복을 기다리지 않으므로 작은 레코드를 많이 쓸 때 처리량이 늘어난다.
+// 묶음은 하나씩 차례로 보내므로 레코드는 Produce를 부른 순서대로 로그에 쓰인다.
+type AsyncProducer struct {
+	client api_v1.LogClient
+	config AsyncProducerConfig
+
+	mu      sync.Mutex
+	pending []*pendingRecord
+	bytes   int
+	timer   *time.Timer
+	closed  bool
+
+	batches chan []*pendingRecord
+	done    chan struct{}
+}
+
+type pendingRecord struct {
+	req    *api_v1.ProduceRequest
+	future *Future
+}
+
+// Future는 AsyncProducer로 보낸 레코드 하나의 결과다.
+type Future struct {
+	done   chan struct{}
+	offset uint64
+	err    error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(offset uint64, err error) {
+	f.offset, f.err = offset, err
+	close(f.done)
+}
+
+// Done은 결과가 나오면 닫히는 채널을 리턴한다.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Offset은 결과가 나올 때까지 기다렸다가 레코드가 쓰인 오프셋을 리턴한다.
+// 레코드를 쓰지 못했으면 그 이유를 리턴한다.
+func (f *Future) Offset() (uint64, error) {
+	<-f.done
+	return f.offset, f.err
+}
+
+func NewAsyncProducer(client api_v1.LogClient, config AsyncProducerConfig) *AsyncProducer {
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 16 * 1024
+	}
+	if config.MaxRecords == 0 {
+		config.MaxRecords = 100
+	}
+	if config.Linger == 0 {
+		config.Linger = 5 * time.Millisecond
+	}
+	p := &AsyncProducer{
+		client:  client,
+		config:  config,
+		batches: make(chan []*pendingRecord),
+		done:    make(chan struct{}),
+	}
+	go p.sendLoop()
+	return p
+}
+
+// Produce는 레코드를 다음 묶음에 넣고 바로 리턴한다.
+func (p *AsyncProducer) Produce(req *api_v1.ProduceRequest) *Future {
+	f := newFuture()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		f.resolve(0, ErrProducerClosed)
+		return f
+	}
+	p.pending = append(p.pending, &pendingRecord{req: req, future: f})
+	p.bytes += len(req.Record.GetValue())
+	switch {
+	case len(p.pending) >= p.config.MaxRecords || p.bytes >= p.config.MaxBytes:
+		p.flushLocked()
+	case len(p.pending) == 1:
+		p.timer = time.AfterFunc(p.config.Linger, func() { p.lingerExpired(f) })
+	}
+	return f
+}
+
+// lingerExpired는 first로 시작한 묶음이 아직 보내지지 않았으면 보낸다.
+func (p *AsyncProducer) lingerExpired(first *Future) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) > 0 && p.pending[0].future == first {
+		p.flushLocked()
+	}
+}
+
+// Flush는 모인 레코드를 기다리지 않고 바로 보낸다.
+func (p *AsyncProducer) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.flushLocked()
+}
+
+// flushLocked는 앞 묶음을 보내는 중이면 끝날 때까지 기다린다.
+// 그동안 Produce도 기다리므로 보내는 속도보다 빨리 쌓이지 않는다.
+func (p *AsyncProducer) flushLocked() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if len(p.pending) == 0 {
+		return
+	}
+	p.batches <- p.pending
+	p.pending = nil
+	p.bytes = 0
+}
+
+// Close는 남은 레코드를 보내고 모든 결과가 나올 때까지 기다린다.
+// 그 뒤의 Produce는 ErrProducerClosed로 끝난다.
+func (p *AsyncProducer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.flushLocked()
+	close(p.batches)
+	p.mu.Unlock()
+
+	<-p.done
+	return nil
+}
+
+func (p *AsyncProducer) sendLoop() {
+	defer close(p.done)
+	for batch := range p.batches {
+		p.send(batch)
+	}
+}
+
+// send는 묶음을 ProduceStream 하나로 보낸다. 서버는 레코드를 쓰지 못하면
+// 스트림을 끝내므로 그 레코드와 뒤따르는 레코드는 같은 에러로 끝난다.
+func (p *AsyncProducer) send(batch []*pendingRecord) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := p.client.ProduceStream(ctx)
+	if err != nil {
+		for _, r := range batch {
+			r.future.resolve(0, err)
+		}
+		return
+	}
+
+	// 응답을 받는 동안 보내야 흐름 제어 창이 차도 서로 기다리지 않는다.
+	go func() {
+		for _, r := range batch {
+			if err := stream.Send(r.req); err != nil {
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+
+	for i, r := range batch {
+		res, err := stream.Recv()
+		if err != nil {
+			for _, r := range batch[i:] {
+				r.future.resolve(0, err)
+			}
+			return
+		}
+		r.future.resolve(res.Offset, nil)
+	}
+}
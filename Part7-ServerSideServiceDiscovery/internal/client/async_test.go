@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAsyncProducer(t *testing.T) {
+	addr, teardown := setupServer(t, nil)
+	defer teardown()
+
+	// 묶음마다 ProduceStream을 하나 연다.
+	var streams atomic.Int64
+	client, cc, err := New(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainStreamInterceptor(countStreams(&streams)),
+	)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	p := NewAsyncProducer(client, AsyncProducerConfig{
+		MaxRecords: 100,
+		Linger:     time.Hour,
+	})
+	var futures []*Future
+	for i := 0; i < 1000; i++ {
+		futures = append(futures, p.Produce(&api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		}))
+	}
+	// 모자란 묶음은 Close가 보낸다.
+	futures = append(futures, p.Produce(&api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("record 1000")},
+	}))
+	require.NoError(t, p.Close())
+
+	for i, f := range futures {
+		off, err := f.Offset()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+	}
+	require.Equal(t, int64(11), streams.Load())
+
+	ctx := context.Background()
+	res, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 1000})
+	require.NoError(t, err)
+	require.Equal(t, []byte("record 1000"), res.Record.Value)
+
+	_, err = p.Produce(&api_v1.ProduceRequest{Record: &api_v1.Record{}}).Offset()
+	require.Equal(t, ErrProducerClosed, err)
+}
+
+func TestAsyncProducerLinger(t *testing.T) {
+	addr, teardown := setupServer(t, nil)
+	defer teardown()
+	client, cc, err := New(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	p := NewAsyncProducer(client, AsyncProducerConfig{Linger: 10 * time.Millisecond})
+	defer p.Close()
+	f := p.Produce(&api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	select {
+	case <-f.Done():
+	case <-time.After(time.Second):
+		t.Fatal("record was not sent after the linger time")
+	}
+	off, err := f.Offset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+}
+
+func TestAsyncProducerRecordError(t *testing.T) {
+	addr, teardown := setupServer(t, func(c *server.Config) {
+		c.Validators = []server.Validator{func(r *api_v1.Record) error {
+			if string(r.Value) == "bad" {
+				return errors.New("bad record")
+			}
+			return nil
+		}}
+	})
+	defer teardown()
+	client, cc, err := New(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	p := NewAsyncProducer(client, AsyncProducerConfig{Linger: time.Hour})
+	var futures []*Future
+	for _, v := range []string{"good", "bad", "good"} {
+		futures = append(futures, p.Produce(&api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(v)},
+		}))
+	}
+	require.NoError(t, p.Close())
+
+	off, err := futures[0].Offset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	// 서버가 스트림을 끝내므로 실패한 레코드 뒤의 레코드도 같은 에러를 받는다.
+	for _, f := range futures[1:] {
+		_, err := f.Offset()
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func countStreams(n *atomic.Int64) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		n.Add(1)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Dial은 addr의 로그 서버에 연결한다. 연결은 첫 RPC 때 맺어진다.
+// 모든 요청에 클라이언트가 따르는 API 버전을 헤더로 붙인다.
+func Dial(addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(versionUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(versionStreamInterceptor),
+	}, opts...)
+	return grpc.NewClient(addr, opts...)
+}
+
+// New는 addr의 로그 서버에 연결한 클라이언트와 연결을 리턴한다.
+func New(addr string, opts ...grpc.DialOption) (api_v1.LogClient, *grpc.ClientConn, error) {
+	cc, err := Dial(addr, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return api_v1.NewLogClient(cc), cc, nil
+}
+
+// withAPIVersion은 호출하는 쪽이 이미 버전을 정하지 않았으면 api_v1.APIVersion을 붙인다.
+func withAPIVersion(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(api_v1.APIVersionHeader)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, api_v1.APIVersionHeader, api_v1.APIVersion)
+}
+
+func versionUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	return invoker(withAPIVersion(ctx), method, req, reply, cc, opts...)
+}
+
+func versionStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return streamer(withAPIVersion(ctx), desc, cc, method, opts...)
+}
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAPIVersionHeader(t *testing.T) {
+	var got []string
+	invoker := func(ctx context.Context, _ string, _, _ interface{}, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		got = md.Get(api_v1.APIVersionHeader)
+		return nil
+	}
+
+	ctx := context.Background()
+	require.NoError(t, versionUnaryInterceptor(ctx, "/log.v1.Log/Produce", nil, nil, nil, invoker))
+	require.Equal(t, []string{api_v1.APIVersion}, got)
+
+	// 호출하는 쪽이 정한 버전은 그대로 둔다.
+	ctx = metadata.AppendToOutgoingContext(ctx, api_v1.APIVersionHeader, "2")
+	require.NoError(t, versionUnaryInterceptor(ctx, "/log.v1.Log/Produce", nil, nil, nil, invoker))
+	require.Equal(t, []string{"2"}, got)
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+var ErrPoolClosed = errors.New("client pool is closed")
+
+var _ grpc.ClientConnInterface = (*Pool)(nil)
+
+// Pool은 같은 서버로 여러 개의 연결을 맺어 두고 RPC마다 돌아가며 쓴다.
+// 연결 하나의 HTTP/2 스트림 수 제한에 막히지 않고 처리량을 높일 수 있다.
+// 망가진(종료됐거나 실패 상태인) 연결은 고를 때 새 연결로 바꾼다.
+type Pool struct {
+	addr string
+	opts []grpc.DialOption
+
+	mu     sync.Mutex
+	conns  []*grpc.ClientConn
+	closed bool
+	next   atomic.Uint64
+}
+
+func NewPool(addr string, size int, opts ...grpc.DialOption) (*Pool, error) {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		addr:  addr,
+		opts:  opts,
+		conns: make([]*grpc.ClientConn, size),
+	}
+	for i := range p.conns {
+		cc, err := Dial(addr, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns[i] = cc
+	}
+	return p, nil
+}
+
+// Client는 풀을 통해 RPC를 보내는 LogClient를 리턴한다.
+func (p *Pool) Client() api_v1.LogClient {
+	return api_v1.NewLogClient(p)
+}
+
+func (p *Pool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	cc, err := p.pick()
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (p *Pool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cc, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	return cc.NewStream(ctx, desc, method, opts...)
+}
+
+func (p *Pool) pick() (*grpc.ClientConn, error) {
+	i := int(p.next.Add(1) % uint64(len(p.conns)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	cc := p.conns[i]
+	switch cc.GetState() {
+	case connectivity.Shutdown, connectivity.TransientFailure:
+		fresh, err := Dial(p.addr, p.opts...)
+		if err != nil {
+			return nil, err
+		}
+		cc.Close()
+		p.conns[i] = fresh
+		cc = fresh
+	}
+	return cc, nil
+}
+
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	var errs []error
+	for _, cc := range p.conns {
+		if cc != nil {
+			errs = append(errs, cc.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
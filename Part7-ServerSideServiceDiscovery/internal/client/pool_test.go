@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestPool(t *testing.T) {
+	addr, teardown := setupServer(t, nil)
+	defer teardown()
+
+	pool, err := NewPool(addr, 2, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer pool.Close()
+	client := pool.Client()
+
+	ctx := context.Background()
+	for i := uint64(0); i < 4; i++ {
+		produce, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+		require.Equal(t, i, produce.Offset)
+	}
+
+	// 연결 하나가 망가져도 풀이 새 연결로 바꿔서 계속 동작해야 한다.
+	broken := pool.conns[0]
+	require.NoError(t, broken.Close())
+	for i := uint64(0); i < 4; i++ {
+		consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: i})
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), consume.Record.Value)
+	}
+	require.NotSame(t, broken, pool.conns[0])
+
+	require.NoError(t, pool.Close())
+	_, err = client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 0})
+	require.Equal(t, ErrPoolClosed, err)
+}
+
+func BenchmarkPoolProduce(b *testing.B) {
+	for name, size := range map[string]int{
+		"single conn": 1,
+		"pooled":      4,
+	} {
+		b.Run(name, func(b *testing.B) {
+			addr, teardown := setupServer(b, nil)
+			defer teardown()
+
+			pool, err := NewPool(addr, size, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(b, err)
+			defer pool.Close()
+			client := pool.Client()
+
+			req := &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			}
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.Produce(context.Background(), req); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func setupServer(t testing.TB, fn func(*server.Config)) (addr string, teardown func()) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "client-test")
+	require.NoError(t, err)
+
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	cfg := &server.Config{
+		CommitLog:  clog,
+		Authorizer: allowAll{},
+	}
+	if fn != nil {
+		fn(cfg)
+	}
+	srv, err := server.NewGRPCServer(cfg)
+	require.NoError(t, err)
+
+	go func() {
+		srv.Serve(l)
+	}()
+
+	return l.Addr().String(), func() {
+		srv.Stop()
+		l.Close()
+		clog.Remove()
+	}
+}
+
+type allowAll struct{}
+
+func (allowAll) Authorize(subject, object, action string) error {
+	return nil
+}
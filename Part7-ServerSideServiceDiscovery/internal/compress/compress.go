@@ -0,0 +1,202 @@
+// compress는 압축 코덱을 이름으로 찾는 레지스트리다. 로그 설정이나 전송 계층이
+// 코덱을 이름으로 가리키고, 사용자는 자기 코덱을 등록해 쓸 수 있다.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// 기본으로 등록된 코덱 이름.
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	ErrUnknownCodec    = errors.New("unknown compression codec")
+	ErrCodecRegistered = errors.New("compression codec already registered")
+)
+
+type Compressor interface {
+	Compress(p []byte) ([]byte, error)
+}
+
+type Decompressor interface {
+	Decompress(p []byte) ([]byte, error)
+}
+
+// CompressorFunc와 DecompressorFunc는 함수를 Compressor와 Decompressor로 쓰게 한다.
+type CompressorFunc func(p []byte) ([]byte, error)
+
+func (f CompressorFunc) Compress(p []byte) ([]byte, error) { return f(p) }
+
+type DecompressorFunc func(p []byte) ([]byte, error)
+
+func (f DecompressorFunc) Decompress(p []byte) ([]byte, error) { return f(p) }
+
+type codec struct {
+	c Compressor
+	d Decompressor
+}
+
+// Registry는 이름과 Compressor/Decompressor 쌍을 묶어 둔다. 여러 고루틴에서
+// 함께 써도 된다.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]codec
+}
+
+// NewRegistry는 none, gzip, zstd가 등록된 레지스트리를 만든다.
+func NewRegistry() *Registry {
+	r := &Registry{codecs: make(map[string]codec)}
+	identity := func(p []byte) ([]byte, error) { return p, nil }
+	r.codecs[None] = codec{CompressorFunc(identity), DecompressorFunc(identity)}
+	r.codecs[Gzip] = codec{CompressorFunc(gzipCompress), DecompressorFunc(gzipDecompress)}
+	r.codecs[Zstd] = codec{CompressorFunc(zstdCompress), DecompressorFunc(zstdDecompress)}
+	return r
+}
+
+// Register는 name으로 코덱을 등록한다. 이미 있는 이름이면 ErrCodecRegistered를 리턴한다.
+func (r *Registry) Register(name string, c Compressor, d Decompressor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.codecs[name]; ok {
+		return fmt.Errorf("%w: %q", ErrCodecRegistered, name)
+	}
+	r.codecs[name] = codec{c, d}
+	return nil
+}
+
+// Lookup은 name으로 등록된 코덱을 리턴한다. 없으면 ErrUnknownCodec을 리턴한다.
+func (r *Registry) Lookup(name string) (Compressor, Decompressor, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.codecs[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+	}
+	return c.c, c.d, nil
+}
+
+// Names는 등록된 코덱 이름을 정렬해서 리턴한다.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.codecs))
+	for name := range r.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GRPC는 name 코덱을 gRPC의 encoding.Compressor로 감싼다. 서버와 클라이언트가
+// 같은 코덱으로 메시지를 압축하려면 init에서 encoding.RegisterCompressor로 등록한다.
+func (r *Registry) GRPC(name string) (encoding.Compressor, error) {
+	c, d, err := r.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcCompressor{name: name, c: c, d: d}, nil
+}
+
+// Default는 패키지 함수들이 쓰는 레지스트리다. 로그는 이 레지스트리에서 코덱을 찾는다.
+var Default = NewRegistry()
+
+func Register(name string, c Compressor, d Decompressor) error {
+	return Default.Register(name, c, d)
+}
+
+func Lookup(name string) (Compressor, Decompressor, error) {
+	return Default.Lookup(name)
+}
+
+func gzipCompress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gzipDecompress(p []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdEncoder와 zstdDecoder는 EncodeAll과 DecodeAll만 쓰므로 여러 고루틴이
+// 함께 써도 된다.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func zstdCompress(p []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(p, nil), nil
+}
+
+func zstdDecompress(p []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(p, nil)
+}
+
+// grpcCompressor는 메시지 하나를 모아서 한 번에 압축한다.
+type grpcCompressor struct {
+	name string
+	c    Compressor
+	d    Decompressor
+}
+
+func (g *grpcCompressor) Name() string { return g.name }
+
+func (g *grpcCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &compressWriter{w: w, c: g.c}, nil
+}
+
+func (g *grpcCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err = g.d.Decompress(p)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(p), nil
+}
+
+type compressWriter struct {
+	w   io.Writer
+	c   Compressor
+	buf bytes.Buffer
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	return cw.buf.Write(p)
+}
+
+func (cw *compressWriter) Close() error {
+	p, err := cw.c.Compress(cw.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = cw.w.Write(p)
+	return err
+}
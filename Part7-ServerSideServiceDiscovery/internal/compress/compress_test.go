@@ -0,0 +1,59 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	require.Equal(t, []string{Gzip, None, Zstd}, r.Names())
+
+	value := bytes.Repeat([]byte("hello world "), 100)
+	for _, name := range r.Names() {
+		c, d, err := r.Lookup(name)
+		require.NoError(t, err)
+		p, err := c.Compress(value)
+		require.NoError(t, err)
+		got, err := d.Decompress(p)
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+	}
+
+	_, _, err := r.Lookup("lz4")
+	require.ErrorIs(t, err, ErrUnknownCodec)
+
+	identity := func(p []byte) ([]byte, error) { return p, nil }
+	require.NoError(t, r.Register("lz4", CompressorFunc(identity), DecompressorFunc(identity)))
+	_, _, err = r.Lookup("zstd")
+	require.NoError(t, err)
+	err = r.Register(Gzip, CompressorFunc(identity), DecompressorFunc(identity))
+	require.ErrorIs(t, err, ErrCodecRegistered)
+}
+
+func TestRegistryGRPC(t *testing.T) {
+	c, err := NewRegistry().GRPC(Gzip)
+	require.NoError(t, err)
+	require.Equal(t, Gzip, c.Name())
+
+	value := bytes.Repeat([]byte("hello world "), 100)
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(value)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Less(t, buf.Len(), len(value))
+
+	r, err := c.Decompress(&buf)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, value, got)
+
+	_, err = NewRegistry().GRPC("snappy")
+	require.ErrorIs(t, err, ErrUnknownCodec)
+}
@@ -6,17 +6,21 @@ import (
 )
 
 var (
-	CAFile               = configFile("ca.pem")
-	ServerCertFile       = configFile("server.pem")
-	ServerKeyFile        = configFile("server-key.pem")
-	ClientCertFile       = configFile("client.pem")
-	ClientKeyFile        = configFile("client-key.pem")
-	RootClientCertFile   = configFile("root-client.pem")
-	RootClientKeyFile    = configFile("root-client-key.pem")
-	NobodyClientCertFile = configFile("nobody-client.pem")
-	NobodyClientKeyFile  = configFile("nobody-client-key.pem")
-	ACLModelFile         = configFile("model.conf")
-	ACLPolicyFile        = configFile("policy.csv")
+	CAFile                = configFile("ca.pem")
+	ServerCertFile        = configFile("server.pem")
+	ServerKeyFile         = configFile("server-key.pem")
+	ClientCertFile        = configFile("client.pem")
+	ClientKeyFile         = configFile("client-key.pem")
+	RootClientCertFile    = configFile("root-client.pem")
+	RootClientKeyFile     = configFile("root-client-key.pem")
+	NobodyClientCertFile  = configFile("nobody-client.pem")
+	NobodyClientKeyFile   = configFile("nobody-client-key.pem")
+	TenantAClientCertFile = configFile("tenant-a-client.pem")
+	TenantAClientKeyFile  = configFile("tenant-a-client-key.pem")
+	TenantBClientCertFile = configFile("tenant-b-client.pem")
+	TenantBClientKeyFile  = configFile("tenant-b-client-key.pem")
+	ACLModelFile          = configFile("model.conf")
+	ACLPolicyFile         = configFile("policy.csv")
 )
 
 func configFile(filename string) string {
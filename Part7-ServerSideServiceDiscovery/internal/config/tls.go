@@ -3,22 +3,80 @@ package config
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
 type TLSConfig struct {
-	CertFile      string
-	KeyFile       string
+	CertFile string
+	KeyFile  string
+	// CAFile과 CAFiles의 CA를 모두 믿는다. CA를 바꾸는 동안 이전 CA와 새 CA가
+	// 서명한 인증서를 함께 받으려면 둘 다 넣는다. CAFile은 CA가 하나일 때
+	// 쓰는 편의용이다.
 	CAFile        string
+	CAFiles       []string
 	ServerAddress string
 	Server        bool
+	// CertHolder가 있으면 인증서를 tls.Config에 고정하지 않고 CertHolder에
+	// 넣은 뒤 연결마다 CertHolder에서 꺼내 쓴다. 나중에 ReloadCertificate로
+	// 바꾼 인증서는 새 연결부터 쓰인다.
+	CertHolder *CertHolder
+	// ClientCertOptional이면 서버는 클라이언트 인증서를 보냈을 때만 검증하고
+	// 인증서 없는 연결도 받는다. 서버가 JWTPublicKey로 Bearer 토큰을 받아
+	// 인증서 대신 쓸 때 켠다. 인증서도 토큰도 없는 요청은 서버가 거부한다.
+	ClientCertOptional bool
+}
+
+// CertHolder는 다시 시작하지 않고 바꿀 수 있는 인증서다. 인증서를 통째로
+// 바꿔 끼우므로 연결은 이전 인증서나 새 인증서 중 하나를 쓴다.
+type CertHolder struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// ReloadCertificate는 certFile과 keyFile에서 인증서를 읽어 바꾼다. 읽지
+// 못하면 이전 인증서를 그대로 쓴다.
+func (h *CertHolder) ReloadCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	h.cert.Store(&cert)
+	return nil
+}
+
+func (h *CertHolder) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return h.certificate()
+}
+
+func (h *CertHolder) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return h.certificate()
+}
+
+func (h *CertHolder) certificate() (*tls.Certificate, error) {
+	cert := h.cert.Load()
+	if cert == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+	return cert, nil
 }
 
 func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 	var err error
 	tlsConfig := &tls.Config{}
-	if cfg.CertFile != "" && cfg.KeyFile != "" {
+	if h := cfg.CertHolder; h != nil {
+		if cfg.CertFile != "" && cfg.KeyFile != "" {
+			if err := h.ReloadCertificate(cfg.CertFile, cfg.KeyFile); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.Server {
+			tlsConfig.GetCertificate = h.GetCertificate
+		} else {
+			tlsConfig.GetClientCertificate = h.GetClientCertificate
+		}
+	} else if cfg.CertFile != "" && cfg.KeyFile != "" {
 		tlsConfig.Certificates = make([]tls.Certificate, 1)
 		tlsConfig.Certificates[0], err = tls.LoadX509KeyPair(
 			cfg.CertFile,
@@ -28,22 +86,27 @@ func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 			return nil, err
 		}
 	}
-	if cfg.CAFile != "" {
-		b, err := os.ReadFile(cfg.CAFile)
-		if err != nil {
-			return nil, err
-		}
+	if caFiles := cfg.caFiles(); len(caFiles) > 0 {
 		ca := x509.NewCertPool()
-		ok := ca.AppendCertsFromPEM([]byte(b))
-		if !ok {
-			return nil, fmt.Errorf(
-				"failed to parse root certificate: %q",
-				cfg.CAFile,
-			)
+		for _, caFile := range caFiles {
+			b, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+			ok := ca.AppendCertsFromPEM(b)
+			if !ok {
+				return nil, fmt.Errorf(
+					"failed to parse root certificate: %q",
+					caFile,
+				)
+			}
 		}
 		if cfg.Server {
 			tlsConfig.ClientCAs = ca
 			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if cfg.ClientCertOptional {
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
 		} else {
 			tlsConfig.RootCAs = ca
 		}
@@ -51,3 +114,12 @@ func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
 	}
 	return tlsConfig, nil
 }
+
+// caFiles는 CAFile과 CAFiles를 합친 것이다.
+func (cfg TLSConfig) caFiles() []string {
+	var files []string
+	if cfg.CAFile != "" {
+		files = append(files, cfg.CAFile)
+	}
+	return append(files, cfg.CAFiles...)
+}
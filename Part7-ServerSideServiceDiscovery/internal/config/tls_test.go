@@ -0,0 +1,218 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert는 commonName으로 자체 서명한 인증서와 키를 dir에 쓰고 파일 이름을 리턴한다.
+func writeCert(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, commonName+".pem")
+	keyFile = filepath.Join(dir, commonName+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	oldCert, oldKey := writeCert(t, dir, "old")
+	newCert, newKey := writeCert(t, dir, "new")
+
+	holder := &CertHolder{}
+	serverTLSConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:   oldCert,
+		KeyFile:    oldKey,
+		Server:     true,
+		CertHolder: holder,
+	})
+	require.NoError(t, err)
+	require.Empty(t, serverTLSConfig.Certificates)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	// peer는 새로 연결해서 서버가 보여준 인증서의 CommonName을 리턴한다.
+	peer := func() string {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	require.Equal(t, "old", peer())
+
+	require.NoError(t, holder.ReloadCertificate(newCert, newKey))
+	require.Equal(t, "new", peer())
+
+	// 읽지 못한 인증서는 이전 인증서를 바꾸지 않는다.
+	require.Error(t, holder.ReloadCertificate(filepath.Join(dir, "missing.pem"), newKey))
+	require.Equal(t, "new", peer())
+}
+
+func TestSetupTLSConfigStatic(t *testing.T) {
+	certFile, keyFile := writeCert(t, t.TempDir(), "static")
+	tlsConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	require.Len(t, tlsConfig.Certificates, 1)
+	require.Nil(t, tlsConfig.GetCertificate)
+}
+
+// testCA는 테스트에서 인증서를 서명하는 CA다.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// writeCA는 commonName으로 자체 서명한 CA 인증서를 dir에 쓴다.
+func writeCA(t *testing.T, dir, commonName string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	file := filepath.Join(dir, commonName+".pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// sign은 ca로 서명한 인증서와 키를 dir에 쓰고 파일 이름을 리턴한다.
+func (ca *testCA) sign(t *testing.T, dir, commonName string, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, commonName+".pem")
+	keyFile = filepath.Join(dir, commonName+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestSetupTLSConfigMultipleCAs(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := writeCA(t, dir, "old-ca")
+	newCA := writeCA(t, dir, "new-ca")
+	serverCert, serverKey := oldCA.sign(t, dir, "server", x509.ExtKeyUsageServerAuth)
+
+	// handshake는 caFiles를 믿는 서버에 client가 서명한 인증서로 연결해 본다.
+	handshake := func(caFile string, caFiles []string, client *testCA) error {
+		serverTLSConfig, err := SetupTLSConfig(TLSConfig{
+			CertFile: serverCert,
+			KeyFile:  serverKey,
+			CAFile:   caFile,
+			CAFiles:  caFiles,
+			Server:   true,
+		})
+		require.NoError(t, err)
+		l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig)
+		require.NoError(t, err)
+		defer l.Close()
+		serverErr := make(chan error, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			defer conn.Close()
+			serverErr <- conn.(*tls.Conn).Handshake()
+		}()
+
+		clientCert, clientKey := client.sign(t, t.TempDir(), "client", x509.ExtKeyUsageClientAuth)
+		clientTLSConfig, err := SetupTLSConfig(TLSConfig{
+			CertFile:      clientCert,
+			KeyFile:       clientKey,
+			CAFile:        oldCA.file,
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+		conn, err := tls.Dial("tcp", l.Addr().String(), clientTLSConfig)
+		if err == nil {
+			conn.Close()
+		}
+		// 클라이언트 인증서를 거부한 것은 서버의 핸드셰이크가 안다.
+		return <-serverErr
+	}
+
+	// 두 CA를 모두 믿는 동안에는 새 CA가 서명한 클라이언트도 연결한다.
+	require.NoError(t, handshake(oldCA.file, []string{newCA.file}, newCA))
+	require.NoError(t, handshake("", []string{oldCA.file, newCA.file}, oldCA))
+	require.Error(t, handshake(oldCA.file, nil, newCA))
+
+	bad := filepath.Join(dir, "bad.pem")
+	require.NoError(t, os.WriteFile(bad, []byte("not a certificate"), 0644))
+	_, err := SetupTLSConfig(TLSConfig{CAFiles: []string{oldCA.file, bad}, Server: true})
+	require.ErrorContains(t, err, bad)
+}
@@ -1,7 +1,9 @@
 package discovery
 
 import (
+	"fmt"
 	"net"
+	"sync"
 
 	"github.com/hashicorp/serf/serf"
 	"go.uber.org/zap"
@@ -9,10 +11,11 @@ import (
 
 type Membership struct {
 	Config
-	handler Handler
-	serf    *serf.Serf
-	events  chan serf.Event
-	logger  *zap.Logger
+	mu       sync.RWMutex
+	handlers []Handler
+	serf     *serf.Serf
+	events   chan serf.Event
+	logger   *zap.Logger
 }
 
 type Config struct {
@@ -21,16 +24,22 @@ type Config struct {
 	Tags           map[string]string
 	StartJoinAddrs []string
 }
+
+// Handler는 다른 노드가 클러스터에 들어오고 나갈 때 불린다.
 type Handler interface {
 	Join(name, addr string) error
 	Leave(name string) error
 }
 
+// New는 handler를 등록하고 클러스터에 들어간다. handler가 nil이면
+// 나중에 AddHandler로 등록해도 된다.
 func New(handler Handler, config Config) (*Membership, error) {
 	c := &Membership{
-		Config:  config,
-		handler: handler,
-		logger:  zap.L().Named("membership"),
+		Config: config,
+		logger: zap.L().Named("membership"),
+	}
+	if handler != nil {
+		c.handlers = append(c.handlers, handler)
 	}
 
 	if err := c.setupSerf(); err != nil {
@@ -50,6 +59,7 @@ func (m *Membership) setupSerf() (err error) {
 	config.MemberlistConfig.BindAddr = addr.IP.String()
 	config.MemberlistConfig.BindPort = addr.Port
 	m.events = make(chan serf.Event)
+	config.EventCh = m.events
 	config.Tags = m.Tags
 	config.NodeName = m.Config.NodeName
 	m.serf, err = serf.Create(config)
@@ -80,7 +90,7 @@ func (m *Membership) eventHandler() {
 		case serf.EventMemberLeave, serf.EventMemberFailed:
 			for _, member := range e.(serf.MemberEvent).Members {
 				if m.isLocal(member) {
-					return
+					continue
 				}
 				m.handleLeave(member)
 			}
@@ -88,21 +98,47 @@ func (m *Membership) eventHandler() {
 	}
 }
 
+// AddHandler는 handler를 더 등록한다. 등록한 뒤에 일어난 이벤트부터 받는다.
+func (m *Membership) AddHandler(handler Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
 func (m *Membership) handleJoin(member serf.Member) { // 입장
-	if err := m.handler.Join(
-		member.Name,
-		member.Tags["rpc_addr"],
-	); err != nil {
-		m.logError(err, "failed to join", member)
-	}
+	m.dispatch(member, "failed to join", func(h Handler) error {
+		return h.Join(member.Name, member.Tags["rpc_addr"])
+	})
 }
 
 func (m *Membership) handleLeave(member serf.Member) { // 탈퇴
-	if err := m.handler.Leave(member.Name); err != nil {
-		m.logError(err, "failed to leave", member)
+	m.dispatch(member, "failed to leave", func(h Handler) error {
+		return h.Leave(member.Name)
+	})
+}
+
+// dispatch는 등록된 순서대로 모든 핸들러에 이벤트를 넘긴다. 핸들러가 에러를
+// 리턴하거나 패닉을 일으키면 로그만 남기고 다음 핸들러로 넘어간다.
+func (m *Membership) dispatch(member serf.Member, msg string, fn func(Handler) error) {
+	m.mu.RLock()
+	handlers := m.handlers
+	m.mu.RUnlock()
+	for _, h := range handlers {
+		if err := call(h, fn); err != nil {
+			m.logError(err, msg, member)
+		}
 	}
 }
 
+func call(h Handler, fn func(Handler) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return fn(h)
+}
+
 func (m *Membership) isLocal(member serf.Member) bool {
 	return m.serf.LocalMember().Name == member.Name
 }
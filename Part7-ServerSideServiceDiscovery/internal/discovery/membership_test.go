@@ -29,6 +29,26 @@ func TestMembership(t *testing.T) {
 	require.Equal(t, fmt.Sprintf("%d", 2), <-handler.leaves)
 }
 
+func TestMembershipHandlers(t *testing.T) {
+	m, first := setupMember(t, nil)
+	// 앞의 핸들러가 패닉을 일으켜도 뒤의 핸들러는 이벤트를 받는다.
+	m[0].AddHandler(panicHandler{})
+	second := &handler{joins: make(chan map[string]string, 1)}
+	m[0].AddHandler(second)
+
+	m, _ = setupMember(t, m)
+
+	for _, h := range []*handler{first, second} {
+		select {
+		case join := <-h.joins:
+			require.Equal(t, "1", join["id"])
+			require.Equal(t, m[1].BindAddr, join["addr"])
+		case <-time.After(3 * time.Second):
+			t.Fatal("handler did not receive the join")
+		}
+	}
+}
+
 func setupMember(t *testing.T, members []*discovery.Membership) ([]*discovery.Membership, *handler) {
 	id := len(members)
 
@@ -80,3 +100,13 @@ func (h *handler) Leave(id string) error {
 	}
 	return nil
 }
+
+type panicHandler struct{}
+
+func (panicHandler) Join(id, addr string) error {
+	panic("join " + id)
+}
+
+func (panicHandler) Leave(id string) error {
+	panic("leave " + id)
+}
@@ -0,0 +1,70 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAllocatorMismatch는 로그를 만들 때와 다른 OffsetAllocator로 열면 리턴한다.
+var ErrAllocatorMismatch = errors.New("offset allocator does not match the existing log")
+
+// OffsetAllocator는 Append가 레코드에 줄 오프셋을 정한다. 로그는 처음 만들 때의
+// 할당기를 meta.json에 남기고 다른 할당기로는 열지 않는다. fmt.Stringer를
+// 구현하면 그 값으로, 아니면 타입 이름으로 할당기를 구분하므로 설정에 따라
+// 오프셋이 달라지는 할당기는 String에 설정을 담아야 한다.
+type OffsetAllocator interface {
+	// Next는 from 이상인 오프셋 중에서 쓸 수 있는 가장 작은 오프셋을 리턴한다.
+	Next(from uint64) uint64
+}
+
+// SequentialAllocator는 오프셋을 빈틈없이 차례대로 준다. 기본값이다.
+type SequentialAllocator struct{}
+
+func (SequentialAllocator) Next(from uint64) uint64 {
+	return from
+}
+
+// StridedAllocator는 TotalShards개의 노드가 따로 쓴 로그를 나중에 합칠 수
+// 있도록 오프셋을 TotalShards 간격으로 준다. Shard번 노드의 오프셋은
+// TotalShards로 나눈 나머지가 Shard다.
+type StridedAllocator struct {
+	shard, totalShards uint64
+}
+
+func NewStridedAllocator(shard, totalShards uint64) (*StridedAllocator, error) {
+	if shard >= totalShards {
+		return nil, fmt.Errorf("shard %d out of range for %d shards", shard, totalShards)
+	}
+	return &StridedAllocator{shard: shard, totalShards: totalShards}, nil
+}
+
+func (a *StridedAllocator) String() string {
+	return fmt.Sprintf("strided %d/%d", a.shard, a.totalShards)
+}
+
+func (a *StridedAllocator) Next(from uint64) uint64 {
+	off := from - from%a.totalShards + a.shard
+	if off < from {
+		off += a.totalShards
+	}
+	return off
+}
+
+// allocatorID는 meta.json에 남길 할당기의 이름이다. 기본 할당기면 빈 문자열이다.
+func allocatorID(a OffsetAllocator) string {
+	switch a := a.(type) {
+	case nil, SequentialAllocator, *SequentialAllocator:
+		return ""
+	case fmt.Stringer:
+		return a.String()
+	}
+	return fmt.Sprintf("%T", a)
+}
+
+// next는 from 이상에서 Append가 쓸 다음 오프셋이다.
+func (c Config) next(from uint64) uint64 {
+	if c.OffsetAllocator == nil {
+		return from
+	}
+	return c.OffsetAllocator.Next(from)
+}
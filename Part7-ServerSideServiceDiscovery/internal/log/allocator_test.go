@@ -0,0 +1,93 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStridedAllocator(t *testing.T) {
+	dir, err := os.MkdirTemp("", "strided-allocator-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	alloc, err := NewStridedAllocator(1, 3)
+	require.NoError(t, err)
+	c := Config{OffsetAllocator: alloc}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	var want []uint64
+	for i := uint64(0); i < 10; i++ {
+		off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+		require.Equal(t, 1+3*i, off)
+		want = append(want, off)
+	}
+	require.Greater(t, len(log.segments), 1)
+
+	testStridedReads := func(log *Log) {
+		for _, off := range want {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+			// 샤드에 속하지 않는 오프셋에는 레코드가 없다.
+			_, err = log.Read(off + 1)
+			require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+		}
+	}
+	testStridedReads(log)
+
+	b, err := io.ReadAll(io.NewSectionReader(log.ReaderAt(), 0, 1<<20))
+	require.NoError(t, err)
+	require.Len(t, b, len(want)*len("hello world"))
+
+	// 다시 열어도 다음 오프셋을 이어서 준다.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	testStridedReads(log)
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(31), off)
+
+	// 건너뛰어 쓸 때도 샤드의 오프셋에만 쓴다.
+	err = log.AppendAt(35, &api_v1.Record{Value: []byte("hello world")})
+	require.ErrorIs(t, err, ErrOffsetGap)
+	require.NoError(t, log.AppendAt(37, &api_v1.Record{Value: []byte("hello world")}))
+	off, err = log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(40), off)
+
+	// 다른 할당기로는 열지 않고 어떤 할당기로 열어야 하는지 알린다.
+	require.NoError(t, log.Close())
+	other, err := NewStridedAllocator(2, 3)
+	require.NoError(t, err)
+	for _, a := range []OffsetAllocator{nil, SequentialAllocator{}, other} {
+		c := c
+		c.OffsetAllocator = a
+		_, err = NewLog(dir, c)
+		require.ErrorIs(t, err, ErrAllocatorMismatch)
+		require.ErrorIs(t, err, ErrConfigMismatch)
+		require.ErrorContains(t, err, `existing log uses "strided 1/3"`)
+	}
+
+	// 기본 할당기로 만든 로그도 다른 할당기로 열지 않는다.
+	seq := t.TempDir()
+	log, err = NewLog(seq, Config{})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	_, err = NewLog(seq, Config{OffsetAllocator: alloc})
+	require.ErrorIs(t, err, ErrAllocatorMismatch)
+	log, err = NewLog(seq, Config{OffsetAllocator: SequentialAllocator{}})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	_, err = NewStridedAllocator(3, 3)
+	require.Error(t, err)
+}
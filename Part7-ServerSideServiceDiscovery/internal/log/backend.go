@@ -0,0 +1,243 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrBackendNotFile = errors.New("direct writes, mmap reads and healing need the file store backend")
+
+// StoreBackend는 스토어가 레코드를 쓰는 저장소다. 스토어는 Size부터 이어서
+// WriteAt으로 쓰고, 그 앞은 ReadAt으로 읽는다. Truncate, Sync, Close는
+// *os.File과 같다. 기본값은 로그 디렉터리의 스토어 파일이다.
+type StoreBackend interface {
+	io.WriterAt
+	io.ReaderAt
+	Truncate(size int64) error
+	Sync() error
+	Size() (int64, error)
+	Close() error
+}
+
+// StoreStorage는 스토어 파일 경로를 이름으로 써서 StoreBackend를 열고, 지우고,
+// 이름을 바꾼다. Open은 없는 이름이면 빈 백엔드를 만든다. 압축은 새 스토어를
+// 다른 이름으로 쓴 뒤 Rename으로 바꿔 끼운다.
+type StoreStorage interface {
+	Open(name string) (StoreBackend, error)
+	Remove(name string) error
+	Rename(oldName, newName string) error
+}
+
+// modTimer는 보존 기간을 재는 데 쓰는 마지막 수정 시각을 읽고 바꿀 수 있는
+// 백엔드다. 구현하지 않은 백엔드의 세그먼트는 Retention.MaxAge로 지우지 않는다.
+type modTimer interface {
+	ModTime() (time.Time, error)
+	SetModTime(time.Time) error
+}
+
+// storage는 Store.Storage가 없으면 파일 스토리지를 리턴한다.
+func (c Config) storage() StoreStorage {
+	if c.Store.Storage == nil {
+		return fileStorage{}
+	}
+	return c.Store.Storage
+}
+
+// checkStorage는 파일이 있어야 하는 설정을 다른 스토리지와 함께 쓰지 않는지 확인한다.
+func (c Config) checkStorage() error {
+	if c.Store.Storage == nil {
+		return nil
+	}
+	if c.Store.Direct || c.Store.MmapReads || c.Segment.HealOnOpen {
+		return ErrBackendNotFile
+	}
+	return nil
+}
+
+// fileBackend는 기본 StoreBackend로 *os.File에 쓴다.
+type fileBackend struct {
+	*os.File
+}
+
+func (f fileBackend) Size() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (f fileBackend) ModTime() (time.Time, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (f fileBackend) SetModTime(t time.Time) error {
+	return os.Chtimes(f.Name(), t, t)
+}
+
+type fileStorage struct{}
+
+func (fileStorage) Open(name string) (StoreBackend, error) {
+	// WriteAt을 쓰므로 O_APPEND로 열지 않는다.
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return fileBackend{f}, nil
+}
+
+func (fileStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (fileStorage) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// MemoryStorage는 스토어를 메모리에 두는 StoreStorage다. 같은 MemoryStorage로
+// 다시 연 로그는 닫기 전에 쓴 레코드를 그대로 읽는다. 인덱스와 meta.json은
+// 여전히 로그 디렉터리에 쓴다. 테스트처럼 스토어를 디스크에 남길 필요가 없을 때 쓴다.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	backends map[string]*memoryBackend
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{backends: make(map[string]*memoryBackend)}
+}
+
+func (m *MemoryStorage) Open(name string) (StoreBackend, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.backends[name]
+	if !ok {
+		b = &memoryBackend{name: name, modTime: time.Now()}
+		m.backends[name] = b
+	}
+	return b, nil
+}
+
+func (m *MemoryStorage) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.backends[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.backends, name)
+	return nil
+}
+
+func (m *MemoryStorage) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.backends[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(m.backends, oldName)
+	b.mu.Lock()
+	b.name = newName
+	b.mu.Unlock()
+	m.backends[newName] = b
+	return nil
+}
+
+// Names는 지금 있는 스토어 이름이다. 순서는 정해지지 않았다.
+func (m *MemoryStorage) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.backends))
+	for name := range m.backends {
+		names = append(names, name)
+	}
+	return names
+}
+
+type memoryBackend struct {
+	mu      sync.Mutex
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+func (b *memoryBackend) Name() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.name
+}
+
+func (b *memoryBackend) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "writeat", Path: b.Name(), Err: fs.ErrInvalid}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	b.modTime = time.Now()
+	return copy(b.data[off:], p), nil
+}
+
+func (b *memoryBackend) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: b.Name(), Err: fs.ErrInvalid}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *memoryBackend) Truncate(size int64) error {
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: b.Name(), Err: fs.ErrInvalid}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size <= int64(len(b.data)) {
+		b.data = b.data[:size]
+	} else {
+		b.data = append(b.data, make([]byte, size-int64(len(b.data)))...)
+	}
+	b.modTime = time.Now()
+	return nil
+}
+
+func (b *memoryBackend) Sync() error { return nil }
+
+func (b *memoryBackend) Size() (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(len(b.data)), nil
+}
+
+// Close는 아무것도 하지 않는다. 데이터는 MemoryStorage.Remove할 때까지 남는다.
+func (b *memoryBackend) Close() error { return nil }
+
+func (b *memoryBackend) ModTime() (time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.modTime, nil
+}
+
+func (b *memoryBackend) SetModTime(t time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.modTime = t
+	return nil
+}
@@ -0,0 +1,122 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreMemoryBackend(t *testing.T) {
+	b, err := NewMemoryStorage().Open("store_memory_test")
+	require.NoError(t, err)
+
+	s, err := newStore(b, Config{})
+	require.NoError(t, err)
+	testAppend(t, s)
+	testRead(t, s)
+	testReadAt(t, s)
+	require.NoError(t, s.Close())
+
+	// 닫아도 데이터는 남아 있어서 다시 만든 스토어가 이어서 쓴다.
+	s, err = newStore(b, Config{})
+	require.NoError(t, err)
+	testRead(t, s)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.Equal(t, 3*width, pos)
+	require.NoError(t, s.Close())
+}
+
+func TestMemoryStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewMemoryStorage()
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	c.Compaction.ByKey = true
+	c.Store.Storage = storage
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	// 세그먼트마다 레코드 3개씩, 0-2, 3-5, 6-8, 9(활성)
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(&api_v1.Record{
+			Key:   []byte(fmt.Sprintf("key %d", i%2)),
+			Value: []byte(fmt.Sprintf("value %d", i)),
+		})
+		require.NoError(t, err)
+	}
+	storeNames := func() []string {
+		var names []string
+		for _, name := range storage.Names() {
+			names = append(names, filepath.Base(name))
+		}
+		slices.Sort(names)
+		return names
+	}
+	require.Equal(t, []string{"0.store", "3.store", "6.store", "9.store"}, storeNames())
+	stores, err := filepath.Glob(filepath.Join(dir, "*.store"))
+	require.NoError(t, err)
+	require.Empty(t, stores)
+
+	check := func(log *Log, offsets ...uint64) {
+		t.Helper()
+		for _, off := range offsets {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("value %d", off), string(record.Value))
+		}
+	}
+	check(log, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	require.NoError(t, log.Close())
+
+	// 같은 스토리지로 다시 열면 세그먼트를 인덱스 파일에서 찾는다.
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Len(t, log.segments, 4)
+	check(log, 0, 5, 9)
+
+	// 봉인된 세그먼트에서 8, 9가 아닌 레코드는 모두 더 최근 레코드가 있다.
+	reclaimed, err := log.Compact()
+	require.NoError(t, err)
+	require.Positive(t, reclaimed)
+	require.Equal(t, []string{"6.store", "9.store"}, storeNames())
+	check(log, 8, 9)
+	_, err = log.Read(7)
+	require.Error(t, err)
+
+	require.NoError(t, log.Truncate(8))
+	require.Equal(t, []string{"9.store"}, storeNames())
+	check(log, 9)
+	_, err = os.Stat(filepath.Join(dir, "6.index"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestStorageNeedsFile(t *testing.T) {
+	for name, fn := range map[string]func(*Config){
+		"direct":       func(c *Config) { c.Store.Direct = true },
+		"mmap reads":   func(c *Config) { c.Store.MmapReads = true },
+		"heal on open": func(c *Config) { c.Segment.HealOnOpen = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := Config{}
+			c.Store.Storage = NewMemoryStorage()
+			fn(&c)
+			_, err := NewLog(t.TempDir(), c)
+			require.ErrorIs(t, err, ErrBackendNotFile)
+		})
+	}
+
+	c := Config{}
+	c.Store.Storage = NewMemoryStorage()
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Heal()
+	require.ErrorIs(t, err, ErrBackendNotFile)
+}
@@ -0,0 +1,122 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+type BackupOptions struct {
+	// FromSegment는 백업을 시작할 세그먼트의 베이스 오프셋이다.
+	// 중단된 백업은 Progress로 받은 값을 넣어 이어서 받을 수 있다.
+	FromSegment uint64
+	// Progress는 세그먼트 하나를 다 쓸 때마다 다음에 이어서 받을
+	// 세그먼트의 베이스 오프셋과 함께 불린다.
+	Progress func(next uint64)
+}
+
+type BackupManifest struct {
+	Segments []BackupSegment `json:"segments"`
+}
+
+type BackupSegment struct {
+	BaseOffset uint64 `json:"base_offset"`
+	NextOffset uint64 `json:"next_offset"`
+	Size       uint64 `json:"size"`
+}
+
+// Backup은 세그먼트들의 스토어 파일을 순서대로 w에 쓴다.
+//
+// 처음부터 받는 백업은 맨 앞에 매니페스트를 쓴다:
+//
+//	[8바이트 길이][매니페스트 JSON]
+//
+// 그 뒤로 세그먼트마다 다음을 쓴다. 스토어 데이터는 lenWidth 길이 뒤에
+// 레코드가 붙은 형식 그대로다:
+//
+//	[8바이트 베이스 오프셋][8바이트 크기][스토어 데이터]
+//
+// 세그먼트 사이마다 ctx를 확인해서 취소되면 ctx.Err()를 리턴한다.
+// 그때까지 쓴 결과에 FromSegment로 이어 받은 결과를 붙이면
+// 한 번에 받은 백업과 같다.
+// 매니페스트의 세그먼트가 백업 도중에 지워지면 ErrSegmentRemoved를 리턴한다.
+func (l *Log) Backup(ctx context.Context, w io.Writer, opts BackupOptions) error {
+	l.mu.RLock()
+	segments := make([]*segment, len(l.segments))
+	copy(segments, l.segments)
+	m := newManifest(segments)
+	l.mu.RUnlock()
+
+	if len(segments) > 0 && opts.FromSegment <= segments[0].baseOffset {
+		if err := writeManifest(w, m); err != nil {
+			return err
+		}
+	}
+
+	for i, s := range segments {
+		if s.baseOffset < opts.FromSegment {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := l.backupSegment(w, s, m.Segments[i].Size); err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(m.Segments[i].NextOffset)
+		}
+	}
+	return nil
+}
+
+func newManifest(segments []*segment) BackupManifest {
+	m := BackupManifest{}
+	for _, s := range segments {
+		m.Segments = append(m.Segments, BackupSegment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			Size:       s.store.size,
+		})
+	}
+	return m
+}
+
+func writeManifest(w io.Writer, m BackupManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := writeUint64s(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// backupSegment는 세그먼트의 스토어를 매니페스트를 만들 때 본 크기 size만큼
+// 쓴다. 그 뒤에 활성 세그먼트에 덧붙인 레코드는 매니페스트에 없으므로 쓰지
+// 않는다. 압축처럼 세그먼트를 고정해 두고 로그 락 없이 복사하므로 w가 느려도
+// 쓰기를 막지 않고, 복사하는 도중에 세그먼트가 닫히거나 지워지지 않는다.
+// 매니페스트를 만든 뒤에 Truncate나 보존 설정으로 지워졌거나 압축으로 다시
+// 쓰였으면 ErrSegmentRemoved를 리턴한다.
+func (l *Log) backupSegment(w io.Writer, s *segment, size uint64) error {
+	if !l.pinSegments(s) {
+		return ErrSegmentRemoved
+	}
+	defer unpinSegments(s)
+	if err := writeUint64s(w, s.baseOffset, size); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, io.NewSectionReader(s.store, 0, int64(size)))
+	return err
+}
+
+func writeUint64s(w io.Writer, vs ...uint64) error {
+	b := make([]byte, 0, len(vs)*lenWidth)
+	for _, v := range vs {
+		b = enc.AppendUint64(b, v)
+	}
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,164 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupResume(t *testing.T) {
+	dir, err := os.MkdirTemp("", "backup-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, len(log.segments))
+
+	var full bytes.Buffer
+	require.NoError(t, log.Backup(context.Background(), &full, BackupOptions{}))
+
+	// 세그먼트 하나를 받은 뒤 취소한다.
+	var partial bytes.Buffer
+	var next uint64
+	ctx, cancel := context.WithCancel(context.Background())
+	err = log.Backup(ctx, &partial, BackupOptions{
+		Progress: func(n uint64) {
+			next = n
+			cancel()
+		},
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, uint64(1), next)
+	require.Less(t, partial.Len(), full.Len())
+
+	err = log.Backup(context.Background(), &partial, BackupOptions{
+		FromSegment: next,
+	})
+	require.NoError(t, err)
+	require.Equal(t, full.Bytes(), partial.Bytes())
+}
+
+func TestBackupMatchesManifest(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// 첫 세그먼트를 쓴 뒤 활성 세그먼트에 레코드를 덧붙인다.
+	var buf bytes.Buffer
+	err = log.Backup(context.Background(), &buf, BackupOptions{
+		Progress: func(uint64) {
+			_, err := log.Append(&api_v1.Record{Value: []byte("appended")})
+			require.NoError(t, err)
+		},
+	})
+	require.NoError(t, err)
+
+	b := buf.Bytes()
+	n := enc.Uint64(b)
+	var m BackupManifest
+	require.NoError(t, json.Unmarshal(b[lenWidth:lenWidth+n], &m))
+	b = b[lenWidth+n:]
+	require.Len(t, m.Segments, 2)
+	for _, seg := range m.Segments {
+		require.Equal(t, seg.BaseOffset, enc.Uint64(b))
+		size := enc.Uint64(b[lenWidth:])
+		require.Equal(t, seg.Size, size)
+		b = b[2*lenWidth+size:]
+	}
+	// 매니페스트에 적은 크기만큼만 썼다.
+	require.Empty(t, b)
+}
+
+func TestBackupSegmentDoesNotHoldLogLock(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// 두 번째 세그먼트의 헤더를 쓴 뒤 스토어 데이터를 쓰다가 멈춘다.
+	w := &stallingWriter{writes: 2, started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- log.Backup(context.Background(), w, BackupOptions{FromSegment: 1})
+	}()
+	<-w.started
+
+	// 느린 w에 복사하는 동안에도 추가는 막히지 않는다.
+	appended := make(chan error, 1)
+	go func() {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		appended <- err
+	}()
+	select {
+	case err := <-appended:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		close(w.release)
+		t.Fatal("append blocked while backup was copying")
+	}
+	close(w.release)
+	require.NoError(t, <-done)
+}
+
+func TestBackupRemovedSegment(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// 첫 세그먼트를 쓴 뒤 매니페스트에 있는 다음 세그먼트를 지운다.
+	var buf bytes.Buffer
+	err = log.Backup(context.Background(), &buf, BackupOptions{
+		Progress: func(next uint64) {
+			require.NoError(t, log.Truncate(next))
+		},
+	})
+	require.ErrorIs(t, err, ErrSegmentRemoved)
+}
+
+// stallingWriter는 writes번째 Write에서 started를 닫고 release가 닫힐 때까지
+// 기다린다.
+type stallingWriter struct {
+	bytes.Buffer
+	n, writes int
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func (w *stallingWriter) Write(p []byte) (int, error) {
+	w.n++
+	if w.n == w.writes {
+		close(w.started)
+		<-w.release
+	}
+	return w.Buffer.Write(p)
+}
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"errors"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/compress"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrCodecWithFixedSize = errors.New("compression cannot be used with fixed-size records")
+
+// checkCodec은 설정한 코덱이 등록되어 있고 다른 설정과 함께 쓸 수 있는지 확인한다.
+func (c Config) checkCodec() error {
+	if c.Store.Codec == "" {
+		return nil
+	}
+	if c.Store.FixedRecordSize > 0 {
+		return ErrCodecWithFixedSize
+	}
+	_, _, err := compress.Lookup(c.Store.Codec)
+	return err
+}
+
+// encode는 레코드를 스토어에 쓸 바이트로 만든다.
+func (c Config) encode(record *api_v1.Record) ([]byte, error) {
+	p, err := proto.Marshal(record)
+	if err != nil || c.Store.Codec == "" {
+		return p, err
+	}
+	comp, _, err := compress.Lookup(c.Store.Codec)
+	if err != nil {
+		return nil, err
+	}
+	return comp.Compress(p)
+}
+
+// decode는 encode가 만든 바이트를 레코드로 되돌린다.
+func (c Config) decode(p []byte, record *api_v1.Record) error {
+	if c.Store.Codec != "" {
+		_, decomp, err := compress.Lookup(c.Store.Codec)
+		if err != nil {
+			return err
+		}
+		if p, err = decomp.Decompress(p); err != nil {
+			return err
+		}
+	}
+	return proto.Unmarshal(p, record)
+}
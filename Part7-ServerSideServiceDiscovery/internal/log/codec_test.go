@@ -0,0 +1,88 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/compress"
+	"github.com/stretchr/testify/require"
+)
+
+// reverse는 바이트 순서를 뒤집는 테스트용 코덱이다.
+func reverse(p []byte) ([]byte, error) {
+	out := make([]byte, len(p))
+	for i, b := range p {
+		out[len(p)-1-i] = b
+	}
+	return out, nil
+}
+
+func init() {
+	if err := compress.Register(
+		"reverse",
+		compress.CompressorFunc(reverse),
+		compress.DecompressorFunc(reverse),
+	); err != nil {
+		panic(err)
+	}
+}
+
+func TestLogCodec(t *testing.T) {
+	for _, codec := range []string{"reverse", compress.Gzip} {
+		t.Run(codec, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "codec-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Store.Codec = codec
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			value := bytes.Repeat([]byte("hello world "), 10)
+			for i := uint64(0); i < 3; i++ {
+				off, err := log.Append(&api_v1.Record{Value: value})
+				require.NoError(t, err)
+				require.Equal(t, i, off)
+			}
+			require.NoError(t, log.Close())
+
+			// 스토어에는 코덱을 거친 바이트가 들어간다.
+			b, err := os.ReadFile(filepath.Join(dir, "0.store"))
+			require.NoError(t, err)
+			require.False(t, bytes.Contains(b, value))
+
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			for off := uint64(0); off < 3; off++ {
+				record, err := log.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, off, record.Offset)
+				require.Equal(t, value, record.Value)
+			}
+
+			// 다른 코덱으로는 열 수 없다.
+			_, err = NewLog(dir, Config{})
+			require.ErrorIs(t, err, ErrConfigMismatch)
+		})
+	}
+}
+
+func TestLogUnknownCodec(t *testing.T) {
+	dir, err := os.MkdirTemp("", "codec-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Store.Codec = "lz4"
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, compress.ErrUnknownCodec)
+
+	c.Store.Codec = compress.Gzip
+	c.Store.FixedRecordSize = 8
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrCodecWithFixedSize)
+}
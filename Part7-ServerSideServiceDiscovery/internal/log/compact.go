@@ -0,0 +1,560 @@
+package log
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"go.uber.org/zap"
+)
+
+// compactSuffix는 압축하는 동안 새로 쓰는 세그먼트 파일에 붙는다.
+const compactSuffix = ".compact"
+
+// Compact는 봉인된 세그먼트를 다시 써서 만료된 레코드를 지우고 되찾은 바이트
+// 수를 리턴한다. Compaction.ByKey면 같은 키의 더 최근 레코드가 있는 레코드도
+// 지워서 키마다 마지막 레코드만 남긴다. Compaction.MinSegmentBytes보다 작은
+// 세그먼트가 이어져 있으면 하나로 합친다. 남은 레코드의 오프셋과 순서는 바뀌지
+// 않는다. 활성 세그먼트는 건드리지 않으므로 Append와 함께 돌려도 된다.
+func (l *Log) Compact() (int64, error) {
+	latest, err := l.latestKeys()
+	if err != nil {
+		return 0, err
+	}
+	return l.compact(time.Now(), latest)
+}
+
+func (l *Log) compact(now time.Time, latest map[string]uint64) (int64, error) {
+	// 다시 쓰기 전에 버퍼에 남은 레코드를 파일에 내린다.
+	if err := l.Flush(); err != nil {
+		return 0, err
+	}
+	l.mu.RLock()
+	sealed := slices.Clone(l.segments[:len(l.segments)-1])
+	l.mu.RUnlock()
+
+	var reclaimed int64
+	for _, s := range sealed {
+		n, err := l.compactSegment(s, now, latest)
+		reclaimed += n
+		if err != nil {
+			return reclaimed, err
+		}
+	}
+	for _, run := range l.mergeRuns() {
+		n, err := l.replaceSegments(run, keeper(now, latest), sortedRun(run))
+		reclaimed += n
+		if err != nil {
+			return reclaimed, err
+		}
+	}
+	recordStats(
+		CompactionRuns.M(1),
+		BytesReclaimed.M(reclaimed),
+		LastCompactionReclaimed.M(reclaimed),
+	)
+	return reclaimed, nil
+}
+
+// compactSegment는 s에 지울 레코드가 있을 때만 다시 쓴다. 정렬된
+// 세그먼트는 정렬된 채로 남는다.
+func (l *Log) compactSegment(s *segment, now time.Time, latest map[string]uint64) (int64, error) {
+	if !l.pinSegments(s) {
+		return 0, nil
+	}
+	dead, err := s.deadBytes(now)
+	if err == nil && latest != nil {
+		var sd uint64
+		sd, err = s.supersededBytes(now, latest)
+		// 세어 둔 값이 어긋났으면 훑은 값으로 바로잡는다.
+		s.setSuperseded(sd)
+		dead += sd
+	}
+	unpinSegments(s)
+	if err != nil || dead == 0 {
+		return 0, err
+	}
+	return l.replaceSegment(s, keeper(now, latest), s.keys != nil)
+}
+
+// mergeRuns는 스토어가 Compaction.MinSegmentBytes보다 작은 봉인된 세그먼트가
+// 둘 이상 이어진 곳을 찾아, 합쳐도 MaxStoreBytes와 MaxIndexBytes를 넘지 않도록
+// 앞에서부터 묶는다. MinSegmentBytes가 0이면 아무것도 묶지 않는다.
+func (l *Log) mergeRuns() [][]*segment {
+	min := l.Config.Compaction.MinSegmentBytes
+	if min == 0 {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var runs [][]*segment
+	var run []*segment
+	var storeBytes, indexBytes uint64
+	flush := func() {
+		if len(run) > 1 {
+			runs = append(runs, run)
+		}
+		run, storeBytes, indexBytes = nil, 0, 0
+	}
+	for _, s := range l.segments[:len(l.segments)-1] {
+		if s.store.size >= min {
+			flush()
+			continue
+		}
+		if len(run) > 0 && (storeBytes+s.store.size > l.Config.Segment.MaxStoreBytes ||
+			indexBytes+s.index.size > l.Config.Segment.MaxIndexBytes ||
+			s.nextOffset-run[0].baseOffset > math.MaxUint32) {
+			flush()
+		}
+		run = append(run, s)
+		storeBytes += s.store.size
+		indexBytes += s.index.size
+	}
+	flush()
+	return runs
+}
+
+// sortedRun은 run의 세그먼트가 모두 키 순서로 정렬돼 있는지 본다. 하나라도
+// 아니면 합친 세그먼트는 오프셋 순서로 쓴다.
+func sortedRun(run []*segment) bool {
+	for _, s := range run {
+		if s.keys == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// latestKeys는 Compaction.ByKey일 때 키마다 가장 최근 레코드의 오프셋을
+// 모은다. 활성 세그먼트까지 보므로 봉인된 세그먼트의 레코드가 활성 세그먼트의
+// 레코드로 대체된 것도 안다. 키 맵이 모든 세그먼트를 담고 있으면 훑지 않고
+// 복사한다. ByKey가 아니면 nil을 리턴한다.
+func (l *Log) latestKeys() (map[string]uint64, error) {
+	if !l.Config.Compaction.ByKey || l.Config.Store.FixedRecordSize > 0 {
+		return nil, nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.compactKeys != nil {
+		latest := make(map[string]uint64, len(l.compactKeys))
+		for key, k := range l.compactKeys {
+			latest[key] = k.offset
+		}
+		return latest, nil
+	}
+	if l.keyOffsets != nil && l.keysFrom <= l.segments[0].baseOffset {
+		return maps.Clone(l.keyOffsets), nil
+	}
+	latest := make(map[string]uint64)
+	for _, s := range l.segments {
+		// 정렬된 세그먼트는 키 순서로 훑으므로 오프셋이 큰 것을 남긴다.
+		err := s.scanForward(0, func(_ uint64, _ []byte, record *api_v1.Record) bool {
+			if len(record.Key) == 0 {
+				return true
+			}
+			if off, ok := latest[string(record.Key)]; !ok || record.Offset > off {
+				latest[string(record.Key)] = record.Offset
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// keeper는 압축할 때 남길 레코드를 고른다. now에 만료된 레코드와, latest가
+// 있으면 같은 키의 더 최근 레코드가 있는 레코드를 버린다.
+func keeper(now time.Time, latest map[string]uint64) func(*api_v1.Record) bool {
+	return func(record *api_v1.Record) bool {
+		return !expired(record, now) && !superseded(record, latest)
+	}
+}
+
+func superseded(record *api_v1.Record, latest map[string]uint64) bool {
+	if len(record.Key) == 0 {
+		return false
+	}
+	off, ok := latest[string(record.Key)]
+	return ok && off > record.Offset
+}
+
+// supersededBytes는 만료되지 않았지만 같은 키의 더 최근 레코드가 있는
+// 레코드가 스토어에서 차지하는 바이트 수다.
+func (s *segment) supersededBytes(now time.Time, latest map[string]uint64) (uint64, error) {
+	var n uint64
+	err := s.each(func(_ uint32, p []byte) error {
+		record := &api_v1.Record{}
+		if err := s.config.decode(p, record); err != nil {
+			return err
+		}
+		if !expired(record, now) && superseded(record, latest) {
+			n += s.config.recordWidth(len(p))
+		}
+		return nil
+	})
+	return n, err
+}
+
+// pinSegments는 run의 세그먼트가 모두 아직 로그에 있으면 각각의 pin을 읽기로
+// 잡고 true를 리턴한다. 봉인된 세그먼트는 더 바뀌지 않으므로 잡은 뒤에는 로그
+// 락 없이 읽어도 된다. 다 읽으면 unpinSegments를 불러야 한다.
+func (l *Log) pinSegments(run ...*segment) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return false
+	}
+	for _, s := range run {
+		if !slices.Contains(l.segments, s) {
+			return false
+		}
+	}
+	for _, s := range run {
+		s.pin.RLock()
+	}
+	return true
+}
+
+func unpinSegments(run ...*segment) {
+	for _, s := range run {
+		s.pin.RUnlock()
+	}
+}
+
+// replaceSegment는 s를 keep이 고른 레코드만 남기고 다시 쓴다.
+func (l *Log) replaceSegment(s *segment, keep func(*api_v1.Record) bool, sorted bool) (int64, error) {
+	return l.replaceSegments([]*segment{s}, keep, sorted)
+}
+
+// replaceSegments는 이어진 봉인된 세그먼트 run의 남길 레코드를 run[0]의 베이스
+// 오프셋으로 된 새 세그먼트 하나에 쓰고 run을 그것으로 바꿔 끼운다. 새 파일에
+// 쓰는 동안에는 로그 락을 잡지 않아 Append를 막지 않고, 파일을 바꿔 끼울 때만
+// 쓰기 락을 잡는다. 되찾은 바이트 수를 리턴한다.
+func (l *Log) replaceSegments(run []*segment, keep func(*api_v1.Record) bool, sorted bool) (int64, error) {
+	l.rewriteMu.Lock()
+	defer l.rewriteMu.Unlock()
+	if !l.pinSegments(run...) {
+		return 0, nil
+	}
+	s, last := run[0], run[len(run)-1]
+	var before uint64
+	for _, r := range run {
+		before += r.store.size + r.index.size
+	}
+	names := []string{s.store.Name(), s.index.Name(), s.keysName()}
+	kept, err := l.rewrite(run, keep, sorted)
+	unpinSegments(run...)
+	storage := l.Config.storage()
+	removeTemp := func() {
+		storage.Remove(names[0] + compactSuffix)
+		for _, name := range names[1:] {
+			os.Remove(name + compactSuffix)
+		}
+	}
+	if err != nil {
+		removeTemp()
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := slices.Index(l.segments, s)
+	if i < 0 || l.closed || i+len(run) > len(l.segments) || !slices.Equal(l.segments[i:i+len(run)], run) {
+		// 그 사이에 Truncate 등으로 세그먼트가 없어졌거나 로그를 닫았다.
+		removeTemp()
+		return 0, nil
+	}
+	// 다시 써도 보존 기간은 원래 스토어에 마지막으로 쓴 때부터 잰다. 합칠 때는
+	// 가장 최근에 쓴 스토어의 시각을 쓴다.
+	var modTime time.Time
+	hasModTime := true
+	for _, r := range run {
+		m, ok := r.store.backend.(modTimer)
+		if !ok {
+			hasModTime = false
+			break
+		}
+		t, err := m.ModTime()
+		if err != nil {
+			return 0, err
+		}
+		if t.After(modTime) {
+			modTime = t
+		}
+	}
+	// 지운 레코드를 캐시에서 계속 읽지 않도록 세그먼트 범위를 비운다.
+	l.readCache.drop(s.baseOffset, last.nextOffset)
+	if len(kept) == 0 {
+		// 남은 레코드가 없으면 세그먼트를 통째로 지운다. 뒤에서부터 지우므로
+		// 중간에 멈춰도 지울 레코드가 남을 뿐 잃는 레코드는 없다.
+		removeTemp()
+		for j := len(run) - 1; j >= 0; j-- {
+			if err := run[j].Remove(); err != nil {
+				return 0, l.reopenRun(i, run, err)
+			}
+		}
+		l.segments = slices.Delete(l.segments, i, i+len(run))
+		recordStats(SegmentsDeleted.M(int64(len(run))))
+		return int64(before), nil
+	}
+	ns, err := l.swapRun(run, names, sorted)
+	if err != nil {
+		return 0, l.reopenRun(i, run, err)
+	}
+	l.segments = slices.Replace(l.segments, i, i+len(run), ns)
+	ns.loadStats(kept, l.keptSuperseded(ns.baseOffset, kept))
+	if len(run) > 1 {
+		recordStats(SegmentsDeleted.M(int64(len(run) - 1)))
+	}
+	if hasModTime {
+		if err := ns.store.backend.(modTimer).SetModTime(modTime); err != nil {
+			return 0, err
+		}
+	}
+	return int64(before) - int64(ns.store.size+ns.index.size), nil
+}
+
+// swapRun은 run을 닫고 rewrite가 쓴 파일을 run[0]의 이름으로 옮긴 뒤 합쳐진
+// run[1:]의 파일을 지우고 새 세그먼트를 연다. 옮기기 전에 멈추면 예전 파일이
+// 그대로 남고, 옮긴 뒤 지우다 멈추면 남은 세그먼트는 합친 세그먼트의 범위
+// 안에 있어서 다시 열 때 setup이 지운다. 어느 쪽이든 레코드를 잃지 않는다.
+func (l *Log) swapRun(run []*segment, names []string, sorted bool) (*segment, error) {
+	storage := l.Config.storage()
+	for _, r := range run {
+		if err := r.Close(); err != nil {
+			return nil, err
+		}
+		// 지운 레코드나 키 순서로 옮긴 레코드를 가리킬 수 있으니 시간 인덱스는
+		// 버린다. 다시 연 세그먼트는 오프셋 인덱스로 시각을 찾는다.
+		if err := removeFile(r.timesName()); err != nil {
+			return nil, err
+		}
+	}
+	if !sorted {
+		// 정렬하지 않고 다시 쓴 스토어에는 예전 키 인덱스가 맞지 않는다.
+		if err := removeFile(names[2]); err != nil {
+			return nil, err
+		}
+		names = names[:2]
+	}
+	if err := storage.Rename(names[0]+compactSuffix, names[0]); err != nil {
+		return nil, err
+	}
+	for _, name := range names[1:] {
+		if err := os.Rename(name+compactSuffix, name); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range run[1:] {
+		if err := storage.Remove(r.store.Name()); err != nil {
+			return nil, err
+		}
+		if err := removeFile(r.index.Name()); err != nil {
+			return nil, err
+		}
+		if err := removeFile(r.keysName()); err != nil {
+			return nil, err
+		}
+	}
+	return newSegment(l.Dir, run[0].baseOffset, l.Config)
+}
+
+// reopenRun은 run을 바꿔 끼우다 err로 실패했을 때 디스크에 남은 파일로
+// l.segments[i:]의 run 자리를 다시 채워 닫힌 세그먼트가 남지 않게 한다.
+// 로그를 다시 열 때처럼 앞 세그먼트의 범위 안에 있는 세그먼트는 버린다.
+func (l *Log) reopenRun(i int, run []*segment, err error) error {
+	bases, berr := l.baseOffsets()
+	if berr != nil {
+		l.segments = slices.Delete(l.segments, i, i+len(run))
+		return errors.Join(err, berr)
+	}
+	var segs []*segment
+	for _, base := range bases {
+		if base < run[0].baseOffset || base > run[len(run)-1].baseOffset {
+			continue
+		}
+		ns, nerr := newSegment(l.Dir, base, l.Config)
+		if nerr != nil {
+			err = errors.Join(err, nerr)
+			continue
+		}
+		if n := len(segs); n > 0 && base < segs[n-1].nextOffset {
+			ns.Close()
+			continue
+		}
+		segs = append(segs, ns)
+	}
+	l.segments = slices.Replace(l.segments, i, i+len(run), segs...)
+	// 다시 연 세그먼트의 대체된 바이트는 다음에 다시 센다.
+	l.compactKeys = nil
+	return err
+}
+
+// keptRecord는 rewrite가 새 파일에 쓸 레코드다.
+type keptRecord struct {
+	rel      uint32
+	key      []byte
+	expireAt int64
+	p        []byte
+}
+
+// rewrite는 run에서 keep이 고른 레코드만 run[0]의 베이스 오프셋에서의 상대
+// 오프셋으로 새 스토어와 인덱스 파일에 쓰고 남긴 레코드를 오프셋 순서로
+// 리턴한다. 오프셋은
+// 바뀌지 않는다. sorted면 스토어에 키 순서로 쓰고 키 인덱스도 만든다. 인덱스는
+// 어느 쪽이든 오프셋 순서다.
+func (l *Log) rewrite(run []*segment, keep func(*api_v1.Record) bool, sorted bool) ([]keptRecord, error) {
+	s := run[0]
+	var kept []keptRecord
+	for _, r := range run {
+		shift := uint32(r.baseOffset - s.baseOffset)
+		err := r.each(func(rel uint32, p []byte) error {
+			record := &api_v1.Record{}
+			if err := l.Config.decode(p, record); err != nil {
+				return err
+			}
+			if keep(record) {
+				kept = append(kept, keptRecord{rel: shift + rel, key: record.Key, expireAt: record.ExpireAt, p: p})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(r.store.Name()), err)
+		}
+	}
+	if sorted {
+		// 인덱스를 오프셋 순서로 읽었으므로 같은 키는 오프셋 순서로 남는다.
+		slices.SortStableFunc(kept, func(a, b keptRecord) int {
+			return bytes.Compare(a.key, b.key)
+		})
+	}
+
+	backend, err := l.Config.storage().Open(s.store.Name() + compactSuffix)
+	if err != nil {
+		return nil, err
+	}
+	// 지난번 압축이 남긴 파일이 있을 수 있다.
+	if err := backend.Truncate(0); err != nil {
+		backend.Close()
+		return nil, err
+	}
+	st, err := newStore(backend, l.Config)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	defer st.Close()
+	indexFile, err := os.OpenFile(s.index.Name()+compactSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := newIndex(indexFile, l.Config)
+	if err != nil {
+		indexFile.Close()
+		return nil, err
+	}
+	defer idx.Close()
+
+	interval := l.Config.Segment.KeyIndexInterval
+	if interval <= 0 {
+		interval = defaultKeyIndexInterval
+	}
+	positions := make(map[uint32]uint64, len(kept))
+	var keys []keyEntry
+	for i, k := range kept {
+		_, pos, err := st.Append(k.p)
+		if err != nil {
+			return nil, err
+		}
+		positions[k.rel] = pos
+		if sorted && i%interval == 0 {
+			keys = append(keys, keyEntry{key: k.key, pos: pos})
+		}
+	}
+	if sorted {
+		if err := writeKeyIndex(s.keysName()+compactSuffix, keys); err != nil {
+			return nil, err
+		}
+		slices.SortFunc(kept, func(a, b keptRecord) int {
+			return cmp.Compare(a.rel, b.rel)
+		})
+	}
+	var indexed uint64
+	for i, k := range kept {
+		pos := positions[k.rel]
+		if !l.Config.indexes(i == 0, indexed, pos) {
+			continue
+		}
+		if err := idx.Write(k.rel, pos); err != nil {
+			return nil, err
+		}
+		indexed = pos
+	}
+	return kept, nil
+}
+
+// maybeCompact는 봉인된 세그먼트에서 되찾을 수 있는 바이트가 전체 스토어
+// 크기의 Compaction.DeadRatio 이상일 때만 압축하고, 압축했는지 리턴한다.
+// 레코드를 훑지 않고 세그먼트마다 세어 둔 만료된 바이트와 대체된 바이트로
+// 잰다. 작은 세그먼트를 합치면 세그먼트마다 MaxIndexBytes 크기로 열어 둔
+// 인덱스 파일이 없어지므로 그만큼을 되찾을 바이트로 센다.
+func (l *Log) maybeCompact(now time.Time) (bool, error) {
+	if l.Config.Compaction.ByKey && l.Config.Store.FixedRecordSize == 0 {
+		if err := l.loadSuperseded(); err != nil {
+			return false, err
+		}
+	}
+	l.mu.RLock()
+	segments := slices.Clone(l.segments)
+	var total uint64
+	for _, s := range segments {
+		total += s.store.size
+	}
+	l.mu.RUnlock()
+	if len(segments) == 0 {
+		return false, nil
+	}
+
+	var reclaimable uint64
+	for _, s := range segments[:len(segments)-1] {
+		// 만료된 바이트를 처음 셀 때는 스토어를 훑으므로 로그 락 없이 센다.
+		if !l.pinSegments(s) {
+			continue
+		}
+		dead, err := s.deadBytes(now)
+		unpinSegments(s)
+		if err != nil {
+			return false, err
+		}
+		reclaimable += dead + s.supersededEstimate()
+	}
+	for _, run := range l.mergeRuns() {
+		reclaimable += uint64(len(run)-1) * l.Config.Segment.MaxIndexBytes
+	}
+	if reclaimable == 0 || float64(reclaimable) < l.Config.Compaction.DeadRatio*float64(total) {
+		return false, nil
+	}
+	latest, err := l.latestKeys()
+	if err != nil {
+		return false, err
+	}
+	_, err = l.compact(now, latest)
+	return true, err
+}
+
+// compactIfNeeded는 자동 압축 주기마다 불린다.
+func (l *Log) compactIfNeeded() {
+	if _, err := l.maybeCompact(time.Now()); err != nil {
+		zap.L().Named("log").Error("compaction failed", zap.String("dir", l.Dir), zap.Error(err))
+	}
+}
@@ -0,0 +1,525 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestLogCompact(t *testing.T) {
+	require.NoError(t, view.Register(Views...))
+
+	dir, err := os.MkdirTemp("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 2 * entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	past := time.Now().Add(-time.Hour).UnixNano()
+	// 세그먼트 0: 만료 + 살아 있음, 세그먼트 2: 둘 다 만료,
+	// 세그먼트 4(활성): 만료 + 살아 있음
+	expiring := map[uint64]bool{0: true, 2: true, 3: true, 4: true}
+	for off := uint64(0); off < 6; off++ {
+		record := &api_v1.Record{Value: []byte("hello world")}
+		if expiring[off] {
+			record.ExpireAt = past
+		}
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+	runs := viewSum(t, "proglog/log/compaction_runs")
+
+	reclaimed, err := log.Compact()
+	require.NoError(t, err)
+	require.Positive(t, reclaimed)
+	require.Equal(t, runs+1, viewSum(t, "proglog/log/compaction_runs"))
+	rows, err := view.RetrieveData("proglog/log/last_compaction_reclaimed_bytes")
+	require.NoError(t, err)
+	require.Equal(t, float64(reclaimed), rows[0].Data.(*view.LastValueData).Value)
+	// 레코드가 모두 지워진 세그먼트는 없어진다.
+	require.Len(t, log.segments, 2)
+
+	for off := uint64(0); off < 6; off++ {
+		record, err := log.Read(off)
+		switch {
+		case off == 4:
+			// 활성 세그먼트는 압축하지 않는다.
+			require.IsType(t, api_v1.ErrRecordExpired{}, err)
+		case expiring[off]:
+			require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+		default:
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+		}
+	}
+
+	// 더 지울 것이 없으면 아무것도 되찾지 않는다.
+	reclaimed, err = log.Compact()
+	require.NoError(t, err)
+	require.Zero(t, reclaimed)
+
+	// 다시 열어도 오프셋은 그대로다.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	record, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Offset)
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+}
+
+func TestLogCompactionScheduler(t *testing.T) {
+	dir, err := os.MkdirTemp("", "compaction-scheduler-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Compaction.DeadRatio = 0.5
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	now := time.Now()
+	expireAt := now.Add(time.Hour)
+	later := expireAt.Add(time.Hour)
+	append := func(n int, expire bool) {
+		for i := 0; i < n; i++ {
+			record := &api_v1.Record{Value: []byte("hello world")}
+			if expire {
+				record.ExpireAt = expireAt.UnixNano()
+			}
+			_, err := log.Append(record)
+			require.NoError(t, err)
+		}
+	}
+
+	// 만료된 레코드가 전체의 1/4이면 압축하지 않는다.
+	append(1, true)
+	append(3, false)
+	compacted, err := log.maybeCompact(later)
+	require.NoError(t, err)
+	require.False(t, compacted)
+	_, err = log.Read(0)
+	require.NoError(t, err)
+
+	// 아직 만료되지 않았으면 비율이 높아도 압축하지 않는다.
+	append(5, true)
+	compacted, err = log.maybeCompact(now)
+	require.NoError(t, err)
+	require.False(t, compacted)
+
+	// 만료된 레코드가 절반을 넘으면 압축한다.
+	compacted, err = log.maybeCompact(later)
+	require.NoError(t, err)
+	require.True(t, compacted)
+	_, err = log.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	_, err = log.Read(1)
+	require.NoError(t, err)
+}
+
+func TestLogCompactMergesTinySegments(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxIndexBytes = 4 * entWidth
+	c.Compaction.MinSegmentBytes = 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer func() { log.Close() }()
+
+	past := time.Now().Add(-time.Hour).UnixNano()
+	// 세그먼트 0과 4는 첫 레코드만 남고, 8은 활성 세그먼트다.
+	expiring := func(off uint64) bool { return off < 8 && off%4 != 0 }
+	for off := uint64(0); off < 10; off++ {
+		record := &api_v1.Record{Value: []byte("hello world")}
+		if expiring(off) {
+			record.ExpireAt = past
+		}
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	reclaimed, err := log.Compact()
+	require.NoError(t, err)
+	require.Positive(t, reclaimed)
+	// 압축으로 작아진 0번과 4번 세그먼트는 0번 하나로 합쳐진다.
+	require.Len(t, log.segments, 2)
+	require.Equal(t, uint64(0), log.segments[0].baseOffset)
+	_, err = os.Stat(filepath.Join(dir, "4.store"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	check := func() {
+		for off := uint64(0); off < 10; off++ {
+			record, err := log.Read(off)
+			if expiring(off) {
+				require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+		}
+	}
+	check()
+
+	// 다시 열어도 오프셋은 그대로다.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Len(t, log.segments, 2)
+	check()
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+}
+
+func TestLogCompactionSchedulerMergesTinySegments(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// 세그먼트 크기를 늘려 다시 열어도 MinSegmentBytes가 없으면 합치지 않는다.
+	c.Segment.MaxIndexBytes = 4 * entWidth
+	c.Compaction.DeadRatio = 0.5
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	compacted, err := log.maybeCompact(time.Now())
+	require.NoError(t, err)
+	require.False(t, compacted)
+	require.Len(t, log.segments, 4)
+	require.NoError(t, log.Close())
+
+	// 합쳐서 되찾을 인덱스 파일이 DeadRatio에 못 미치면 합치지 않는다.
+	c.Compaction.MinSegmentBytes = 1024
+	c.Compaction.DeadRatio = 1
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	compacted, err = log.maybeCompact(time.Now())
+	require.NoError(t, err)
+	require.False(t, compacted)
+	require.Len(t, log.segments, 4)
+	require.NoError(t, log.Close())
+
+	// 지울 레코드가 없어도 작은 세그먼트를 합쳐 되찾을 바이트가 충분하면
+	// 압축해서 합친다.
+	c.Compaction.DeadRatio = 0.5
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	compacted, err = log.maybeCompact(time.Now())
+	require.NoError(t, err)
+	require.True(t, compacted)
+	require.Len(t, log.segments, 2)
+	for off := uint64(0); off < 4; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+	}
+
+	// 더 합칠 것이 없으면 압축하지 않는다.
+	compacted, err = log.maybeCompact(time.Now())
+	require.NoError(t, err)
+	require.False(t, compacted)
+}
+
+// failingStorage는 fail이 에러를 리턴하는 이름의 Rename과 Remove를 실패시킨다.
+type failingStorage struct {
+	StoreStorage
+	fail func(op, name string) error
+}
+
+func (s failingStorage) Rename(oldName, newName string) error {
+	if err := s.fail("rename", filepath.Base(oldName)); err != nil {
+		return err
+	}
+	return s.StoreStorage.Rename(oldName, newName)
+}
+
+func (s failingStorage) Remove(name string) error {
+	if err := s.fail("remove", filepath.Base(name)); err != nil {
+		return err
+	}
+	return s.StoreStorage.Remove(name)
+}
+
+func TestLogCompactMergeFailsMidway(t *testing.T) {
+	for scenario, failing := range map[string]struct {
+		op, name string
+		segments int
+	}{
+		// 합친 파일을 옮기기 전이면 예전 세그먼트가 그대로 남는다.
+		"rename": {op: "rename", name: "0.store" + compactSuffix, segments: 4},
+		// 옮긴 뒤면 남은 세그먼트를 다시 열 때 지운다.
+		"remove": {op: "remove", name: "1.store", segments: 2},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxIndexBytes = entWidth
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < 4; i++ {
+				_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			require.NoError(t, log.Close())
+
+			errFail := fmt.Errorf("%s failed", failing.op)
+			storage := failingStorage{StoreStorage: fileStorage{}}
+			storage.fail = func(op, name string) error {
+				if op == failing.op && name == failing.name {
+					return errFail
+				}
+				return nil
+			}
+			c.Store.Storage = storage
+			c.Segment.MaxIndexBytes = 4 * entWidth
+			c.Compaction.MinSegmentBytes = 1024
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			_, err = log.Compact()
+			require.ErrorIs(t, err, errFail)
+
+			check := func() {
+				for off := uint64(0); off < 4; off++ {
+					record, err := log.Read(off)
+					require.NoError(t, err)
+					require.Equal(t, off, record.Offset)
+				}
+			}
+			// 실패한 뒤에도 닫힌 세그먼트가 남지 않는다.
+			check()
+			require.NoError(t, log.Close())
+
+			storage.fail = func(string, string) error { return nil }
+			c.Store.Storage = storage
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			require.Len(t, log.segments, failing.segments)
+			check()
+			off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(4), off)
+		})
+	}
+}
+
+func TestLogMaybeCompactCountsSupersededBytes(t *testing.T) {
+	var stall sync.Mutex
+	c := Config{}
+	c.Segment.MaxIndexBytes = 2 * entWidth
+	c.Store.Storage = readStallingStorage{StoreStorage: NewMemoryStorage(), name: "0.store", mu: &stall}
+	c.Compaction.ByKey = true
+	c.Compaction.DeadRatio = 0.3
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	append := func(key string) {
+		_, err := log.Append(&api_v1.Record{Key: []byte(key), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		append(key)
+	}
+	compacted, err := log.maybeCompact(time.Now())
+	require.NoError(t, err)
+	require.False(t, compacted)
+	// 0번 레코드의 크기는 1번 레코드의 위치다.
+	width, err := log.segments[0].position(1)
+	require.NoError(t, err)
+
+	// 한 번 센 뒤로는 봉인된 세그먼트를 읽지 않고 Append가 센 값으로 잰다.
+	stall.Lock()
+	release := sync.OnceFunc(stall.Unlock)
+	defer release()
+	done := make(chan bool, 1)
+	go func() {
+		append("a")
+		compacted, err := log.maybeCompact(time.Now())
+		require.NoError(t, err)
+		done <- compacted
+	}()
+	select {
+	case compacted := <-done:
+		require.False(t, compacted)
+	case <-time.After(time.Second):
+		t.Fatal("maybeCompact read a sealed store")
+	}
+	require.Equal(t, width, log.segments[0].supersededEstimate())
+	release()
+
+	// 대체된 바이트가 DeadRatio를 넘으면 압축한다.
+	append("b")
+	compacted, err = log.maybeCompact(time.Now())
+	require.NoError(t, err)
+	require.True(t, compacted)
+	_, err = log.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	for _, s := range log.segments {
+		require.Zero(t, s.supersededEstimate())
+	}
+}
+
+func TestLogCompactByKey(t *testing.T) {
+	for scenario, fn := range map[string]func(*Config){
+		"by key":      func(c *Config) {},
+		"sort by key": func(c *Config) { c.Segment.SortByKey = true },
+		"key map":     func(c *Config) { c.KeyMap.Enabled = true },
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxIndexBytes = 3 * entWidth
+			c.Compaction.ByKey = true
+			fn(&c)
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			// 세그먼트 0: a b a, 세그먼트 3: (키 없음) b c, 세그먼트 6(활성): a a
+			keys := []string{"a", "b", "a", "", "b", "c", "a", "a"}
+			for i, key := range keys {
+				record := &api_v1.Record{Value: []byte(fmt.Sprintf("value %d", i))}
+				if key != "" {
+					record.Key = []byte(key)
+				}
+				_, err := log.Append(record)
+				require.NoError(t, err)
+			}
+			log.sorting.Wait()
+
+			reclaimed, err := log.Compact()
+			require.NoError(t, err)
+			require.Positive(t, reclaimed)
+
+			// 봉인된 세그먼트에는 키마다 마지막 레코드만 남고 오프셋은 그대로다.
+			// 활성 세그먼트는 압축하지 않는다.
+			live := map[uint64]bool{3: true, 4: true, 5: true, 6: true, 7: true}
+			for off := range keys {
+				record, err := log.Read(uint64(off))
+				if !live[uint64(off)] {
+					require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err, "offset %d", off)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, uint64(off), record.Offset)
+				require.Equal(t, []byte(fmt.Sprintf("value %d", off)), record.Value)
+			}
+			require.Len(t, log.segments, 2)
+
+			// 새 레코드는 이어서 쓰인다.
+			off, err := log.Append(&api_v1.Record{Key: []byte("b"), Value: []byte("newest")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(keys)), off)
+		})
+	}
+}
+
+// readStallingStorage는 이름이 name인 스토어의 읽기를 mu를 잡고 있는 동안 멈춘다.
+type readStallingStorage struct {
+	StoreStorage
+	name string
+	mu   *sync.Mutex
+}
+
+func (s readStallingStorage) Open(name string) (StoreBackend, error) {
+	b, err := s.StoreStorage.Open(name)
+	if err != nil || filepath.Base(name) != s.name {
+		return b, err
+	}
+	return readStallingBackend{StoreBackend: b, mu: s.mu}, nil
+}
+
+type readStallingBackend struct {
+	StoreBackend
+	mu *sync.Mutex
+}
+
+func (b readStallingBackend) Name() string {
+	return b.StoreBackend.(interface{ Name() string }).Name()
+}
+
+func (b readStallingBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.StoreBackend.ReadAt(p, off)
+}
+
+func TestLogCompactDoesNotBlockAppend(t *testing.T) {
+	var stall sync.Mutex
+	c := Config{}
+	c.Segment.MaxIndexBytes = 2 * entWidth
+	c.Store.Storage = readStallingStorage{StoreStorage: NewMemoryStorage(), name: "0.store", mu: &stall}
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	past := time.Now().Add(-time.Hour).UnixNano()
+	for i := 0; i < 3; i++ {
+		record := &api_v1.Record{Value: []byte("hello world")}
+		if i == 0 {
+			record.ExpireAt = past
+		}
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	// 세그먼트 0을 읽는 동안 압축을 멈춰 둔다.
+	stall.Lock()
+	release := sync.OnceFunc(stall.Unlock)
+	defer release()
+	compacted := make(chan int64, 1)
+	go func() {
+		reclaimed, err := log.Compact()
+		require.NoError(t, err)
+		compacted <- reclaimed
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// 압축이 봉인된 세그먼트를 다시 쓰는 동안에도 Append는 기다리지 않는다.
+	appended := make(chan error, 1)
+	go func() {
+		_, err := log.Append(&api_v1.Record{Value: []byte("during compaction")})
+		appended <- err
+	}()
+	select {
+	case err := <-appended:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("append waited for compaction")
+	}
+	select {
+	case <-compacted:
+		t.Fatal("compaction finished while the store was stalled")
+	default:
+	}
+
+	release()
+	require.Positive(t, <-compacted)
+	_, err = log.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	record, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, []byte("during compaction"), record.Value)
+}
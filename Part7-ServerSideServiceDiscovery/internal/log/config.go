@@ -1,9 +1,144 @@
 package log
 
+import "time"
+
 type Config struct {
+	// OffsetAllocator는 새 레코드의 오프셋을 정한다. 없으면 차례대로 준다.
+	// meta.json에 남으므로 로그를 만든 뒤에는 바꿔서 열 수 없다.
+	OffsetAllocator OffsetAllocator
+	// SyncInterval마다 Sync를 불러 그때까지 쓴 레코드를 디스크에 남긴다.
+	// 0이면 세그먼트를 닫거나 Sync를 직접 부를 때만 동기화한다.
+	SyncInterval time.Duration
+	// ReadCacheSize가 0보다 크면 최근에 읽은 레코드를 인코딩 크기 합이 이
+	// 바이트를 넘지 않을 만큼 메모리에 두고 같은 오프셋을 다시 읽을 때 스토어를
+	// 읽지 않는다. 세그먼트를 지우거나 압축하면 그 범위를 비운다. 캐시한 레코드는
+	// 여러 Read가 나눠 가지므로 고치면 안 된다.
+	ReadCacheSize uint64
+
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// RollTimeout은 새 세그먼트를 만들지 못했을 때 Append가 다시 시도하며
+		// 기다리는 최대 시간이다. 넘기면 ErrSegmentRolling을 리턴한다.
+		RollTimeout time.Duration
+		// HealOnOpen이 켜져 있으면 NewLog가 세그먼트를 열기 전에 Heal과 같은
+		// 복구를 한다.
+		HealOnOpen bool
+		// SortByKey면 봉인된 세그먼트를 레코드 키 순서로 다시 쓰고, 키
+		// KeyIndexInterval(기본 16)개마다 하나씩 위치를 적은 키 인덱스를
+		// 만든다. 키 범위로 훑거나 키로 찾을 때 쓴다. 오프셋으로 읽는 것은
+		// 그대로 된다. FixedRecordSize와 함께 쓸 수 없다.
+		SortByKey        bool
+		KeyIndexInterval int
+		// IndexStride가 1보다 크면 인덱스에 레코드마다 항목을 넣지 않고 스토어
+		// IndexStride 바이트마다 하나만 넣는다. 작은 레코드가 많을 때 인덱스
+		// 크기가 줄어드는 대신, 항목이 없는 레코드는 가장 가까운 앞 항목부터
+		// 스토어를 훑어 찾는다. 로그를 만든 뒤에는 바꿀 수 없고 FixedRecordSize,
+		// SortByKey와 함께 쓸 수 없다.
+		IndexStride uint64
+		// IndexPositionWidth는 인덱스 항목에서 스토어 위치가 차지하는 바이트
+		// 수로 8이나 4다. 0이면 8이라서 4GiB가 넘는 스토어도 가리킨다. 4면
+		// 인덱스가 3분의 2로 줄지만 MaxStoreBytes가 4GiB를 넘을 수 없다. 로그를
+		// 만든 뒤에는 바꿀 수 없고, 다른 폭으로 만든 로그를 열면 ErrIndexWidth를
+		// 리턴한다.
+		IndexPositionWidth int
+		// TimeIndexInterval이 0보다 크면 세그먼트마다 .timeindex 파일을 두고
+		// 레코드 TimeIndexInterval개마다 하나씩 시각과 오프셋을 적는다.
+		// OffsetForTime은 이 파일을 이진 탐색한 뒤 스토어를 앞으로 훑는다.
+		// 시각이 마지막 항목보다 이른 레코드는 적지 않는다. 켜기 전에 쓴
+		// 세그먼트나 압축한 세그먼트는 오프셋 인덱스로 찾는다.
+		TimeIndexInterval int
+	}
+	Store struct {
+		// ReadAt이 아무것도 읽지 못하고 돌아왔을 때 다시 시도할 횟수와
+		// 첫 대기 시간. 대기 시간은 시도할 때마다 두 배로 늘어난다.
+		ReadRetries int
+		ReadBackoff time.Duration
+		// FixedRecordSize가 0보다 크면 모든 레코드의 값이 이 크기라고 보고
+		// 길이 정보 없이 값만 저장한다. 크기가 다른 레코드는 거부한다.
+		FixedRecordSize int
+		// 버퍼에 쌓인 바이트가 FlushBytes 이상이 되면 바로 파일에 쓰고, 그보다
+		// 적으면 첫 바이트가 쌓인 뒤 FlushLatency 안에 쓴다. 몰려드는 쓰기는
+		// 한 번에 모아 쓰고 드문 쓰기도 오래 버퍼에 머물지 않는다.
+		// 0이면 버퍼가 찼을 때와 읽을 때만 쓴다.
+		FlushBytes   int
+		FlushLatency time.Duration
+		// Codec은 레코드를 스토어에 쓰기 전에 압축할 코덱의 이름이다. 코덱은
+		// compress.Default에서 찾는다. 비어 있으면 압축하지 않는다. 로그를
+		// 만든 뒤에는 바꿀 수 없고 FixedRecordSize와 함께 쓸 수 없다.
+		Codec string
+		// Direct면 스토어 파일에 O_DIRECT로 페이지 캐시를 거치지 않고 쓴다.
+		// 큰 레코드를 계속 쓸 때 페이지 캐시가 밀려나지 않는다. 읽기는
+		// 그대로 페이지 캐시를 거친다. 리눅스에서만 쓸 수 있다.
+		Direct bool
+		// Checksum이면 레코드마다 길이 뒤에 값의 CRC32(Castagnoli)를 붙이고
+		// 읽을 때 확인해서 맞지 않으면 ErrCorruptRecord를 리턴한다. 체크섬 없이
+		// 만든 로그는 끈 채로 읽어야 한다. 로그를 만든 뒤에는 바꿀 수 없고
+		// FixedRecordSize와 함께 쓸 수 없다.
+		Checksum bool
+		// Sync는 스토어 파일을 디스크에 동기화할 시점이다. 기본값 SyncNever면
+		// 스토어가 스스로 동기화하지 않고 SyncInterval이나 Sync, Close에
+		// 맡긴다. SyncEveryWrite면 Append마다 버퍼를 비우고 동기화하며,
+		// SyncInterval(d)면 스토어마다 d 간격으로 동기화한다.
+		Sync SyncPolicy
+		// MmapReads면 스토어 파일을 메모리에 읽기 전용으로 매핑해 두고 매핑한
+		// 범위는 시스템 콜 없이 메모리에서 읽는다. 매핑한 뒤로 쓴 꼬리는
+		// 파일에서 읽다가 1MiB 이상 커지면 다시 매핑한다.
+		MmapReads bool
+		// MaxRecordSize가 0보다 크면 스토어에 쓸 바이트(압축했으면 압축한
+		// 크기)가 이보다 큰 레코드를 버퍼에 넣기 전에 ErrRecordTooLarge로
+		// 거부한다. 0이면 크기를 제한하지 않는다.
+		MaxRecordSize uint64
+		// Storage는 스토어를 둘 곳이다. nil이면 로그 디렉터리의 파일에 둔다.
+		// 인덱스와 meta.json은 어느 쪽이든 로그 디렉터리에 쓴다. Direct,
+		// MmapReads, Segment.HealOnOpen은 파일이 있어야 하므로 함께 쓸 수 없다.
+		Storage StoreStorage
+	}
+	Compaction struct {
+		// Interval마다 봉인된 세그먼트에서 되찾을 수 있는 바이트가 전체
+		// 스토어 크기에서 차지하는 비율을 보고, DeadRatio 이상이면 Compact를
+		// 돌린다. 레코드를 훑지 않고 세그먼트마다 세어 둔 값으로 잰다.
+		// Interval이 0이면 자동으로 돌리지 않는다.
+		Interval  time.Duration
+		DeadRatio float64
+		// ByKey면 압축할 때 같은 키의 더 최근 레코드가 있는 레코드도 지워서
+		// 키마다 마지막 레코드만 남긴다. 키가 없는 레코드는 그대로 둔다.
+		// 지운 레코드도 DeadRatio를 잴 때 센다.
+		ByKey bool
+		// MinSegmentBytes가 0보다 크면 스토어가 이보다 작은 봉인된 세그먼트가
+		// 이어져 있을 때 MaxStoreBytes와 MaxIndexBytes를 넘지 않는 만큼 하나로
+		// 합친다. 압축으로 작아진 세그먼트가 파일과 mmap을 차지하며 쌓이지
+		// 않게 한다. 합치면 없어지는 세그먼트마다 MaxIndexBytes 크기의 인덱스
+		// 파일을 되찾는 것으로 보고 DeadRatio와 비교한다.
+		MinSegmentBytes uint64
+	}
+	Retention struct {
+		// MaxBytes가 0보다 크면 세그먼트의 스토어와 인덱스 크기 합이 넘지
+		// 않도록 가장 오래된 세그먼트부터 지운다. MaxAge가 0보다 크면 마지막으로
+		// 쓴 지 MaxAge가 지난 세그먼트를 오래된 것부터 지운다. 활성 세그먼트는
+		// 지우지 않는다. Interval(기본 1분)마다 확인한다.
+		MaxBytes uint64
+		MaxAge   time.Duration
+		Interval time.Duration
+	}
+	KeyMap struct {
+		// Enabled면 키마다 가장 최근 레코드의 오프셋을 메모리에 두어
+		// OffsetForKey가 스토어를 훑지 않고 찾는다. NewLog는 최근 세그먼트부터
+		// 스토어 크기 합이 RebuildBytes(기본 64MiB)를 넘지 않는 세그먼트까지만
+		// 훑어 맵을 다시 만들므로 여는 시간이 로그 크기에 비례하지 않는다. 맵에
+		// 없는 키는 그보다 오래된 세그먼트를 훑어 찾는다. FixedRecordSize와 함께
+		// 쓸 수 없다.
+		Enabled      bool
+		RebuildBytes uint64
+	}
+	PeerFallback struct {
+		// Reader가 있으면 Read가 로컬에 없는 오프셋을 다른 노드에서 읽어 온다.
+		// 격리된 세그먼트나 뒤처진 복제본 때문에 비어 있는 범위도 읽을 수 있다.
+		// 한 번 읽는 데 Timeout(기본 1초)까지 기다리고, 읽어 온 레코드는
+		// 최근 CacheSize(기본 1024)개까지 메모리에 둔다.
+		Reader    PeerReader
+		Timeout   time.Duration
+		CacheSize int
 	}
 }
@@ -0,0 +1,113 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+)
+
+var ErrDirectUnsupported = errors.New("direct store writes are not supported on this platform")
+
+const (
+	// directBlock는 O_DIRECT로 쓰는 파일 위치, 길이, 메모리 주소를 맞출 단위다.
+	directBlock = 4096
+	// directBufferBlocks는 directWriter가 한 번에 쓰는 최대 블록 수다.
+	directBufferBlocks = 256
+)
+
+// directWriter는 Config.Store.Direct일 때 스토어 파일에 페이지 캐시를 거치지
+// 않고 쓴다. O_DIRECT는 블록 단위로만 쓸 수 있으므로 꽉 찬 블록만 바로 쓰고
+// 마지막 블록의 남은 바이트(꼬리)는 메모리에 두었다가 Flush할 때 0으로 채워
+// 쓴 뒤 파일을 실제 크기로 자른다. 꼬리 블록은 다음 쓰기에서 통째로 다시 쓴다.
+type directWriter struct {
+	direct *os.File
+	file   *os.File
+	// buf[:n]은 파일의 off부터 쓸 바이트다. off는 항상 블록 경계다.
+	buf []byte
+	n   int
+	off int64
+	// dirty면 꼬리를 마지막으로 쓴 뒤에 바뀌었다.
+	dirty bool
+}
+
+// newDirectWriter는 f를 O_DIRECT로 한 번 더 열고, 파일이 블록 중간에서
+// 끝나면 그 꼬리를 읽어 둔다. 읽기는 계속 f로 한다.
+func newDirectWriter(f *os.File, size uint64) (*directWriter, error) {
+	direct, err := openDirect(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	w := &directWriter{
+		direct: direct,
+		file:   f,
+		buf:    alignedBuffer(directBufferBlocks * directBlock),
+		off:    int64(size) / directBlock * directBlock,
+	}
+	w.n = int(int64(size) - w.off)
+	if _, err := f.ReadAt(w.buf[:w.n], w.off); err != nil {
+		direct.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// alignedBuffer는 시작 주소가 directBlock 경계인 n 바이트 슬라이스를 만든다.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directBlock)
+	skip := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) % directBlock); rem != 0 {
+		skip = directBlock - rem
+	}
+	return b[skip : skip+n]
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		m := copy(w.buf[w.n:], p)
+		w.n += m
+		p = p[m:]
+		written += m
+		w.dirty = true
+		if err := w.writeBlocks(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// writeBlocks는 buf의 꽉 찬 블록을 쓰고 남은 꼬리를 buf 앞으로 옮긴다.
+func (w *directWriter) writeBlocks() error {
+	full := w.n / directBlock * directBlock
+	if full == 0 {
+		return nil
+	}
+	if _, err := w.direct.WriteAt(w.buf[:full], w.off); err != nil {
+		return err
+	}
+	w.off += int64(full)
+	w.n = copy(w.buf, w.buf[full:w.n])
+	return nil
+}
+
+// Flush는 꼬리 블록을 0으로 채워 쓰고 파일을 실제 크기로 자른다.
+func (w *directWriter) Flush() error {
+	if !w.dirty {
+		return nil
+	}
+	if w.n > 0 {
+		clear(w.buf[w.n:directBlock])
+		if _, err := w.direct.WriteAt(w.buf[:directBlock], w.off); err != nil {
+			return err
+		}
+		if err := w.file.Truncate(w.off + int64(w.n)); err != nil {
+			return err
+		}
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *directWriter) Close() error {
+	return w.direct.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package log
+
+import "os"
+
+func openDirect(name string) (*os.File, error) {
+	return nil, ErrDirectUnsupported
+}
@@ -0,0 +1,159 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+// quarantineDir는 복구할 수 없는 세그먼트를 옮겨 두는 디렉터리다.
+const quarantineDir = "quarantine"
+
+// HealReport는 Heal이 고친 내용이다. 키와 원소는 세그먼트의 베이스 오프셋이다.
+type HealReport struct {
+	// Truncated는 끝에서 잘린 레코드를 잘라낸 세그먼트와 잘라낸 바이트 수다.
+	Truncated map[uint64]uint64
+	// Rebuilt는 인덱스가 없거나 스토어와 맞지 않아 다시 만든 세그먼트다.
+	Rebuilt []uint64
+	// Quarantined는 레코드가 깨져서 quarantine 디렉터리로 옮긴 세그먼트다.
+	Quarantined []uint64
+}
+
+// Heal은 비정상 종료 뒤에 세그먼트의 스토어와 인덱스를 맞춘다.
+// 스토어 끝에 쓰다 만 레코드가 있으면 잘라내고, 인덱스가 없거나 스토어와
+// 맞지 않으면 스토어를 읽어 다시 만들고, 중간의 레코드가 깨진 세그먼트는
+// quarantine 디렉터리로 옮긴다. 고칠 것이 없으면 아무것도 바꾸지 않으므로
+// 여러 번 불러도 된다. 세그먼트를 닫고 고친 뒤 다시 연다.
+// 스토어 파일을 직접 고치므로 Store.Storage를 쓰는 로그면 ErrBackendNotFile을 리턴한다.
+func (l *Log) Heal() (*HealReport, error) {
+	if l.Config.Store.Storage != nil {
+		return nil, ErrBackendNotFile
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.segments {
+		if err := s.Close(); err != nil {
+			return nil, err
+		}
+	}
+	l.segments = nil
+	l.activeSegment = nil
+	l.readCache.reset()
+
+	report, err := l.heal()
+	if err != nil {
+		return nil, err
+	}
+	return report, l.setup()
+}
+
+func (l *Log) heal() (*HealReport, error) {
+	baseOffsets, err := l.baseOffsets()
+	if err != nil {
+		return nil, err
+	}
+	report := &HealReport{Truncated: make(map[uint64]uint64)}
+	for _, base := range baseOffsets {
+		storeName := filepath.Join(l.Dir, fmt.Sprintf("%d.store", base))
+		indexName := filepath.Join(l.Dir, fmt.Sprintf("%d.index", base))
+		keysName := filepath.Join(l.Dir, fmt.Sprintf("%d%s", base, keysExt))
+		timesName := filepath.Join(l.Dir, fmt.Sprintf("%d%s", base, timeIndexExt))
+
+		b, err := os.ReadFile(storeName)
+		if err != nil {
+			return nil, err
+		}
+		_, err = os.Stat(keysName)
+		sorted := err == nil
+		positions, offsets, end, err := l.scan(b, base, sorted)
+		if errors.Is(err, ErrCorruptRecord) {
+			if err := quarantine(l.Dir, storeName, indexName, keysName, timesName); err != nil {
+				return nil, err
+			}
+			report.Quarantined = append(report.Quarantined, base)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if end < uint64(len(b)) {
+			if err := os.Truncate(storeName, int64(end)); err != nil {
+				return nil, err
+			}
+			report.Truncated[base] = uint64(len(b)) - end
+		}
+
+		want := l.Config.indexEntries(base, positions, offsets)
+		got, err := os.ReadFile(indexName)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if !bytes.Equal(got, want) {
+			if err := os.WriteFile(indexName, want, 0644); err != nil {
+				return nil, err
+			}
+			report.Rebuilt = append(report.Rebuilt, base)
+		}
+	}
+	return report, nil
+}
+
+// scan은 스토어 내용에서 온전한 레코드의 위치와 오프셋, 마지막 온전한 레코드가
+// 끝나는 위치를 리턴한다. 끝에 남은 부분은 쓰다 만 레코드로 보고, 길이는 맞는데
+// 읽을 수 없는 레코드가 있으면 ErrCorruptRecord를 리턴한다. 길이가 0이거나
+// 오프셋이 base보다 작거나 앞 레코드보다 크지 않은 레코드도 거기서 끝난 것으로
+// 본다. 비정상 종료로 0으로 채워진 꼬리는 길이가 0인 레코드로 읽히기 때문이다.
+// 키 순서로 정렬된(sorted) 스토어는 오프셋 순서가 아니므로 늘어나는지는 보지
+// 않는다. 고정 크기 모드에서는 레코드에 오프셋이 없으므로 offsets는 nil이다.
+func (l *Log) scan(b []byte, base uint64, sorted bool) (positions, offsets []uint64, end uint64, err error) {
+	size := uint64(len(b))
+	if fixed := uint64(l.Config.Store.FixedRecordSize); fixed > 0 {
+		for end+fixed <= size {
+			positions = append(positions, end)
+			end += fixed
+		}
+		return positions, nil, end, nil
+	}
+	header := l.Config.headerWidth()
+	for end+header <= size {
+		n := enc.Uint64(b[end : end+lenWidth])
+		if n == 0 || n > size-end-header {
+			break
+		}
+		p := b[end+header : end+header+n]
+		if l.Config.Store.Checksum && crc32.Checksum(p, crcTable) != enc.Uint32(b[end+lenWidth:]) {
+			return nil, nil, 0, fmt.Errorf("%w: checksum mismatch at position %d", ErrCorruptRecord, end)
+		}
+		record := &api_v1.Record{}
+		if err := l.Config.decode(p, record); err != nil {
+			return nil, nil, 0, fmt.Errorf("%w at position %d: %v", ErrCorruptRecord, end, err)
+		}
+		if record.Offset < base || (!sorted && len(offsets) > 0 && record.Offset <= offsets[len(offsets)-1]) {
+			break
+		}
+		positions = append(positions, end)
+		offsets = append(offsets, record.Offset)
+		end += header + n
+	}
+	return positions, offsets, end, nil
+}
+
+func quarantine(dir string, names ...string) error {
+	qdir := filepath.Join(dir, quarantineDir)
+	if err := os.MkdirAll(qdir, 0755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		err := os.Rename(name, filepath.Join(qdir, filepath.Base(name)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log/logtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogHeal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "heal-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	layout, err := logtest.Build(dir, logtest.Spec{Segments: []logtest.Segment{
+		// 0번 세그먼트는 두 번째 레코드의 내용을 망가뜨린다.
+		{Records: logtest.Records(3, 11), Corrupt: []int{1}},
+		// 3번 세그먼트는 인덱스가 없다.
+		{Records: logtest.Records(3, 11), DropIndex: true},
+		// 6번 세그먼트는 마지막 레코드를 쓰다 만 것처럼 자른다.
+		{Records: logtest.Records(3, 11), TruncateTail: 3},
+	}})
+	require.NoError(t, err)
+	last := layout.Segments[2]
+	partial := last.StoreSize - last.Positions[2]
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// 3번과 6번 세그먼트의 인덱스는 NewLog가 열면서 이미 다시 만들었다.
+	report, err := log.Heal()
+	require.NoError(t, err)
+	require.Equal(t, &HealReport{
+		Truncated:   map[uint64]uint64{6: partial},
+		Quarantined: []uint64{0},
+	}, report)
+	_, err = os.Stat(filepath.Join(dir, quarantineDir, "0.store"))
+	require.NoError(t, err)
+
+	for off := uint64(0); off < 9; off++ {
+		record, err := log.Read(off)
+		if off < 3 || off == 8 {
+			require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, logtest.Records(3, 11)[off%3].Value, record.Value)
+	}
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), off)
+
+	// 이미 고친 로그는 다시 고칠 것이 없다.
+	report, err = log.Heal()
+	require.NoError(t, err)
+	require.Equal(t, &HealReport{Truncated: map[uint64]uint64{}}, report)
+}
+
+func TestLogHealOnOpen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "heal-on-open-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.HealOnOpen = true
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// 비정상 종료하면 인덱스 파일은 최대 크기로 남는다.
+	require.NoError(t, os.Truncate(filepath.Join(dir, "0.index"), int64(c.Segment.MaxIndexBytes+1024)))
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
+
+func TestLogHealZeroFilledTail(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.InitialOffset = 100
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// 비정상 종료로 스토어 끝이 0으로 채워졌다.
+	f, err := os.OpenFile(filepath.Join(dir, "100.store"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 64))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	report, err := log.Heal()
+	require.NoError(t, err)
+	require.Equal(t, &HealReport{Truncated: map[uint64]uint64{100: 64}}, report)
+
+	off, err := log.Append(&api_v1.Record{Value: []byte("appended")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(103), off)
+	for off := uint64(100); off <= 103; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+	}
+}
@@ -1,28 +1,93 @@
 package log
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
 
 	"github.com/tysonmote/gommap"
 )
 
-// 인덱스 항목 내의 바이트 개수
+// 인덱스 항목 내의 바이트 개수. posWidth와 entWidth는 기본값이고 실제 폭은
+// Segment.IndexPositionWidth를 따른다.
 var (
 	offWidth uint64 = 4 // 레코드의 오프셋 정보 uint32 4바이트 - 즉 몇 번째인지
 	posWidth uint64 = 8 // 위치(position) 정보 uint64 8바이트 - 즉 정확한 위치
 	entWidth        = offWidth + posWidth
 )
 
+// compactPosWidth는 4GiB보다 작은 스토어만 가리키는 인덱스의 위치 폭이다.
+const compactPosWidth = 4
+
+var (
+	ErrIndexPositionWidth = errors.New("index position width must be 4 or 8 bytes")
+	ErrIndexWidth         = errors.New("index entry width does not match the existing log")
+)
+
+// ErrIndexPosition은 위치 폭이 4바이트인 인덱스에 4GiB 넘는 위치를 쓰려 할 때 리턴한다.
+type ErrIndexPosition struct {
+	Pos uint64
+}
+
+func (e ErrIndexPosition) Error() string {
+	return fmt.Sprintf("store position %d does not fit in a 4-byte index position", e.Pos)
+}
+
+// positionWidth는 인덱스 항목에서 스토어 위치가 차지하는 바이트 수다.
+func (c Config) positionWidth() uint64 {
+	if c.Segment.IndexPositionWidth == 0 {
+		return posWidth
+	}
+	return uint64(c.Segment.IndexPositionWidth)
+}
+
+// entryWidth는 인덱스 항목 하나의 크기다.
+func (c Config) entryWidth() uint64 {
+	return offWidth + c.positionWidth()
+}
+
+// checkIndexWidth는 위치 폭이 4나 8이고, 4면 스토어가 4GiB를 넘지 않는지 확인한다.
+func (c Config) checkIndexWidth() error {
+	switch c.positionWidth() {
+	case posWidth:
+		return nil
+	case compactPosWidth:
+		if c.Segment.MaxStoreBytes > math.MaxUint32 {
+			return fmt.Errorf("%w: MaxStoreBytes %d needs 8-byte positions",
+				ErrIndexPositionWidth, c.Segment.MaxStoreBytes)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: got %d", ErrIndexPositionWidth, c.Segment.IndexPositionWidth)
+}
+
+// appendEntry는 인덱스 항목 하나를 b에 붙인다.
+func (c Config) appendEntry(b []byte, off uint32, pos uint64) []byte {
+	b = enc.AppendUint32(b, off)
+	if c.positionWidth() == compactPosWidth {
+		return enc.AppendUint32(b, uint32(pos))
+	}
+	return enc.AppendUint64(b, pos)
+}
+
 type index struct {
 	file *os.File
 	mmap gommap.MMap
 	size uint64
+	// posWidth와 entWidth는 Config에서 정한 이 인덱스의 위치 폭과 항목 크기다.
+	posWidth, entWidth uint64
+	// synced면 크기가 syncedSize일 때 디스크에 동기화했다.
+	synced     bool
+	syncedSize uint64
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
 	idx := &index{
-		file: f,
+		file:     f,
+		posWidth: c.positionWidth(),
+		entWidth: c.entryWidth(),
 	}
 	fi, err := os.Stat(f.Name())
 	if err != nil {
@@ -37,23 +102,52 @@ func newIndex(f *os.File, c Config) (*index, error) {
 		gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED); err != nil {
 		return nil, err
 	}
+	idx.trim()
 	return idx, nil
 }
 
-func (i *index) Close() error {
-	// 메모리 맵 파일부터 싱크
+// trim은 닫지 않은 인덱스 파일 끝의 0으로 채워진 항목을 크기에서 뺀다.
+// 닫기 전의 인덱스 파일은 최대 크기이기 때문이다. 상대 오프셋이 늘어나기만
+// 하므로 0으로만 된 항목은 첫 번째 항목일 때만 올바르다.
+func (i *index) trim() {
+	i.size = min(i.size, uint64(len(i.mmap))/i.entWidth*i.entWidth)
+	for i.size > i.entWidth {
+		ent := i.mmap[i.size-i.entWidth : i.size]
+		if enc.Uint32(ent[:offWidth]) != 0 || i.position(ent) != 0 {
+			return
+		}
+		i.size -= i.entWidth
+	}
+}
+
+// Sync는 메모리 맵과 파일을 디스크에 동기화한다. 지난번 동기화 뒤로 쓴 항목이
+// 없으면 아무것도 하지 않는다.
+func (i *index) Sync() error {
+	if i.synced && i.size == i.syncedSize {
+		return nil
+	}
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
 	}
-	// 그 다음 파일 싱크
 	if err := i.file.Sync(); err != nil {
 		return err
 	}
+	i.synced, i.syncedSize = true, i.size
+	return nil
+}
+
+func (i *index) Close() error {
+	// 메모리 맵 파일부터 싱크하고 그 다음 파일 싱크
+	err := i.Sync()
 	// 이제 실제 크기만큼 다시 자르기
-	if err := i.file.Truncate(int64(i.size)); err != nil {
-		return err
+	if err == nil {
+		err = i.file.Truncate(int64(i.size))
 	}
-	return i.file.Close()
+	// 앞에서 실패해도 파일은 닫는다.
+	if cerr := i.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 // in 번째 인덱스를 읽어서
@@ -63,27 +157,56 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 		return 0, 0, io.EOF
 	}
 	if in == -1 {
-		out = uint32((i.size / entWidth) - 1) // 가장 마지막 인덱스 계산
+		out = uint32((i.size / i.entWidth) - 1) // 가장 마지막 인덱스 계산
 	} else {
 		out = uint32(in)
 	}
-	pos = uint64(out) * entWidth // 몇 번째 바이트를 읽을 지 계산
-	if i.size < pos+entWidth {
+	pos = uint64(out) * i.entWidth // 몇 번째 바이트를 읽을 지 계산
+	if i.size < pos+i.entWidth {
 		return 0, 0, io.EOF
 	}
 	// out과 pos를 여러번 재활용해서 좀 헷갈린다.
-	out = enc.Uint32(i.mmap[pos : pos+offWidth])          // 4바이트 읽기
-	pos = enc.Uint64(i.mmap[pos+offWidth : pos+entWidth]) // 8바이트 읽기
+	ent := i.mmap[pos : pos+i.entWidth]
+	out = enc.Uint32(ent[:offWidth]) // 4바이트 읽기
+	pos = i.position(ent)            // 위치 폭만큼 읽기
 	return out, pos, nil
 }
 
+// position은 항목 ent의 스토어 위치다.
+func (i *index) position(ent []byte) uint64 {
+	if i.posWidth == compactPosWidth {
+		return uint64(enc.Uint32(ent[offWidth:]))
+	}
+	return enc.Uint64(ent[offWidth:])
+}
+
 func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth { // 인덱스 하나 추가해도 크기 괜찮은가?
+	if uint64(len(i.mmap)) < i.size+i.entWidth { // 인덱스 하나 추가해도 크기 괜찮은가?
 		return io.EOF
 	}
+	if i.posWidth == compactPosWidth && pos > math.MaxUint32 {
+		return ErrIndexPosition{Pos: pos}
+	}
 	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
-	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
-	i.size += uint64(entWidth)
+	if i.posWidth == compactPosWidth {
+		enc.PutUint32(i.mmap[i.size+offWidth:i.size+i.entWidth], uint32(pos))
+	} else {
+		enc.PutUint64(i.mmap[i.size+offWidth:i.size+i.entWidth], pos)
+	}
+	i.size += i.entWidth
+	return nil
+}
+
+// reset은 인덱스 내용을 b로 바꾼다. b 뒤에 남은 예전 항목은 0으로 지워서
+// 닫지 않고 멈춰도 trim이 b 뒤를 항목으로 보지 않게 한다.
+func (i *index) reset(b []byte) error {
+	if uint64(len(b)) > uint64(len(i.mmap)) {
+		return io.EOF
+	}
+	copy(i.mmap, b)
+	clear(i.mmap[len(b):max(i.size, uint64(len(b)))])
+	i.size = uint64(len(b))
+	i.synced = false
 	return nil
 }
 
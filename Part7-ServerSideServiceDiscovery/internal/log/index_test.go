@@ -2,6 +2,7 @@ package log
 
 import (
 	"io"
+	"math"
 	"os"
 	"testing"
 
@@ -53,3 +54,48 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, uint32(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 }
+
+func TestIndexPositionWidth(t *testing.T) {
+	for _, width := range []int{4, 8} {
+		f, err := os.CreateTemp(t.TempDir(), "index_width_test")
+		require.NoError(t, err)
+		c := Config{}
+		c.Segment.MaxIndexBytes = 1024
+		c.Segment.IndexPositionWidth = width
+		idx, err := newIndex(f, c)
+		require.NoError(t, err)
+
+		require.NoError(t, idx.Write(0, 0))
+		require.NoError(t, idx.Write(1, math.MaxUint32))
+		err = idx.Write(2, math.MaxUint32+1)
+		if width == 4 {
+			// 4바이트 위치로는 4GiB 넘는 위치를 가리킬 수 없다.
+			require.ErrorIs(t, err, ErrIndexPosition{Pos: math.MaxUint32 + 1})
+		} else {
+			require.NoError(t, err)
+		}
+		require.NoError(t, idx.Close())
+
+		f, err = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+		require.NoError(t, err)
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		require.Zero(t, uint64(fi.Size())%c.entryWidth())
+		idx, err = newIndex(f, c)
+		require.NoError(t, err)
+		off, pos, err := idx.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, uint32(1), off)
+		require.Equal(t, uint64(math.MaxUint32), pos)
+		require.NoError(t, idx.Close())
+	}
+
+	c := Config{}
+	c.Segment.IndexPositionWidth = 2
+	_, err := NewLog(t.TempDir(), c)
+	require.ErrorIs(t, err, ErrIndexPositionWidth)
+	c.Segment.IndexPositionWidth = 4
+	c.Segment.MaxStoreBytes = math.MaxUint32 + 1
+	_, err = NewLog(t.TempDir(), c)
+	require.ErrorIs(t, err, ErrIndexPositionWidth)
+}
@@ -0,0 +1,121 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+var ErrKeyMapWithFixedSize = errors.New("a key map cannot be used with fixed-size records")
+
+const defaultKeyMapRebuildBytes = 64 << 20
+
+func (c Config) checkKeyMap() error {
+	if c.KeyMap.Enabled && c.Store.FixedRecordSize > 0 {
+		return ErrKeyMapWithFixedSize
+	}
+	return nil
+}
+
+// rebuildKeyMap은 최근 세그먼트를 훑어 키마다 가장 최근 오프셋을 다시 모은다.
+// 활성 세그먼트부터 거슬러 올라가며 스토어 크기 합이 KeyMap.RebuildBytes를
+// 넘지 않는 세그먼트까지만 훑고, 그보다 오래된 세그먼트는 OffsetForKey가
+// 찾을 때 훑는다. 활성 세그먼트는 크기와 상관없이 훑는다.
+func (l *Log) rebuildKeyMap() error {
+	if !l.Config.KeyMap.Enabled {
+		return nil
+	}
+	first := len(l.segments) - 1
+	size := l.segments[first].store.size
+	for first > 0 && size+l.segments[first-1].store.size <= l.Config.KeyMap.RebuildBytes {
+		first--
+		size += l.segments[first].store.size
+	}
+	l.keyOffsets = make(map[string]uint64)
+	l.keysFrom = l.segments[first].baseOffset
+	for _, s := range l.segments[first:] {
+		// 정렬된 세그먼트는 키 순서로 훑으므로 오프셋이 큰 것을 남긴다.
+		err := s.scanForward(0, func(_ uint64, _ []byte, record *api_v1.Record) bool {
+			l.indexKey(record.Key, record.Offset)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexKey는 key의 가장 최근 오프셋을 off로 바꾼다. 키 맵을 쓰지 않거나 키가
+// 없는 레코드면 아무것도 하지 않는다.
+func (l *Log) indexKey(key []byte, off uint64) {
+	if l.keyOffsets == nil || len(key) == 0 {
+		return
+	}
+	if latest, ok := l.keyOffsets[string(key)]; !ok || off > latest {
+		l.keyOffsets[string(key)] = off
+	}
+}
+
+// OffsetForKey는 Key가 key인 가장 최근 레코드의 오프셋을 리턴한다. 없으면
+// ErrKeyNotFound를 리턴한다. KeyMap.Enabled면 키 맵에서 찾고, 없으면 키 맵이
+// 담지 않는 오래된 세그먼트만 최근 것부터 훑는다. 끄면 모든 세그먼트를 훑는다.
+// 찾은 레코드가 만료되었을 수 있으므로 읽는 쪽에서 확인한다.
+func (l *Log) OffsetForKey(key []byte) (uint64, error) {
+	if len(key) == 0 || l.Config.Store.FixedRecordSize > 0 {
+		return 0, ErrKeyNotFound
+	}
+	// 지워진 세그먼트를 가리키는 키를 키 맵에서 빼므로 쓰기 락을 잡는다.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+
+	segments := l.segments
+	if l.keyOffsets != nil {
+		if off, ok := l.keyOffsets[string(key)]; ok {
+			if off >= segments[0].baseOffset {
+				return off, nil
+			}
+			// Truncate나 보존 정책으로 지워진 세그먼트에 있던 레코드다. 그보다
+			// 오래된 레코드도 함께 지워졌다.
+			delete(l.keyOffsets, string(key))
+			return 0, ErrKeyNotFound
+		}
+		segments = segments[:sort.Search(len(segments), func(i int) bool {
+			return segments[i].baseOffset >= l.keysFrom
+		})]
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		off, ok, err := segments[i].latestKey(key)
+		if err != nil || ok {
+			return off, err
+		}
+	}
+	return 0, ErrKeyNotFound
+}
+
+// latestKey는 세그먼트에서 Key가 key인 가장 최근 레코드의 오프셋을 찾는다.
+// 없으면 ok가 false다. 정렬된 세그먼트는 키 인덱스로 찾는다.
+func (s *segment) latestKey(key []byte) (off uint64, ok bool, err error) {
+	if s.keys != nil {
+		record, err := s.lookup(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		return record.Offset, true, nil
+	}
+	err = s.scanForward(0, func(_ uint64, _ []byte, record *api_v1.Record) bool {
+		if bytes.Equal(record.Key, key) && (!ok || record.Offset > off) {
+			off, ok = record.Offset, true
+		}
+		return true
+	})
+	return off, ok, err
+}
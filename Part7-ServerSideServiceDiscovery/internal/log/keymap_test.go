@@ -0,0 +1,111 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetForKey(t *testing.T) {
+	for scenario, fn := range map[string]func(*Config){
+		"key map":     func(c *Config) { c.KeyMap.Enabled = true },
+		"no key map":  func(c *Config) {},
+		"sort by key": func(c *Config) { c.Segment.SortByKey = true },
+		"small rebuild": func(c *Config) {
+			c.KeyMap.Enabled = true
+			c.KeyMap.RebuildBytes = 1
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxIndexBytes = 3 * entWidth
+			fn(&c)
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			// 키 a, b, c를 돌아가며 쓰므로 키마다 마지막 값은 나중 세그먼트에 있다.
+			const n = 12
+			keys := []string{"a", "b", "c"}
+			for i := 0; i < n; i++ {
+				_, err := log.Append(&api_v1.Record{
+					Key:   []byte(keys[i%len(keys)]),
+					Value: []byte(fmt.Sprintf("value %d", i)),
+				})
+				require.NoError(t, err)
+			}
+			_, err = log.Append(&api_v1.Record{Key: []byte("old"), Value: []byte("old")})
+			require.NoError(t, err)
+			for i := 0; i < 6; i++ {
+				_, err := log.Append(&api_v1.Record{Key: []byte("a"), Value: []byte("latest")})
+				require.NoError(t, err)
+			}
+
+			check := func(log *Log) {
+				t.Helper()
+				for key, want := range map[string]uint64{"a": n + 6, "b": n - 2, "c": n - 1, "old": n} {
+					off, err := log.OffsetForKey([]byte(key))
+					require.NoError(t, err)
+					require.Equal(t, want, off, "key %s", key)
+				}
+				_, err = log.OffsetForKey([]byte("missing"))
+				require.ErrorIs(t, err, ErrKeyNotFound)
+				_, err = log.OffsetForKey(nil)
+				require.ErrorIs(t, err, ErrKeyNotFound)
+			}
+			check(log)
+
+			// 다시 열면 키 맵을 다시 만든다.
+			require.NoError(t, log.Close())
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			check(log)
+
+			// 지운 세그먼트에만 있던 키는 찾지 못한다.
+			require.NoError(t, log.Truncate(n))
+			_, err = log.OffsetForKey([]byte("b"))
+			require.ErrorIs(t, err, ErrKeyNotFound)
+			off, err := log.OffsetForKey([]byte("a"))
+			require.NoError(t, err)
+			require.Equal(t, uint64(n+6), off)
+		})
+	}
+}
+
+func TestKeyMapRebuildBytes(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 9; i++ {
+		_, err := log.Append(&api_v1.Record{Key: []byte{byte(i)}, Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// 세그먼트 하나 반만큼이면 활성 세그먼트만 훑는다.
+	c.KeyMap.Enabled = true
+	c.KeyMap.RebuildBytes = 3 * (lenWidth + 20) / 2
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, uint64(6), log.keysFrom)
+	require.Len(t, log.keyOffsets, 3)
+
+	// 맵에 없는 키는 오래된 세그먼트에서 찾는다.
+	off, err := log.OffsetForKey([]byte{1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+func TestKeyMapWithFixedSize(t *testing.T) {
+	c := Config{}
+	c.Store.FixedRecordSize = 8
+	c.KeyMap.Enabled = true
+	_, err := NewLog(t.TempDir(), c)
+	require.ErrorIs(t, err, ErrKeyMapWithFixedSize)
+}
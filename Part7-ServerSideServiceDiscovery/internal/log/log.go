@@ -1,15 +1,34 @@
 package log
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrOffsetGap      = errors.New("offset is not one the allocator assigns")
+	ErrOffsetConflict = errors.New("record conflicts with the record at offset")
+	ErrSegmentRemoved = errors.New("segment was removed while reading")
+	// ErrLogClosed는 Close나 Remove로 닫은 로그를 쓰거나 읽으면 리턴한다.
+	// Reset으로 다시 열면 다시 쓸 수 있다.
+	ErrLogClosed = errors.New("log is closed")
 )
 
 type Log struct {
@@ -19,6 +38,46 @@ type Log struct {
 
 	activeSegment *segment
 	segments      []*segment
+	// appended는 레코드를 추가할 때마다 닫고 새로 만든다. mu로 지킨다.
+	appended chan struct{}
+
+	// done을 닫으면 자동 압축이나 주기적인 동기화 같은 백그라운드 작업을 멈춘다.
+	done     chan struct{}
+	stopOnce sync.Once
+	loops    sync.WaitGroup
+
+	// sortMu는 봉인된 세그먼트를 한 번에 하나씩만 정렬하게 하고, sorting은
+	// 백그라운드에서 정렬 중인 작업을 센다.
+	sortMu  sync.Mutex
+	sorting sync.WaitGroup
+	// rewriteMu는 압축과 정렬이 세그먼트를 한 번에 하나씩만 다시 쓰게 한다.
+	// 같은 임시 파일에 함께 쓰지 않도록 replaceSegments가 잡는다.
+	rewriteMu sync.Mutex
+
+	// readCache는 ReadCacheSize가 있을 때 최근에 읽은 레코드다. mu로 지킨다.
+	readCache *readCache
+
+	// groupOffsets는 컨슈머 그룹이 커밋한 오프셋으로 처음 쓸 때 offsets.json에서
+	// 읽는다. offsetsMu로 지킨다.
+	offsetsMu    sync.Mutex
+	groupOffsets map[string]uint64
+
+	peerMu    sync.Mutex
+	peerCache map[uint64]*api_v1.Record
+	peerOrder []uint64
+
+	// keyOffsets는 KeyMap.Enabled일 때 키마다 가장 최근 레코드의 오프셋이다.
+	// 베이스 오프셋이 keysFrom 이상인 세그먼트의 레코드만 담는다. mu로 지킨다.
+	keyOffsets map[string]uint64
+	keysFrom   uint64
+	// compactKeys는 Compaction.ByKey일 때 키마다 가장 최근 레코드다. 자동
+	// 압축이 처음 확인할 때 loadSuperseded가 채우고 그 전에는 nil이다. mu로
+	// 지킨다.
+	compactKeys map[string]keyRecord
+
+	// closed면 Close로 세그먼트를 닫았다. Reset이 다시 열 때까지 Close와
+	// Remove는 닫은 파일을 다시 건드리지 않는다. mu로 지킨다.
+	closed bool
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -28,41 +87,146 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1024
 	}
+	if c.Segment.RollTimeout == 0 {
+		c.Segment.RollTimeout = time.Second
+	}
+	if c.PeerFallback.Timeout == 0 {
+		c.PeerFallback.Timeout = time.Second
+	}
+	if c.PeerFallback.CacheSize == 0 {
+		c.PeerFallback.CacheSize = 1024
+	}
+	if c.Retention.Interval == 0 && (c.Retention.MaxBytes > 0 || c.Retention.MaxAge > 0) {
+		c.Retention.Interval = time.Minute
+	}
+	if c.KeyMap.RebuildBytes == 0 {
+		c.KeyMap.RebuildBytes = defaultKeyMapRebuildBytes
+	}
+
+	if err := c.checkCodec(); err != nil {
+		return nil, err
+	}
+	if err := c.checkSort(); err != nil {
+		return nil, err
+	}
+	if err := c.checkStride(); err != nil {
+		return nil, err
+	}
+	if err := c.checkChecksum(); err != nil {
+		return nil, err
+	}
+	if err := c.checkKeyMap(); err != nil {
+		return nil, err
+	}
+	if err := c.checkStorage(); err != nil {
+		return nil, err
+	}
+	if err := c.checkIndexWidth(); err != nil {
+		return nil, err
+	}
 
 	l := &Log{
-		Dir:    dir,
-		Config: c,
+		Dir:       dir,
+		Config:    c,
+		appended:  make(chan struct{}),
+		readCache: newReadCache(c.ReadCacheSize),
+	}
+
+	if c.Segment.HealOnOpen {
+		// 설정이 디렉터리와 맞는지 먼저 확인해야 레코드를 잘못 해석하지 않는다.
+		if err := l.setupMeta(); err != nil {
+			return nil, err
+		}
+		if _, err := l.heal(); err != nil {
+			return nil, err
+		}
 	}
 
-	return l, l.setup()
+	if err := l.setup(); err != nil {
+		return nil, err
+	}
+	if c.Segment.SortByKey {
+		// 정렬하기 전에 멈췄던 세그먼트를 마저 정렬한다.
+		if err := l.sortSealed(); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := l.rebuildKeyMap(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	l.startLoops()
+	return l, nil
 }
 
-func (l *Log) setup() error {
-	files, err := os.ReadDir(l.Dir)
-	if err != nil {
-		return err
+// startLoops는 설정에 있는 백그라운드 작업을 시작한다. Reset이 다시 열 때도 부른다.
+func (l *Log) startLoops() {
+	l.done = make(chan struct{})
+	l.stopOnce = sync.Once{}
+	l.every(l.Config.Compaction.Interval, l.compactIfNeeded)
+	l.every(l.Config.SyncInterval, l.syncInBackground)
+	l.every(l.Config.Retention.Interval, l.retainInBackground)
+}
+
+// every는 Close할 때까지 interval마다 fn을 부른다. interval이 0이면 아무것도 하지 않는다.
+func (l *Log) every(interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
 	}
+	l.loops.Add(1)
+	go func() {
+		defer l.loops.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
 
-	var baseOffsets []uint64
-	for _, file := range files {
-		offStr := strings.TrimSuffix(
-			file.Name(),
-			path.Ext(file.Name()),
-		)
+func (l *Log) stopLoops() {
+	if l.done == nil {
+		return
+	}
+	l.stopOnce.Do(func() { close(l.done) })
+	l.loops.Wait()
+}
 
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
+func (l *Log) setup() error {
+	if err := l.setupMeta(); err != nil {
+		return err
 	}
 
-	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
+	baseOffsets, err := l.baseOffsets()
+	if err != nil {
+		return err
+	}
 
+	// 다시 연 세그먼트는 대체된 바이트를 세지 않았으므로 다시 센다.
+	l.compactKeys = nil
 	for i := 0; i < len(baseOffsets); i++ {
+		if n := len(l.segments); n > 0 && baseOffsets[i] < l.segments[n-1].nextOffset {
+			// 압축이 세그먼트를 합친 뒤 예전 파일을 지우다 멈추면 합친
+			// 세그먼트의 범위 안에 예전 세그먼트가 남는다. 그 레코드는 합친
+			// 세그먼트에 있으므로 지운다.
+			s, err := newSegment(l.Dir, baseOffsets[i], l.Config)
+			if err != nil {
+				return err
+			}
+			if err := s.Remove(); err != nil {
+				return err
+			}
+			continue
+		}
 		if err = l.newSegment(baseOffsets[i]); err != nil {
 			return err
 		}
-		// 베이스 오프셋은 index와 store 두 파일을 중복해서 담고 있기에
-		// 같은 값이 하나 더 있다. 그래서 한번 건너뛴다.
-		i++
 	}
 
 	if l.segments == nil {
@@ -71,27 +235,235 @@ func (l *Log) setup() error {
 		); err != nil {
 			return err
 		}
+		recordStats(SegmentsCreated.M(1))
 	}
 	return nil
 }
 
+// baseOffsets는 디렉터리에 있는 세그먼트의 베이스 오프셋을 오름차순으로 리턴한다.
+func (l *Log) baseOffsets() ([]uint64, error) {
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 스토어를 다른 스토리지에 두면 디렉터리에는 인덱스 파일만 있다.
+	ext := ".store"
+	if l.Config.Store.Storage != nil {
+		ext = ".index"
+	}
+	var baseOffsets []uint64
+	for _, file := range files {
+		// 베이스 오프셋은 index와 store 두 파일에 중복해서 담겨 있고
+		// meta.json 같은 다른 파일도 있으니 store 파일만 본다.
+		if path.Ext(file.Name()) != ext {
+			continue
+		}
+		offStr := strings.TrimSuffix(
+			file.Name(),
+			path.Ext(file.Name()),
+		)
+
+		off, _ := strconv.ParseUint(offStr, 10, 0)
+		baseOffsets = append(baseOffsets, off)
+	}
+
+	sort.Slice(baseOffsets, func(i, j int) bool { return baseOffsets[i] < baseOffsets[j] })
+	return baseOffsets, nil
+}
+
 func (l *Log) Append(record *api_v1.Record) (uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	return l.append(record)
+}
+
+// AppendContext는 Append와 같지만 ctx가 먼저 끝나면 기다리지 않고 ctx.Err()를
+// 리턴한다. 느린 디스크 때문에 요청이 기한을 넘겨 붙잡히지 않게 할 때 쓴다.
+// 시작한 쓰기는 멈출 수 없으므로 ctx.Err()를 리턴해도 레코드가 나중에 쓰일
+// 수 있다.
+func (l *Log) AppendContext(ctx context.Context, record *api_v1.Record) (uint64, error) {
+	return withContext(ctx, func() (uint64, error) {
+		return l.Append(record)
+	})
+}
 
+func (l *Log) append(record *api_v1.Record) (uint64, error) {
+	if l.closed {
+		return 0, ErrLogClosed
+	}
 	if l.activeSegment.IsMaxed() {
-		off := l.activeSegment.nextOffset
-		if err := l.newSegment(off); err != nil {
+		if err := l.roll(); err != nil {
 			return 0, err
 		}
 	}
-	return l.activeSegment.Append(record)
+	l.Config.stamp(record)
+	size := l.activeSegment.store.size
+	off, err := l.activeSegment.Append(record)
+	if err != nil {
+		return 0, err
+	}
+	l.indexKey(record.Key, off)
+	l.supersede(record.Key, off, l.activeSegment.store.size-size)
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return off, nil
 }
 
-func (l *Log) Read(off uint64) (*api_v1.Record, error) {
+// HighWatermarkChanged는 다음 레코드가 추가되면 닫히는 채널을 리턴한다.
+// 기다리는 쪽은 레코드가 있는지 보기 전에 채널을 먼저 받아 두어야 그 사이에
+// 추가된 레코드를 놓치지 않는다.
+func (l *Log) HighWatermarkChanged() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.appended
+}
+
+// DryRun은 레코드를 쓰지 않고 Append가 하는 검사만 해서 레코드가 받을
+// 오프셋을 리턴한다.
+func (l *Log) DryRun(record *api_v1.Record) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+	p, err := l.activeSegment.marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.Config.checkRecordSize(len(p)); err != nil {
+		return 0, err
+	}
+	return l.Config.next(l.activeSegment.nextOffset), nil
+}
+
+// roll은 활성 세그먼트 다음에 새 세그먼트를 만든다. 만들지 못하면
+// RollTimeout 동안 간격을 늘려 가며 다시 시도하고, 그래도 안 되면
+// 클라이언트가 다시 시도할 수 있도록 ErrSegmentRolling을 리턴한다.
+// 락을 잡은 채로 기다리므로 다른 Append도 새 세그먼트가 생길 때까지 기다린다.
+func (l *Log) roll() error {
+	off := l.activeSegment.nextOffset
+	deadline := time.Now().Add(l.Config.Segment.RollTimeout)
+	backoff := time.Millisecond
+	for {
+		err := l.newSegment(off)
+		if err == nil {
+			recordStats(SegmentsCreated.M(1))
+			if l.Config.Segment.SortByKey {
+				l.sortInBackground()
+			}
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return api_v1.ErrSegmentRolling{RetryAfter: backoff}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// AppendAt은 레코드를 지정한 오프셋에 쓴다. 복제나 복원처럼 원본과 같은
+// 오프셋을 유지해야 할 때 쓴다. offset이 다음 오프셋이면 추가하고, 그보다
+// 크면 그 사이를 비워 두고 추가한다. 압축이나 보존 설정으로 원본에 빈
+// 오프셋이 있어도 그대로 옮길 수 있다. 이미 같은 내용의 레코드가 그 오프셋에
+// 있으면 아무것도 하지 않는다. 내용이 다르면 ErrOffsetConflict를, 다음
+// 오프셋보다 크지만 OffsetAllocator가 주지 않을 오프셋이면 ErrOffsetGap을
+// 리턴한다.
+func (l *Log) AppendAt(offset uint64, record *api_v1.Record) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+
+	next := l.Config.next(l.activeSegment.nextOffset)
+	switch {
+	case offset == next:
+		_, err := l.append(record)
+		return err
+	case offset > next:
+		if l.Config.next(offset) != offset {
+			return fmt.Errorf("%w: offset %d, next offset %d", ErrOffsetGap, offset, next)
+		}
+		return l.appendAfterGap(offset, record)
+	}
+
+	existing, err := l.read(offset)
+	if err != nil {
+		return err
+	}
+	want := proto.Clone(record).(*api_v1.Record)
+	want.Offset = offset
+	if want.Timestamp == 0 {
+		// 시각은 쓸 때 로그가 넣으므로 시각 없이 다시 보낸 레코드는 시각을 빼고 비교한다.
+		want.Timestamp = existing.Timestamp
+	}
+	if !proto.Equal(existing, want) {
+		return fmt.Errorf("%w: offset %d", ErrOffsetConflict, offset)
+	}
+	return nil
+}
+
+// appendAfterGap은 활성 세그먼트의 다음 오프셋을 offset으로 옮기고 레코드를
+// 쓴다. 세그먼트의 상대 오프셋에 담을 수 없을 만큼 멀면 offset에서 새
+// 세그먼트를 시작한다. 쓰지 못하면 다음 오프셋을 되돌린다.
+func (l *Log) appendAfterGap(offset uint64, record *api_v1.Record) error {
+	s := l.activeSegment
+	prev := s.nextOffset
+	s.nextOffset = offset
+	if offset-s.baseOffset > math.MaxUint32 {
+		if err := l.roll(); err != nil {
+			s.nextOffset = prev
+			return err
+		}
+	}
+	if _, err := l.append(record); err != nil {
+		if l.activeSegment == s {
+			s.nextOffset = prev
+		}
+		return err
+	}
+	return nil
+}
 
+// ReadContext는 Read와 같지만 ctx가 먼저 끝나면 기다리지 않고 ctx.Err()를 리턴한다.
+func (l *Log) ReadContext(ctx context.Context, off uint64) (*api_v1.Record, error) {
+	return withContext(ctx, func() (*api_v1.Record, error) {
+		return l.Read(off)
+	})
+}
+
+// Read는 off의 레코드를 읽는다. 로그는 읽기 락만 잡으므로 읽기끼리 막지 않는다.
+// 활성 세그먼트의 버퍼에 남은 레코드를 읽을 때만 스토어가 쓰기 락을 잡고
+// 버퍼를 비운다.
+func (l *Log) Read(off uint64) (*api_v1.Record, error) {
+	l.mu.RLock()
+	record, err := l.read(off)
+	l.mu.RUnlock()
+
+	// 다른 노드를 기다리는 동안 로그를 잠가 두지 않도록 락을 풀고 읽는다.
+	if errors.As(err, &api_v1.ErrOffsetOutOfRange{}) && l.Config.PeerFallback.Reader != nil {
+		record, err = l.readFromPeer(off, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expired(record, time.Now()) {
+		return nil, api_v1.ErrRecordExpired{
+			Offset:   off,
+			ExpireAt: record.ExpireAt,
+		}
+	}
+	return record, nil
+}
+
+func (l *Log) read(off uint64) (*api_v1.Record, error) {
+	if l.closed {
+		return nil, ErrLogClosed
+	}
+	if record, ok := l.readCache.get(off); ok {
+		return record, nil
+	}
 	var s *segment
 	for _, segment := range l.segments {
 		if segment.baseOffset <= off && off < segment.nextOffset {
@@ -104,44 +476,176 @@ func (l *Log) Read(off uint64) (*api_v1.Record, error) {
 		return nil, api_v1.ErrOffsetOutOfRange{Offset: off}
 	}
 
-	return s.Read(off)
+	record, err := s.Read(off)
+	if errors.As(err, &ErrPosOutOfRange{}) {
+		// 인덱스가 스토어에 없는 위치를 가리키면 그 레코드는 없는 것이다.
+		return nil, api_v1.ErrOffsetOutOfRange{Offset: off}
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.readCache.put(off, record)
+	return record, nil
+}
+
+// ExpireAt이 0이면 만료되지 않는 레코드다.
+func expired(record *api_v1.Record, now time.Time) bool {
+	return record.ExpireAt != 0 && record.ExpireAt <= now.UnixNano()
+}
 
+// SegmentInfo는 off를 담고 있는 세그먼트의 베이스 오프셋과 스토어 파일 이름을 리턴한다.
+// 이름은 로그 디렉터리 기준의 상대 경로다.
+func (l *Log) SegmentInfo(off uint64) (baseOffset uint64, name string, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range l.segments {
+		if s.baseOffset <= off && off < s.nextOffset {
+			return s.baseOffset, filepath.Base(s.store.Name()), nil
+		}
+	}
+	return 0, "", api_v1.ErrOffsetOutOfRange{Offset: off}
 }
 
+// Close는 세그먼트를 모두 닫는다. 이미 닫은 로그면 아무것도 하지 않고 nil을
+// 리턴한다. 세그먼트를 닫다가 실패해도 닫은 것으로 본다.
 func (l *Log) Close() error {
+	// 백그라운드 작업이 닫힌 세그먼트를 건드리지 않도록 먼저 멈춘다.
+	l.stopLoops()
+	l.sorting.Wait()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	// 활성 세그먼트를 마지막에 닫는다. 하나가 실패해도 나머지는 닫고
+	// 처음 난 에러를 리턴한다.
+	var err error
+	for _, segment := range l.segments {
+		if segment == l.activeSegment {
+			continue
+		}
+		if serr := segment.Close(); err == nil {
+			err = serr
+		}
+	}
+	if l.activeSegment != nil {
+		if serr := l.activeSegment.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Probe는 로그를 쓸 수 있는지 디스크를 건드리지 않을 만큼 가볍게 확인한다.
+// 로그 디렉터리가 없어졌으면 에러를, 로그가 이미 닫혔으면 ErrLogClosed를
+// 리턴한다.
+func (l *Log) Probe() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	if _, err := os.Stat(l.Dir); err != nil {
+		return err
+	}
+	_, err := l.activeSegment.store.backend.Size()
+	return err
+}
+
+// Flush는 닫지 않고 모든 세그먼트의 버퍼를 파일에 쓰고 디스크에 동기화한다.
+// Flush가 리턴하면 같은 디렉터리를 새로 연 로그도 그때까지의 레코드를 읽을 수 있고,
+// 읽기도 버퍼를 비우지 않고 바로 파일에서 읽는다. 봉인된 세그먼트도 읽거나 닫기
+// 전까지는 버퍼를 비우지 않으므로 보존 기간 확인과 압축이 먼저 부른다.
+func (l *Log) Flush() error {
+	return l.Sync()
+}
+
+// Sync는 모든 세그먼트의 스토어와 인덱스를 디스크에 동기화한다. 봉인된
+// 세그먼트는 이미 동기화했으면 건너뛰므로 보통은 활성 세그먼트만 동기화한다.
+// 읽기 락만 잡으므로 동기화하는 동안 읽기는 막지 않는다.
+func (l *Log) Sync() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	for _, segment := range l.segments {
-		if err := segment.Close(); err != nil {
+		if err := segment.Flush(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (l *Log) syncInBackground() {
+	if err := l.Sync(); err != nil {
+		zap.L().Named("log").Error("sync failed", zap.String("dir", l.Dir), zap.Error(err))
+	}
+}
+
+// Remove는 로그를 닫고 디렉터리와 스토어를 지운다. 닫은 로그에 불러도 되고
+// 여러 번 불러도 된다.
 func (l *Log) Remove() error {
 	if err := l.Close(); err != nil {
 		return err
 	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Config.Store.Storage != nil {
+		// 디렉터리 밖에 있는 스토어는 RemoveAll로 지워지지 않는다.
+		for _, s := range l.segments {
+			err := l.Config.Store.Storage.Remove(s.store.Name())
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	l.segments = nil
+	l.activeSegment = nil
+	l.readCache.reset()
+	l.offsetsMu.Lock()
+	l.groupOffsets = nil
+	l.offsetsMu.Unlock()
 	return os.RemoveAll(l.Dir)
 }
 
+// Reset은 로그를 지우고 같은 디렉터리에 빈 로그를 다시 연다. 닫거나 지운
+// 로그에 불러도 된다.
 func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
-	return l.setup()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	if err := l.setup(); err != nil {
+		return err
+	}
+	if err := l.rebuildKeyMap(); err != nil {
+		return err
+	}
+	l.closed = false
+	l.startLoops()
+	return nil
 }
 
+// LowestOffset과 HighestOffset은 닫은 로그에도 닫을 때의 오프셋을 리턴한다.
+// Remove로 세그먼트까지 지웠으면 ErrLogClosed를 리턴한다.
 func (l *Log) LowestOffset() (uint64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	if len(l.segments) == 0 {
+		return 0, ErrLogClosed
+	}
 	return l.segments[0].baseOffset, nil
 }
 
 func (l *Log) HighestOffset() (uint64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	if len(l.segments) == 0 {
+		return 0, ErrLogClosed
+	}
 	off := l.segments[len(l.segments)-1].nextOffset
 	if off == 0 {
 		return 0, nil
@@ -149,15 +653,20 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return off - 1, nil
 }
 
+// Truncate는 레코드가 모두 lowest 이하인 세그먼트를 지운다. 활성 세그먼트는
+// 이어서 써야 하므로 lowest가 로그 끝을 넘어도 지우지 않는다.
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	var segments []*segment
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
+			size := s.store.size + s.index.size
 			if err := s.Remove(); err != nil {
 				return err
 			}
+			l.readCache.drop(s.baseOffset, s.nextOffset)
+			recordStats(SegmentsDeleted.M(1), BytesReclaimed.M(int64(size)))
 			continue
 		}
 		segments = append(segments, s)
@@ -166,24 +675,62 @@ func (l *Log) Truncate(lowest uint64) error {
 	return nil
 }
 
+// Reader는 모든 세그먼트의 스토어 파일을 베이스 오프셋 순서대로 이어 붙여
+// 읽는다. 레코드를 하나씩 읽지 않고 io.Copy로 로그를 통째로 옮길 때 쓴다.
+// 먼저 버퍼에 쌓인 쓰기를 파일에 쓰고, 그때의 스토어 크기까지만 읽으므로
+// 읽는 동안 추가된 레코드는 담기지 않는다. Read마다 읽기 락을 잡으므로 한 번
+// 읽는 동안에는 세그먼트가 지워지지 않는다. 읽는 사이에 Truncate나 보존
+// 설정으로 세그먼트가 지워지거나 압축으로 다시 쓰이면 ErrSegmentRemoved를
+// 리턴한다.
+//
+// 스토어 사이에는 구분자가 없다. 스토어마다 레코드가 오프셋 순서대로(SortByKey면
+// 키 순서로) 다음 형식으로 이어진다. 정수는 모두 빅 엔디언이다:
+//
+//	[8바이트 길이][4바이트 CRC32(Castagnoli), Store.Checksum일 때만][값]
+//
+// 값은 api_v1.Record를 protobuf로 직렬화한 것이고 Store.Codec이 있으면 그
+// 코덱으로 압축한 것이다. Store.FixedRecordSize면 길이 없이 레코드 값만
+// FixedRecordSize 바이트씩 이어진다. 세그먼트 경계가 필요하면 Backup을 쓴다.
 func (l *Log) Reader() io.Reader {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	readers := make([]io.Reader, len(l.segments))
 
 	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+		r := &originReader{log: l, segment: segment}
+		if r.err = segment.store.flushBuffer(); r.err == nil {
+			r.size = int64(segment.store.size)
+		}
+		readers[i] = r
 	}
 	return io.MultiReader(readers...)
 }
 
+// originReader는 세그먼트 하나의 스토어를 처음부터 size까지 읽는다.
 type originReader struct {
-	*store
-	off int64
+	log     *Log
+	segment *segment
+	off     int64
+	size    int64
+	err     error
 }
 
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
+	if o.err != nil {
+		return 0, o.err
+	}
+	// io.MultiReader는 다음 스토어로 넘어가려면 io.EOF를 받아야 한다.
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	o.log.mu.RLock()
+	defer o.log.mu.RUnlock()
+	if !slices.Contains(o.log.segments, o.segment) {
+		o.err = fmt.Errorf("%w: base offset %d", ErrSegmentRemoved, o.segment.baseOffset)
+		return 0, o.err
+	}
+	p = p[:min(int64(len(p)), o.size-o.off)]
+	n, err := o.segment.store.ReadAt(p, o.off)
 	o.off += int64(n)
 	return n, err
 }
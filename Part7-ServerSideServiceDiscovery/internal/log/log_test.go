@@ -1,12 +1,20 @@
 package log
 
 import (
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,6 +26,9 @@ func TestLog(t *testing.T) {
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
 		"make new segment":                  testNewSegment,
+		"reopen with conflicting config":    testConfigMismatch,
+		"expired record":                    testExpiredRecord,
+		"append at offset":                  testAppendAt,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "store-test")
@@ -35,6 +46,143 @@ func TestLog(t *testing.T) {
 		})
 	}
 }
+func TestLogConcurrentRolls(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	const producers, records = 8, 50
+	offsets := make(chan uint64, producers*records)
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				off, err := log.Append(&api_v1.Record{
+					Value: []byte(fmt.Sprintf("%d-%d", p, i)),
+				})
+				if !assert.NoError(t, err) {
+					return
+				}
+				offsets <- off
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(offsets)
+
+	seen := make(map[uint64]bool)
+	for off := range offsets {
+		require.False(t, seen[off], "offset %d assigned twice", off)
+		seen[off] = true
+		_, err := log.Read(off)
+		require.NoError(t, err)
+	}
+	require.Equal(t, producers*records, len(seen))
+	require.Greater(t, len(log.segments), 1)
+}
+
+func TestLogReadTakesReadLock(t *testing.T) {
+	c := Config{}
+	c.Store.FlushBytes = 1 << 20
+	c.ReadCacheSize = 1 << 10
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// 다른 읽기가 로그 락을 잡고 있어도 버퍼에 있는 레코드까지 읽는다.
+	log.mu.RLock()
+	defer log.mu.RUnlock()
+	read := make(chan error, 1)
+	go func() {
+		_, err := log.Read(0)
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Read waited for the exclusive log lock")
+	}
+}
+
+func TestLogRollFailure(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	c.Segment.RollTimeout = 20 * time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	_, err = log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// 다음 세그먼트의 스토어 파일 자리에 디렉터리가 있으면 세그먼트를 만들 수 없다.
+	blocker := filepath.Join(dir, "1.store")
+	require.NoError(t, os.Mkdir(blocker, 0755))
+	_, err = log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.IsType(t, api_v1.ErrSegmentRolling{}, err)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	require.NoError(t, os.Remove(blocker))
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+func TestLogFixedRecordSize(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Store.FixedRecordSize = 8
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := uint64(0); i < 3; i++ {
+		value := enc.AppendUint64(nil, i*100)
+		off, err := log.Append(&api_v1.Record{Value: value})
+		require.NoError(t, err)
+		require.Equal(t, i, off)
+	}
+	_, err = log.Append(&api_v1.Record{Value: []byte("short")})
+	require.ErrorIs(t, err, ErrRecordSize)
+	require.Equal(t, 3*uint64(8), log.activeSegment.store.size)
+	_, err = log.DryRun(&api_v1.Record{Value: []byte("short")})
+	require.ErrorIs(t, err, ErrRecordSize)
+	off, err := log.DryRun(&api_v1.Record{Value: enc.AppendUint64(nil, 300)})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	for i := uint64(0); i < 3; i++ {
+		read, err := log.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, i, read.Offset)
+		require.Equal(t, i*100, enc.Uint64(read.Value))
+	}
+	require.NoError(t, log.Close())
+
+	// 고정 크기 모드는 메타데이터에 기록되므로 다른 크기로 열 수 없다.
+	c.Store.FixedRecordSize = 16
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+	c.Store.FixedRecordSize = 0
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+}
+
 func testAppendRead(t *testing.T, log *Log) {
 	append := &api_v1.Record{
 		Value: []byte("hello world"),
@@ -131,3 +279,453 @@ func testNewSegment(t *testing.T, log *Log) {
 
 	require.Equal(t, 3, len(log.segments))
 }
+
+func testConfigMismatch(t *testing.T, log *Log) {
+	_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	c := log.Config
+	c.Segment.InitialOffset = 16
+	_, err = NewLog(log.Dir, c)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+
+	// 세그먼트 크기 같은 설정은 바꿔서 열어도 된다.
+	c = log.Config
+	c.Segment.MaxStoreBytes = 1024
+	n, err := NewLog(log.Dir, c)
+	require.NoError(t, err)
+	read, err := n.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), read.Value)
+}
+
+func testExpiredRecord(t *testing.T, log *Log) {
+	expireAt := time.Now().Add(-time.Minute).UnixNano()
+	off, err := log.Append(&api_v1.Record{
+		Value:    []byte("expired"),
+		ExpireAt: expireAt,
+	})
+	require.NoError(t, err)
+	_, err = log.Read(off)
+	require.Equal(t, api_v1.ErrRecordExpired{Offset: off, ExpireAt: expireAt}, err)
+
+	off, err = log.Append(&api_v1.Record{
+		Value:    []byte("alive"),
+		ExpireAt: time.Now().Add(time.Hour).UnixNano(),
+	})
+	require.NoError(t, err)
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("alive"), read.Value)
+}
+
+func testAppendAt(t *testing.T, log *Log) {
+	for i := uint64(0); i < 3; i++ {
+		err := log.AppendAt(i, &api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// 같은 내용을 다시 쓰면 아무 일도 일어나지 않는다.
+	err := log.AppendAt(1, &api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	err = log.AppendAt(1, &api_v1.Record{Value: []byte("something else")})
+	require.ErrorIs(t, err, ErrOffsetConflict)
+
+	// 원본에 빈 오프셋이 있으면 그 사이를 비워 두고 쓴다.
+	err = log.AppendAt(5, &api_v1.Record{Value: []byte("after gap")})
+	require.NoError(t, err)
+	off, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+	_, err = log.Read(4)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	read, err := log.Read(5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("after gap"), read.Value)
+	err = log.AppendAt(4, &api_v1.Record{Value: []byte("hello world")})
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+	// 세그먼트의 상대 오프셋을 넘는 간격이면 새 세그먼트에서 시작한다.
+	far := uint64(6) + math.MaxUint32 + 1
+	require.NoError(t, log.AppendAt(far, &api_v1.Record{Value: []byte("far")}))
+	read, err = log.Read(far)
+	require.NoError(t, err)
+	require.Equal(t, []byte("far"), read.Value)
+	require.Equal(t, far, log.activeSegment.baseOffset)
+	require.NoError(t, log.Close())
+
+	// 다시 열어도 빈 오프셋 다음부터 이어서 쓴다.
+	log, err = NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	defer log.Close()
+	read, err = log.Read(5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("after gap"), read.Value)
+	off, err = log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, far+1, off)
+}
+
+func TestLogFlush(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-flush-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 2 * entWidth
+	c.Store.FlushBytes = 1 << 20
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Flush())
+
+	// 닫지 않은 로그를 따로 열어도 Flush까지의 레코드를 모두 읽을 수 있다.
+	other, err := NewLog(dir, c)
+	require.NoError(t, err)
+	off, err := other.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	for off := uint64(0); off < 5; off++ {
+		record, err := other.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+	require.NoError(t, log.Close())
+	require.NoError(t, other.Close())
+}
+
+func TestLogFlushSkipsReadPathFlush(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = 2 * entWidth
+	c.Store.FlushBytes = 1 << 20
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Flush())
+
+	// 봉인된 세그먼트까지 버퍼가 모두 파일에 있으므로 읽을 때 플러시하지 않는다.
+	flushes := make([]uint64, len(log.segments))
+	for i, s := range log.segments {
+		fi, err := os.Stat(s.store.Name())
+		require.NoError(t, err)
+		require.Equal(t, int64(s.store.size), fi.Size())
+		flushes[i] = s.store.Stats().Flushes
+	}
+	for off := uint64(0); off < 5; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+	for i, s := range log.segments {
+		require.Equal(t, flushes[i], s.store.Stats().Flushes)
+	}
+}
+
+func TestLogSync(t *testing.T) {
+	for scenario, interval := range map[string]time.Duration{
+		"explicit sync": 0,
+		"periodic sync": 10 * time.Millisecond,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "log-sync-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxIndexBytes = 2 * entWidth
+			c.Store.FlushBytes = 1 << 20
+			c.SyncInterval = interval
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < 5; i++ {
+				_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			if interval == 0 {
+				require.NoError(t, log.Sync())
+			}
+
+			// 닫지 않은 로그를 따로 열어 죽은 뒤 다시 연 것처럼 확인한다.
+			require.Eventually(t, func() bool {
+				other, err := NewLog(dir, c)
+				require.NoError(t, err)
+				defer other.Close()
+				for off := uint64(0); off < 5; off++ {
+					if _, err := other.Read(off); err != nil {
+						return false
+					}
+				}
+				return true
+			}, time.Second, 20*time.Millisecond)
+
+			log.mu.RLock()
+			for _, s := range log.segments {
+				s.store.mu.Lock()
+				synced := s.store.synced && s.store.size == s.store.syncedSize
+				s.store.mu.Unlock()
+				require.True(t, synced)
+			}
+			log.mu.RUnlock()
+
+			require.NoError(t, log.Close())
+			select {
+			case <-log.done:
+			default:
+				t.Fatal("background loops still running after Close")
+			}
+		})
+	}
+}
+
+func TestLogCloseClosesAllSegments(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-close-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 3)
+
+	// 첫 세그먼트의 스토어를 미리 닫아서 Close가 실패하게 만든다.
+	require.NoError(t, log.segments[0].store.backend.Close())
+	require.Error(t, log.Close())
+	for _, s := range log.segments {
+		_, err := s.store.backend.Size()
+		require.ErrorIs(t, err, os.ErrClosed)
+		_, err = s.index.file.Stat()
+		require.ErrorIs(t, err, os.ErrClosed)
+	}
+}
+
+func TestLogLargeStore(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxStoreBytes = 8 << 30
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// 스토어 앞을 4GiB 넘게 비워 둔 희소 파일로 만들어 다음 레코드가 4GiB
+	// 경계 너머에 쓰이게 한다.
+	const hole = 1<<32 + 16
+	require.NoError(t, os.Truncate(filepath.Join(dir, "0.store"), hole))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	// 0으로 채워진 구간은 레코드가 아니다.
+	_, err = log.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	off, err := log.Append(&api_v1.Record{Value: []byte("past 4GiB")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	_, pos, err := log.segments[0].index.Read(int64(off))
+	require.NoError(t, err)
+	require.Equal(t, uint64(hole), pos)
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	record, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("past 4GiB"), record.Value)
+
+	// 다른 위치 폭으로는 열지 않고 어떻게 열어야 하는지 알린다.
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.IndexPositionWidth = 4
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrIndexWidth)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+	require.ErrorContains(t, err, "IndexPositionWidth 8")
+}
+
+func TestLogLifecycle(t *testing.T) {
+	appendTo := func(t *testing.T, log *Log) {
+		t.Helper()
+		for i := 0; i < 3; i++ {
+			_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+		}
+	}
+	newLog := func(t *testing.T, c Config) (*Log, string) {
+		t.Helper()
+		dir := filepath.Join(t.TempDir(), "log")
+		require.NoError(t, os.Mkdir(dir, 0755))
+		c.Segment.MaxIndexBytes = entWidth
+		c.Compaction.Interval = time.Millisecond
+		log, err := NewLog(dir, c)
+		require.NoError(t, err)
+		return log, dir
+	}
+
+	t.Run("close twice", func(t *testing.T) {
+		log, _ := newLog(t, Config{})
+		appendTo(t, log)
+		require.NoError(t, log.Close())
+		require.NoError(t, log.Close())
+	})
+
+	t.Run("remove after close", func(t *testing.T) {
+		log, dir := newLog(t, Config{})
+		appendTo(t, log)
+		require.NoError(t, log.Close())
+		require.NoError(t, log.Remove())
+		_, err := os.Stat(dir)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.NoError(t, log.Remove())
+		require.NoError(t, log.Close())
+	})
+
+	t.Run("use after remove", func(t *testing.T) {
+		log, _ := newLog(t, Config{})
+		appendTo(t, log)
+		require.NoError(t, log.Remove())
+
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.ErrorIs(t, err, ErrLogClosed)
+		err = log.AppendAt(3, &api_v1.Record{Value: []byte("hello world")})
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.DryRun(&api_v1.Record{Value: []byte("hello world")})
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.Read(0)
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.LowestOffset()
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.HighestOffset()
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.Stats()
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.OffsetForKey([]byte("key"))
+		require.ErrorIs(t, err, ErrLogClosed)
+		_, err = log.OffsetForTime(0)
+		require.ErrorIs(t, err, ErrLogClosed)
+		require.ErrorIs(t, log.Probe(), ErrLogClosed)
+	})
+
+	t.Run("reset fresh log", func(t *testing.T) {
+		log, dir := newLog(t, Config{})
+		require.NoError(t, log.Reset())
+		require.NoError(t, log.Reset())
+		appendTo(t, log)
+		record, err := log.Read(2)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), record.Offset)
+		_, err = os.Stat(filepath.Join(dir, metaFile))
+		require.NoError(t, err)
+		require.NoError(t, log.Close())
+	})
+
+	t.Run("reset after remove", func(t *testing.T) {
+		storage := NewMemoryStorage()
+		c := Config{}
+		c.Store.Storage = storage
+		log, _ := newLog(t, c)
+		appendTo(t, log)
+		require.NoError(t, log.Remove())
+		require.Empty(t, storage.Names())
+
+		// 다시 연 로그는 지운 레코드를 읽지 않고 처음부터 쓴다.
+		require.NoError(t, log.Reset())
+		off, err := log.Append(&api_v1.Record{Value: []byte("after reset")})
+		require.NoError(t, err)
+		require.Zero(t, off)
+		_, err = log.Read(1)
+		require.Error(t, err)
+		require.NoError(t, log.Close())
+		require.NoError(t, log.Remove())
+		require.Empty(t, storage.Names())
+	})
+}
+
+func TestLogProbe(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, log.Probe())
+
+	require.NoError(t, log.Close())
+	require.Error(t, log.Probe())
+}
+
+func TestLogHighWatermarkChanged(t *testing.T) {
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	changed := log.HighWatermarkChanged()
+	select {
+	case <-changed:
+		t.Fatal("closed before any append")
+	default:
+	}
+	_, err = log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not closed after an append")
+	}
+	// 다음 추가를 기다리는 새 채널이다.
+	select {
+	case <-log.HighWatermarkChanged():
+		t.Fatal("new channel already closed")
+	default:
+	}
+}
+
+func TestLogReaderSpansSegments(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 8; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 3)
+
+	var size uint64
+	for _, s := range log.segments {
+		size += s.store.size
+	}
+	b, err := io.ReadAll(log.Reader())
+	require.NoError(t, err)
+	require.Equal(t, size, uint64(len(b)))
+
+	// 문서에 적힌 형식대로 처음부터 레코드를 읽어 낼 수 있다.
+	for off := uint64(0); len(b) > 0; off++ {
+		n := enc.Uint64(b)
+		record := &api_v1.Record{}
+		require.NoError(t, proto.Unmarshal(b[lenWidth:lenWidth+n], record))
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, fmt.Sprintf("record %d", off), string(record.Value))
+		b = b[lenWidth+n:]
+	}
+
+	// 읽다가 세그먼트가 지워지면 잘린 로그를 끝까지 읽은 것처럼 보이지 않는다.
+	r := log.Reader()
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+	require.NoError(t, log.Truncate(2))
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrSegmentRemoved)
+}
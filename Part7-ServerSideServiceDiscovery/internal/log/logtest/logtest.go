@@ -0,0 +1,165 @@
+// logtest는 테스트가 원하는 상태의 로그 디렉터리를 파일로 직접 만든다.
+// 복구나 보존, 압축처럼 특정한 모양의 로그가 필요한 테스트에서 쓴다.
+// log 패키지를 쓰지 않고 디스크 형식대로 쓰므로 log 패키지 안의 테스트에서도 쓸 수 있다.
+package logtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// lenWidth는 log 패키지가 스토어의 레코드 앞에 붙이는 길이의 크기고,
+// crcWidth는 Store.Checksum일 때 길이 뒤에 붙는 체크섬의 크기다.
+const (
+	lenWidth = 8
+	crcWidth = 4
+)
+
+var enc = binary.BigEndian
+
+// Spec은 만들 로그의 세그먼트들이다. 세그먼트는 베이스 오프셋 순서대로 둔다.
+type Spec struct {
+	Segments []Segment
+	// Checksum이면 Store.Checksum을 켠 로그처럼 레코드마다 CRC32를 붙인다.
+	Checksum bool
+	// IndexPositionWidth가 4면 Segment.IndexPositionWidth가 4인 로그처럼
+	// 인덱스에 위치를 4바이트로 쓴다. 0이면 8바이트다.
+	IndexPositionWidth int
+}
+
+// Segment는 세그먼트 하나의 레코드들과 일부러 망가뜨릴 부분이다.
+// 레코드 수는 열 때 쓸 설정의 MaxIndexBytes에 들어갈 만큼이어야 한다.
+type Segment struct {
+	// BaseOffset이 0이면 앞 세그먼트 바로 다음 오프셋에서 시작한다.
+	BaseOffset uint64
+	Records    []Record
+
+	// Corrupt에 있는 번호의 레코드는 값을 0xff로 덮어 읽을 수 없게 만든다.
+	Corrupt []int
+	// TruncateTail만큼 스토어 끝을 잘라 쓰다 만 레코드를 만든다.
+	TruncateTail int
+	// DropIndex면 인덱스 파일을 만들지 않는다.
+	DropIndex bool
+}
+
+// Record는 레코드 하나다. ExpireAt이 0이면 만료되지 않는다.
+type Record struct {
+	Value    []byte
+	ExpireAt time.Time
+}
+
+// Layout은 Build가 만든 파일의 모양이다. 망가뜨린 만큼 기대값을 계산할 때 쓴다.
+type Layout struct {
+	Segments []SegmentLayout
+}
+
+type SegmentLayout struct {
+	BaseOffset uint64
+	// Positions[i]는 i번째 레코드가 스토어에서 시작하는 위치다.
+	Positions []uint64
+	// StoreSize는 TruncateTail만큼 자른 뒤의 스토어 크기다.
+	StoreSize uint64
+}
+
+// Records는 size 바이트짜리 값을 가진 레코드 n개를 만든다. 값은 매번 같다.
+func Records(n, size int) []Record {
+	records := make([]Record, n)
+	for i := range records {
+		value := make([]byte, size)
+		for j := range value {
+			value[j] = '.'
+		}
+		copy(value, fmt.Sprintf("record %d", i))
+		records[i].Value = value
+	}
+	return records
+}
+
+// Build는 dir에 spec대로 세그먼트 파일을 만든다. meta.json은 만들지 않으므로
+// 처음 여는 NewLog가 그때의 설정으로 만든다. 망가뜨리지 않은 spec이면 기본
+// 설정의 NewLog로 열어 모든 레코드를 읽을 수 있다.
+func Build(dir string, spec Spec) (*Layout, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	layout := &Layout{}
+	var next uint64
+	for _, s := range spec.Segments {
+		base := s.BaseOffset
+		if base == 0 {
+			base = next
+		}
+		sl, err := buildSegment(dir, base, s, spec)
+		if err != nil {
+			return nil, err
+		}
+		layout.Segments = append(layout.Segments, sl)
+		next = base + uint64(len(s.Records))
+	}
+	return layout, nil
+}
+
+func buildSegment(dir string, base uint64, s Segment, spec Spec) (SegmentLayout, error) {
+	sl := SegmentLayout{BaseOffset: base}
+	checksum := spec.Checksum
+	header := uint64(lenWidth)
+	if checksum {
+		header += crcWidth
+	}
+	var store, index []byte
+	for i, r := range s.Records {
+		record := &api_v1.Record{Value: r.Value, Offset: base + uint64(i)}
+		if !r.ExpireAt.IsZero() {
+			record.ExpireAt = r.ExpireAt.UnixNano()
+		}
+		p, err := proto.Marshal(record)
+		if err != nil {
+			return sl, err
+		}
+		pos := uint64(len(store))
+		sl.Positions = append(sl.Positions, pos)
+		store = enc.AppendUint64(store, uint64(len(p)))
+		if checksum {
+			store = enc.AppendUint32(store, crc32.Checksum(p, crc32.MakeTable(crc32.Castagnoli)))
+		}
+		store = append(store, p...)
+		index = enc.AppendUint32(index, uint32(i))
+		if spec.IndexPositionWidth == 4 {
+			index = enc.AppendUint32(index, uint32(pos))
+		} else {
+			index = enc.AppendUint64(index, pos)
+		}
+	}
+
+	for _, i := range s.Corrupt {
+		if i < 0 || i >= len(s.Records) {
+			return sl, fmt.Errorf("corrupt record %d out of range", i)
+		}
+		start := sl.Positions[i] + header
+		end := start + enc.Uint64(store[sl.Positions[i]:])
+		for j := start; j < end; j++ {
+			store[j] = 0xff
+		}
+	}
+	if s.TruncateTail > len(store) {
+		return sl, fmt.Errorf("cannot truncate %d bytes of a %d byte store", s.TruncateTail, len(store))
+	}
+	store = store[:len(store)-s.TruncateTail]
+	sl.StoreSize = uint64(len(store))
+
+	name := filepath.Join(dir, fmt.Sprint(base))
+	if err := os.WriteFile(name+".store", store, 0644); err != nil {
+		return sl, err
+	}
+	if s.DropIndex {
+		return sl, nil
+	}
+	return sl, os.WriteFile(name+".index", index, 0644)
+}
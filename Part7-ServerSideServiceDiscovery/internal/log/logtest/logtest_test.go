@@ -0,0 +1,104 @@
+package logtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	dir, err := os.MkdirTemp("", "logtest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	expired := Records(1, 32)
+	expired[0].ExpireAt = time.Unix(1, 0)
+	layout, err := Build(dir, Spec{Segments: []Segment{
+		{Records: Records(3, 32)},
+		{Records: expired},
+		{BaseOffset: 10, Records: Records(2, 32)},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 3, 10}, []uint64{
+		layout.Segments[0].BaseOffset,
+		layout.Segments[1].BaseOffset,
+		layout.Segments[2].BaseOffset,
+	})
+
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer l.Close()
+
+	for off, want := range map[uint64][]byte{
+		0:  Records(3, 32)[0].Value,
+		2:  Records(3, 32)[2].Value,
+		10: Records(2, 32)[0].Value,
+		11: Records(2, 32)[1].Value,
+	} {
+		record, err := l.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, record.Offset)
+		require.Equal(t, want, record.Value)
+	}
+	_, err = l.Read(3)
+	require.IsType(t, api_v1.ErrRecordExpired{}, err)
+	_, err = l.Read(5)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+	off, err := l.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), off)
+}
+
+func TestBuildChecksum(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Build(dir, Spec{
+		Checksum: true,
+		Segments: []Segment{{Records: Records(3, 32), Corrupt: []int{1}}},
+	})
+	require.NoError(t, err)
+
+	c := log.Config{}
+	c.Store.Checksum = true
+	l, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+
+	for off, want := range Records(3, 32) {
+		record, err := l.Read(uint64(off))
+		if off == 1 {
+			require.ErrorIs(t, err, log.ErrCorruptRecord)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, want.Value, record.Value)
+	}
+}
+
+func TestBuildIndexPositionWidth(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Build(dir, Spec{
+		IndexPositionWidth: 4,
+		Segments:           []Segment{{Records: Records(3, 32)}},
+	})
+	require.NoError(t, err)
+	fi, err := os.Stat(filepath.Join(dir, "0.index"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3*8), fi.Size())
+
+	c := log.Config{}
+	c.Segment.IndexPositionWidth = 4
+	l, err := log.NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	for off, want := range Records(3, 32) {
+		record, err := l.Read(uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, want.Value, record.Value)
+	}
+}
@@ -0,0 +1,123 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+const (
+	metaFile    = "meta.json"
+	metaVersion = 1
+)
+
+var ErrConfigMismatch = errors.New("log config does not match the existing log")
+
+// meta는 로그를 처음 만들 때의 설정 중 나중에 바뀌면 안 되는 값들이다.
+// 세그먼트 크기처럼 바뀌어도 되는 설정은 담지 않는다.
+type meta struct {
+	Version       int    `json:"version"`
+	InitialOffset uint64 `json:"initial_offset"`
+	LenWidth      uint64 `json:"len_width"`
+	EntryWidth    uint64 `json:"entry_width"`
+	// 아래 필드들은 기본값이면 생략해서 예전에 만든 meta.json과도 맞는다.
+	FixedRecordSize int    `json:"fixed_record_size,omitempty"`
+	Codec           string `json:"codec,omitempty"`
+	IndexStride     uint64 `json:"index_stride,omitempty"`
+	Checksum        bool   `json:"checksum,omitempty"`
+	OffsetAllocator string `json:"offset_allocator,omitempty"`
+}
+
+func newMeta(c Config) meta {
+	m := meta{
+		Version:         metaVersion,
+		InitialOffset:   c.Segment.InitialOffset,
+		LenWidth:        lenWidth,
+		EntryWidth:      c.entryWidth(),
+		FixedRecordSize: c.Store.FixedRecordSize,
+		Codec:           c.Store.Codec,
+		Checksum:        c.Store.Checksum,
+		OffsetAllocator: allocatorID(c.OffsetAllocator),
+	}
+	if c.strided() {
+		m.IndexStride = c.Segment.IndexStride
+	}
+	return m
+}
+
+func (m meta) check(other meta) error {
+	if m.EntryWidth != other.EntryWidth {
+		// 인덱스 항목 크기가 다르면 인덱스를 잘못 읽으므로 어떻게 열어야 하는지 알린다.
+		return fmt.Errorf(
+			"%w: %w: existing index entries are %d bytes, config makes %d; open it with Segment.IndexPositionWidth %d",
+			ErrConfigMismatch, ErrIndexWidth, other.EntryWidth, m.EntryWidth, other.EntryWidth-offWidth,
+		)
+	}
+	if m.OffsetAllocator != other.OffsetAllocator {
+		// 다른 할당기로 이어 쓰면 오프셋이 섞이므로 어떤 할당기로 열어야 하는지 알린다.
+		return fmt.Errorf(
+			"%w: %w: existing log uses %q, config uses %q",
+			ErrConfigMismatch, ErrAllocatorMismatch, describeAllocator(other.OffsetAllocator), describeAllocator(m.OffsetAllocator),
+		)
+	}
+	if m != other {
+		return fmt.Errorf("%w: have %+v, want %+v", ErrConfigMismatch, other, m)
+	}
+	return nil
+}
+
+func describeAllocator(id string) string {
+	if id == "" {
+		return "sequential"
+	}
+	return id
+}
+
+// setupMeta는 meta.json이 없으면 현재 설정으로 만들고,
+// 있으면 현재 설정과 충돌하지 않는지 확인한다.
+func (l *Log) setupMeta() error {
+	want := newMeta(l.Config)
+	name := path.Join(l.Dir, metaFile)
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return writeMeta(name, want)
+	}
+	if err != nil {
+		return err
+	}
+	var have meta
+	if err := json.Unmarshal(b, &have); err != nil {
+		return err
+	}
+	return want.check(have)
+}
+
+func writeMeta(name string, m meta) error {
+	return writeJSON(name, m)
+}
+
+// writeJSON은 v를 임시 파일에 쓰고 동기화한 뒤 name으로 바꿔 끼운다.
+func writeJSON(name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
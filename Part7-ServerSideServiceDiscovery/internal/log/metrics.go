@@ -0,0 +1,154 @@
+package log
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	SegmentsCreated = stats.Int64(
+		"proglog/log/segments_created",
+		"Number of segments created",
+		stats.UnitDimensionless,
+	)
+	SegmentsDeleted = stats.Int64(
+		"proglog/log/segments_deleted",
+		"Number of segments deleted by truncation or retention",
+		stats.UnitDimensionless,
+	)
+	BytesReclaimed = stats.Int64(
+		"proglog/log/bytes_reclaimed",
+		"Bytes freed by deleting or compacting segments",
+		stats.UnitBytes,
+	)
+	CompactionRuns = stats.Int64(
+		"proglog/log/compaction_runs",
+		"Number of compaction runs",
+		stats.UnitDimensionless,
+	)
+	LastCompactionReclaimed = stats.Int64(
+		"proglog/log/last_compaction_reclaimed_bytes",
+		"Bytes freed by the most recent compaction run",
+		stats.UnitBytes,
+	)
+
+	StoreAppendedBytes = stats.Int64(
+		"proglog/log/store_appended_bytes",
+		"Bytes appended to segment stores, including length prefixes",
+		stats.UnitBytes,
+	)
+	StoreReadBytes = stats.Int64(
+		"proglog/log/store_read_bytes",
+		"Bytes read from segment store files",
+		stats.UnitBytes,
+	)
+	StoreFlushes = stats.Int64(
+		"proglog/log/store_flushes",
+		"Number of store buffer writes to the file",
+		stats.UnitDimensionless,
+	)
+	StoreSyncs = stats.Int64(
+		"proglog/log/store_syncs",
+		"Number of store fsyncs",
+		stats.UnitDimensionless,
+	)
+	StoreReadAts = stats.Int64(
+		"proglog/log/store_read_at_calls",
+		"Number of ReadAt calls on store files",
+		stats.UnitDimensionless,
+	)
+
+	ReadCacheHits = stats.Int64(
+		"proglog/log/read_cache_hits",
+		"Number of reads served from the read cache",
+		stats.UnitDimensionless,
+	)
+	ReadCacheMisses = stats.Int64(
+		"proglog/log/read_cache_misses",
+		"Number of reads that missed the read cache",
+		stats.UnitDimensionless,
+	)
+)
+
+// Views는 로그의 세그먼트 생명주기와 스토어 I/O, 읽기 캐시 지표를 모아 놓은 것이다.
+// 다른 OpenCensus 뷰와 함께 view.Register로 등록하면 된다.
+var Views = []*view.View{
+	{
+		Name:        "proglog/log/segments_created",
+		Measure:     SegmentsCreated,
+		Description: SegmentsCreated.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/segments_deleted",
+		Measure:     SegmentsDeleted,
+		Description: SegmentsDeleted.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/bytes_reclaimed",
+		Measure:     BytesReclaimed,
+		Description: BytesReclaimed.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/compaction_runs",
+		Measure:     CompactionRuns,
+		Description: CompactionRuns.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/last_compaction_reclaimed_bytes",
+		Measure:     LastCompactionReclaimed,
+		Description: LastCompactionReclaimed.Description(),
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        "proglog/log/store_appended_bytes",
+		Measure:     StoreAppendedBytes,
+		Description: StoreAppendedBytes.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/store_read_bytes",
+		Measure:     StoreReadBytes,
+		Description: StoreReadBytes.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/store_flushes",
+		Measure:     StoreFlushes,
+		Description: StoreFlushes.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/store_syncs",
+		Measure:     StoreSyncs,
+		Description: StoreSyncs.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/store_read_at_calls",
+		Measure:     StoreReadAts,
+		Description: StoreReadAts.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/read_cache_hits",
+		Measure:     ReadCacheHits,
+		Description: ReadCacheHits.Description(),
+		Aggregation: view.Sum(),
+	},
+	{
+		Name:        "proglog/log/read_cache_misses",
+		Measure:     ReadCacheMisses,
+		Description: ReadCacheMisses.Description(),
+		Aggregation: view.Sum(),
+	},
+}
+
+func recordStats(ms ...stats.Measurement) {
+	stats.Record(context.Background(), ms...)
+}
@@ -0,0 +1,49 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestSegmentMetrics(t *testing.T) {
+	require.NoError(t, view.Register(Views...))
+
+	dir, err := os.MkdirTemp("", "metrics-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	created := viewSum(t, "proglog/log/segments_created")
+	deleted := viewSum(t, "proglog/log/segments_deleted")
+	reclaimed := viewSum(t, "proglog/log/bytes_reclaimed")
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// 세그먼트마다 레코드가 하나씩 들어가므로 두 번 새 세그먼트를 만든다.
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Equal(t, created+3, viewSum(t, "proglog/log/segments_created"))
+
+	require.NoError(t, log.Truncate(1))
+	require.Equal(t, deleted+2, viewSum(t, "proglog/log/segments_deleted"))
+	require.Less(t, reclaimed, viewSum(t, "proglog/log/bytes_reclaimed"))
+}
+
+func viewSum(t *testing.T, name string) float64 {
+	t.Helper()
+	rows, err := view.RetrieveData(name)
+	require.NoError(t, err)
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.SumData).Value
+}
@@ -0,0 +1,77 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidName = errors.New("invalid namespace name")
+
+// Namespaces는 테넌트와 토픽마다 따로 로그를 둔다. 각 로그는
+// Dir/<tenant>/<topic> 아래에 있고 처음 요청할 때 열린다.
+type Namespaces struct {
+	Dir    string
+	Config Config
+
+	mu   sync.Mutex
+	logs map[string]*Log
+}
+
+func NewNamespaces(dir string, c Config) *Namespaces {
+	return &Namespaces{
+		Dir:    dir,
+		Config: c,
+		logs:   make(map[string]*Log),
+	}
+}
+
+// Log는 tenant의 topic 로그를 리턴한다. 이름에 경로 구분자나 ".", ".."이
+// 있으면 다른 테넌트의 디렉터리를 가리킬 수 있으므로 ErrInvalidName을 리턴한다.
+func (n *Namespaces) Log(tenant, topic string) (*Log, error) {
+	if err := ValidName(tenant); err != nil {
+		return nil, err
+	}
+	if err := ValidName(topic); err != nil {
+		return nil, err
+	}
+	key := tenant + "/" + topic
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if l, ok := n.logs[key]; ok {
+		return l, nil
+	}
+	dir := filepath.Join(n.Dir, tenant, topic)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l, err := NewLog(dir, n.Config)
+	if err != nil {
+		return nil, err
+	}
+	n.logs[key] = l
+	return l, nil
+}
+
+func (n *Namespaces) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var errs []error
+	for key, l := range n.logs {
+		errs = append(errs, l.Close())
+		delete(n.logs, key)
+	}
+	return errors.Join(errs...)
+}
+
+func ValidName(name string) error {
+	if name == "" || name == "." || name == ".." ||
+		strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, os.PathSeparator) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaces(t *testing.T) {
+	dir, err := os.MkdirTemp("", "namespace-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	n := NewNamespaces(dir, Config{})
+	defer n.Close()
+
+	a, err := n.Log("tenant-a", "orders")
+	require.NoError(t, err)
+	b, err := n.Log("tenant-b", "orders")
+	require.NoError(t, err)
+	require.NotSame(t, a, b)
+	require.Equal(t, filepath.Join(dir, "tenant-a", "orders"), a.Dir)
+
+	_, err = a.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	_, err = b.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+	again, err := n.Log("tenant-a", "orders")
+	require.NoError(t, err)
+	require.Same(t, a, again)
+
+	for _, name := range []string{"", ".", "..", "../tenant-b", "a/b", `a\b`} {
+		_, err = n.Log("tenant-a", name)
+		require.ErrorIs(t, err, ErrInvalidName, name)
+	}
+}
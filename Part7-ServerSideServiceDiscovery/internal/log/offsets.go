@@ -0,0 +1,73 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+)
+
+const offsetsFile = "offsets.json"
+
+var ErrGroupName = errors.New("consumer group name is empty")
+
+// CommitOffset은 컨슈머 그룹 group이 커밋한 오프셋을 offsets.json에 남긴다.
+// 파일을 통째로 새로 쓰고 동기화한 뒤 바꿔 끼우므로 로그를 다시 열어도,
+// 쓰다가 멈춰도 이전 값이나 새 값 중 하나가 남는다. 커밋은 레코드와 상관없이
+// 남으므로 Truncate로 지운 오프셋을 가리킬 수도 있다.
+func (l *Log) CommitOffset(group string, offset uint64) error {
+	if group == "" {
+		return ErrGroupName
+	}
+	l.offsetsMu.Lock()
+	defer l.offsetsMu.Unlock()
+	offsets, err := l.loadOffsets()
+	if err != nil {
+		return err
+	}
+	next := make(map[string]uint64, len(offsets)+1)
+	for g, off := range offsets {
+		next[g] = off
+	}
+	next[group] = offset
+	if err := writeJSON(path.Join(l.Dir, offsetsFile), next); err != nil {
+		return err
+	}
+	l.groupOffsets = next
+	return nil
+}
+
+// FetchOffset은 group이 마지막으로 커밋한 오프셋을 리턴한다. 커밋한 적이
+// 없으면 ok가 false다.
+func (l *Log) FetchOffset(group string) (offset uint64, ok bool, err error) {
+	if group == "" {
+		return 0, false, ErrGroupName
+	}
+	l.offsetsMu.Lock()
+	defer l.offsetsMu.Unlock()
+	offsets, err := l.loadOffsets()
+	if err != nil {
+		return 0, false, err
+	}
+	offset, ok = offsets[group]
+	return offset, ok, nil
+}
+
+// loadOffsets는 처음 불릴 때 offsets.json을 읽는다. offsetsMu를 잡고 불러야 한다.
+func (l *Log) loadOffsets() (map[string]uint64, error) {
+	if l.groupOffsets != nil {
+		return l.groupOffsets, nil
+	}
+	offsets := make(map[string]uint64)
+	b, err := os.ReadFile(path.Join(l.Dir, offsetsFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &offsets); err != nil {
+			return nil, err
+		}
+	}
+	l.groupOffsets = offsets
+	return offsets, nil
+}
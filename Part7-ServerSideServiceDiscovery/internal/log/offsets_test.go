@@ -0,0 +1,46 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogGroupOffsets(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+
+	_, ok, err := log.FetchOffset("billing")
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, err = os.Stat(filepath.Join(dir, offsetsFile))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, log.CommitOffset("billing", 7))
+	require.NoError(t, log.CommitOffset("billing", 9))
+	require.NoError(t, log.CommitOffset("audit", 3))
+	require.ErrorIs(t, log.CommitOffset("", 1), ErrGroupName)
+	_, _, err = log.FetchOffset("")
+	require.ErrorIs(t, err, ErrGroupName)
+	require.NoError(t, log.Close())
+
+	// 다시 연 로그는 offsets.json에서 커밋한 오프셋을 읽는다.
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	for group, want := range map[string]uint64{"billing": 9, "audit": 3} {
+		off, ok, err := log.FetchOffset(group)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, want, off)
+	}
+
+	// Reset한 로그는 이전 커밋을 잊는다.
+	require.NoError(t, log.Reset())
+	_, ok, err = log.FetchOffset("billing")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, log.Close())
+}
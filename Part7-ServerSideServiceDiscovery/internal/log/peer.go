@@ -0,0 +1,46 @@
+package log
+
+import (
+	"context"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+// PeerReader는 클러스터의 다른 노드에서 레코드를 읽어 온다. Replicator가 구현한다.
+type PeerReader interface {
+	ReadFromPeer(ctx context.Context, off uint64) (*api_v1.Record, error)
+}
+
+// readFromPeer는 로컬에 없는 off를 캐시나 다른 노드에서 찾는다. 어디에도 없으면
+// 로컬에서 받은 에러 localErr를 그대로 리턴한다.
+func (l *Log) readFromPeer(off uint64, localErr error) (*api_v1.Record, error) {
+	l.peerMu.Lock()
+	record, ok := l.peerCache[off]
+	l.peerMu.Unlock()
+	if ok {
+		return record, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.Config.PeerFallback.Timeout)
+	defer cancel()
+	record, err := l.Config.PeerFallback.Reader.ReadFromPeer(ctx, off)
+	if err != nil {
+		return nil, localErr
+	}
+
+	l.peerMu.Lock()
+	defer l.peerMu.Unlock()
+	if l.peerCache == nil {
+		l.peerCache = make(map[uint64]*api_v1.Record)
+	}
+	if _, ok := l.peerCache[off]; !ok {
+		// 가장 먼저 담은 레코드부터 내보낸다.
+		if len(l.peerOrder) >= l.Config.PeerFallback.CacheSize {
+			delete(l.peerCache, l.peerOrder[0])
+			l.peerOrder = l.peerOrder[1:]
+		}
+		l.peerOrder = append(l.peerOrder, off)
+	}
+	l.peerCache[off] = record
+	return record, nil
+}
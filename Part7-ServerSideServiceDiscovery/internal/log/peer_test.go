@@ -0,0 +1,125 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestReadFromPeer(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		peers   []map[uint64]*api_v1.Record
+		slow    bool
+		wantErr bool
+	}{
+		"peer has the offset": {
+			peers: []map[uint64]*api_v1.Record{
+				{},
+				{5: {Value: []byte("from peer"), Offset: 5}},
+			},
+		},
+		"no peer has the offset": {
+			peers:   []map[uint64]*api_v1.Record{{}, {}},
+			wantErr: true,
+		},
+		"peer does not answer in time": {
+			peers:   []map[uint64]*api_v1.Record{{5: {Value: []byte("from peer"), Offset: 5}}},
+			slow:    true,
+			wantErr: true,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			r := &Replicator{
+				DialOptions: []grpc.DialOption{
+					grpc.WithTransportCredentials(insecure.NewCredentials()),
+				},
+			}
+			defer r.Close()
+			var stops []func()
+			for i, records := range tc.peers {
+				addr, stop := startPeer(t, records, tc.slow)
+				stops = append(stops, stop)
+				require.NoError(t, r.Join(fmt.Sprintf("peer-%d", i), addr))
+			}
+
+			dir, err := os.MkdirTemp("", "peer-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			c := Config{}
+			c.PeerFallback.Reader = r
+			c.PeerFallback.Timeout = 100 * time.Millisecond
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			start := time.Now()
+			record, err := log.Read(5)
+			if tc.wantErr {
+				require.ErrorAs(t, err, &api_v1.ErrOffsetOutOfRange{})
+				require.Less(t, time.Since(start), time.Second)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []byte("from peer"), record.Value)
+
+			// 한 번 읽어 온 레코드는 노드들이 사라져도 캐시에서 읽는다.
+			for _, stop := range stops {
+				stop()
+			}
+			record, err = log.Read(5)
+			require.NoError(t, err)
+			require.Equal(t, []byte("from peer"), record.Value)
+		})
+	}
+}
+
+func TestReadFromPeerDisabled(t *testing.T) {
+	dir, err := os.MkdirTemp("", "peer-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Read(5)
+	require.ErrorAs(t, err, &api_v1.ErrOffsetOutOfRange{})
+}
+
+// peerServer는 정해진 레코드만 Consume으로 돌려주는 다른 노드다.
+// slow면 요청이 끝날 때까지 답하지 않는다.
+type peerServer struct {
+	api_v1.UnimplementedLogServer
+	records map[uint64]*api_v1.Record
+	slow    bool
+}
+
+func (p *peerServer) Consume(ctx context.Context, req *api_v1.ConsumeRequest) (*api_v1.ConsumeResponse, error) {
+	if p.slow {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	record, ok := p.records[req.Offset]
+	if !ok {
+		return nil, api_v1.ErrOffsetOutOfRange{Offset: req.Offset}
+	}
+	return &api_v1.ConsumeResponse{Record: record}, nil
+}
+
+func startPeer(t *testing.T, records map[uint64]*api_v1.Record, slow bool) (string, func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	api_v1.RegisterLogServer(srv, &peerServer{records: records, slow: slow})
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return l.Addr().String(), srv.Stop
+}
@@ -0,0 +1,113 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// readCache는 최근에 읽은 레코드를 오프셋으로 찾는 LRU 캐시다. 담은 레코드의
+// 인코딩 크기 합이 max를 넘지 않도록 가장 오래 읽지 않은 레코드부터 내보낸다.
+// 레코드 수가 아니라 바이트로 재므로 큰 레코드가 많아도 메모리가 max에 묶인다.
+// Read가 Log.mu를 읽기로만 잡고 함께 부르므로 mu로 따로 지킨다.
+type readCache struct {
+	mu      sync.Mutex
+	max     uint64
+	size    uint64
+	order   *list.List
+	entries map[uint64]*list.Element
+}
+
+type readCacheEntry struct {
+	off    uint64
+	record *api_v1.Record
+	size   uint64
+}
+
+// newReadCache는 max 바이트까지 담는 캐시를 만든다. max가 0이면 nil을 리턴하고
+// nil 캐시는 아무것도 담지 않는다.
+func newReadCache(max uint64) *readCache {
+	if max == 0 {
+		return nil
+	}
+	return &readCache{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[uint64]*list.Element),
+	}
+}
+
+// get은 off의 레코드를 찾고 찾은 레코드를 가장 최근에 읽은 것으로 옮긴다.
+// 찾았는지를 ReadCacheHits와 ReadCacheMisses로 센다.
+func (c *readCache) get(off uint64) (*api_v1.Record, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[off]
+	if !ok {
+		recordStats(ReadCacheMisses.M(1))
+		return nil, false
+	}
+	recordStats(ReadCacheHits.M(1))
+	c.order.MoveToFront(e)
+	return e.Value.(*readCacheEntry).record, true
+}
+
+// put은 off의 레코드를 담는다. 혼자서 max를 넘는 레코드는 담지 않는다.
+func (c *readCache) put(off uint64, record *api_v1.Record) {
+	if c == nil {
+		return
+	}
+	size := uint64(proto.Size(record))
+	if size > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(off)
+	for c.size+size > c.max {
+		c.remove(c.order.Back().Value.(*readCacheEntry).off)
+	}
+	c.entries[off] = c.order.PushFront(&readCacheEntry{off: off, record: record, size: size})
+	c.size += size
+}
+
+func (c *readCache) remove(off uint64) {
+	e, ok := c.entries[off]
+	if !ok {
+		return
+	}
+	c.order.Remove(e)
+	delete(c.entries, off)
+	c.size -= e.Value.(*readCacheEntry).size
+}
+
+// drop은 [from, to) 범위의 레코드를 내보낸다. 세그먼트를 지우거나 다시 쓸 때 부른다.
+func (c *readCache) drop(from, to uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for off := range c.entries {
+		if from <= off && off < to {
+			c.remove(off)
+		}
+	}
+}
+
+// reset은 캐시를 비운다.
+func (c *readCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+	c.size = 0
+}
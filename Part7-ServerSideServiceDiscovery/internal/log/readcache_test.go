@@ -0,0 +1,112 @@
+package log
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReadCache(t *testing.T) {
+	storage := &countingStorage{StoreStorage: NewMemoryStorage()}
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	c.Store.Storage = storage
+	// 로그가 오프셋과 시각을 넣은 레코드 두 개가 들어갈 만큼만 둔다.
+	size := proto.Size(&api_v1.Record{
+		Value:     []byte("hello 0"),
+		Offset:    1,
+		Timestamp: time.Now().UnixNano(),
+	})
+	c.ReadCacheSize = uint64(size*2 + size/2)
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// 세그먼트마다 레코드 3개씩, 0-2, 3-5, 6
+	for i := 0; i < 7; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte(fmt.Sprintf("hello %d", i))})
+		require.NoError(t, err)
+	}
+	read := func(off uint64) int64 {
+		t.Helper()
+		before := storage.reads.Load()
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("hello %d", off), string(record.Value))
+		return storage.reads.Load() - before
+	}
+
+	// 두 번째로 읽을 때는 스토어를 읽지 않는다.
+	require.Positive(t, read(1))
+	require.Zero(t, read(1))
+
+	// 크기를 넘으면 가장 오래 읽지 않은 레코드부터 내보낸다.
+	require.Positive(t, read(4))
+	require.Zero(t, read(1))
+	require.Positive(t, read(5))
+	require.Zero(t, read(1))
+	require.Positive(t, read(4))
+
+	// 지운 세그먼트의 레코드는 캐시에서도 읽을 수 없고 남은 세그먼트의
+	// 레코드는 그대로 캐시에 있다.
+	require.NoError(t, log.Truncate(2))
+	_, err = log.Read(1)
+	require.ErrorAs(t, err, &api_v1.ErrOffsetOutOfRange{})
+	require.Zero(t, read(4))
+}
+
+func TestReadCacheCompact(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	c.Compaction.ByKey = true
+	c.ReadCacheSize = 1 << 20
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(&api_v1.Record{Key: []byte("key"), Value: []byte(fmt.Sprintf("value %d", i))})
+		require.NoError(t, err)
+	}
+	_, err = log.Read(0)
+	require.NoError(t, err)
+
+	// 압축으로 지운 레코드는 캐시에 남아 있어도 읽을 수 없다.
+	_, err = log.Compact()
+	require.NoError(t, err)
+	_, err = log.Read(0)
+	require.Error(t, err)
+}
+
+// countingStorage는 연 스토어의 ReadAt 횟수를 센다.
+type countingStorage struct {
+	StoreStorage
+	reads atomic.Int64
+}
+
+func (s *countingStorage) Open(name string) (StoreBackend, error) {
+	b, err := s.StoreStorage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingBackend{StoreBackend: b, reads: &s.reads}, nil
+}
+
+type countingBackend struct {
+	StoreBackend
+	reads *atomic.Int64
+}
+
+func (b countingBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.reads.Add(1)
+	return b.StoreBackend.ReadAt(p, off)
+}
+
+func (b countingBackend) Name() string {
+	return b.StoreBackend.(interface{ Name() string }).Name()
+}
@@ -0,0 +1,111 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+// ReaderAt은 레코드 값들을 오프셋 순서대로 이어 붙인 하나의 바이트 공간을
+// io.ReaderAt으로 보여준다. 레코드의 길이 정보 같은 프레이밍은 들어가지 않는다.
+// 바이트 위치가 바뀌지 않도록 만료된 레코드의 값도 그대로 포함한다.
+func (l *Log) ReaderAt() io.ReaderAt {
+	return &valueReaderAt{log: l}
+}
+
+type valueReaderAt struct {
+	log *Log
+
+	mu sync.Mutex
+	// offs[i]는 i번째 레코드의 오프셋이고 ends[i]는 그 레코드까지의 값 길이를
+	// 모두 더한 것이다. 읽는 범위에 따라 필요한 만큼만 늘린다.
+	offs []uint64
+	ends []int64
+	// next는 다음에 읽어 볼 오프셋이고, started는 next를 정했는지다.
+	next    uint64
+	started bool
+}
+
+func (r *valueReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		i, err := r.locate(pos)
+		if err != nil {
+			return n, err
+		}
+		record, err := r.read(r.offs[i])
+		if err != nil {
+			return n, err
+		}
+		start := r.ends[i] - int64(len(record.Value))
+		n += copy(p[n:], record.Value[pos-start:])
+	}
+	return n, nil
+}
+
+// locate는 pos 바이트를 담고 있는 레코드의 인덱스를 리턴한다.
+// 로그 끝을 넘으면 io.EOF를 리턴한다. 압축으로 지운 레코드처럼 로그 끝보다
+// 앞인데 없는 오프셋은 건너뛴다.
+func (r *valueReaderAt) locate(pos int64) (int, error) {
+	for {
+		i := sort.Search(len(r.ends), func(i int) bool { return r.ends[i] > pos })
+		if i < len(r.ends) {
+			return i, nil
+		}
+		off, err := r.nextOffset()
+		if err != nil {
+			return 0, err
+		}
+		record, err := r.read(off)
+		if _, ok := err.(api_v1.ErrOffsetOutOfRange); ok {
+			highest, herr := r.log.HighestOffset()
+			if herr != nil {
+				return 0, herr
+			}
+			if off >= highest {
+				return 0, io.EOF
+			}
+			r.next = off + 1
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.next = off + 1
+		var end int64
+		if len(r.ends) > 0 {
+			end = r.ends[len(r.ends)-1]
+		}
+		r.offs = append(r.offs, off)
+		r.ends = append(r.ends, end+int64(len(record.Value)))
+	}
+}
+
+// nextOffset은 다음에 읽어 볼 레코드의 오프셋이다. OffsetAllocator에 따라
+// 오프셋 사이에 빈 곳이 있을 수 있다.
+func (r *valueReaderAt) nextOffset() (uint64, error) {
+	if !r.started {
+		lowest, err := r.log.LowestOffset()
+		if err != nil {
+			return 0, err
+		}
+		r.next, r.started = lowest, true
+	}
+	return r.log.Config.next(r.next), nil
+}
+
+func (r *valueReaderAt) read(off uint64) (*api_v1.Record, error) {
+	r.log.mu.RLock()
+	defer r.log.mu.RUnlock()
+	return r.log.read(off)
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogReaderAt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "reader-at-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	var want []byte
+	for i := 0; i < 10; i++ {
+		// 빈 값도 섞어서 레코드 경계를 다양하게 만든다.
+		value := bytes.Repeat([]byte{byte('a' + i)}, i%4)
+		_, err := log.Append(&api_v1.Record{Value: value})
+		require.NoError(t, err)
+		want = append(want, value...)
+	}
+	require.Greater(t, len(log.segments), 1)
+
+	r := log.ReaderAt()
+	for off := 0; off < len(want); off++ {
+		for size := 1; off+size <= len(want); size++ {
+			p := make([]byte, size)
+			n, err := r.ReadAt(p, int64(off))
+			require.NoError(t, err)
+			require.Equal(t, size, n)
+			require.Equal(t, want[off:off+size], p, fmt.Sprintf("off %d size %d", off, size))
+		}
+	}
+
+	p := make([]byte, 4)
+	n, err := r.ReadAt(p, int64(len(want)-2))
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, want[len(want)-2:], p[:n])
+
+	// 나중에 추가된 레코드도 읽을 수 있다.
+	_, err = log.Append(&api_v1.Record{Value: []byte("more")})
+	require.NoError(t, err)
+	n, err = r.ReadAt(p, int64(len(want)))
+	require.NoError(t, err)
+	require.Equal(t, []byte("more"), p[:n])
+}
+
+func TestLogReaderAtAfterCompaction(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 200
+	c.Compaction.ByKey = true
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// 짝수 번째 레코드는 키가 같아서 압축하면 마지막 것만 남는다.
+	const n = 20
+	for i := 0; i < n; i++ {
+		record := &api_v1.Record{Value: []byte(fmt.Sprintf("r%03d", i))}
+		if i%2 == 0 {
+			record.Key = []byte("dup")
+		}
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+	_, err = log.Compact()
+	require.NoError(t, err)
+
+	var want []byte
+	for off := uint64(0); off < n; off++ {
+		record, err := log.Read(off)
+		if _, ok := err.(api_v1.ErrOffsetOutOfRange); ok {
+			continue
+		}
+		require.NoError(t, err)
+		want = append(want, record.Value...)
+	}
+	require.Less(t, len(want), 4*n)
+
+	// 지운 오프셋을 건너뛰고 남은 레코드를 모두 읽는다.
+	got, err := io.ReadAll(io.NewSectionReader(log.ReaderAt(), 0, 1<<20))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
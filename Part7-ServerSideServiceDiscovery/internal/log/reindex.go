@@ -0,0 +1,177 @@
+package log
+
+import (
+	"errors"
+	"sort"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"go.uber.org/zap"
+)
+
+// RebuildIndex는 모든 세그먼트의 인덱스를 스토어를 처음부터 읽어 다시 만든다.
+// 인덱스 파일이 잘리거나 깨져서 읽기가 실패할 때 로그를 닫지 않고 부른다.
+// 스토어에서 길이가 맞지 않거나 읽을 수 없는 레코드를 만나면 그 앞까지만
+// 인덱스에 넣는다. 다시 만드는 동안 읽기와 쓰기는 기다린다.
+func (l *Log) RebuildIndex() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.segments {
+		if err := s.rebuildIndex(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildIndex는 세그먼트의 인덱스를 스토어에서 다시 만들고 nextOffset과
+// indexedPos를 새 인덱스에 맞춘다.
+func (s *segment) rebuildIndex() error {
+	var positions, offsets []uint64
+	if fixed := uint64(s.config.Store.FixedRecordSize); fixed > 0 {
+		for pos := uint64(0); pos+fixed <= s.store.size; pos += fixed {
+			positions = append(positions, pos)
+		}
+	} else {
+		err := s.scanStore(0, func(pos uint64, record *api_v1.Record) bool {
+			positions = append(positions, pos)
+			offsets = append(offsets, record.Offset)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.index.reset(s.config.indexEntries(s.baseOffset, positions, offsets)); err != nil {
+		return err
+	}
+	return s.loadIndex()
+}
+
+// loadIndex는 인덱스의 마지막 항목으로 nextOffset과 indexedPos를 정하고
+// 레코드 수를 센다.
+func (s *segment) loadIndex() error {
+	s.nextOffset, s.indexedPos = s.baseOffset, 0
+	if err := s.countRecords(); err != nil {
+		return err
+	}
+	off, pos, err := s.index.Read(-1)
+	if err != nil {
+		return nil
+	}
+	s.nextOffset = s.baseOffset + uint64(off) + 1
+	s.indexedPos = pos
+	if !s.config.strided() {
+		return nil
+	}
+	// 마지막 인덱스 항목 뒤에도 레코드가 있을 수 있다.
+	return s.scanStore(pos, func(_ uint64, record *api_v1.Record) bool {
+		s.nextOffset = record.Offset + 1
+		return true
+	})
+}
+
+// indexMatchesStore는 인덱스가 스토어와 맞는지 본다. 마지막 항목이 그 오프셋의
+// 온전한 레코드를 가리키고, 그 뒤에 인덱스에 들어갔어야 할 레코드가 없으면
+// 맞다고 본다. 키 순서로 정렬된 세그먼트는 마지막 항목이 스토어 끝의 레코드가
+// 아니므로 마지막 항목만 확인한다.
+func (s *segment) indexMatchesStore() (bool, error) {
+	if s.index.size == 0 {
+		return s.store.size == 0, nil
+	}
+	rel, pos, err := s.index.Read(-1)
+	if err != nil {
+		return false, err
+	}
+	if fixed := uint64(s.config.Store.FixedRecordSize); fixed > 0 {
+		n := s.store.size / fixed
+		return n > 0 && pos == (n-1)*fixed, nil
+	}
+	found, complete := false, true
+	err = s.scanStore(pos, func(p uint64, record *api_v1.Record) bool {
+		if p == pos {
+			found = record.Offset == s.baseOffset+uint64(rel)
+			return found && s.keys == nil
+		}
+		complete = !s.config.indexes(false, pos, p)
+		return complete
+	})
+	return found && complete, err
+}
+
+// scanStore는 from부터 스토어를 길이 접두어를 따라 읽어 fn에 넘긴다. 길이가
+// 스토어를 넘거나 0인 레코드, 읽을 수 없는 레코드, 오프셋이 세그먼트 밖이거나 앞
+// 레코드보다 크지 않은 레코드를 만나면 스토어가 거기서 끝난 것으로 보고 멈춘다.
+// 0으로 채워진 구간은 오프셋이 0인 빈 레코드로 읽히므로 길이로 걸러야 베이스
+// 오프셋이 0인 세그먼트에서도 레코드로 보지 않는다. 키 순서로 정렬된
+// 세그먼트는 오프셋 순서가 아니므로 오프셋이 늘어나는지는 보지 않는다. fn이
+// false를 리턴해도 멈춘다. 고정 크기 모드에서는 쓸 수 없다.
+func (s *segment) scanStore(from uint64, fn func(pos uint64, record *api_v1.Record) bool) error {
+	var prev *uint64
+	for pos := from; pos < s.store.size; {
+		p, err := s.store.Read(pos)
+		if errors.Is(err, ErrCorruptRecord) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			return nil
+		}
+		record := &api_v1.Record{}
+		if err := s.config.decode(p, record); err != nil {
+			return nil
+		}
+		if record.Offset < s.baseOffset || (s.keys == nil && prev != nil && record.Offset <= *prev) {
+			return nil
+		}
+		prev = &record.Offset
+		if !fn(pos, record) {
+			return nil
+		}
+		pos += s.config.recordWidth(len(p))
+	}
+	return nil
+}
+
+// indexEntries는 스토어의 positions에 있는 레코드로 인덱스 내용을 만든다.
+// offsets는 그 레코드의 오프셋이다. 고정 크기 모드의 레코드에는 오프셋이
+// 없으므로 nil로 주면 Append처럼 OffsetAllocator로 다시 정한다. Heal과
+// rebuildIndex가 함께 쓴다.
+func (c Config) indexEntries(base uint64, positions, offsets []uint64) []byte {
+	if offsets == nil {
+		off := base
+		for range positions {
+			off = c.next(off)
+			offsets = append(offsets, off)
+			off++
+		}
+	}
+	// 키 순서로 정렬된 스토어는 레코드가 오프셋 순서가 아니지만 인덱스는
+	// 오프셋 순서여야 한다.
+	order := make([]int, len(positions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return offsets[order[i]] < offsets[order[j]] })
+	var b []byte
+	var indexed uint64
+	for i, j := range order {
+		if !c.indexes(i == 0, indexed, positions[j]) {
+			continue
+		}
+		b = c.appendEntry(b, uint32(offsets[j]-base), positions[j])
+		indexed = positions[j]
+	}
+	return b
+}
+
+// checkIndex는 세그먼트를 열 때 인덱스가 스토어와 맞지 않으면 다시 만든다.
+func (s *segment) checkIndex() error {
+	ok, err := s.indexMatchesStore()
+	if err != nil || ok {
+		return err
+	}
+	zap.L().Named("log").Warn("rebuilding index", zap.String("file", s.index.Name()))
+	return s.rebuildIndex()
+}
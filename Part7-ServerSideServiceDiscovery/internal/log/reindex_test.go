@@ -0,0 +1,110 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRebuildIndex(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, dir string){
+		"index deleted": func(t *testing.T, dir string) {
+			require.NoError(t, os.Remove(filepath.Join(dir, "0.index")))
+		},
+		"index truncated": func(t *testing.T, dir string) {
+			require.NoError(t, os.Truncate(filepath.Join(dir, "3.index"), int64(entWidth)))
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+			c := Config{}
+			c.Segment.MaxIndexBytes = 3 * entWidth
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+			for i := 0; i < 5; i++ {
+				_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			require.NoError(t, log.Close())
+
+			fn(t, dir)
+
+			// 다시 연 로그는 인덱스를 스토어에서 다시 만들어 모든 레코드를 읽는다.
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			for off := uint64(0); off < 5; off++ {
+				record, err := log.Read(off)
+				require.NoError(t, err)
+				require.Equal(t, off, record.Offset)
+			}
+			off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(5), off)
+		})
+	}
+}
+
+func TestLogRebuildIndexCorruptStore(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// 스토어보다 긴 길이를 붙이고 인덱스를 지운다.
+	f, err := os.OpenFile(filepath.Join(dir, "0.store"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(enc.AppendUint64(nil, 1<<40))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(filepath.Join(dir, "0.index")))
+
+	// 깨진 길이 앞까지만 인덱스에 넣는다.
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	for off := uint64(0); off < 3; off++ {
+		_, err := log.Read(off)
+		require.NoError(t, err)
+	}
+	_, err = log.Read(3)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+	// 열린 로그에서도 다시 만들 수 있다.
+	require.NoError(t, log.segments[0].index.reset(nil))
+	_, err = log.Read(1)
+	require.Error(t, err)
+	require.NoError(t, log.RebuildIndex())
+	record, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), record.Offset)
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func TestLogRebuildIndexZeroFilledStore(t *testing.T) {
+	dir := t.TempDir()
+	// 비정상 종료로 스토어가 0으로만 채워졌다.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0.store"), make([]byte, 64), 0644))
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	record, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), record.Value)
+}
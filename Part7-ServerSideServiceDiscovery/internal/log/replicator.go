@@ -2,6 +2,7 @@ package log
 
 import (
 	"context"
+	"io"
 	"sync"
 
 	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
@@ -12,12 +13,23 @@ import (
 type Replicator struct {
 	DialOptions []grpc.DialOption
 	LocalServer api_v1.LogClient
+	// LocalLog가 있으면 LocalServer 대신 이 로그에 리더와 같은 오프셋으로
+	// 쓰고 레코드마다 디스크에 동기화한다. 웜 스탠바이는 이렇게 해서 리더로
+	// 올라갈 때 오프셋이 비거나 겹치지 않고 받은 레코드를 잃지 않는다.
+	LocalLog *Log
 
 	logger  *zap.Logger
 	mu      sync.Mutex
 	servers map[string]chan struct{}
-	closed  bool
-	close   chan struct{}
+	// addrs는 복제 중인 서버의 이름과 주소다.
+	addrs  map[string]string
+	closed bool
+	close  chan struct{}
+	// wg는 돌고 있는 replicator 고루틴 수다.
+	wg sync.WaitGroup
+
+	caughtUp     chan struct{}
+	caughtUpOnce sync.Once
 }
 
 func (r *Replicator) Join(name, addr string) error {
@@ -35,12 +47,17 @@ func (r *Replicator) Join(name, addr string) error {
 	}
 
 	r.servers[name] = make(chan struct{})
+	r.addrs[name] = addr
 
+	r.wg.Add(1)
 	go r.replicator(addr, r.servers[name])
 	return nil
 }
 
+// replicator는 addr 서버의 지금 끝까지 먼저 따라잡고, 그 다음부터는 새로
+// 쓰이는 레코드를 계속 받아 로컬 서버에 쓴다.
 func (r *Replicator) replicator(addr string, leave chan struct{}) {
+	defer r.wg.Done()
 	cc, err := grpc.NewClient(addr, r.DialOptions...)
 	if err != nil {
 		r.logError(err, "failed to dial", addr)
@@ -49,42 +66,125 @@ func (r *Replicator) replicator(addr string, leave chan struct{}) {
 	defer cc.Close()
 	client := api_v1.NewLogClient(cc)
 
-	ctx := context.Background()
-	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{Offset: 0})
-
+	next, err := r.copy(client, &api_v1.ConsumeRequest{StopAtHead: true}, leave)
 	if err != nil {
-		r.logError(err, "failed to consume", addr)
+		r.logError(err, "failed to catch up", addr)
 		return
 	}
+	r.caughtUpOnce.Do(func() { close(r.caughtUp) })
+
+	if _, err = r.copy(client, &api_v1.ConsumeRequest{Offset: next}, leave); err != nil {
+		r.logError(err, "failed to replicate", addr)
+	}
+}
+
+// copy는 req로 읽은 레코드를 로컬 서버에 쓰고 다음에 읽을 오프셋을 리턴한다.
+// 스트림이 끝나거나 복제를 멈추면 nil을 리턴한다.
+func (r *Replicator) copy(
+	client api_v1.LogClient,
+	req *api_v1.ConsumeRequest,
+	leave chan struct{},
+) (uint64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := req.Offset
+	stream, err := client.ConsumeStream(ctx, req)
+	if err != nil {
+		return next, err
+	}
+
 	records := make(chan *api_v1.Record)
+	errc := make(chan error, 1)
 	go func() {
 		for {
 			recv, err := stream.Recv()
 			if err != nil {
-				r.logError(err, "failed to receive", addr)
+				errc <- err
+				return
+			}
+			select {
+			case records <- recv.Record:
+			case <-ctx.Done():
 				return
 			}
-			records <- recv.Record
 		}
 	}()
 
 	for {
 		select {
 		case <-r.close:
-			return
+			return next, nil
 		case <-leave:
-			return
+			return next, nil
+		case err := <-errc:
+			if err == io.EOF {
+				return next, nil
+			}
+			return next, err
 		case record := <-records:
-			_, err = r.LocalServer.Produce(ctx,
-				&api_v1.ProduceRequest{Record: record})
-
-			if err != nil {
-				r.logError(err, "failed to produce", addr)
-				return
+			if err := r.write(ctx, record); err != nil {
+				return next, err
 			}
+			next = record.Offset + 1
+		}
+	}
+}
 
+func (r *Replicator) write(ctx context.Context, record *api_v1.Record) error {
+	if r.LocalLog == nil {
+		_, err := r.LocalServer.Produce(ctx, &api_v1.ProduceRequest{Record: record})
+		return err
+	}
+	if err := r.LocalLog.AppendAt(record.Offset, record); err != nil {
+		return err
+	}
+	return r.LocalLog.Sync()
+}
+
+// CaughtUp은 처음으로 한 서버의 레코드를 복제를 시작할 때의 끝까지 모두
+// 받으면 닫힌다. 클러스터에 새로 들어온 서버는 이 채널이 닫힐 때까지
+// 읽기를 받지 않아야 덜 찬 로그를 보여주지 않는다.
+func (r *Replicator) CaughtUp() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	return r.caughtUp
+}
+
+// ReadFromPeer는 복제 중인 서버들에 차례로 off의 레코드를 물어 처음 받은
+// 레코드를 리턴한다. 어느 서버에도 없으면 ErrOffsetOutOfRange를 리턴한다.
+func (r *Replicator) ReadFromPeer(ctx context.Context, off uint64) (*api_v1.Record, error) {
+	r.mu.Lock()
+	r.init()
+	addrs := make([]string, 0, len(r.addrs))
+	for _, addr := range r.addrs {
+		addrs = append(addrs, addr)
+	}
+	r.mu.Unlock()
+
+	for _, addr := range addrs {
+		record, err := r.consume(ctx, addr, off)
+		if err == nil {
+			return record, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 	}
+	return nil, api_v1.ErrOffsetOutOfRange{Offset: off}
+}
+
+func (r *Replicator) consume(ctx context.Context, addr string, off uint64) (*api_v1.Record, error) {
+	cc, err := grpc.NewClient(addr, r.DialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+	res, err := api_v1.NewLogClient(cc).Consume(ctx, &api_v1.ConsumeRequest{Offset: off})
+	if err != nil {
+		return nil, err
+	}
+	return res.Record, nil
 }
 
 func (r *Replicator) Leave(name string) error {
@@ -96,6 +196,7 @@ func (r *Replicator) Leave(name string) error {
 	}
 	close(r.servers[name])
 	delete(r.servers, name)
+	delete(r.addrs, name)
 	return nil
 }
 
@@ -108,20 +209,32 @@ func (r *Replicator) init() {
 		r.servers = make(map[string]chan struct{})
 	}
 
+	if r.addrs == nil {
+		r.addrs = make(map[string]string)
+	}
+
 	if r.close == nil {
 		r.close = make(chan struct{})
 	}
+
+	if r.caughtUp == nil {
+		r.caughtUp = make(chan struct{})
+	}
 }
 
+// Close는 복제를 멈추고, 쓰고 있던 레코드를 마저 쓸 때까지 기다린다.
 func (r *Replicator) Close() error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	r.init()
 	if r.closed {
+		r.mu.Unlock()
 		return nil
 	}
 	r.closed = true
 	close(r.close)
+	r.mu.Unlock()
+
+	r.wg.Wait()
 	return nil
 }
 
@@ -0,0 +1,66 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// enforceRetention은 Config.Retention을 넘긴 세그먼트를 가장 오래된 것부터
+// 지우고 지운 세그먼트 수를 리턴한다. 로그가 중간에 비지 않도록 앞에서부터
+// 이어진 세그먼트만 지우므로 지운 오프셋은 LowestOffset 아래로 빠지고 읽으면
+// ErrOffsetOutOfRange를 리턴한다. 세그먼트의 나이는 스토어 파일의 수정
+// 시각으로 잰다. 버퍼에 쌓인 레코드는 나중에 파일에 쓰이므로 나이는 실제보다
+// 짧게 잡히고, 세그먼트를 일찍 지우지는 않는다.
+func (l *Log) enforceRetention(now time.Time) (int, error) {
+	maxBytes, maxAge := l.Config.Retention.MaxBytes, l.Config.Retention.MaxAge
+	if maxBytes == 0 && maxAge <= 0 {
+		return 0, nil
+	}
+	// 봉인된 세그먼트도 읽기 전까지는 마지막 레코드가 버퍼에 남아 있을 수
+	// 있다. 파일의 수정 시각이 마지막 쓰기보다 이르면 아직 보존 기간 안의
+	// 세그먼트를 지우므로 먼저 버퍼를 비운다.
+	if err := l.Flush(); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total uint64
+	for _, s := range l.segments {
+		total += s.store.size + s.index.size
+	}
+
+	removed := 0
+	for len(l.segments) > 1 {
+		s := l.segments[0]
+		size := s.store.size + s.index.size
+		remove := maxBytes > 0 && total > maxBytes
+		if m, ok := s.store.backend.(modTimer); ok && !remove && maxAge > 0 {
+			modTime, err := m.ModTime()
+			if err != nil {
+				return removed, err
+			}
+			remove = now.Sub(modTime) > maxAge
+		}
+		if !remove {
+			break
+		}
+		if err := s.Remove(); err != nil {
+			return removed, err
+		}
+		l.segments = l.segments[1:]
+		l.readCache.drop(s.baseOffset, s.nextOffset)
+		total -= size
+		removed++
+		recordStats(SegmentsDeleted.M(1), BytesReclaimed.M(int64(size)))
+	}
+	return removed, nil
+}
+
+// retainInBackground는 보존 확인 주기마다 불린다.
+func (l *Log) retainInBackground() {
+	if _, err := l.enforceRetention(time.Now()); err != nil {
+		zap.L().Named("log").Error("retention failed", zap.String("dir", l.Dir), zap.Error(err))
+	}
+}
@@ -0,0 +1,127 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+func TestRetentionMaxBytes(t *testing.T) {
+	require.NoError(t, view.Register(Views...))
+
+	dir, err := os.MkdirTemp("", "retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	c.Retention.MaxBytes = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	// 세그먼트마다 레코드 3개씩, 0-2, 3-5, 6-8, 9(활성)
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// 첫 세그먼트만 지우면 한도 안에 들어온다.
+	var total uint64
+	for _, s := range log.segments {
+		total += s.store.size + s.index.size
+	}
+	segmentSize := log.segments[0].store.size + log.segments[0].index.size
+	log.Config.Retention.MaxBytes = total - segmentSize
+	deleted := viewSum(t, "proglog/log/segments_deleted")
+	reclaimed := viewSum(t, "proglog/log/bytes_reclaimed")
+
+	removed, err := log.enforceRetention(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.Equal(t, deleted+1, viewSum(t, "proglog/log/segments_deleted"))
+	require.Equal(t, reclaimed+float64(segmentSize), viewSum(t, "proglog/log/bytes_reclaimed"))
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	_, err = log.Read(2)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	_, err = log.Read(3)
+	require.NoError(t, err)
+
+	// 한도가 아무리 작아도 활성 세그먼트는 남는다.
+	log.Config.Retention.MaxBytes = 1
+	removed, err = log.enforceRetention(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	require.Len(t, log.segments, 1)
+	record, err := log.Read(9)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), record.Offset)
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), off)
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	c.Retention.MaxAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 7; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Sync())
+	// 첫 세그먼트만 두 시간 전에 마지막으로 썼다.
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(log.segments[0].store.Name(), old, old))
+
+	removed, err := log.enforceRetention(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+	_, err = log.Read(0)
+	require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+	// 모두 오래되어도 활성 세그먼트는 지우지 않는다.
+	removed, err = log.enforceRetention(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.Len(t, log.segments, 1)
+	_, err = log.Read(6)
+	require.NoError(t, err)
+}
+
+func TestRetentionInBackground(t *testing.T) {
+	dir, err := os.MkdirTemp("", "retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 3 * entWidth
+	c.Retention.MaxBytes = 1
+	c.Retention.Interval = 10 * time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 7; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		lowest, err := log.LowestOffset()
+		return err == nil && lowest == 6
+	}, time.Second, 10*time.Millisecond)
+}
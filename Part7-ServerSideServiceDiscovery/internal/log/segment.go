@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
-	"google.golang.org/protobuf/proto"
 )
 
 type segment struct {
@@ -14,6 +17,35 @@ type segment struct {
 	index                  *index
 	baseOffset, nextOffset uint64
 	config                 Config
+
+	// expiring은 만료 시각이 있는 레코드의 만료 시각과 스토어에서 차지하는
+	// 크기다. 처음 필요할 때 스토어를 훑어 채우고 그 뒤로는 Append가 이어서 채운다.
+	// superseded는 같은 키의 더 최근 레코드로 대체된 레코드의 바이트 수로,
+	// Compaction.ByKey일 때 loadSuperseded와 Log.append가 센다.
+	statsMu    sync.Mutex
+	scanned    bool
+	expiring   []expiry
+	superseded uint64
+
+	// keys는 키 순서로 정렬된 세그먼트의 키 인덱스다. 정렬하지 않았으면 nil이다.
+	keys []keyEntry
+	// indexedPos는 인덱스에 마지막으로 넣은 레코드의 스토어 위치다.
+	indexedPos uint64
+	// records는 세그먼트의 레코드 수다.
+	records uint64
+	// times는 시간 인덱스 항목이고 timeFile은 그 파일이다. 시간 인덱스를 두지
+	// 않으면 timeFile이 nil이다.
+	times    []timeEntry
+	timeFile *os.File
+
+	// pin은 압축처럼 로그 락 없이 봉인된 세그먼트를 읽는 동안 읽기로 잡는다.
+	// Close는 쓰기로 잡으므로 읽는 도중에 파일을 닫지 않는다.
+	pin sync.RWMutex
+}
+
+type expiry struct {
+	at   int64
+	size uint64
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
@@ -23,15 +55,13 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	}
 
 	var err error
-	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644,
-	)
+	backend, err := c.storage().Open(path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")))
 	if err != nil {
 		return nil, err
 	}
 
-	if s.store, err = newStore(storeFile); err != nil {
+	if s.store, err = newStore(backend, c); err != nil {
+		backend.Close()
 		return nil, err
 	}
 
@@ -40,6 +70,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		os.O_RDWR|os.O_CREATE, 0644,
 	)
 	if err != nil {
+		s.store.Close()
 		return nil, err
 	}
 
@@ -47,10 +78,24 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
-	if off, _, err := s.index.Read(-1); err != nil {
-		s.nextOffset = baseOffset
-	} else {
-		s.nextOffset = baseOffset + uint64(off) + 1
+	if s.keys, err = readKeyIndex(s.keysName()); err != nil && !os.IsNotExist(err) {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.loadIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	// 인덱스가 잘리거나 없어졌으면 스토어에서 다시 만든다.
+	if err := s.checkIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.openTimeIndex(); err != nil {
+		s.Close()
+		return nil, err
 	}
 
 	return s, nil
@@ -58,33 +103,48 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 }
 
 func (s *segment) Append(record *api_v1.Record) (offset uint64, err error) {
-	cur := s.nextOffset
+	cur := s.config.next(s.nextOffset)
 	record.Offset = cur
 
-	p, err := proto.Marshal(record)
+	p, err := s.marshal(record)
 	if err != nil {
 		return 0, err
 	}
 
-	_, pos, err := s.store.Append(p)
+	n, pos, err := s.store.Append(p)
 	if err != nil {
 		return 0, err
 	}
+	if record.ExpireAt != 0 {
+		s.statsMu.Lock()
+		if s.scanned {
+			s.expiring = append(s.expiring, expiry{at: record.ExpireAt, size: n})
+		}
+		s.statsMu.Unlock()
+	}
+
+	if s.config.indexes(s.index.size == 0, s.indexedPos, pos) {
+		if err = s.index.Write(
+			// 인덱스의 오프셋은 베이스 오프셋에서의 상댓값이다.
+			uint32(cur-uint64(s.baseOffset)),
+			pos,
+		); err != nil {
+			return 0, err
+		}
+		s.indexedPos = pos
+	}
 
-	if err = s.index.Write(
-		// 인덱스의 오프셋은 베이스 오프셋에서의 상댓값이다.
-		uint32(s.nextOffset-uint64(s.baseOffset)),
-		pos,
-	); err != nil {
+	if err := s.appendTime(record); err != nil {
 		return 0, err
 	}
 
-	s.nextOffset++
+	s.nextOffset = cur + 1
+	s.records++
 	return cur, nil
 }
 
 func (s *segment) Read(off uint64) (*api_v1.Record, error) {
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	pos, err := s.position(off)
 	if err != nil {
 		return nil, err
 	}
@@ -92,13 +152,121 @@ func (s *segment) Read(off uint64) (*api_v1.Record, error) {
 	if err != nil {
 		return nil, err
 	}
+	if s.config.Store.FixedRecordSize > 0 {
+		return &api_v1.Record{Value: p, Offset: off}, nil
+	}
 	record := &api_v1.Record{}
-	err = proto.Unmarshal(p, record)
+	err = s.config.decode(p, record)
 	return record, err
 }
 
+// position은 off 레코드의 스토어 위치를 인덱스에서 찾는다. 오프셋이 빈틈없이
+// 이어지면 off-baseOffset번째 항목이 그 레코드이고, 아니면 항목들이 오프셋
+// 순서로 있으므로 이진 탐색한다. 인덱스 간격을 두었으면 off 앞의 가장 가까운
+// 항목부터 스토어를 앞으로 훑는다.
+func (s *segment) position(off uint64) (uint64, error) {
+	rel := uint32(off - s.baseOffset)
+	out, pos, err := s.index.Read(int64(rel))
+	if err == nil && out == rel {
+		return pos, nil
+	}
+	n := int(s.index.size / s.index.entWidth)
+	i := sort.Search(n, func(i int) bool {
+		out, _, _ := s.index.Read(int64(i))
+		return out >= rel
+	})
+	if i < n {
+		if out, pos, _ = s.index.Read(int64(i)); out == rel {
+			return pos, nil
+		}
+	}
+	if s.config.strided() && i > 0 {
+		_, from, _ := s.index.Read(int64(i - 1))
+		found := false
+		err := s.scanForward(from, func(p uint64, _ []byte, record *api_v1.Record) bool {
+			if record.Offset < off {
+				return true
+			}
+			pos, found = p, record.Offset == off
+			return false
+		})
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return pos, nil
+		}
+	}
+	return 0, api_v1.ErrOffsetOutOfRange{Offset: off}
+}
+
+// 고정 크기 모드에서는 오프셋을 위치로 알 수 있으므로 값만 저장한다.
+// 값 외의 필드는 저장할 수 없으니 거부한다.
+func (s *segment) marshal(record *api_v1.Record) ([]byte, error) {
+	if s.config.Store.FixedRecordSize == 0 {
+		return s.config.encode(record)
+	}
+	if record.ExpireAt != 0 || record.Timestamp != 0 {
+		return nil, fmt.Errorf("%w: only values can be stored in fixed-size mode", ErrRecordSize)
+	}
+	if len(record.Value) != s.config.Store.FixedRecordSize {
+		return nil, ErrRecordSize
+	}
+	return record.Value, nil
+}
+
+// deadBytes는 now에 만료된 레코드가 스토어에서 차지하는 바이트 수다.
+func (s *segment) deadBytes(now time.Time) (uint64, error) {
+	if s.config.Store.FixedRecordSize > 0 {
+		return 0, nil
+	}
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if !s.scanned {
+		err := s.each(func(_ uint32, p []byte) error {
+			record := &api_v1.Record{}
+			if err := s.config.decode(p, record); err != nil {
+				return err
+			}
+			if record.ExpireAt != 0 {
+				s.expiring = append(s.expiring, expiry{
+					at:   record.ExpireAt,
+					size: s.config.recordWidth(len(p)),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		s.scanned = true
+	}
+	var dead uint64
+	for _, e := range s.expiring {
+		if e.at <= now.UnixNano() {
+			dead += e.size
+		}
+	}
+	return dead, nil
+}
+
+// loadStats는 압축으로 다시 쓴 세그먼트의 통계를 남긴 레코드로 채워서
+// 스토어를 다시 훑지 않게 한다.
+func (s *segment) loadStats(kept []keptRecord, superseded uint64) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.expiring = nil
+	for _, k := range kept {
+		if k.expireAt != 0 {
+			s.expiring = append(s.expiring, expiry{at: k.expireAt, size: s.config.recordWidth(len(k.p))})
+		}
+	}
+	s.scanned = true
+	s.superseded = superseded
+}
+
 func (s *segment) IsMaxed() bool {
-	return s.store.size >= s.config.Segment.MaxStoreBytes || s.index.size+entWidth > s.config.Segment.MaxIndexBytes
+	return s.store.size >= s.config.Segment.MaxStoreBytes || s.index.size+s.index.entWidth > s.config.Segment.MaxIndexBytes
 }
 
 func (s *segment) Remove() error {
@@ -108,18 +276,44 @@ func (s *segment) Remove() error {
 	if err := os.Remove(s.index.Name()); err != nil {
 		return err
 	}
-	if err := os.Remove(s.store.Name()); err != nil {
+	if err := s.config.storage().Remove(s.store.Name()); err != nil {
+		return err
+	}
+	if err := os.Remove(s.keysName()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return nil
+	return removeFile(s.timesName())
 }
 
-func (s *segment) Close() error {
-	if err := s.index.Close(); err != nil {
+// keysName은 스토어 파일 옆의 키 인덱스 파일 이름이다.
+func (s *segment) keysName() string {
+	return strings.TrimSuffix(s.store.Name(), ".store") + keysExt
+}
+
+// Flush는 스토어와 인덱스를 디스크에 동기화한다. 스토어를 먼저 써야 인덱스가
+// 아직 없는 레코드를 가리키지 않는다.
+func (s *segment) Flush() error {
+	if err := s.store.Sync(); err != nil {
 		return err
 	}
-	if err := s.store.Close(); err != nil {
-		return err
+	if s.timeFile != nil {
+		if err := s.timeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	return s.index.Sync()
+}
+
+// Close는 인덱스와 스토어를 모두 닫고 처음 난 에러를 리턴한다.
+func (s *segment) Close() error {
+	s.pin.Lock()
+	defer s.pin.Unlock()
+	err := s.index.Close()
+	if serr := s.store.Close(); err == nil {
+		err = serr
+	}
+	if terr := s.closeTimeIndex(); err == nil {
+		err = terr
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,214 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrSortWithFixedSize = errors.New("sorting by key cannot be used with fixed-size records")
+	ErrKeyNotFound       = errors.New("key not found")
+)
+
+const (
+	keysExt = ".keys"
+
+	defaultKeyIndexInterval = 16
+)
+
+// keyEntry는 키 인덱스 항목 하나로, 정렬된 스토어에서 key인 레코드의 위치다.
+// 키 인덱스 파일에는 다음 형식으로 차례로 들어 있다:
+//
+//	[4바이트 키 길이][키][8바이트 위치]
+type keyEntry struct {
+	key []byte
+	pos uint64
+}
+
+func (c Config) checkSort() error {
+	if c.Segment.SortByKey && c.Store.FixedRecordSize > 0 {
+		return ErrSortWithFixedSize
+	}
+	return nil
+}
+
+func readKeyIndex(name string) ([]keyEntry, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var keys []keyEntry
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrCorruptRecord
+		}
+		n := int(enc.Uint32(b))
+		if len(b) < 4+n+8 {
+			return nil, ErrCorruptRecord
+		}
+		keys = append(keys, keyEntry{
+			key: b[4 : 4+n],
+			pos: enc.Uint64(b[4+n:]),
+		})
+		b = b[4+n+8:]
+	}
+	return keys, nil
+}
+
+func writeKeyIndex(name string, keys []keyEntry) error {
+	var b []byte
+	for _, k := range keys {
+		b = enc.AppendUint32(b, uint32(len(k.key)))
+		b = append(b, k.key...)
+		b = enc.AppendUint64(b, k.pos)
+	}
+	return os.WriteFile(name, b, 0644)
+}
+
+// scanKeys는 정렬된 세그먼트에서 키가 start 이상인 레코드를 키 순서로 fn에
+// 넘긴다. fn이 false를 리턴하면 멈춘다. 같은 키가 키 인덱스 항목 경계에 걸칠
+// 수 있으므로 start보다 작은 마지막 항목부터 훑는다.
+func (s *segment) scanKeys(start []byte, fn func(*api_v1.Record) bool) error {
+	if len(s.keys) == 0 {
+		return nil
+	}
+	i := sort.Search(len(s.keys), func(i int) bool {
+		return bytes.Compare(s.keys[i].key, start) >= 0
+	})
+	pos := s.keys[max(i-1, 0)].pos
+	for pos < s.store.size {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return err
+		}
+		pos += s.config.recordWidth(len(p))
+		record := &api_v1.Record{}
+		if err := s.config.decode(p, record); err != nil {
+			return err
+		}
+		if bytes.Compare(record.Key, start) < 0 {
+			continue
+		}
+		if !fn(record) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// lookup은 정렬된 세그먼트에서 key인 레코드 중 가장 최근 것을 찾는다.
+// 같은 키는 오프셋 순서로 있으므로 마지막으로 본 것이 가장 최근 것이다.
+func (s *segment) lookup(key []byte) (*api_v1.Record, error) {
+	var latest *api_v1.Record
+	err := s.scanKeys(key, func(record *api_v1.Record) bool {
+		if !bytes.Equal(record.Key, key) {
+			return false
+		}
+		latest = record
+		return true
+	})
+	if err == nil && latest == nil {
+		err = ErrKeyNotFound
+	}
+	return latest, err
+}
+
+// ScanKeys는 키 순서로 정렬된 세그먼트에서 키가 [start, end) 안에 있는
+// 레코드를 키 순서로, 키가 같으면 오프셋 순서로 리턴한다. end가 nil이면
+// 끝까지 훑는다. 아직 정렬하지 않은 활성 세그먼트는 보지 않는다.
+// 만료된 레코드는 건너뛴다.
+func (l *Log) ScanKeys(start, end []byte) ([]*api_v1.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	now := time.Now()
+	var records []*api_v1.Record
+	for _, s := range l.segments {
+		if s.keys == nil {
+			continue
+		}
+		err := s.scanKeys(start, func(record *api_v1.Record) bool {
+			if end != nil && bytes.Compare(record.Key, end) >= 0 {
+				return false
+			}
+			if !expired(record, now) {
+				records = append(records, record)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 세그먼트는 오프셋 순서로 훑었으므로 안정 정렬하면 같은 키는 오프셋 순서로 남는다.
+	slices.SortStableFunc(records, func(a, b *api_v1.Record) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+	return records, nil
+}
+
+// LookupKey는 키 순서로 정렬된 세그먼트에서 key인 가장 최근 레코드를
+// 리턴한다. 없으면 ErrKeyNotFound를 리턴한다.
+func (l *Log) LookupKey(key []byte) (*api_v1.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		s := l.segments[i]
+		if s.keys == nil {
+			continue
+		}
+		record, err := s.lookup(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if expired(record, time.Now()) {
+			return nil, api_v1.ErrRecordExpired{Offset: record.Offset, ExpireAt: record.ExpireAt}
+		}
+		return record, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// sortSealed는 아직 정렬하지 않은 봉인된 세그먼트를 키 순서로 다시 쓴다.
+// 세그먼트를 바꿔 끼우는 것은 압축과 같다.
+func (l *Log) sortSealed() error {
+	l.sortMu.Lock()
+	defer l.sortMu.Unlock()
+
+	l.mu.RLock()
+	var unsorted []*segment
+	for _, s := range l.segments[:len(l.segments)-1] {
+		if s.keys == nil {
+			unsorted = append(unsorted, s)
+		}
+	}
+	l.mu.RUnlock()
+
+	for _, s := range unsorted {
+		if _, err := l.replaceSegment(s, keeper(time.Now(), nil), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortInBackground는 roll이 세그먼트를 봉인할 때 불린다. Append를 기다리게
+// 하지 않도록 따로 돌리고, Close는 끝날 때까지 기다린다.
+func (l *Log) sortInBackground() {
+	l.sorting.Add(1)
+	go func() {
+		defer l.sorting.Done()
+		if err := l.sortSealed(); err != nil {
+			zap.L().Named("log").Error("sorting sealed segments failed", zap.String("dir", l.Dir), zap.Error(err))
+		}
+	}()
+}
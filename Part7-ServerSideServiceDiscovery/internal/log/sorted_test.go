@@ -0,0 +1,88 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSortByKey(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 8 * entWidth
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Segment.SortByKey = true
+	c.Segment.KeyIndexInterval = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	// 첫 세그먼트를 채우고 하나를 더 써서 봉인한다. k1은 키 인덱스 항목
+	// 경계에 걸친다.
+	keys := []string{"k3", "k1", "k2", "k1", "k5", "k3", "k4", "k1", "k2"}
+	for off, key := range keys {
+		_, err := log.Append(&api_v1.Record{
+			Key:   []byte(key),
+			Value: []byte(fmt.Sprintf("%s@%d", key, off)),
+		})
+		require.NoError(t, err)
+	}
+	log.sorting.Wait()
+	require.Len(t, log.segments, 2)
+	require.NotNil(t, log.segments[0].keys)
+	require.Nil(t, log.segments[1].keys)
+
+	check := func(log *Log) {
+		t.Helper()
+		// 오프셋으로 읽는 것은 정렬과 상관없다.
+		for off, key := range keys {
+			record, err := log.Read(uint64(off))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("%s@%d", key, off), string(record.Value))
+		}
+
+		// 활성 세그먼트의 k2@8은 아직 정렬되지 않아 보이지 않는다.
+		records, err := log.ScanKeys([]byte("k1"), []byte("k4"))
+		require.NoError(t, err)
+		var got []string
+		for _, record := range records {
+			got = append(got, string(record.Value))
+		}
+		require.Equal(t, []string{"k1@1", "k1@3", "k1@7", "k2@2", "k3@0", "k3@5"}, got)
+
+		records, err = log.ScanKeys([]byte("k4"), nil)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		for key, want := range map[string]uint64{"k1": 7, "k3": 5, "k5": 4} {
+			record, err := log.LookupKey([]byte(key))
+			require.NoError(t, err)
+			require.Equal(t, want, record.Offset)
+		}
+		_, err = log.LookupKey([]byte("k0"))
+		require.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	check(log)
+
+	// 정렬된 세그먼트와 키 인덱스는 다시 열어도 그대로다. 인덱스를 잃어도
+	// 복구하면 오프셋 순서로 다시 만든다.
+	require.NoError(t, log.Close())
+	require.NoError(t, os.Remove(filepath.Join(dir, "0.index")))
+	c.Segment.HealOnOpen = true
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	check(log)
+}
+
+func TestLogSortByKeyFixedSize(t *testing.T) {
+	c := Config{}
+	c.Segment.SortByKey = true
+	c.Store.FixedRecordSize = 8
+	_, err := NewLog(t.TempDir(), c)
+	require.ErrorIs(t, err, ErrSortWithFixedSize)
+}
@@ -0,0 +1,56 @@
+package log
+
+import api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+
+// LogStats는 로그를 훑지 않고 세그먼트마다 세어 둔 값으로 만든 통계다.
+type LogStats struct {
+	// Records는 세그먼트에 있는 레코드 수다. 만료되었지만 아직 압축이나
+	// 보존 기간으로 지우지 않은 레코드도 센다.
+	Records uint64
+	// Bytes는 스토어 크기의 합으로 길이와 체크섬도 포함한다. 인덱스는 뺀다.
+	Bytes    uint64
+	Segments int
+	// LowestOffset과 HighestOffset은 LowestOffset, HighestOffset과 같다.
+	LowestOffset, HighestOffset uint64
+}
+
+// Stats는 로그의 통계를 리턴한다. 세그먼트 수에 비례하는 시간만 걸린다.
+// Remove로 세그먼트를 지운 로그면 ErrLogClosed를 리턴한다.
+func (l *Log) Stats() (LogStats, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.segments) == 0 {
+		return LogStats{}, ErrLogClosed
+	}
+	stats := LogStats{
+		Segments:     len(l.segments),
+		LowestOffset: l.segments[0].baseOffset,
+	}
+	for _, s := range l.segments {
+		stats.Records += s.records
+		stats.Bytes += s.store.size
+	}
+	if next := l.activeSegment.nextOffset; next > 0 {
+		stats.HighestOffset = next - 1
+	}
+	return stats, nil
+}
+
+// countRecords는 세그먼트의 레코드 수를 센다. 모든 레코드가 인덱스에 있으면
+// 인덱스 크기로 알 수 있고, 인덱스 간격을 두었으면 스토어를 한 번 훑는다.
+// 그 뒤로는 Append가 센다.
+func (s *segment) countRecords() error {
+	if fixed := uint64(s.config.Store.FixedRecordSize); fixed > 0 {
+		s.records = s.store.size / fixed
+		return nil
+	}
+	if !s.config.strided() {
+		s.records = s.index.size / s.index.entWidth
+		return nil
+	}
+	s.records = 0
+	return s.scanStore(0, func(uint64, *api_v1.Record) bool {
+		s.records++
+		return true
+	})
+}
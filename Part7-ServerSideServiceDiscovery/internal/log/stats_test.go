@@ -0,0 +1,66 @@
+package log
+
+import (
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStats(t *testing.T) {
+	for scenario, c := range map[string]Config{
+		"every record indexed": {},
+		"strided index": func() Config {
+			c := Config{}
+			c.Segment.IndexStride = 64
+			return c
+		}(),
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir := t.TempDir()
+			c.Segment.MaxIndexBytes = 3 * entWidth
+			c.Segment.MaxStoreBytes = 200
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			stats, err := log.Stats()
+			require.NoError(t, err)
+			require.Equal(t, LogStats{Segments: 1}, stats)
+
+			for i := 0; i < 10; i++ {
+				_, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			stats, err = log.Stats()
+			require.NoError(t, err)
+			require.Equal(t, uint64(10), stats.Records)
+			require.Equal(t, len(log.segments), stats.Segments)
+			require.Greater(t, stats.Segments, 1)
+			require.Equal(t, uint64(0), stats.LowestOffset)
+			require.Equal(t, uint64(9), stats.HighestOffset)
+			var size uint64
+			for _, s := range log.segments {
+				size += s.store.size
+			}
+			require.Equal(t, size, stats.Bytes)
+			require.NoError(t, log.Close())
+
+			// 다시 연 로그도 같은 값을 센다.
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+			reopened, err := log.Stats()
+			require.NoError(t, err)
+			require.Equal(t, stats, reopened)
+
+			// 지운 세그먼트의 레코드는 빠진다.
+			removed := log.segments[0].records
+			require.NoError(t, log.Truncate(log.segments[1].baseOffset-1))
+			truncated, err := log.Stats()
+			require.NoError(t, err)
+			require.Equal(t, stats.Records-removed, truncated.Records)
+			require.Equal(t, stats.Segments-1, truncated.Segments)
+			require.Equal(t, log.segments[0].baseOffset, truncated.LowestOffset)
+		})
+	}
+}
@@ -2,101 +2,671 @@ package log
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
-	"os"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tysonmote/gommap"
 )
 
 var (
 	enc = binary.BigEndian
+
+	ErrCorruptRecord  = errors.New("corrupt record")
+	ErrRecordSize     = errors.New("record size does not match the fixed record size")
+	ErrRecordTooLarge = errors.New("record is larger than the maximum record size")
+
+	ErrChecksumWithFixedSize = errors.New("checksums cannot be used with fixed-size records")
+
+	// crcTable은 레코드 체크섬에 쓰는 CRC32 Castagnoli 테이블이다.
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
 )
 
+// ErrPosOutOfRange는 스토어에 쓴 데이터 밖의 위치를 읽으려 할 때 리턴한다.
+type ErrPosOutOfRange struct {
+	Pos  uint64
+	Size uint64
+}
+
+func (e ErrPosOutOfRange) Error() string {
+	return fmt.Sprintf("position %d out of range, store size %d", e.Pos, e.Size)
+}
+
+// ErrRecordLimit은 Store.MaxRecordSize를 넘는 레코드를 거부할 때 리턴하고
+// 그 크기를 담는다. errors.Is로 ErrRecordTooLarge와 비교할 수 있다.
+type ErrRecordLimit struct {
+	Size uint64
+	Max  uint64
+}
+
+func (e ErrRecordLimit) Error() string {
+	return fmt.Sprintf("%v: %d bytes, max %d", ErrRecordTooLarge, e.Size, e.Max)
+}
+
+func (e ErrRecordLimit) Unwrap() error {
+	return ErrRecordTooLarge
+}
+
+// ErrReadOutOfBounds는 ReadAt의 시작 위치가 음수이거나 스토어에 쓴 데이터
+// 밖일 때 리턴한다. 인덱스 항목이 깨져 엉뚱한 위치를 가리킬 때 io.EOF 대신
+// 요청한 위치와 스토어 크기를 알려 준다.
+type ErrReadOutOfBounds struct {
+	Offset int64
+	Size   uint64
+}
+
+func (e ErrReadOutOfBounds) Error() string {
+	return fmt.Sprintf("read at offset %d out of bounds, store size %d", e.Offset, e.Size)
+}
+
 const (
 	lenWidth = 8
+	// crcWidth는 Store.Checksum일 때 길이 뒤에 붙는 체크섬의 크기다.
+	crcWidth = 4
+
+	defaultReadRetries = 5
+	defaultReadBackoff = 10 * time.Millisecond
+	defaultBufferSize  = 4096
+
+	// mmapRemapBytes는 MmapReads일 때 스토어가 매핑한 뒤로 이만큼 커지면 다시
+	// 매핑하는 크기다. 그보다 적게 커진 꼬리는 파일에서 읽는다. 활성 세그먼트의
+	// 꼬리를 읽을 때마다 다시 매핑하지 않기 위해서다.
+	mmapRemapBytes = 1 << 20
 )
 
 type store struct {
-	*os.File
-	mu   sync.Mutex
-	buf  *bufio.Writer
-	size uint64
+	backend StoreBackend
+	// mu는 쓰기와 플러시가 잡는다. 읽기는 파일에 이미 있는 범위만 읽으므로
+	// RLock만 잡아서 여러 읽기가 함께 돈다.
+	mu     sync.RWMutex
+	buf    *bufio.Writer
+	size   uint64
+	config Config
+	reader io.ReaderAt
+	// flushed는 버퍼를 마지막으로 다 비웠을 때의 크기다. Append 사이에만
+	// 비우므로 레코드 경계이고, 그 앞은 버퍼를 거치지 않고 파일에서 읽는다.
+	flushed uint64
+	// timer는 FlushLatency가 지나면 버퍼를 플러시한다.
+	timer *time.Timer
+	// stats는 mu를 잡고 센다. 읽기가 세는 ReadAts와 ReadBytes는 RLock만 잡고
+	// 세므로 atomic으로 더한다.
+	stats StoreStats
+	// synced면 크기가 syncedSize일 때 디스크에 동기화했다.
+	synced     bool
+	syncedSize uint64
+	// direct가 있으면 Config.Store.Direct라서 버퍼를 direct로 비운다.
+	direct *directWriter
+	// done을 닫으면 Config.Store.Sync의 주기적인 동기화를 멈춘다.
+	done  chan struct{}
+	loops sync.WaitGroup
+	// mapped는 Config.Store.MmapReads일 때 파일 앞부분을 읽기 전용으로 매핑한
+	// 것이다. 처음 읽을 때 매핑한다. 읽기끼리 함께 다시 매핑하지 않도록
+	// mapMu로 지킨다. 닫을 때는 mu를 잡아 읽기가 없으므로 mapMu를 잡지 않는다.
+	mapMu  sync.RWMutex
+	mapped gommap.MMap
 }
 
-func newStore(f *os.File) (*store, error) {
-	fi, err := os.Stat(f.Name())
+// checkChecksum은 체크섬을 다른 설정과 함께 쓸 수 있는지 확인한다.
+func (c Config) checkChecksum() error {
+	if c.Store.Checksum && c.Store.FixedRecordSize > 0 {
+		return ErrChecksumWithFixedSize
+	}
+	return nil
+}
+
+// headerWidth는 스토어에서 레코드 값 앞에 붙는 길이와 체크섬의 크기다.
+func (c Config) headerWidth() uint64 {
+	if c.Store.Checksum {
+		return lenWidth + crcWidth
+	}
+	return lenWidth
+}
+
+// checkRecordSize는 스토어에 쓸 n 바이트가 Store.MaxRecordSize를 넘지 않는지 확인한다.
+func (c Config) checkRecordSize(n int) error {
+	if limit := c.Store.MaxRecordSize; limit > 0 && uint64(n) > limit {
+		return ErrRecordLimit{Size: uint64(n), Max: limit}
+	}
+	return nil
+}
+
+// recordWidth는 n 바이트짜리 값이 스토어에서 차지하는 크기다.
+func (c Config) recordWidth(n int) uint64 {
+	return c.headerWidth() + uint64(n)
+}
+
+// newStore는 b에 이미 쓴 데이터 뒤로 이어서 쓰는 스토어를 만든다. 버퍼는
+// b의 WriteAt으로 비운다. Direct와 MmapReads는 b가 파일일 때만 쓸 수 있다.
+func newStore(b StoreBackend, c Config) (*store, error) {
+	n, err := b.Size()
 	if err != nil {
 		return nil, err
 	}
-	size := uint64(fi.Size())
-	return &store{
-		File: f,
-		size: size,
-		buf:  bufio.NewWriter(f),
-	}, nil
+	f, isFile := b.(fileBackend)
+	if (c.Store.Direct || c.Store.MmapReads) && !isFile {
+		return nil, ErrBackendNotFile
+	}
+	if c.Store.ReadRetries == 0 {
+		c.Store.ReadRetries = defaultReadRetries
+	}
+	if c.Store.ReadBackoff == 0 {
+		c.Store.ReadBackoff = defaultReadBackoff
+	}
+	size := uint64(n)
+	s := &store{
+		backend: b,
+		size:    size,
+		flushed: size,
+		config:  c,
+		reader:  b,
+	}
+	var w io.Writer = &backendWriter{b: b, off: n}
+	if c.Store.Direct {
+		if s.direct, err = newDirectWriter(f.File, size); err != nil {
+			return nil, err
+		}
+		w = s.direct
+	}
+	s.buf = bufio.NewWriterSize(
+		&countingWriter{w: w, n: &s.stats.Flushes},
+		max(c.Store.FlushBytes, defaultBufferSize),
+	)
+	if d := c.Store.Sync.interval(); d > 0 {
+		s.syncEvery(d)
+	}
+	return s, nil
+}
+
+// Name은 백엔드의 이름이다. 파일이면 경로다.
+func (s *store) Name() string {
+	if n, ok := s.backend.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// backendWriter는 버퍼를 백엔드의 off 위치부터 이어서 쓴다.
+type backendWriter struct {
+	b   StoreBackend
+	off int64
+}
+
+func (w *backendWriter) Write(p []byte) (int, error) {
+	n, err := w.b.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// StoreStats는 스토어가 지금까지 한 I/O를 센 값이다.
+type StoreStats struct {
+	// AppendedBytes는 Append로 쓴 바이트 수로 길이 정보도 포함한다.
+	AppendedBytes uint64
+	// ReadBytes는 Read와 ReadAt으로 파일에서 읽은 바이트 수다.
+	ReadBytes uint64
+	// Flushes는 버퍼를 파일에 쓴 횟수고 Syncs는 디스크에 동기화한 횟수다.
+	Flushes uint64
+	Syncs   uint64
+	// ReadAts는 파일에 ReadAt을 부른 횟수다. 짧게 읽혀 다시 부른 것도 센다.
+	ReadAts uint64
+}
+
+// Stats는 지금까지 센 값을 리턴한다.
+func (s *store) Stats() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// countingWriter는 w에 쓴 횟수를 n에 세고 StoreFlushes로 기록한다.
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c.n++
+	recordStats(StoreFlushes.M(1))
+	return c.w.Write(p)
 }
 
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	n, pos, err = s.appendLocked(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, pos, s.written()
+}
+
+// AppendBatch는 ps를 한 번 잠근 채로 차례로 버퍼에 쓰고 플러시나 동기화는
+// 마지막에 한 번만 한다. 레코드마다 쓴 크기와 위치를 리턴한다. 중간에 실패하면
+// 그 앞까지 쓴 레코드의 크기와 위치만 에러와 함께 리턴하므로 len(ns)가 쓴
+// 레코드 수다. 이미 쓴 레코드는 그대로 남는다.
+func (s *store) AppendBatch(ps [][]byte) (ns []uint64, poss []uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ns = make([]uint64, 0, len(ps))
+	poss = make([]uint64, 0, len(ps))
+	for _, p := range ps {
+		n, pos, err := s.appendLocked(p)
+		if err != nil {
+			if len(ns) > 0 {
+				// 쓴 레코드는 실패와 상관없이 설정대로 내보낸다.
+				s.written()
+			}
+			return ns, poss, err
+		}
+		ns = append(ns, n)
+		poss = append(poss, pos)
+	}
+	return ns, poss, s.written()
+}
+
+// appendLocked는 p를 버퍼에 쓰고 쓴 크기와 위치를 리턴한다. mu를 잡고 불러야
+// 하고 플러시나 동기화는 하지 않는다.
+func (s *store) appendLocked(p []byte) (n uint64, pos uint64, err error) {
 	pos = s.size
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+	// 버퍼에 넣기 전에 거부해야 큰 레코드가 메모리에 쌓이지 않는다.
+	if err := s.config.checkRecordSize(len(p)); err != nil {
+		return 0, 0, err
+	}
+	if fixed := s.config.Store.FixedRecordSize; fixed > 0 {
+		if len(p) != fixed {
+			return 0, 0, ErrRecordSize
+		}
+		w, err := s.buf.Write(p)
+		if err != nil {
+			return 0, 0, err
+		}
+		s.size += uint64(w)
+		s.appended(w)
+		return uint64(w), pos, nil
+	}
+	header := enc.AppendUint64(make([]byte, 0, s.config.headerWidth()), uint64(len(p)))
+	if s.config.Store.Checksum {
+		header = enc.AppendUint32(header, crc32.Checksum(p, crcTable))
+	}
+	if _, err := s.buf.Write(header); err != nil {
 		return 0, 0, err
 	}
 	w, err := s.buf.Write(p)
 	if err != nil {
 		return 0, 0, err
 	}
-	w += lenWidth
+	w += len(header)
 
 	s.size += uint64(w)
+	s.appended(w)
 	return uint64(w), pos, nil
 }
 
-func (s *store) Read(pos uint64) ([]byte, error) {
+// AppendContext는 Append를 다른 고루틴에서 하고 ctx가 먼저 끝나면 기다리지 않고
+// ctx.Err()를 리턴한다. 시작한 쓰기는 멈출 수 없으므로 ctx.Err()를 리턴해도
+// 레코드가 나중에 쓰일 수 있다.
+func (s *store) AppendContext(ctx context.Context, p []byte) (n uint64, pos uint64, err error) {
+	type appended struct{ n, pos uint64 }
+	a, err := withContext(ctx, func() (appended, error) {
+		n, pos, err := s.Append(p)
+		return appended{n, pos}, err
+	})
+	return a.n, a.pos, err
+}
+
+// written은 Append가 버퍼에 쓴 뒤에 불린다. SyncEveryWrite면 바로 동기화하고
+// 아니면 플러시 설정을 따른다.
+func (s *store) written() error {
+	if s.config.Store.Sync == SyncEveryWrite {
+		return s.syncLocked()
+	}
+	return s.flushIfNeeded()
+}
+
+func (s *store) appended(n int) {
+	s.stats.AppendedBytes += uint64(n)
+	recordStats(StoreAppendedBytes.M(int64(n)))
+}
+
+// flushIfNeeded는 버퍼에 FlushBytes 이상 쌓였으면 바로 플러시하고, 아니면
+// FlushLatency 안에 플러시되도록 타이머를 건다. 타이머의 플러시가 실패하면
+// 그 에러는 버퍼에 남아 다음 쓰기에서 리턴된다.
+func (s *store) flushIfNeeded() error {
+	c := s.config.Store
+	if c.FlushBytes > 0 && s.buf.Buffered() >= c.FlushBytes {
+		return s.flush()
+	}
+	if c.FlushLatency > 0 && s.timer == nil && s.buf.Buffered() > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(c.FlushLatency, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// 그 사이에 다른 플러시가 이 타이머를 멈췄으면 할 일이 없다.
+			if s.timer != t {
+				return
+			}
+			s.timer = nil
+			s.flush()
+		})
+		s.timer = t
+	}
+	return nil
+}
+
+func (s *store) flush() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if s.direct != nil {
+		if err := s.direct.Flush(); err != nil {
+			return err
+		}
+	}
+	s.flushed = s.size
+	return nil
+}
+
+// flushBuffer는 버퍼에 쌓인 바이트를 파일에 쓴다. 디스크에 동기화하지는 않는다.
+func (s *store) flushBuffer() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if err := s.buf.Flush(); err != nil {
-		return nil, err
+	return s.flush()
+}
+
+// rlockFlushed는 mu를 읽기로 잡고 지금까지 쓴 바이트 중 end 앞의 것이 모두
+// 파일에 있을 때까지 필요하면 버퍼를 비운다. 처음 잡았을 때의 크기를
+// 리턴하므로 그 뒤에 추가된 레코드는 보지 않는다. 에러가 없으면 RLock을 잡은
+// 채로 돌아온다.
+func (s *store) rlockFlushed(end uint64) (uint64, error) {
+	s.mu.RLock()
+	size := s.size
+	end = min(end, size)
+	for end > s.flushed {
+		s.mu.RUnlock()
+		s.mu.Lock()
+		var err error
+		if end > s.flushed {
+			err = s.flush()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		s.mu.RLock()
 	}
+	return size, nil
+}
 
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+func (s *store) Read(pos uint64) ([]byte, error) {
+	// Append가 리턴한 레코드는 아직 버퍼에 있어도 바로 읽을 수 있어야 한다.
+	// Produce가 응답을 보낸 오프셋을 다른 클라이언트가 곧바로 읽을 수 있는 것도
+	// 이 플러시 덕분이다. 이미 파일에 있으면 플러시하지 않아 읽기끼리 막지 않는다.
+	size, err := s.rlockFlushed(pos + 1)
+	if err != nil {
 		return nil, err
 	}
+	defer s.mu.RUnlock()
 
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
-		return nil, err
+	// 쓴 데이터 밖은 파일을 읽어 보지 않고 거부한다.
+	if pos >= size {
+		return nil, ErrPosOutOfRange{Pos: pos, Size: size}
+	}
+
+	if fixed := s.config.Store.FixedRecordSize; fixed > 0 {
+		b := make([]byte, fixed)
+		if _, err := s.readFull(b, int64(pos), size); err != nil {
+			return nil, corrupt(err)
+		}
+		return b, nil
+	}
+
+	header := make([]byte, s.config.headerWidth())
+	if _, err := s.readFull(header, int64(pos), size); err != nil {
+		return nil, corrupt(err)
+	}
+
+	// 길이가 깨졌으면 스토어보다 큰 버퍼를 만들지 않도록 먼저 거부한다.
+	n := enc.Uint64(header)
+	if rest := size - pos; rest < uint64(len(header)) || n > rest-uint64(len(header)) {
+		return nil, fmt.Errorf("%w: length %d at position %d runs past the store", ErrCorruptRecord, n, pos)
+	}
+	b := make([]byte, n)
+	if _, err := s.readFull(b, int64(pos+uint64(len(header))), size); err != nil {
+		return nil, corrupt(err)
+	}
+	if s.config.Store.Checksum && crc32.Checksum(b, crcTable) != enc.Uint32(header[lenWidth:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch at position %d", ErrCorruptRecord, pos)
 	}
 	return b, nil
 }
 
+// ReadContext는 Read를 다른 고루틴에서 하고 ctx가 먼저 끝나면 기다리지 않고
+// ctx.Err()를 리턴한다.
+func (s *store) ReadContext(ctx context.Context, pos uint64) ([]byte, error) {
+	return withContext(ctx, func() ([]byte, error) {
+		return s.Read(pos)
+	})
+}
+
+// withContext는 fn을 다른 고루틴에서 돌리고 fn과 ctx 중 먼저 끝나는 쪽을
+// 기다린다. ctx가 이미 끝났으면 fn을 부르지 않는다. ctx가 먼저 끝나도 fn은
+// 끝까지 돌고 그 결과는 버린다.
+func withContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	// 끝나지 않는 컨텍스트면 고루틴을 띄울 필요가 없다.
+	if ctx.Done() == nil {
+		return fn()
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
 // func (s *store) Read(pos uint64) ([]byte, error)
 // 해당 위치의 저장된 레코드를 리턴한다. 읽으려는 레코드가 아직 버퍼에 있을 때를 대비해서 우선은 버퍼의
 // 내용을 플러시(flush)해서 디스크에 쓴다. 다음으로 읽을 레코드의 바이트 크기를 알아내고 그 만큼의 바이트를
 // 읽어 리턴한다. 함수 내에서 할당하는 메모리가 함수 바깥에서 쓰이지 않으면, 컴파일러는 그 메모리를 스택(stack)
 // 에 할당한다. 반대로 함수가 종료해도 함수 외부에서 계속 쓰이는 값이면 힙(heap)에 할당한다.
 
+// ReadAt은 레코드 경계와 상관없이 스토어의 바이트를 그대로 읽으므로 체크섬을
+// 확인하지 않는다. 백업은 이렇게 읽어 체크섬도 함께 옮긴다. off가 스토어
+// 안이면 io.ReaderAt처럼 동작해서, 끝을 넘는 부분은 읽지 않고 읽은 만큼과
+// io.EOF를 리턴한다. off가 밖이면 ErrReadOutOfBounds를 리턴한다.
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.buf.Flush(); err != nil {
+	size, err := s.rlockFlushed(uint64(max(off, 0)) + uint64(len(p)))
+	if err != nil {
 		return 0, err
 	}
-	return s.File.ReadAt(p, off)
+	defer s.mu.RUnlock()
+	if off < 0 || uint64(off) >= size {
+		return 0, ErrReadOutOfBounds{Offset: off, Size: size}
+	}
+	if rest := size - uint64(off); uint64(len(p)) > rest {
+		n, err := s.readFull(p[:rest], off, size)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return s.readFull(p, off, size)
+}
+
+// readFull은 NFS 같은 파일시스템에서 ReadAt이 len(p)보다 적게 읽고 돌아오는
+// 경우를 대비해 p를 다 채우거나 실제 에러가 날 때까지 ReadAt을 반복한다.
+// 아무것도 읽지 못하고 에러도 없으면 잠시 기다렸다가 다시 시도한다. size는
+// rlockFlushed가 리턴한, 파일에 있는 크기다.
+func (s *store) readFull(p []byte, off int64, size uint64) (int, error) {
+	if ok, err := s.readMapped(p, off, size); ok || err != nil {
+		return len(p), err
+	}
+	var n, retries int
+	backoff := s.config.Store.ReadBackoff
+	for n < len(p) {
+		m, err := s.reader.ReadAt(p[n:], off+int64(n))
+		n += m
+		atomic.AddUint64(&s.stats.ReadAts, 1)
+		atomic.AddUint64(&s.stats.ReadBytes, uint64(m))
+		recordStats(StoreReadAts.M(1), StoreReadBytes.M(int64(m)))
+		switch {
+		case n == len(p):
+			return n, nil
+		case err != nil:
+			return n, err
+		case m > 0:
+			retries = 0
+			backoff = s.config.Store.ReadBackoff
+		case retries == s.config.Store.ReadRetries:
+			return n, io.ErrNoProgress
+		default:
+			retries++
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return n, nil
+}
+
+// readMapped는 MmapReads일 때 p를 매핑한 범위에서 읽고 읽었는지 리턴한다.
+// 매핑한 범위를 넘는 읽기는 파일이 mmapRemapBytes 이상 커졌으면 다시 매핑해
+// 읽고, 아니면 readFull이 파일에서 읽도록 false를 리턴한다. 읽기 전에
+// 버퍼를 비우므로 size까지는 파일에 있다.
+func (s *store) readMapped(p []byte, off int64, size uint64) (bool, error) {
+	if !s.config.Store.MmapReads {
+		return false, nil
+	}
+	end := uint64(off) + uint64(len(p))
+	s.mapMu.RLock()
+	mapped := uint64(len(s.mapped))
+	if end > mapped && size > mapped && (mapped == 0 || size-mapped >= mmapRemapBytes) {
+		s.mapMu.RUnlock()
+		if err := s.remap(size); err != nil {
+			return false, err
+		}
+		s.mapMu.RLock()
+	}
+	defer s.mapMu.RUnlock()
+	if end > uint64(len(s.mapped)) {
+		return false, nil
+	}
+	n := copy(p, s.mapped[off:end])
+	atomic.AddUint64(&s.stats.ReadBytes, uint64(n))
+	recordStats(StoreReadBytes.M(int64(n)))
+	return true, nil
+}
+
+// remap은 파일을 size만큼 다시 매핑한다. 다른 읽기가 먼저 그만큼 매핑했으면
+// 그대로 둔다.
+func (s *store) remap(size uint64) error {
+	s.mapMu.Lock()
+	defer s.mapMu.Unlock()
+	if uint64(len(s.mapped)) >= size {
+		return nil
+	}
+	if err := s.unmap(); err != nil {
+		return err
+	}
+	m, err := gommap.MapRegion(s.backend.(fileBackend).Fd(), 0, int64(size), gommap.PROT_READ, gommap.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	s.mapped = m
+	return nil
+}
+
+func (s *store) unmap() error {
+	if s.mapped == nil {
+		return nil
+	}
+	err := s.mapped.UnsafeUnmap()
+	s.mapped = nil
+	return err
+}
+
+// 레코드를 읽다가 데이터가 중간에 끝나면 레코드가 잘린 것이다.
+func corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorruptRecord
+	}
+	return err
 }
 
 // func (s *store) ReadAt(p []byte, off int64) (int,error)
 // 스토어 파일에서 off 오프셋부터 len(p) 바이트만큼 p에 넣어준다. 이 메서드는
 // io.ReaderAt 인터페이스를 store 자료형에 구현한 것이다.
 
-func (s *store) Close() error {
+// Sync는 버퍼의 데이터를 파일에 쓰고 디스크에 동기화한다. 지난번 동기화
+// 뒤로 추가한 데이터가 없으면 아무것도 하지 않는다.
+func (s *store) Sync() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if err := s.buf.Flush(); err != nil {
+	return s.syncLocked()
+}
+
+func (s *store) syncLocked() error {
+	if s.synced && s.size == s.syncedSize {
+		return nil
+	}
+	if err := s.flush(); err != nil {
 		return err
 	}
-	return s.File.Close()
+	if err := s.sync(); err != nil {
+		return err
+	}
+	s.synced, s.syncedSize = true, s.size
+	return nil
+}
+
+func (s *store) sync() error {
+	s.stats.Syncs++
+	recordStats(StoreSyncs.M(1))
+	return s.backend.Sync()
+}
+
+func (s *store) Close() error {
+	// 동기화 고루틴은 mu를 잡으므로 mu를 잡기 전에 멈춘다.
+	if s.done != nil {
+		close(s.done)
+		s.loops.Wait()
+		s.done = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flush()
+	if err == nil {
+		err = s.sync()
+	}
+	// 플러시나 싱크가 실패해도 파일은 닫아야 디스크가 찼을 때 파일 디스크립터가
+	// 새지 않는다. 에러는 모두 합쳐 리턴한다.
+	errs := []error{err, s.unmap()}
+	if s.direct != nil {
+		errs = append(errs, s.direct.Close())
+	}
+	errs = append(errs, s.backend.Close())
+	return errors.Join(errs...)
 }
 
-// Close() 메서드는 파일을 닫기 전 버퍼의 데이터를 파일에 쓴다.
+// Close() 메서드는 파일을 닫기 전 버퍼의 데이터를 파일에 쓰고 디스크에 동기화한다.
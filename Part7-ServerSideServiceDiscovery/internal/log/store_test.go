@@ -1,10 +1,24 @@
 package log
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
 )
 
 var (
@@ -17,14 +31,14 @@ func TestStoreAppendRead(t *testing.T) {
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
 
-	s, err := newStore(f)
+	s, err := newStore(fileBackend{f}, Config{})
 	require.NoError(t, err)
 
 	testAppend(t, s)
 	testRead(t, s)
 	testReadAt(t, s)
 
-	s, err = newStore(f)
+	s, err = newStore(fileBackend{f}, Config{})
 	require.NoError(t, err)
 	testRead(t, s)
 }
@@ -76,7 +90,7 @@ func TestStoreClose(t *testing.T) {
 	f, err := os.CreateTemp("", "store_close_test")
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
-	s, err := newStore(f)
+	s, err := newStore(fileBackend{f}, Config{})
 	require.NoError(t, err)
 	_, _, err = s.Append(write)
 	require.NoError(t, err)
@@ -93,6 +107,45 @@ func TestStoreClose(t *testing.T) {
 	t.Logf("beforeSize %d, afterSize %d", beforeSize, afterSize)
 }
 
+// failingBackend는 fail이 켜지면 쓰기에 실패하고, 닫을 때 closeErr를 리턴한다.
+type failingBackend struct {
+	StoreBackend
+	fail     bool
+	closed   bool
+	closeErr error
+}
+
+func (b *failingBackend) WriteAt(p []byte, off int64) (int, error) {
+	if b.fail {
+		return 0, syscall.ENOSPC
+	}
+	return b.StoreBackend.WriteAt(p, off)
+}
+
+func (b *failingBackend) Close() error {
+	b.closed = true
+	b.StoreBackend.Close()
+	return b.closeErr
+}
+
+func TestStoreCloseFlushFails(t *testing.T) {
+	mem, err := NewMemoryStorage().Open("store_close_flush_test")
+	require.NoError(t, err)
+	errClose := errors.New("close failed")
+	b := &failingBackend{StoreBackend: mem, closeErr: errClose}
+	s, err := newStore(b, Config{})
+	require.NoError(t, err)
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+
+	// 버퍼를 플러시하지 못해도 파일은 닫고 두 에러를 모두 리턴한다.
+	b.fail = true
+	err = s.Close()
+	require.ErrorIs(t, err, syscall.ENOSPC)
+	require.ErrorIs(t, err, errClose)
+	require.True(t, b.closed)
+}
+
 func openFile(name string) (file *os.File, size int64, err error) {
 	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND,
 		0644,
@@ -110,3 +163,777 @@ func openFile(name string) (file *os.File, size int64, err error) {
 	return f, fi.Size(), nil
 
 }
+
+func TestStoreShortReads(t *testing.T) {
+	f, err := os.CreateTemp("", "store_short_read_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.ReadRetries = 3
+	c.Store.ReadBackoff = time.Millisecond
+	s, err := newStore(fileBackend{f}, c)
+	require.NoError(t, err)
+	testAppend(t, s)
+	require.NoError(t, s.buf.Flush())
+
+	// 한 번에 3바이트씩만 읽어도 레코드는 온전히 읽혀야 한다.
+	s.reader = &shortReaderAt{r: f, chunk: 3}
+	testRead(t, s)
+	testReadAt(t, s)
+
+	// 잠깐 아무것도 읽지 못해도 다시 시도해서 읽는다.
+	s.reader = &shortReaderAt{r: f, chunk: 3, stalls: 2}
+	testRead(t, s)
+
+	s.reader = &shortReaderAt{r: f, chunk: 3, stalls: 10}
+	_, err = s.Read(0)
+	require.Equal(t, io.ErrNoProgress, err)
+
+	// 레코드 중간에서 데이터가 끝나면 손상된 레코드다.
+	s.reader = io.NewSectionReader(f, 0, int64(lenWidth+2))
+	_, err = s.Read(0)
+	require.Equal(t, ErrCorruptRecord, err)
+}
+
+type shortReaderAt struct {
+	r      io.ReaderAt
+	chunk  int
+	stalls int
+}
+
+func (s *shortReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if s.stalls > 0 {
+		s.stalls--
+		return 0, nil
+	}
+	if len(p) > s.chunk {
+		p = p[:s.chunk]
+	}
+	return s.r.ReadAt(p, off)
+}
+
+func TestStoreFixedRecordSize(t *testing.T) {
+	f, err := os.CreateTemp("", "store_fixed_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.FixedRecordSize = len(write)
+	s, err := newStore(fileBackend{f}, c)
+	require.NoError(t, err)
+
+	for i := uint64(0); i < 3; i++ {
+		n, pos, err := s.Append(write)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(write)), n)
+		require.Equal(t, i*uint64(len(write)), pos)
+	}
+
+	_, _, err = s.Append([]byte("too short"))
+	require.Equal(t, ErrRecordSize, err)
+	require.Equal(t, 3*uint64(len(write)), s.size)
+
+	for i := uint64(0); i < 3; i++ {
+		read, err := s.Read(i * uint64(len(write)))
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+}
+
+func TestStoreMaxRecordSize(t *testing.T) {
+	f, err := os.CreateTemp("", "store_max_record_size_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.MaxRecordSize = uint64(len(write))
+	s, err := newStore(fileBackend{f}, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	size := s.size
+
+	_, _, err = s.Append(append(write, '!'))
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	var limit ErrRecordLimit
+	require.ErrorAs(t, err, &limit)
+	require.Equal(t, ErrRecordLimit{Size: uint64(len(write)) + 1, Max: uint64(len(write))}, limit)
+	require.Equal(t, size, s.size)
+
+	read, err := s.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+	_, err = s.Read(size)
+	require.Error(t, err)
+}
+
+func TestStoreReadOutOfRange(t *testing.T) {
+	f, err := os.CreateTemp("", "store_out_of_range_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(fileBackend{f}, Config{})
+	require.NoError(t, err)
+	defer s.Close()
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+
+	read, err := s.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+	b := make([]byte, lenWidth)
+	n, err := s.ReadAt(b, 0)
+	require.NoError(t, err)
+	require.Equal(t, lenWidth, n)
+
+	for _, pos := range []uint64{width, width + 1} {
+		_, err = s.Read(pos)
+		require.Equal(t, ErrPosOutOfRange{Pos: pos, Size: width}, err)
+	}
+}
+
+func TestStoreReadAtBounds(t *testing.T) {
+	f, err := os.CreateTemp("", "store_read_at_bounds_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(fileBackend{f}, Config{})
+	require.NoError(t, err)
+	defer s.Close()
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+
+	b := make([]byte, lenWidth)
+	for _, off := range []int64{int64(width), int64(width) + 1, -1} {
+		n, err := s.ReadAt(b, off)
+		require.Zero(t, n)
+		require.Equal(t, ErrReadOutOfBounds{Offset: off, Size: width}, err, "offset %d", off)
+	}
+
+	// 끝을 넘는 읽기는 io.ReaderAt처럼 읽을 수 있는 만큼 읽고 io.EOF를 리턴한다.
+	b = make([]byte, width)
+	n, err := s.ReadAt(b, lenWidth)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, len(write), n)
+	require.Equal(t, write, b[:n])
+}
+
+func TestStoreFlushLatency(t *testing.T) {
+	f, err := os.CreateTemp("", "store_flush_latency_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.FlushBytes = 1 << 20
+	c.Store.FlushLatency = 20 * time.Millisecond
+	s, err := newStore(fileBackend{f}, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// 드문드문 쓰는 레코드도 FlushLatency가 지나면 파일에 들어가야 한다.
+	for i := uint64(1); i < 4; i++ {
+		start := time.Now()
+		_, _, err := s.Append(write)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			fi, err := os.Stat(f.Name())
+			require.NoError(t, err)
+			return uint64(fi.Size()) == width*i
+		}, 10*c.Store.FlushLatency, time.Millisecond)
+		require.GreaterOrEqual(t, time.Since(start), c.Store.FlushLatency)
+	}
+}
+
+func BenchmarkStoreAppendBurst(b *testing.B) {
+	for name, flushBytes := range map[string]int{
+		"flush every append": 1,
+		"adaptive":           64 << 10,
+	} {
+		b.Run(name, func(b *testing.B) {
+			f, err := os.CreateTemp("", "store_append_bench")
+			require.NoError(b, err)
+			defer os.Remove(f.Name())
+
+			c := Config{}
+			c.Store.FlushBytes = flushBytes
+			c.Store.FlushLatency = time.Millisecond
+			s, err := newStore(fileBackend{f}, c)
+			require.NoError(b, err)
+			defer s.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.Append(write); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			s.mu.Lock()
+			b.ReportMetric(float64(s.stats.Flushes)/float64(b.N), "writes/op")
+			s.mu.Unlock()
+		})
+	}
+}
+
+// BenchmarkStoreAppendBatch는 레코드를 하나씩 쓸 때와 batch개씩 묶어 쓸 때를
+// 비교한다. FlushBytes가 1이라 하나씩 쓰면 레코드마다 파일에 쓴다.
+func BenchmarkStoreAppendBatch(b *testing.B) {
+	const batch = 64
+	for _, name := range []string{"single", "batch"} {
+		b.Run(name, func(b *testing.B) {
+			f, err := os.CreateTemp(b.TempDir(), "store_append_batch_bench")
+			require.NoError(b, err)
+			c := Config{}
+			c.Store.FlushBytes = 1
+			s, err := newStore(fileBackend{f}, c)
+			require.NoError(b, err)
+			defer s.Close()
+
+			ps := make([][]byte, batch)
+			for i := range ps {
+				ps[i] = write
+			}
+			b.SetBytes(int64(width))
+			b.ResetTimer()
+			for i := 0; i < b.N; i += batch {
+				if name == "batch" {
+					if _, _, err := s.AppendBatch(ps[:min(batch, b.N-i)]); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				for _, p := range ps[:min(batch, b.N-i)] {
+					if _, _, err := s.Append(p); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStoreAppendBatch(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "store_append_batch_test")
+	require.NoError(t, err)
+	c := Config{}
+	c.Store.MaxRecordSize = 16
+	s, err := newStore(fileBackend{f}, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	ps := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	ns, poss, err := s.AppendBatch(ps)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{lenWidth + 5, lenWidth + 6, lenWidth + 5}, ns)
+	require.Equal(t, []uint64{0, lenWidth + 5, 2*lenWidth + 11}, poss)
+	for i, pos := range poss {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, ps[i], read)
+	}
+
+	// 중간에 실패하면 그 앞까지 쓴 레코드만 리턴하고 그 레코드는 남는다.
+	size := s.size
+	ns, poss, err = s.AppendBatch([][]byte{write, bytes.Repeat([]byte("x"), 17), write})
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	require.Equal(t, []uint64{width}, ns)
+	require.Equal(t, []uint64{size}, poss)
+	require.Equal(t, size+width, s.size)
+	read, err := s.Read(poss[0])
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+}
+
+func TestStoreDirect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip(ErrDirectUnsupported)
+	}
+	dir := t.TempDir()
+	c := Config{}
+	c.Store.Direct = true
+
+	// 블록 경계에 걸치거나 블록보다 큰 레코드들이다.
+	var records [][]byte
+	for i, n := range []int{10, directBlock - lenWidth - 10, 3000, directBlock, 3 * directBlock, 1, 5000} {
+		records = append(records, bytes.Repeat([]byte{byte('a' + i)}, n))
+	}
+
+	open := func(name string, c Config) *store {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+		require.NoError(t, err)
+		s, err := newStore(fileBackend{f}, c)
+		require.NoError(t, err)
+		return s
+	}
+	direct := open(filepath.Join(dir, "direct"), c)
+	buffered := open(filepath.Join(dir, "buffered"), Config{})
+
+	var positions []uint64
+	appendAll := func(records [][]byte) {
+		for _, record := range records {
+			_, pos, err := direct.Append(record)
+			require.NoError(t, err)
+			positions = append(positions, pos)
+			_, _, err = buffered.Append(record)
+			require.NoError(t, err)
+
+			// 꼬리가 아직 메모리에 있어도 방금 쓴 레코드를 읽을 수 있다.
+			got, err := direct.Read(pos)
+			require.NoError(t, err)
+			require.Equal(t, record, got)
+		}
+	}
+	appendAll(records)
+
+	// 닫으면 꼬리를 쓰고 파일을 실제 크기로 자른다. 다시 열면 그 꼬리 뒤에
+	// 이어 쓴다.
+	require.NoError(t, direct.Close())
+	direct = open(filepath.Join(dir, "direct"), c)
+	defer direct.Close()
+	appendAll(records)
+
+	all := append(slices.Clone(records), records...)
+	for i, pos := range positions {
+		got, err := direct.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, all[i], got)
+	}
+
+	// 버퍼를 거쳐 쓴 파일과 바이트 단위로 같아야 한다.
+	require.NoError(t, direct.Sync())
+	require.NoError(t, buffered.Close())
+	want, err := os.ReadFile(filepath.Join(dir, "buffered"))
+	require.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(dir, "direct"))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func BenchmarkStoreAppendSustained(b *testing.B) {
+	record := bytes.Repeat([]byte("a"), 64<<10)
+	for _, name := range []string{"buffered", "direct"} {
+		b.Run(name, func(b *testing.B) {
+			if name == "direct" && runtime.GOOS != "linux" {
+				b.Skip(ErrDirectUnsupported)
+			}
+			f, err := os.CreateTemp(b.TempDir(), "store_append_bench")
+			require.NoError(b, err)
+
+			c := Config{}
+			c.Store.Direct = name == "direct"
+			c.Store.FlushBytes = 1 << 20
+			s, err := newStore(fileBackend{f}, c)
+			require.NoError(b, err)
+			defer s.Close()
+
+			b.SetBytes(int64(len(record)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.Append(record); err != nil {
+					b.Fatal(err)
+				}
+			}
+			require.NoError(b, s.Sync())
+		})
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	require.NoError(t, view.Register(Views...))
+	appended := viewSum(t, "proglog/log/store_appended_bytes")
+
+	f, err := os.CreateTemp(t.TempDir(), "store_stats_test")
+	require.NoError(t, err)
+	s, err := newStore(fileBackend{f}, Config{})
+	require.NoError(t, err)
+	defer s.Close()
+
+	testAppend(t, s)
+	require.Equal(t, StoreStats{AppendedBytes: 3 * width}, s.Stats())
+	require.Equal(t, appended+float64(3*width), viewSum(t, "proglog/log/store_appended_bytes"))
+
+	// 첫 Read가 버퍼를 비우고, 레코드마다 길이와 값을 따로 읽는다.
+	testRead(t, s)
+	require.Equal(t, StoreStats{
+		AppendedBytes: 3 * width,
+		ReadBytes:     3 * width,
+		Flushes:       1,
+		ReadAts:       6,
+	}, s.Stats())
+
+	b := make([]byte, lenWidth)
+	_, err = s.ReadAt(b, 0)
+	require.NoError(t, err)
+	// 바뀐 게 없으면 두 번째 Sync는 동기화하지 않는다.
+	require.NoError(t, s.Sync())
+	require.NoError(t, s.Sync())
+	require.Equal(t, StoreStats{
+		AppendedBytes: 3 * width,
+		ReadBytes:     3*width + lenWidth,
+		Flushes:       1,
+		Syncs:         1,
+		ReadAts:       7,
+	}, s.Stats())
+}
+
+func TestStoreChecksum(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string, checksum bool) *store {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE, 0644)
+		require.NoError(t, err)
+		c := Config{}
+		c.Store.Checksum = checksum
+		s, err := newStore(fileBackend{f}, c)
+		require.NoError(t, err)
+		return s
+	}
+	// flip은 pos 레코드 값의 첫 바이트를 파일에서 바꾼다.
+	flip := func(s *store, pos uint64) {
+		require.NoError(t, s.Sync())
+		b := make([]byte, 1)
+		at := int64(pos + s.config.headerWidth())
+		_, err := s.backend.ReadAt(b, at)
+		require.NoError(t, err)
+		_, err = s.backend.WriteAt([]byte{b[0] ^ 0xff}, at)
+		require.NoError(t, err)
+	}
+
+	for name, checksum := range map[string]bool{"with checksum": true, "without checksum": false} {
+		t.Run(name, func(t *testing.T) {
+			s := open(name, checksum)
+			defer s.Close()
+			var positions []uint64
+			for i := 0; i < 3; i++ {
+				n, pos, err := s.Append(write)
+				require.NoError(t, err)
+				require.Equal(t, s.config.recordWidth(len(write)), n)
+				positions = append(positions, pos)
+			}
+			flip(s, positions[1])
+
+			for i, pos := range positions {
+				got, err := s.Read(pos)
+				switch {
+				case i != 1:
+					require.NoError(t, err)
+					require.Equal(t, write, got)
+				case checksum:
+					require.ErrorIs(t, err, ErrCorruptRecord)
+				default:
+					// 체크섬이 없으면 바뀐 값을 그대로 돌려줄 수밖에 없다.
+					require.NoError(t, err)
+					require.NotEqual(t, write, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreSyncPolicy(t *testing.T) {
+	for name, policy := range map[string]SyncPolicy{
+		"never":       SyncNever,
+		"every write": SyncEveryWrite,
+		"interval":    SyncInterval(10 * time.Millisecond),
+	} {
+		t.Run(name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "store")
+			f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+			require.NoError(t, err)
+			c := Config{}
+			c.Store.Sync = policy
+			s, err := newStore(fileBackend{f}, c)
+			require.NoError(t, err)
+
+			for i := 0; i < 3; i++ {
+				_, _, err := s.Append(write)
+				require.NoError(t, err)
+			}
+			size := int(3 * c.recordWidth(len(write)))
+
+			// 프로세스가 여기서 죽으면 버퍼에 남은 데이터는 사라진다. 파일을
+			// 새로 열어 보면 동기화된 데이터만 남아 있다.
+			onDisk := func() int {
+				b, err := os.ReadFile(name)
+				require.NoError(t, err)
+				return len(b)
+			}
+			switch policy {
+			case SyncNever:
+				require.Zero(t, onDisk())
+				require.Zero(t, s.Stats().Syncs)
+			case SyncEveryWrite:
+				require.Equal(t, size, onDisk())
+				require.Equal(t, uint64(3), s.Stats().Syncs)
+			default:
+				require.Eventually(t, func() bool {
+					return onDisk() == size
+				}, time.Second, 5*time.Millisecond)
+				require.NotZero(t, s.Stats().Syncs)
+			}
+
+			require.NoError(t, s.Close())
+			require.Equal(t, size, onDisk())
+			// 주기적으로 동기화하던 고루틴도 멈춰서 닫은 뒤에는 동기화하지 않는다.
+			syncs := s.Stats().Syncs
+			time.Sleep(30 * time.Millisecond)
+			require.Equal(t, syncs, s.Stats().Syncs)
+		})
+	}
+}
+
+func TestStoreMmapReads(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "store_mmap_test")
+	require.NoError(t, err)
+	c := Config{}
+	c.Store.MmapReads = true
+	s, err := newStore(fileBackend{f}, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// 처음 읽을 때 매핑하고 그 뒤로는 파일을 읽지 않는다.
+	testAppend(t, s)
+	testRead(t, s)
+	testReadAt(t, s)
+	require.Equal(t, 3*width, uint64(len(s.mapped)))
+	require.Zero(t, s.Stats().ReadAts)
+
+	// 매핑한 뒤로 조금 쓴 꼬리는 파일에서 읽는다.
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	read, err := s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+	require.Equal(t, uint64(2), s.Stats().ReadAts)
+
+	// 충분히 커지면 다시 매핑한다.
+	big := bytes.Repeat([]byte("a"), mmapRemapBytes)
+	_, pos, err = s.Append(big)
+	require.NoError(t, err)
+	read, err = s.Read(pos)
+	require.NoError(t, err)
+	require.Equal(t, big, read)
+	require.Equal(t, s.size, uint64(len(s.mapped)))
+	require.Equal(t, uint64(2), s.Stats().ReadAts)
+	require.Equal(t, 7*width+lenWidth+uint64(len(big)), s.Stats().ReadBytes)
+
+	// 쓴 데이터 밖은 매핑과 상관없이 거부한다.
+	_, err = s.Read(s.size)
+	require.IsType(t, ErrPosOutOfRange{}, err)
+}
+
+func BenchmarkStoreRead(b *testing.B) {
+	for _, name := range []string{"read at", "mmap"} {
+		b.Run(name, func(b *testing.B) {
+			f, err := os.CreateTemp(b.TempDir(), "store_read_bench")
+			require.NoError(b, err)
+			c := Config{}
+			c.Store.MmapReads = name == "mmap"
+			s, err := newStore(fileBackend{f}, c)
+			require.NoError(b, err)
+			defer s.Close()
+
+			const records = 1024
+			for i := 0; i < records; i++ {
+				_, _, err := s.Append(write)
+				require.NoError(b, err)
+			}
+
+			b.SetBytes(int64(width))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Read(uint64(i%records) * width); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStoreParallelRead는 readers개의 고루틴이 b.N번의 읽기를 나눠
+// 읽을 때의 처리량을 잰다. 파일에 이미 있는 레코드는 읽기끼리 막지 않고
+// 읽으므로, 읽을 때마다 스토어 전체를 잠그던 것처럼 한 번에 하나씩만 읽는
+// "serialized"보다 읽는 고루틴이 많을수록 빨라야 한다.
+func BenchmarkStoreParallelRead(b *testing.B) {
+	for _, name := range []string{"serialized", "read at", "mmap"} {
+		for _, readers := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/%d readers", name, readers), func(b *testing.B) {
+				f, err := os.CreateTemp(b.TempDir(), "store_parallel_read_bench")
+				require.NoError(b, err)
+				c := Config{}
+				c.Store.MmapReads = name == "mmap"
+				s, err := newStore(fileBackend{f}, c)
+				require.NoError(b, err)
+				defer s.Close()
+
+				const records = 1024
+				for i := 0; i < records; i++ {
+					_, _, err := s.Append(write)
+					require.NoError(b, err)
+				}
+				require.NoError(b, s.Sync())
+
+				var serial sync.Mutex
+				read := func(pos uint64) error {
+					if name == "serialized" {
+						serial.Lock()
+						defer serial.Unlock()
+					}
+					_, err := s.Read(pos)
+					return err
+				}
+
+				b.SetBytes(int64(width))
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for r := 0; r < readers; r++ {
+					n := b.N / readers
+					if r < b.N%readers {
+						n++
+					}
+					wg.Add(1)
+					go func(r, n int) {
+						defer wg.Done()
+						for i := 0; i < n; i++ {
+							if err := read(uint64((r+i*readers)%records) * width); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					}(r, n)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+// TestStoreConcurrentReads는 -race로 돌려야 의미가 있다. 쓰는 동안 여러
+// 고루틴이 파일에 있는 레코드와 아직 버퍼에 있는 레코드를 함께 읽는다.
+func TestStoreConcurrentReads(t *testing.T) {
+	for _, mmap := range []bool{false, true} {
+		t.Run(fmt.Sprintf("mmap %t", mmap), func(t *testing.T) {
+			f, err := os.CreateTemp(t.TempDir(), "store_concurrent_test")
+			require.NoError(t, err)
+			c := Config{}
+			c.Store.MmapReads = mmap
+			s, err := newStore(fileBackend{f}, c)
+			require.NoError(t, err)
+			defer s.Close()
+
+			const records = 200
+			var appended atomic.Uint64
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < records; i++ {
+					_, _, err := s.Append(write)
+					if !assert.NoError(t, err) {
+						return
+					}
+					appended.Add(1)
+				}
+			}()
+			for r := 0; r < 8; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < records; i++ {
+						n := appended.Load()
+						if n == 0 {
+							continue
+						}
+						pos := uint64(i) % n * width
+						read, err := s.Read(pos)
+						if !assert.NoError(t, err) || !assert.Equal(t, write, read) {
+							return
+						}
+						p := make([]byte, width)
+						_, err = s.ReadAt(p, int64(pos))
+						if !assert.NoError(t, err) {
+							return
+						}
+						assert.Equal(t, write, p[lenWidth:])
+						s.Stats()
+					}
+				}()
+			}
+			wg.Wait()
+			require.Equal(t, uint64(records), appended.Load())
+		})
+	}
+}
+
+// stallingBackend는 mu를 잡고 있는 동안 읽기와 쓰기를 멈춘다.
+type stallingBackend struct {
+	StoreBackend
+	mu *sync.Mutex
+}
+
+func (b stallingBackend) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.StoreBackend.WriteAt(p, off)
+}
+
+func (b stallingBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.StoreBackend.ReadAt(p, off)
+}
+
+func TestStoreContext(t *testing.T) {
+	mem, err := NewMemoryStorage().Open("store_context_test")
+	require.NoError(t, err)
+	var stall sync.Mutex
+	c := Config{}
+	c.Store.Sync = SyncEveryWrite
+	s, err := newStore(stallingBackend{StoreBackend: mem, mu: &stall}, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	_, pos, err := s.AppendContext(ctx, write)
+	require.NoError(t, err)
+	read, err := s.ReadContext(ctx, pos)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+
+	// 이미 끝난 컨텍스트면 쓰지 않는다.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = s.AppendContext(cancelled, write)
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = s.ReadContext(cancelled, pos)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, width, s.size)
+
+	// 디스크가 멈춰도 기한이 지나면 바로 돌아온다.
+	stall.Lock()
+	for name, fn := range map[string]func(context.Context) error{
+		"append": func(ctx context.Context) error {
+			_, _, err := s.AppendContext(ctx, write)
+			return err
+		},
+		"read": func(ctx context.Context) error {
+			_, err := s.ReadContext(ctx, pos)
+			return err
+		},
+	} {
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		start := time.Now()
+		err := fn(ctx)
+		cancel()
+		require.ErrorIs(t, err, context.DeadlineExceeded, name)
+		require.Less(t, time.Since(start), time.Second, name)
+	}
+	stall.Unlock()
+
+	// 기다리지 않고 돌아온 쓰기도 디스크가 풀리면 끝난다.
+	require.Eventually(t, func() bool {
+		return s.Stats().AppendedBytes == 2*width
+	}, time.Second, 10*time.Millisecond)
+}
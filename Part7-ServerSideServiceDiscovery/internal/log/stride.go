@@ -0,0 +1,91 @@
+package log
+
+import (
+	"errors"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+var (
+	ErrStrideWithFixedSize = errors.New("an index stride cannot be used with fixed-size records")
+	ErrStrideWithSort      = errors.New("an index stride cannot be used with sorting by key")
+)
+
+// strided면 인덱스에 모든 레코드가 아니라 Segment.IndexStride 바이트마다
+// 레코드 하나만 있다. 1 이하이면 모든 레코드가 인덱스에 있다.
+func (c Config) strided() bool {
+	return c.Segment.IndexStride > 1
+}
+
+// checkStride는 인덱스 간격을 다른 설정과 함께 쓸 수 있는지 확인한다.
+// 인덱스 사이의 레코드는 스토어를 앞으로 훑어 오프셋을 보고 찾으므로
+// 레코드에 오프셋이 있고 스토어가 오프셋 순서여야 한다.
+func (c Config) checkStride() error {
+	switch {
+	case !c.strided():
+		return nil
+	case c.Store.FixedRecordSize > 0:
+		return ErrStrideWithFixedSize
+	case c.Segment.SortByKey:
+		return ErrStrideWithSort
+	}
+	return nil
+}
+
+// indexes는 스토어의 pos에 쓴 레코드를 인덱스에 넣을지 정한다. 세그먼트의 첫
+// 레코드와, 인덱스에 마지막으로 넣은 레코드의 위치 last에서 IndexStride 바이트
+// 이상 떨어진 레코드를 넣는다. Append, 압축, 복구가 모두 이 규칙을 따른다.
+func (c Config) indexes(empty bool, last, pos uint64) bool {
+	return !c.strided() || empty || pos-last >= c.Segment.IndexStride
+}
+
+// scanForward는 from부터 스토어를 차례로 읽어 fn에 넘긴다. fn이 false를
+// 리턴하거나 스토어 끝에 닿으면 멈춘다.
+func (s *segment) scanForward(from uint64, fn func(pos uint64, p []byte, record *api_v1.Record) bool) error {
+	for pos := from; pos < s.store.size; {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return err
+		}
+		record := &api_v1.Record{}
+		if err := s.config.decode(p, record); err != nil {
+			return err
+		}
+		if !fn(pos, p, record) {
+			return nil
+		}
+		pos += s.config.recordWidth(len(p))
+	}
+	return nil
+}
+
+// each는 세그먼트의 레코드를 오프셋 순서로 fn에 넘긴다. 모든 레코드가
+// 인덱스에 있으면 인덱스를 따라 읽고, 아니면 스토어를 처음부터 훑는다.
+// 고정 크기 모드에서는 쓸 수 없다.
+func (s *segment) each(fn func(rel uint32, p []byte) error) error {
+	if s.config.strided() {
+		var err error
+		serr := s.scanForward(0, func(_ uint64, p []byte, record *api_v1.Record) bool {
+			err = fn(uint32(record.Offset-s.baseOffset), p)
+			return err == nil
+		})
+		if serr != nil {
+			return serr
+		}
+		return err
+	}
+	for i := int64(0); uint64(i)*s.index.entWidth < s.index.size; i++ {
+		rel, pos, err := s.index.Read(i)
+		if err != nil {
+			return err
+		}
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return err
+		}
+		if err := fn(rel, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentIndexStride(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Segment.MaxIndexBytes = 1 << 20
+	c.Segment.IndexStride = 64
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		off, err := s.Append(&api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+		require.Equal(t, uint64(16+i), off)
+	}
+	// 레코드가 20바이트 남짓이니 서너 개마다 항목 하나다.
+	entries := s.index.size / entWidth
+	require.Less(t, entries, uint64(n/2))
+
+	check := func(s *segment) {
+		t.Helper()
+		// 인덱스에 있는 레코드와 앞 항목부터 훑어 찾는 레코드를 모두 읽는다.
+		for i := 0; i < n; i++ {
+			record, err := s.Read(uint64(16 + i))
+			require.NoError(t, err)
+			require.Equal(t, uint64(16+i), record.Offset)
+			require.Equal(t, fmt.Sprintf("record %d", i), string(record.Value))
+		}
+		for _, off := range []uint64{15, 16 + n} {
+			_, err := s.Read(off)
+			require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+		}
+	}
+	check(s)
+
+	// 다시 열면 마지막 항목 뒤를 훑어 다음 오프셋을 찾는다.
+	require.NoError(t, s.Close())
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, entries, s.index.size/entWidth)
+	require.Equal(t, uint64(16+n), s.nextOffset)
+	check(s)
+}
+
+func TestLogIndexStride(t *testing.T) {
+	dir := t.TempDir()
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 256
+	c.Segment.IndexStride = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// 복구도 같은 간격으로 인덱스를 만드므로 다시 쓰지 않는다.
+	c.Segment.HealOnOpen = true
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	report, err := log.Heal()
+	require.NoError(t, err)
+	require.Empty(t, report.Rebuilt)
+	for i := uint64(0); i < 30; i++ {
+		record, err := log.Read(i)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", i), string(record.Value))
+	}
+
+	// 간격은 로그를 만든 뒤에 바꿀 수 없다.
+	c.Segment.IndexStride = 0
+	_, err = NewLog(dir, c)
+	require.ErrorIs(t, err, ErrConfigMismatch)
+
+	c.Segment.IndexStride = 64
+	c.Segment.SortByKey = true
+	_, err = NewLog(t.TempDir(), c)
+	require.ErrorIs(t, err, ErrStrideWithSort)
+}
+
+func BenchmarkIndexStride(b *testing.B) {
+	record := &api_v1.Record{Value: []byte("tiny")}
+	for _, stride := range []uint64{1, 4096} {
+		b.Run(fmt.Sprintf("stride %d", stride), func(b *testing.B) {
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1 << 30
+			c.Segment.MaxIndexBytes = 1 << 30
+			c.Segment.IndexStride = stride
+			s, err := newSegment(b.TempDir(), 0, c)
+			require.NoError(b, err)
+			defer s.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Append(record); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(s.index.size)/float64(b.N), "index-bytes/record")
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"slices"
+	"sort"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+// keyRecord는 키의 가장 최근 레코드의 오프셋과 스토어에서 차지하는 크기다.
+type keyRecord struct {
+	offset, width uint64
+}
+
+// loadSuperseded는 Compaction.ByKey일 때 키마다 가장 최근 레코드를 모으고,
+// 세그먼트마다 같은 키의 더 최근 레코드로 대체된 레코드의 바이트 수를 센다.
+// 한 번 센 뒤로는 append가 이어서 세므로 maybeCompact가 레코드를 훑지 않는다.
+// 봉인된 세그먼트는 로그 락 없이 훑고, 그 사이에 봉인된 세그먼트와 활성
+// 세그먼트만 쓰기 락을 잡고 훑는다. 이미 셌으면 아무것도 하지 않는다.
+func (l *Log) loadSuperseded() error {
+	// 훑는 동안 압축이 세그먼트를 바꾸지 않게 한다.
+	l.rewriteMu.Lock()
+	defer l.rewriteMu.Unlock()
+	l.mu.RLock()
+	if l.closed || l.compactKeys != nil || len(l.segments) == 0 {
+		l.mu.RUnlock()
+		return nil
+	}
+	sealed := slices.Clone(l.segments[:len(l.segments)-1])
+	l.mu.RUnlock()
+
+	t := &supersededTally{
+		latest: make(map[string]keyRecord),
+		bytes:  make(map[*segment]uint64),
+	}
+	if !l.pinSegments(sealed...) {
+		// 그 사이에 세그먼트가 지워졌다. 다음 주기에 다시 센다.
+		return nil
+	}
+	for _, s := range sealed {
+		if err := t.scan(s); err != nil {
+			unpinSegments(sealed...)
+			return err
+		}
+	}
+	unpinSegments(sealed...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	for _, s := range l.segments {
+		if len(sealed) > 0 && s.baseOffset <= sealed[len(sealed)-1].baseOffset {
+			continue
+		}
+		if err := t.scan(s); err != nil {
+			return err
+		}
+	}
+	for _, s := range l.segments {
+		s.setSuperseded(t.bytes[s])
+	}
+	l.compactKeys = t.latest
+	return nil
+}
+
+// supersededTally는 loadSuperseded가 세그먼트를 훑으며 센 값이다.
+type supersededTally struct {
+	latest   map[string]keyRecord
+	bytes    map[*segment]uint64
+	segments []*segment
+}
+
+// scan은 s의 키가 있는 레코드를 센다. 세그먼트는 오프셋 순서로 넘겨야 한다.
+// 정렬된 세그먼트는 키 순서로 훑으므로 오프셋을 비교해서 대체된 쪽을 정한다.
+func (t *supersededTally) scan(s *segment) error {
+	t.segments = append(t.segments, s)
+	return s.scanForward(0, func(_ uint64, p []byte, record *api_v1.Record) bool {
+		if len(record.Key) == 0 {
+			return true
+		}
+		width := s.config.recordWidth(len(p))
+		prev, ok := t.latest[string(record.Key)]
+		switch {
+		case ok && prev.offset > record.Offset:
+			t.bytes[s] += width
+			return true
+		case ok:
+			if ps := findSegment(t.segments, prev.offset); ps != nil {
+				t.bytes[ps] += prev.width
+			}
+		}
+		t.latest[string(record.Key)] = keyRecord{offset: record.Offset, width: width}
+		return true
+	})
+}
+
+// supersede는 append가 key 레코드를 off에 width 바이트로 쓴 뒤에 부른다. 키의
+// 예전 레코드가 있던 세그먼트에 그 크기를 대체된 바이트로 더한다. 아직 세지
+// 않았거나 키가 없는 레코드면 아무것도 하지 않는다.
+func (l *Log) supersede(key []byte, off, width uint64) {
+	if l.compactKeys == nil || len(key) == 0 {
+		return
+	}
+	if prev, ok := l.compactKeys[string(key)]; ok {
+		if s := findSegment(l.segments, prev.offset); s != nil {
+			s.addSuperseded(prev.width)
+		}
+	}
+	l.compactKeys[string(key)] = keyRecord{offset: off, width: width}
+}
+
+// keptSuperseded는 압축으로 base에서 시작하는 세그먼트에 남긴 레코드 중 같은
+// 키의 더 최근 레코드가 있는 레코드의 바이트 수다.
+func (l *Log) keptSuperseded(base uint64, kept []keptRecord) uint64 {
+	if l.compactKeys == nil {
+		return 0
+	}
+	var n uint64
+	for _, k := range kept {
+		if len(k.key) == 0 {
+			continue
+		}
+		if latest, ok := l.compactKeys[string(k.key)]; ok && latest.offset > base+uint64(k.rel) {
+			n += l.Config.recordWidth(len(k.p))
+		}
+	}
+	return n
+}
+
+// findSegment는 베이스 오프셋 순서인 segments에서 off 레코드가 있는
+// 세그먼트를 찾는다. 없으면 nil이다.
+func findSegment(segments []*segment, off uint64) *segment {
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].baseOffset > off
+	})
+	if i == 0 || off >= segments[i-1].nextOffset {
+		return nil
+	}
+	return segments[i-1]
+}
+
+func (s *segment) addSuperseded(n uint64) {
+	s.statsMu.Lock()
+	s.superseded += n
+	s.statsMu.Unlock()
+}
+
+func (s *segment) setSuperseded(n uint64) {
+	s.statsMu.Lock()
+	s.superseded = n
+	s.statsMu.Unlock()
+}
+
+// supersededEstimate는 세어 둔 대체된 바이트 수다. 압축한 뒤 지워진 최근
+// 레코드를 가리키는 키가 있으면 실제보다 클 수 있고, 압축이 스토어를 훑으며
+// 바로잡는다.
+func (s *segment) supersededEstimate() uint64 {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.superseded
+}
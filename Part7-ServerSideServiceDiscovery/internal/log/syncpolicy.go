@@ -0,0 +1,57 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SyncPolicy는 스토어가 파일을 디스크에 동기화할 시점이다. 0보다 크면
+// 그 간격이다.
+type SyncPolicy time.Duration
+
+const (
+	// SyncNever면 스토어가 스스로 동기화하지 않는다.
+	SyncNever SyncPolicy = 0
+	// SyncEveryWrite면 Append가 리턴하기 전에 동기화한다. 가장 안전하지만
+	// 쓰기마다 디스크를 기다린다.
+	SyncEveryWrite SyncPolicy = -1
+)
+
+// SyncInterval은 d마다 동기화하는 정책이다. 크래시가 나면 마지막 d 동안
+// 쓴 데이터만 잃는다. d가 0 이하이면 SyncNever다.
+func SyncInterval(d time.Duration) SyncPolicy {
+	if d <= 0 {
+		return SyncNever
+	}
+	return SyncPolicy(d)
+}
+
+func (p SyncPolicy) interval() time.Duration {
+	if p <= 0 {
+		return 0
+	}
+	return time.Duration(p)
+}
+
+// syncEvery는 Close할 때까지 interval마다 스토어를 동기화한다. Close가
+// done을 닫고 끝나기를 기다린 뒤에 파일을 닫는다.
+func (s *store) syncEvery(interval time.Duration) {
+	s.done = make(chan struct{})
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if err := s.Sync(); err != nil {
+					zap.L().Named("log").Error("store sync failed", zap.String("file", s.Name()), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
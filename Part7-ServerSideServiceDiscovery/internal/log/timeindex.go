@@ -0,0 +1,140 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+const (
+	timeIndexExt = ".timeindex"
+
+	// timeEntWidth는 시간 인덱스 항목 하나의 크기다.
+	timeEntWidth = 16
+)
+
+// timeEntry는 시간 인덱스 항목 하나로, 레코드의 시각과 오프셋이다. 시간 인덱스
+// 파일에는 다음 형식으로 차례로 들어 있다:
+//
+//	[8바이트 시각][8바이트 오프셋]
+type timeEntry struct {
+	ts     int64
+	offset uint64
+}
+
+// timeIndexed면 세그먼트마다 Segment.TimeIndexInterval개 레코드마다 하나씩
+// 시각과 오프셋을 시간 인덱스에 적는다. 고정 크기 모드의 레코드는 시각이 없다.
+func (c Config) timeIndexed() bool {
+	return c.Segment.TimeIndexInterval > 0 && c.Store.FixedRecordSize == 0
+}
+
+// timesName은 스토어 파일 옆의 시간 인덱스 파일 이름이다.
+func (s *segment) timesName() string {
+	return strings.TrimSuffix(s.store.Name(), ".store") + timeIndexExt
+}
+
+// openTimeIndex는 세그먼트를 열 때 시간 인덱스를 읽는다. 비어 있는 세그먼트면
+// 새로 만들고, 레코드가 있는데 시간 인덱스가 없으면 만들지 않아 OffsetForTime이
+// 오프셋 인덱스로 찾게 둔다. 복구로 스토어가 잘렸으면 없는 레코드를 가리키는
+// 뒤쪽 항목을 버리고, 첫 항목이 세그먼트의 첫 레코드가 아니면 시간 인덱스를
+// 통째로 버린다. nextOffset을 정한 뒤에 불러야 한다.
+func (s *segment) openTimeIndex() error {
+	if !s.config.timeIndexed() {
+		return nil
+	}
+	name := s.timesName()
+	b, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if errors.Is(err, os.ErrNotExist) && s.store.size > 0 {
+		return nil
+	}
+	var times []timeEntry
+	for ; len(b) >= timeEntWidth; b = b[timeEntWidth:] {
+		e := timeEntry{ts: int64(enc.Uint64(b)), offset: enc.Uint64(b[8:])}
+		if e.offset < s.baseOffset || e.offset >= s.nextOffset {
+			break
+		}
+		times = append(times, e)
+	}
+	if s.store.size > 0 {
+		rel, _, err := s.index.Read(0)
+		if err != nil || len(times) == 0 || times[0].offset != s.baseOffset+uint64(rel) {
+			return removeFile(name)
+		}
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(len(times) * timeEntWidth)); err != nil {
+		f.Close()
+		return err
+	}
+	s.timeFile, s.times = f, times
+	return nil
+}
+
+// appendTime은 Append가 레코드를 쓴 뒤에 부른다. 세그먼트의 TimeIndexInterval
+// 번째 레코드마다 항목을 넣는다. 시계가 뒤로 가서 마지막 항목보다 이른 시각이면
+// 이진 탐색할 수 있도록 항목을 넣지 않는다.
+func (s *segment) appendTime(record *api_v1.Record) error {
+	if s.timeFile == nil || s.records%uint64(s.config.Segment.TimeIndexInterval) != 0 {
+		return nil
+	}
+	if n := len(s.times); n > 0 && record.Timestamp < s.times[n-1].ts {
+		return nil
+	}
+	b := enc.AppendUint64(nil, uint64(record.Timestamp))
+	b = enc.AppendUint64(b, record.Offset)
+	if _, err := s.timeFile.WriteAt(b, int64(len(s.times)*timeEntWidth)); err != nil {
+		return err
+	}
+	s.times = append(s.times, timeEntry{ts: record.Timestamp, offset: record.Offset})
+	return nil
+}
+
+// timedOffsetForTime은 시간 인덱스로 세그먼트에서 시각이 since 이상인 첫
+// 레코드를 찾는다. 시각이 since보다 앞선 마지막 항목부터 스토어를 앞으로
+// 훑으므로 레코드를 많아야 TimeIndexInterval개쯤 읽는다. 첫 항목은 세그먼트의
+// 첫 레코드이므로 그 시각이 since 이상이면 바로 리턴한다.
+func (s *segment) timedOffsetForTime(since int64) (off uint64, ok bool, err error) {
+	k := sort.Search(len(s.times), func(i int) bool { return s.times[i].ts >= since })
+	if k == 0 {
+		return s.times[0].offset, true, nil
+	}
+	from, err := s.position(s.times[k-1].offset)
+	if err != nil {
+		return 0, false, err
+	}
+	err = s.scanForward(from, func(_ uint64, _ []byte, record *api_v1.Record) bool {
+		if record.Timestamp >= since {
+			off, ok = record.Offset, true
+			return false
+		}
+		return true
+	})
+	return off, ok, err
+}
+
+// closeTimeIndex는 시간 인덱스 파일을 닫는다.
+func (s *segment) closeTimeIndex() error {
+	if s.timeFile == nil {
+		return nil
+	}
+	err := s.timeFile.Close()
+	s.timeFile = nil
+	return err
+}
+
+// removeFile은 name을 지우고 없으면 무시한다.
+func removeFile(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package log
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+var ErrTimestampWithFixedSize = errors.New("fixed-size records have no timestamps")
+
+// stamp는 시각이 없는 레코드에 지금 시각을 넣는다. 복제하거나 복원한 레코드는
+// 원래 시각을 지킨다. 고정 크기 모드는 값만 저장하므로 넣지 않는다.
+func (c Config) stamp(record *api_v1.Record) {
+	if record.Timestamp == 0 && c.Store.FixedRecordSize == 0 {
+		record.Timestamp = time.Now().UnixNano()
+	}
+}
+
+// OffsetForTime은 Timestamp가 since(유닉스 나노초) 이상인 첫 레코드의 오프셋을
+// 리턴한다. 모든 레코드가 since보다 앞서면 ErrOffsetOutOfRange를 리턴한다.
+//
+// 세그먼트의 첫 레코드로 세그먼트를 고른 뒤, 그 세그먼트에 시간 인덱스가
+// 있으면 시간 인덱스를, 없으면 오프셋 인덱스 항목이 가리키는 레코드의 시각을
+// 이진 탐색한다. 어느 쪽이든 레코드를 O(log n)개만 읽는다. 시각이 오프셋
+// 순서로 늘어난다고 보므로 시각을 지켜 복제한 레코드가 섞이거나 시계가 뒤로
+// 가서 순서가 어긋나면 정확한 첫 레코드가 아닐 수 있다. 만료된 레코드도
+// 찾으므로 읽는 쪽에서 건너뛴다.
+func (l *Log) OffsetForTime(since int64) (uint64, error) {
+	if l.Config.Store.FixedRecordSize > 0 {
+		return 0, ErrTimestampWithFixedSize
+	}
+	// 버퍼에 남은 레코드는 스토어가 읽을 때 비우므로 Read처럼 읽기 락만 잡는다.
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+
+	var segments []*segment
+	for _, s := range l.segments {
+		if s.index.size > 0 {
+			segments = append(segments, s)
+		}
+	}
+	// j는 첫 레코드가 since 이상인 첫 세그먼트다. 찾는 레코드는 그 앞 세그먼트의
+	// 중간에 있거나 j의 첫 레코드다.
+	var err error
+	j := sort.Search(len(segments), func(i int) bool {
+		ts, serr := segments[i].firstTimestamp()
+		if serr != nil {
+			err = serr
+			return true
+		}
+		return ts >= since
+	})
+	if err != nil {
+		return 0, err
+	}
+	if j > 0 {
+		off, ok, err := segments[j-1].offsetForTime(since)
+		if err != nil || ok {
+			return off, err
+		}
+	}
+	if j < len(segments) {
+		rel, _, err := segments[j].index.Read(0)
+		return segments[j].baseOffset + uint64(rel), err
+	}
+	return 0, api_v1.ErrOffsetOutOfRange{Offset: l.Config.next(l.activeSegment.nextOffset)}
+}
+
+// firstTimestamp는 세그먼트의 첫 레코드의 시각이다. 시간 인덱스가 있으면
+// 스토어를 읽지 않는다.
+func (s *segment) firstTimestamp() (int64, error) {
+	if len(s.times) > 0 {
+		return s.times[0].ts, nil
+	}
+	return s.entryTimestamp(0)
+}
+
+// entryTimestamp는 인덱스의 i번째 항목이 가리키는 레코드의 시각이다.
+func (s *segment) entryTimestamp(i int64) (int64, error) {
+	_, pos, err := s.index.Read(i)
+	if err != nil {
+		return 0, err
+	}
+	p, err := s.store.Read(pos)
+	if err != nil {
+		return 0, err
+	}
+	record := &api_v1.Record{}
+	if err := s.config.decode(p, record); err != nil {
+		return 0, err
+	}
+	return record.Timestamp, nil
+}
+
+// offsetForTime은 세그먼트에서 시각이 since 이상인 첫 레코드의 오프셋을 찾는다.
+// 없으면 ok가 false다. 인덱스 간격을 두었으면 찾은 항목 바로 앞 항목부터
+// 스토어를 앞으로 훑는다.
+func (s *segment) offsetForTime(since int64) (off uint64, ok bool, err error) {
+	if len(s.times) > 0 {
+		return s.timedOffsetForTime(since)
+	}
+	n := int(s.index.size / s.index.entWidth)
+	k := sort.Search(n, func(i int) bool {
+		ts, serr := s.entryTimestamp(int64(i))
+		if serr != nil {
+			err = serr
+			return true
+		}
+		return ts >= since
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if s.config.strided() {
+		_, from, err := s.index.Read(int64(max(k-1, 0)))
+		if err != nil {
+			return 0, false, err
+		}
+		err = s.scanForward(from, func(_ uint64, _ []byte, record *api_v1.Record) bool {
+			if record.Timestamp >= since {
+				off, ok = record.Offset, true
+				return false
+			}
+			return true
+		})
+		return off, ok, err
+	}
+	if k == n {
+		return 0, false, nil
+	}
+	rel, _, err := s.index.Read(int64(k))
+	return s.baseOffset + uint64(rel), err == nil, err
+}
@@ -0,0 +1,156 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetForTime(t *testing.T) {
+	for scenario, fn := range map[string]func(*Config){
+		"every record indexed": func(c *Config) {
+			// 세그먼트마다 레코드 세 개가 들어간다.
+			c.Segment.MaxIndexBytes = 3 * entWidth
+		},
+		"index stride": func(c *Config) {
+			c.Segment.MaxStoreBytes = 200
+			c.Segment.IndexStride = 64
+		},
+		"time index": func(c *Config) {
+			c.Segment.MaxIndexBytes = 3 * entWidth
+			c.Segment.TimeIndexInterval = 2
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			fn(&c)
+			log, err := NewLog(t.TempDir(), c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			_, err = log.OffsetForTime(0)
+			require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+
+			// i번째 레코드의 시각은 100*(i+1)이다.
+			const n = 20
+			for i := 0; i < n; i++ {
+				_, err := log.Append(&api_v1.Record{
+					Value:     []byte(fmt.Sprintf("record %d", i)),
+					Timestamp: int64(100 * (i + 1)),
+				})
+				require.NoError(t, err)
+			}
+			require.Greater(t, len(log.segments), 2)
+
+			for _, tc := range []struct {
+				since int64
+				want  uint64
+			}{
+				{since: 0, want: 0},
+				{since: 100, want: 0},
+				{since: 101, want: 1},
+				{since: 950, want: 9},
+				{since: 1000, want: 9},
+				{since: 1001, want: 10},
+				{since: 100 * n, want: n - 1},
+			} {
+				off, err := log.OffsetForTime(tc.since)
+				require.NoError(t, err)
+				require.Equal(t, tc.want, off, "since %d", tc.since)
+			}
+
+			_, err = log.OffsetForTime(100*n + 1)
+			require.Equal(t, api_v1.ErrOffsetOutOfRange{Offset: n}, err)
+		})
+	}
+}
+
+func TestOffsetForTimeIndex(t *testing.T) {
+	dir := t.TempDir()
+	c := Config{}
+	c.Segment.TimeIndexInterval = 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer func() { log.Close() }()
+
+	// i번째 레코드의 시각은 100*(i+1)이고 0, 4, 8, ...번째 레코드가 시간
+	// 인덱스에 들어간다.
+	const n = 20
+	for i := 0; i < n; i++ {
+		_, err := log.Append(&api_v1.Record{
+			Value:     []byte(fmt.Sprintf("record %d", i)),
+			Timestamp: int64(100 * (i + 1)),
+		})
+		require.NoError(t, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "0"+timeIndexExt))
+	require.NoError(t, err)
+	require.Len(t, b, 5*timeEntWidth)
+
+	seek := func() {
+		t.Helper()
+		for _, tc := range []struct {
+			since int64
+			want  uint64
+		}{
+			{since: 0, want: 0},
+			// 500(오프셋 4)과 900(오프셋 8) 사이의 시각이다.
+			{since: 550, want: 5},
+			{since: 800, want: 7},
+			{since: 900, want: 8},
+			{since: 100 * n, want: n - 1},
+		} {
+			off, err := log.OffsetForTime(tc.since)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, off, "since %d", tc.since)
+		}
+		_, err = log.OffsetForTime(100*n + 1)
+		require.Equal(t, api_v1.ErrOffsetOutOfRange{Offset: n}, err)
+	}
+	seek()
+
+	// 다시 열면 파일에서 읽는다.
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Len(t, log.activeSegment.times, 5)
+	seek()
+
+	// 시계가 뒤로 간 레코드는 시간 인덱스에 넣지 않는다.
+	for i := n; i < n+4; i++ {
+		_, err := log.Append(&api_v1.Record{Value: []byte("skewed"), Timestamp: 50})
+		require.NoError(t, err)
+	}
+	require.Len(t, log.activeSegment.times, 5)
+	off, err := log.OffsetForTime(550)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+}
+
+func TestAppendStampsRecords(t *testing.T) {
+	log, err := NewLog(t.TempDir(), Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	before := time.Now().UnixNano()
+	off, err := log.Append(&api_v1.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	record, err := log.Read(off)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, record.Timestamp, before)
+	require.LessOrEqual(t, record.Timestamp, time.Now().UnixNano())
+
+	// 복제한 레코드처럼 시각이 있으면 그대로 둔다.
+	require.NoError(t, log.AppendAt(off+1, &api_v1.Record{Value: []byte("replicated"), Timestamp: 42}))
+	record, err = log.Read(off + 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), record.Timestamp)
+
+	// 시각 없이 다시 보낸 같은 레코드는 충돌이 아니다.
+	require.NoError(t, log.AppendAt(off, &api_v1.Record{Value: []byte("hello world")}))
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// append는 record를 clog에 쓴다. AppendTimeout이 있으면 쓰기를 다른 고루틴에서
+// 하고 그 시간까지만 기다린다.
+//
+// 시간을 넘긴 쓰기는 취소할 수 없으므로 나중에 끝날 수 있다. 그래서
+// DeadlineExceeded는 레코드를 쓰지 못했다는 뜻이 아니라 결과를 모른다는 뜻이다.
+// 그 쓰기가 끝날 때까지 서버는 망가진 상태로 보고 헬스 체크에 NOT_SERVING을
+// 알리며 새 쓰기를 Unavailable로 거부한다. 멈춘 디스크에 쓰기가 쌓이지 않게
+// 하기 위해서다. 쓰기가 나중에 끝나면 어느 오프셋에 쓰였는지 로그로 남기고
+// 다시 쓰기를 받는다. idempotency_key 없이 다시 시도하는 클라이언트는 그
+// 레코드가 이미 쓰였는지 읽어 보고 확인해야 한다. 키가 있으면 그 쓰기가 끝날
+// 때까지 같은 키로 온 요청을 APPEND_PENDING으로 거부하고, 끝나면 쓰인 오프셋을
+// 돌려준다.
+//
+// 요청의 ctx가 끝날 때도 기다리지 않고 ctx.Err()에 맞는 DeadlineExceeded나
+// Canceled로 실패한다. 이때도 레코드가 쓰였는지는 모른다.
+//
+// late가 nil이 아니면 결과를 모른 채 리턴하기 전에 late.begin을 부르고,
+// 쓰기가 끝나면 그 결과로 late.end를 부른다.
+func (s *grpcServer) append(ctx context.Context, clog CommitLog, record *api_v1.Record, late *pendingWrite) (uint64, error) {
+	if s.AppendTimeout <= 0 {
+		return s.appendLog(ctx, clog, record)
+	}
+	if s.stuckAppends.Load() > 0 {
+		return 0, api_v1.NewStatus(
+			codes.Unavailable,
+			"log is degraded: an earlier append has not finished",
+			api_v1.ReasonLogDegraded,
+			nil,
+		).Err()
+	}
+
+	type result struct {
+		offset uint64
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// 핸들러가 리턴하면 gRPC가 ctx를 취소한다. 그래도 쓰기가 실제로 끝날
+		// 때까지 기다려야 망가진 상태를 풀 수 있으므로 취소를 떼어 낸다.
+		off, err := s.appendLog(context.WithoutCancel(ctx), clog, record)
+		done <- result{off, err}
+	}()
+	timer := time.NewTimer(s.AppendTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.offset, r.err
+	case <-ctx.Done():
+		late.begin()
+		go func() {
+			r := <-done
+			late.end(r.offset, r.err)
+		}()
+		return 0, status.FromContextError(ctx.Err()).Err()
+	case <-timer.C:
+	}
+
+	late.begin()
+	s.stuckAppends.Add(1)
+	s.updateHealth()
+	go func() {
+		r := <-done
+		late.end(r.offset, r.err)
+		logger := zap.L().Named("server")
+		if r.err != nil {
+			logger.Error("timed out append failed", zap.Error(r.err))
+		} else {
+			logger.Warn("timed out append completed", zap.Uint64("offset", r.offset))
+		}
+		s.stuckAppends.Add(-1)
+		s.updateHealth()
+	}()
+	return 0, api_v1.NewStatus(
+		codes.DeadlineExceeded,
+		fmt.Sprintf("append did not finish within %s; the record may still be written", s.AppendTimeout),
+		api_v1.ReasonAppendTimeout,
+		map[string]string{"timeout": s.AppendTimeout.String()},
+	).Err()
+}
+
+// rejectedRecord는 로그가 레코드 자체 때문에 거부한 에러를 InvalidArgument로
+// 바꾼다. 다시 보내도 같은 결과이므로 클라이언트가 다시 시도하지 않게 한다.
+func rejectedRecord(err error) error {
+	var limit log.ErrRecordLimit
+	switch {
+	case errors.As(err, &limit):
+		return api_v1.WrapError(codes.InvalidArgument, api_v1.ReasonRecordTooLarge, err, map[string]string{
+			"size":     strconv.FormatUint(limit.Size, 10),
+			"max_size": strconv.FormatUint(limit.Max, 10),
+		}).Err()
+	case errors.Is(err, log.ErrRecordTooLarge):
+		return api_v1.WrapError(codes.InvalidArgument, api_v1.ReasonRecordTooLarge, err, nil).Err()
+	case errors.Is(err, log.ErrRecordSize):
+		return api_v1.WrapError(codes.InvalidArgument, api_v1.ReasonRecordSize, err, nil).Err()
+	}
+	return err
+}
+
+// appendFailed는 레코드를 쓰지 못한 err에 APPEND_FAILED ErrorInfo를 붙인다.
+// 이미 reason이 있는 에러는 그대로 두고, 컨텍스트 에러는 DeadlineExceeded나
+// Canceled 상태로 바꾼다.
+func appendFailed(ctx context.Context, err error, size int) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return api_v1.WrapError(codes.Unknown, api_v1.ReasonAppendFailed, err, map[string]string{
+		"subject":     subject(ctx),
+		"record_size": strconv.Itoa(size),
+	}).Err()
+}
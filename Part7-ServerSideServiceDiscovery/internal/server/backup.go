@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// OffsetAppender는 레코드를 다음 오프셋보다 뒤의 정한 오프셋에 쓰고 그 사이를
+// 비워 둔다. CommitLog가 구현하지 않으면 Restore는 오프셋이 빈틈없이 이어진
+// 백업만 복원한다.
+type OffsetAppender interface {
+	AppendAt(offset uint64, record *api_v1.Record) error
+}
+
+// Backup은 토픽의 로그를 가장 작은 오프셋부터 스트림을 연 때의 끝까지 보낸다.
+// 압축해 저장된 레코드는 풀지 않고 코덱과 함께 그대로 보낸다.
+// 읽을 수 없는 만료된 레코드와 압축으로 비어 있는 오프셋은 건너뛰므로 백업의
+// 오프셋에는 빈틈이 있을 수 있다. 클라이언트가 끊으면 거기서 멈춘다.
+func (s *grpcServer) Backup(req *api_v1.BackupRequest, stream api_v1.Log_BackupServer) error {
+	ctx := stream.Context()
+	clog, err := s.authorize(ctx, req.Topic, backupAction)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return err
+	}
+
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return err
+	}
+	end, err := startOffset(clog, &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST})
+	if err != nil {
+		return err
+	}
+	for off := lowest; off < end; off++ {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		record, err := s.read(ctx, clog, off)
+		switch err.(type) {
+		case nil:
+		case api_v1.ErrOffsetOutOfRange, api_v1.ErrRecordExpired:
+			continue
+		default:
+			return err
+		}
+		if err := stream.Send(&api_v1.BackupResponse{Record: record}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore는 Backup으로 받은 레코드를 빈 로그에 원래 오프셋 그대로 쓴다.
+// 레코드의 오프셋은 늘어나기만 하면 되고, 건너뛴 오프셋은 CommitLog가
+// OffsetAppender를 구현하면 비워 둔다. 로그가 비어 있지 않거나, 레코드의
+// 오프셋이 로그의 다음 오프셋보다 작거나, 비워 둘 수 없는 오프셋을 건너뛰면
+// RESTORE_CONFLICT로 실패한다. 레코드는 하나씩 온전히 쓰므로 클라이언트가
+// 중간에 끊어도 로그에는 그때까지 받은 레코드가 빠짐없이 남는다. 끝나거나
+// 끊기면 로그가 Sync를 구현할 때 디스크에 동기화한다.
+func (s *grpcServer) Restore(stream api_v1.Log_RestoreServer) error {
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return stream.SendAndClose(&api_v1.RestoreResponse{})
+	}
+	if err != nil {
+		return err
+	}
+	clog, err := s.authorize(ctx, req.Topic, restoreAction)
+	if err != nil {
+		return err
+	}
+	if err := s.Standby.check(); err != nil {
+		return err
+	}
+
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return err
+	}
+	next, err := startOffset(clog, &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST})
+	if err != nil {
+		return err
+	}
+	if next != lowest {
+		return restoreConflict("log is not empty", 0, next)
+	}
+	if syncer, ok := clog.(interface{ Sync() error }); ok {
+		defer syncer.Sync()
+	}
+
+	var restored uint64
+	for {
+		if req.Record == nil {
+			return status.Error(codes.InvalidArgument, "restore request has no record")
+		}
+		if err := checkCodec(req.Record); err != nil {
+			return err
+		}
+		switch {
+		case req.Record.Offset < next:
+			return restoreConflict(
+				fmt.Sprintf("record offset %d is before the next offset %d", req.Record.Offset, next),
+				restored, next,
+			)
+		case req.Record.Offset > next:
+			if err := s.restoreAfterGap(clog, req.Record, restored, next); err != nil {
+				return err
+			}
+			next = req.Record.Offset
+		default:
+			off, err := s.append(ctx, clog, req.Record, nil)
+			if err != nil {
+				return err
+			}
+			if off != next {
+				// 누가 그 사이에 썼거나 로그가 오프셋을 건너뛰었다.
+				return restoreConflict(
+					fmt.Sprintf("record %d was written at offset %d", next, off),
+					restored+1, off+1,
+				)
+			}
+		}
+		restored++
+		next++
+
+		req, err = stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&api_v1.RestoreResponse{Restored: restored})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// restoreAfterGap은 다음 오프셋 next와 record의 오프셋 사이를 비워 두고
+// record를 쓴다.
+func (s *grpcServer) restoreAfterGap(clog CommitLog, record *api_v1.Record, restored, next uint64) error {
+	appender, ok := clog.(OffsetAppender)
+	if !ok {
+		return restoreConflict(
+			fmt.Sprintf("log cannot skip from the next offset %d to record offset %d", next, record.Offset),
+			restored, next,
+		)
+	}
+	err := appender.AppendAt(record.Offset, record)
+	if errors.Is(err, log.ErrOffsetGap) || errors.Is(err, log.ErrOffsetConflict) ||
+		errors.As(err, &api_v1.ErrOffsetOutOfRange{}) {
+		// 샤드에 없는 오프셋이거나 누가 그 사이에 썼다.
+		return restoreConflict(err.Error(), restored, next)
+	}
+	if err != nil {
+		return rejectedRecord(err)
+	}
+	s.produced.Add(1)
+	return nil
+}
+
+// restoreConflict는 오프셋을 지키며 복원할 수 없을 때의 에러를 만든다. 메타데이터로
+// 그때까지 복원한 레코드 수와 로그의 다음 오프셋을 알린다.
+func restoreConflict(msg string, restored, next uint64) error {
+	return api_v1.NewStatus(
+		codes.FailedPrecondition,
+		msg,
+		api_v1.ReasonRestoreConflict,
+		map[string]string{
+			"restored":    strconv.FormatUint(restored, 10),
+			"next_offset": strconv.FormatUint(next, 10),
+		},
+	).Err()
+}
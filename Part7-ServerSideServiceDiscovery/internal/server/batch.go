@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBatchSize   = 100
+	defaultBatchWindow = 10 * time.Millisecond
+	// maxBatchSize는 batch_size를 얼마로 주든 한 배치에 담는 최대 레코드 수다.
+	maxBatchSize = 1000
+	// maxBatchBytes는 한 배치의 최대 크기로, 클라이언트가 기본으로 받는 4MiB보다
+	// 작게 잡는다.
+	maxBatchBytes = 1 << 20
+)
+
+// ConsumeStreamBatch는 ConsumeStream과 같이 레코드를 읽되 batch_size개가
+// 모이거나 배치의 첫 레코드를 받고 batch_window_ms가 지나면 RecordBatch로
+// 묶어 보낸다. 레코드가 드문드문 오면 작은 배치가 window마다 나간다. 스트림이
+// 끝나거나 컨텍스트가 취소되면 모여 있던 레코드를 마지막 배치로 보낸다.
+//
+// batch_size는 maxBatchSize까지만 쓴다. 레코드를 더하면 배치가 1MiB나
+// MaxSendMsgSize를 넘을 때도 먼저 보낸다. 그보다 큰 레코드는 혼자 보낸다.
+func (s *grpcServer) ConsumeStreamBatch(
+	req *api_v1.ConsumeRequest,
+	stream api_v1.Log_ConsumeStreamBatchServer,
+) error {
+	size := min(int(req.BatchSize), maxBatchSize)
+	if size == 0 {
+		size = defaultBatchSize
+	}
+	maxBytes := maxBatchBytes
+	if s.MaxSendMsgSize > 0 {
+		maxBytes = min(maxBytes, s.MaxSendMsgSize)
+	}
+	window := time.Duration(req.BatchWindowMs) * time.Millisecond
+	if window == 0 {
+		window = defaultBatchWindow
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	records := make(chan *api_v1.Record, defaultBatchSize)
+	done := make(chan error, 1)
+	go func() {
+		defer close(records)
+		done <- s.consume(ctx, req, func(res *api_v1.ConsumeResponse) error {
+			select {
+			case records <- res.Record:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	batch := &api_v1.RecordBatch{}
+	var batchBytes int
+	var timeout <-chan time.Time
+	flush := func() error {
+		timeout = nil
+		if len(batch.Records) == 0 {
+			return nil
+		}
+		err := stream.Send(batch)
+		batch, batchBytes = &api_v1.RecordBatch{}, 0
+		return err
+	}
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				err := <-done
+				// 컨텍스트가 취소됐어도 모인 레코드는 보내 본다. 이미 끊긴
+				// 스트림이면 보내지 못한 것은 에러로 보지 않는다.
+				if ferr := flush(); err == nil && ctx.Err() == nil {
+					err = ferr
+				}
+				return err
+			}
+			// RecordBatch에서 레코드 하나는 태그와 길이를 더해 차지한다.
+			n := protowire.SizeBytes(proto.Size(record)) + 1
+			if batchBytes+n > maxBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			batch.Records = append(batch.Records, record)
+			batchBytes += n
+			if len(batch.Records) == 1 {
+				timeout = time.After(window)
+			}
+			if len(batch.Records) < size {
+				continue
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-timeout:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
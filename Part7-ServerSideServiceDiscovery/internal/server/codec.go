@@ -0,0 +1,68 @@
+package server
+
+import (
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/compress"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordCodecs는 Record.Codec을 compress 레지스트리의 코덱 이름으로 바꾼다.
+var recordCodecs = map[api_v1.Codec]string{
+	api_v1.Codec_GZIP: compress.Gzip,
+	api_v1.Codec_ZSTD: compress.Zstd,
+}
+
+// checkCodec은 record의 코덱을 서버가 아는지 확인한다.
+func checkCodec(record *api_v1.Record) error {
+	if record.Codec == api_v1.Codec_NONE {
+		return nil
+	}
+	if _, ok := recordCodecs[record.Codec]; !ok {
+		return status.Errorf(codes.InvalidArgument, "%v: record codec %v", compress.ErrUnknownCodec, record.Codec)
+	}
+	return nil
+}
+
+// compressRecord는 record.Codec으로 값을 압축해 바꿔 넣는다. 코덱은 그대로
+// 두어 레코드와 함께 저장되게 한다.
+func compressRecord(record *api_v1.Record) error {
+	if err := checkCodec(record); err != nil || record.Codec == api_v1.Codec_NONE {
+		return err
+	}
+	comp, _, err := compress.Lookup(recordCodecs[record.Codec])
+	if err != nil {
+		return err
+	}
+	if record.Value, err = comp.Compress(record.Value); err != nil {
+		return status.Errorf(codes.Internal, "compress record with %v: %v", record.Codec, err)
+	}
+	return nil
+}
+
+// decompressRecord는 압축해 저장된 레코드의 값을 풀고 코덱을 NONE으로 바꾼
+// 복사본을 리턴한다. read가 돌려준 레코드는 다른 요청과 나눠 가지므로 고치지
+// 않는다. 압축하지 않은 레코드는 그대로 리턴한다.
+func decompressRecord(record *api_v1.Record) (*api_v1.Record, error) {
+	if record.Codec == api_v1.Codec_NONE {
+		return record, nil
+	}
+	name, ok := recordCodecs[record.Codec]
+	if !ok {
+		return nil, status.Errorf(codes.DataLoss, "record %d: %v: record codec %v",
+			record.Offset, compress.ErrUnknownCodec, record.Codec)
+	}
+	_, decomp, err := compress.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decomp.Decompress(record.Value)
+	if err != nil {
+		return nil, status.Errorf(codes.DataLoss, "record %d: decompress %v value: %v", record.Offset, record.Codec, err)
+	}
+	out := proto.Clone(record).(*api_v1.Record)
+	out.Value = value
+	out.Codec = api_v1.Codec_NONE
+	return out, nil
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// NewHTTPGateway는 grpcAddr의 Log 서비스로 요청을 넘기는 REST 게이트웨이를
+// 만든다. POST /v1/records는 Produce, GET /v1/records/{offset}은 Consume으로
+// 가고 응답은 JSON이다. opts가 없으면 TLS 없이 접속하므로 mTLS를 쓰는 서버에는
+// 클라이언트 인증서를 담은 grpc.WithTransportCredentials를 넘긴다. 리턴한
+// 서버의 Addr를 정해 띄우면 되고, Shutdown하면 gRPC 연결도 닫는다.
+func NewHTTPGateway(grpcAddr string, opts ...grpc.DialOption) (*http.Server, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	conn, err := grpc.NewClient(grpcAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	mux := runtime.NewServeMux()
+	if err := api_v1.RegisterLogHandler(context.Background(), mux, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	srv := &http.Server{Handler: mux}
+	srv.RegisterOnShutdown(func() { conn.Close() })
+	return srv, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Prober는 CommitLog를 쓸 수 있는지 가볍게 확인한다. CommitLog가 구현하지
+// 않으면 헬스 체크는 HighestOffset이 에러 없이 돌아오는지 본다.
+type Prober interface {
+	Probe() error
+}
+
+// registerHealth는 표준 헬스 체크 서비스를 등록한다. Log 서비스는 CaughtUp이
+// 닫혀 있고 끝나지 않은 쓰기가 없고 CommitLog의 프로브가 성공할 때
+// SERVING이다. ShuttingDown이 닫히면 모든 서비스가 NOT_SERVING이 되고 다시
+// 바뀌지 않는다.
+func (s *grpcServer) registerHealth(gsrv *grpc.Server) {
+	s.health = health.NewServer()
+	healthpb.RegisterHealthServer(gsrv, &healthServer{Server: s.health, srv: s})
+	s.updateHealth()
+	if s.CaughtUp != nil {
+		go func() {
+			<-s.CaughtUp
+			s.updateHealth()
+		}()
+	}
+	if s.ShuttingDown != nil {
+		go func() {
+			<-s.ShuttingDown
+			s.health.Shutdown()
+		}()
+	}
+}
+
+// healthServer는 Check를 받을 때마다 CommitLog를 프로브해서 상태를 고친다.
+// 프로브를 돌리는 고루틴을 따로 두지 않아도 된다.
+type healthServer struct {
+	*health.Server
+	srv *grpcServer
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	h.srv.updateHealth()
+	return h.Server.Check(ctx, req)
+}
+
+// probe는 CommitLog를 쓸 수 있는지 확인한다. 테넌트마다 로그를 고르면
+// CommitLog가 없을 수 있고 그때는 확인할 것이 없다.
+func (s *grpcServer) probe() error {
+	if s.CommitLog == nil {
+		return nil
+	}
+	if p, ok := s.CommitLog.(Prober); ok {
+		return p.Probe()
+	}
+	_, err := s.CommitLog.HighestOffset()
+	return err
+}
+
+// updateHealth는 서버 상태에 맞춰 Log 서비스의 헬스 상태를 바꾼다.
+func (s *grpcServer) updateHealth() {
+	if s.health == nil {
+		return
+	}
+	st := healthpb.HealthCheckResponse_SERVING
+	if s.checkCaughtUp() != nil || s.stuckAppends.Load() > 0 || s.probe() != nil {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	s.health.SetServingStatus(api_v1.Log_ServiceDesc.ServiceName, st)
+}
+
+// checkCaughtUp은 아직 따라잡는 중이면 읽기를 거부한다.
+func (s *grpcServer) checkCaughtUp() error {
+	if s.CaughtUp == nil {
+		return nil
+	}
+	select {
+	case <-s.CaughtUp:
+		return nil
+	default:
+		return status.Error(codes.Unavailable, "catching up with the cluster")
+	}
+}
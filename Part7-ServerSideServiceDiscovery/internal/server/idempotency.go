@@ -0,0 +1,178 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultIdempotencyTTL       = 10 * time.Minute
+	defaultIdempotencyCacheSize = 10000
+)
+
+// dedupeCache는 멱등 키마다 그 키로 처음 쓴 레코드의 오프셋을 기억한다. 키는
+// 넣은 순서대로 order에 있고 모두 같은 ttl을 가지므로, 앞에서부터 ttl이 지난
+// 키와 size를 넘는 키를 잊는다. AppendTimeout으로 결과를 모른 채 끝난 키는
+// 쓰기가 끝날 때까지 보류로 기억해서 그 사이에 다시 보낸 요청이 한 번 더
+// 쓰지 않게 한다.
+type dedupeCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	offsets map[string]*list.Element
+	order   *list.List
+	// writes는 같은 키로 동시에 온 요청들이 한 번만 쓰게 한다.
+	writes singleflight.Group
+}
+
+type dedupeEntry struct {
+	key     string
+	offset  uint64
+	expires time.Time
+	// pending이면 이 키로 쓴 레코드가 쓰였는지 아직 모른다.
+	pending bool
+}
+
+func newDedupeCache(ttl time.Duration, size int) *dedupeCache {
+	return &dedupeCache{
+		ttl:     ttl,
+		size:    size,
+		offsets: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// do는 key로 쓴 오프셋이 있으면 그 오프셋을, 없으면 write를 불러 쓴 오프셋을
+// 기억하고 리턴한다. write가 실패하면 기억하지 않으므로 다시 보내면 다시 쓴다.
+// write가 결과를 모른 채 리턴하면서 넘겨받은 pendingWrite의 begin을 불렀으면
+// 그 쓰기가 끝날 때까지 같은 키로 온 요청을 Unavailable로 거부하고, 끝나면
+// 쓰인 오프셋을 기억한다.
+func (c *dedupeCache) do(key string, write func(*pendingWrite) (uint64, error)) (uint64, error) {
+	if off, ok, err := c.lookup(key, time.Now()); ok {
+		return off, err
+	}
+	v, err, _ := c.writes.Do(key, func() (any, error) {
+		// 앞서 같은 키로 온 요청이 방금 썼을 수 있다.
+		if off, ok, err := c.lookup(key, time.Now()); ok {
+			return off, err
+		}
+		off, err := write(&pendingWrite{cache: c, key: key})
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, off, time.Now())
+		return off, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(uint64), nil
+}
+
+// lookup은 key를 기억하고 있으면 ok가 true다. 보류 중인 키면 err가 있다.
+func (c *dedupeCache) lookup(key string, now time.Time) (off uint64, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now)
+	e, ok := c.offsets[key]
+	if !ok {
+		return 0, false, nil
+	}
+	if entry := e.Value.(*dedupeEntry); !entry.pending {
+		return entry.offset, true, nil
+	}
+	return 0, true, api_v1.NewStatus(
+		codes.Unavailable,
+		"an earlier append with this idempotency key has not finished",
+		api_v1.ReasonAppendPending,
+		nil,
+	).Err()
+}
+
+func (c *dedupeCache) store(key string, off uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.push(&dedupeEntry{key: key, offset: off, expires: now.Add(c.ttl)})
+}
+
+// push는 entry를 가장 최근 키로 넣는다. mu를 잡고 불러야 한다.
+func (c *dedupeCache) push(entry *dedupeEntry) *list.Element {
+	if e, ok := c.offsets[entry.key]; ok {
+		c.order.Remove(e)
+	}
+	e := c.order.PushBack(entry)
+	c.offsets[entry.key] = e
+	for c.order.Len() > c.size {
+		c.remove(c.order.Front())
+	}
+	return e
+}
+
+// pendingWrite는 do가 write에 넘기는 쓰기 하나다. 결과를 모른 채 리턴하기
+// 전에 begin을, 그 쓰기가 끝나면 end를 부른다. nil이면 아무것도 하지 않는다.
+type pendingWrite struct {
+	cache *dedupeCache
+	key   string
+	// e는 begin이 넣은 보류 항목이다. 그 사이에 잊었거나 다른 항목으로
+	// 바뀌었으면 end는 아무것도 하지 않는다.
+	e *list.Element
+}
+
+func (w *pendingWrite) begin() {
+	if w == nil {
+		return
+	}
+	c := w.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.e = c.push(&dedupeEntry{key: w.key, expires: time.Now().Add(c.ttl), pending: true})
+}
+
+// end는 보류했던 키를 쓰기에 성공했으면 off로 기억하고, 실패했으면 잊어서
+// 다시 보내면 다시 쓰게 한다.
+func (w *pendingWrite) end(off uint64, err error) {
+	if w == nil {
+		return
+	}
+	c := w.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.e == nil || c.offsets[w.key] != w.e {
+		return
+	}
+	if err != nil {
+		c.remove(w.e)
+		return
+	}
+	c.push(&dedupeEntry{key: w.key, offset: off, expires: time.Now().Add(c.ttl)})
+}
+
+// expire는 ttl이 지난 키를 잊는다. mu를 잡고 불러야 한다.
+func (c *dedupeCache) expire(now time.Time) {
+	for e := c.order.Front(); e != nil && !now.Before(e.Value.(*dedupeEntry).expires); e = c.order.Front() {
+		c.remove(e)
+	}
+}
+
+func (c *dedupeCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.offsets, e.Value.(*dedupeEntry).key)
+}
+
+// dedupeKey는 req의 멱등 키를 테넌트, 토픽, 구독자와 묶는다. 다른 구독자나
+// 다른 토픽이 같은 키를 써도 서로의 오프셋을 받지 않는다.
+func dedupeKey(ctx context.Context, req *api_v1.ProduceRequest) string {
+	topic := req.Topic
+	if topic == "" {
+		topic = defaultTopic
+	}
+	return strings.Join([]string{tenant(ctx), topic, subject(ctx), req.IdempotencyKey}, "\x00")
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// inProcessBufSize는 인프로세스 연결의 버퍼 크기다.
+const inProcessBufSize = 1 << 20
+
+// NewInProcessClient는 NewGRPCServer로 만든 서버를 메모리 안의 bufconn
+// 리스너에 띄우고 거기에 붙은 클라이언트를 리턴한다. 포트를 열거나 인증서를
+// 만들지 않아도 되므로 테스트나 로그를 품는 도구에서 쓴다. 연결에 TLS가 없어
+// 구독자는 빈 문자열이므로 Authorizer가 이를 허가해야 하고, 구독자를 정하려면
+// JWTPublicKey를 두고 Bearer 토큰을 보낸다. 리턴한 함수는 연결과 서버를 닫는다.
+// config.CommitLog는 닫지 않는다.
+func NewInProcessClient(config *Config, grpcOpts ...grpc.ServerOption) (api_v1.LogClient, func(), error) {
+	gsrv, err := NewGRPCServer(config, grpcOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	l := bufconn.Listen(inProcessBufSize)
+	go gsrv.Serve(l)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		gsrv.Stop()
+		return nil, nil, err
+	}
+	return api_v1.NewLogClient(conn), func() {
+		conn.Close()
+		gsrv.Stop()
+	}, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// defaultJWTSubjectClaim은 JWTSubjectClaim이 비어 있을 때 구독자로 읽는 클레임이다.
+const defaultJWTSubjectClaim = "sub"
+
+// jwtMethods는 key로 검증할 수 있는 서명 알고리즘이다. 키와 다른 종류의
+// 알고리즘으로 서명한 토큰은 받지 않는다.
+func jwtMethods(key crypto.PublicKey) ([]string, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}, nil
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}, nil
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}, nil
+	}
+	return nil, fmt.Errorf("unsupported JWT public key type %T", key)
+}
+
+// bearerSubject는 authorization 메타데이터에 Bearer 토큰이 있으면 서명과
+// 유효 기간을 검증하고 JWTSubjectClaim 클레임을 구독자로 리턴한다. 토큰이
+// 없으면 ok가 false다. 검증에 실패하면 Unauthenticated를 리턴한다.
+func (s *grpcServer) bearerSubject(ctx context.Context) (subject string, ok bool, err error) {
+	if len(metadata.ValueFromIncomingContext(ctx, "authorization")) == 0 {
+		return "", false, nil
+	}
+	if s.JWTPublicKey == nil {
+		return "", true, status.Error(codes.Unauthenticated, "bearer tokens are not accepted")
+	}
+	raw, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		return "", true, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (any, error) {
+		return s.JWTPublicKey, nil
+	}, jwt.WithValidMethods(s.jwtMethods))
+	if err != nil {
+		return "", true, status.Errorf(codes.Unauthenticated, "invalid bearer token: %v", err)
+	}
+	subject, _ = claims[s.JWTSubjectClaim].(string)
+	if subject == "" {
+		return "", true, status.Errorf(codes.Unauthenticated, "bearer token has no %q claim", s.JWTSubjectClaim)
+	}
+	return subject, true, nil
+}
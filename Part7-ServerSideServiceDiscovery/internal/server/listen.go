@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var (
+	ErrSocketInUse = errors.New("unix socket is in use by another server")
+	ErrNotSocket   = errors.New("file exists and is not a unix socket")
+)
+
+// staleSocketTimeout은 남은 소켓 파일에 아직 서버가 있는지 확인하려고 붙어
+// 보는 시간이다.
+const staleSocketTimeout = time.Second
+
+// Listen은 NewGRPCServer로 만든 서버의 Serve에 넘길 리스너를 연다. network는
+// net.Listen과 같이 "tcp"나 "unix"다.
+//
+// 같은 호스트의 사이드카와 이야기할 때는 "unix"로 소켓 파일 경로를 주면 포트를
+// 열지 않아도 된다. 서버가 죽어서 소켓 파일이 남아 있으면 바인드가 실패하므로,
+// Listen은 그 경로에 붙어 보고 아무도 받지 않는 소켓 파일이면 지운 뒤 연다.
+// 다른 서버가 받고 있으면 ErrSocketInUse를, 소켓이 아닌 파일이면 ErrNotSocket을
+// 리턴하고 아무것도 지우지 않는다. 리스너를 닫으면 소켓 파일도 지워진다.
+//
+// 소켓 파일의 권한만 믿지 않도록 유닉스 소켓에서도 grpc.Creds로 mTLS를 그대로
+// 쓴다. 클라이언트는 "unix:///경로"로 붙고, 이때 TLS가 확인하는 서버 이름은
+// localhost이므로 서버 인증서에 localhost가 있어야 한다.
+func Listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// removeStaleSocket은 path에 받는 서버가 없는 소켓 파일이 남아 있으면 지운다.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%w: %s", ErrNotSocket, path)
+	}
+	conn, err := net.DialTimeout("unix", path, staleSocketTimeout)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%w: %s", ErrSocketInUse, path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/stats"
+)
+
+// defaultUnobservedMethods는 헬스 체크와 리플렉션처럼 자주 불리지만 실제
+// 트래픽이 아닌 서비스의 메서드 접두사다.
+var defaultUnobservedMethods = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.",
+}
+
+// filteredStatsHandler는 접두사가 excluded에 있는 메서드의 RPC를 Handler에
+// 넘기지 않는다. 그런 RPC는 OpenCensus 뷰에 집계되지 않고 스팬도 만들지 않는다.
+type filteredStatsHandler struct {
+	stats.Handler
+	excluded []string
+}
+
+type unobservedContextKey struct{}
+
+func (h *filteredStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	for _, prefix := range h.excluded {
+		if strings.HasPrefix(info.FullMethodName, prefix) {
+			return context.WithValue(ctx, unobservedContextKey{}, true)
+		}
+	}
+	return h.Handler.TagRPC(ctx, info)
+}
+
+func (h *filteredStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if ctx.Value(unobservedContextKey{}) != nil {
+		return
+	}
+	h.Handler.HandleRPC(ctx, s)
+}
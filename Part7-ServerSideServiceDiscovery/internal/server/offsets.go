@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// OffsetCommitter는 컨슈머 그룹이 커밋한 오프셋을 저장하고 읽는다. 커밋한
+// 오프셋은 CommitLog를 다시 열어도 남아야 한다. 커밋한 적 없는 그룹이면
+// FetchOffset의 ok가 false다. CommitLog가 구현하지 않으면 CommitOffset과
+// FetchOffset은 Unimplemented로 실패한다.
+type OffsetCommitter interface {
+	CommitOffset(group string, offset uint64) error
+	FetchOffset(group string) (offset uint64, ok bool, err error)
+}
+
+func (s *grpcServer) CommitOffset(ctx context.Context, req *api_v1.CommitOffsetRequest) (*api_v1.CommitOffsetResponse, error) {
+	committer, err := s.offsetCommitter(ctx, req.Topic, req.Group)
+	if err != nil {
+		return nil, err
+	}
+	if err := committer.CommitOffset(req.Group, req.Offset); err != nil {
+		return nil, err
+	}
+	return &api_v1.CommitOffsetResponse{}, nil
+}
+
+func (s *grpcServer) FetchOffset(ctx context.Context, req *api_v1.FetchOffsetRequest) (*api_v1.FetchOffsetResponse, error) {
+	committer, err := s.offsetCommitter(ctx, req.Topic, req.Group)
+	if err != nil {
+		return nil, err
+	}
+	off, ok, err := committer.FetchOffset(req.Group)
+	if err != nil {
+		return nil, err
+	}
+	return &api_v1.FetchOffsetResponse{Offset: off, Found: ok}, nil
+}
+
+// offsetCommitter는 offset 권한을 확인하고 topic의 OffsetCommitter를 리턴한다.
+func (s *grpcServer) offsetCommitter(ctx context.Context, topic, group string) (OffsetCommitter, error) {
+	clog, err := s.authorize(ctx, topic, offsetAction)
+	if err != nil {
+		return nil, err
+	}
+	if group == "" {
+		return nil, status.Error(codes.InvalidArgument, "consumer group is empty")
+	}
+	committer, ok := clog.(OffsetCommitter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "log does not support consumer group offsets")
+	}
+	return committer, nil
+}
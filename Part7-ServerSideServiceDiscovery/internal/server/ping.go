@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+)
+
+// unauthenticatedMethods는 authenticate를 거치지 않는 Log 서비스 메서드다.
+// 로그를 읽거나 쓰지 않는 메서드만 넣는다.
+var unauthenticatedMethods = []string{
+	api_v1.Log_Ping_FullMethodName,
+}
+
+// AuthFuncOverride는 grpc_auth가 Log 서비스의 메서드마다 authenticate 대신
+// 부른다. unauthenticatedMethods에 있는 메서드는 인증하지 않는다.
+func (s *grpcServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	if slices.Contains(unauthenticatedMethods, fullMethodName) {
+		return ctx, nil
+	}
+	return s.authenticate(ctx)
+}
+
+// Ping은 서버 시각을 돌려준다. 인증과 권한 확인 없이 부를 수 있어서 오래 열어
+// 두는 스트림의 클라이언트가 레코드 사이에 서버가 살아 있는지 확인하거나
+// 왕복 시간을 잴 때 쓴다.
+func (s *grpcServer) Ping(ctx context.Context, req *api_v1.PingRequest) (*api_v1.PingResponse, error) {
+	return &api_v1.PingResponse{ServerTime: time.Now().UnixNano()}, nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// healthMethodPrefix로 시작하는 헬스 체크는 로드 밸런서가 늘 받아야 하므로
+// 요청 수를 제한하지 않는다.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// rateLimiter는 구독자마다 토큰 버킷을 하나씩 두고 요청을 센다. 구독자는
+// CA가 서명한 인증서의 CommonName이라 버킷 수는 발급한 인증서 수를 넘지 않는다.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newRateLimiter(limit float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:    rate.Limit(limit),
+		burst:    max(burst, 1),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// allow는 subject의 버킷에서 토큰을 하나 꺼낸다. 없으면 ResourceExhausted를 리턴한다.
+func (r *rateLimiter) allow(subject string) error {
+	r.mu.Lock()
+	l, ok := r.limiters[subject]
+	if !ok {
+		l = rate.NewLimiter(r.limit, r.burst)
+		r.limiters[subject] = l
+	}
+	r.mu.Unlock()
+	if !l.Allow() {
+		return status.Errorf(codes.ResourceExhausted, "rate limit exceeded for subject %q", subject)
+	}
+	return nil
+}
+
+// limit는 method 요청을 제한해야 하면 ctx의 구독자 버킷에서 토큰을 꺼낸다.
+// 인증보다 먼저 불리므로 구독자는 인증서에서 직접 읽는다.
+func (s *grpcServer) limit(ctx context.Context, method string) error {
+	if s.limiter == nil || strings.HasPrefix(method, healthMethodPrefix) {
+		return nil
+	}
+	return s.limiter.allow(peerCommonName(ctx))
+}
+
+func (s *grpcServer) rateLimitUnaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if err := s.limit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// rateLimitStreamInterceptor는 스트림을 열 때 한 번만 센다. 스트림으로 오가는
+// 메시지는 세지 않는다.
+func (s *grpcServer) rateLimitStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := s.limit(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// peerCommonName은 클라이언트 인증서의 CommonName을 리턴한다. TLS가 아니면
+// 빈 문자열이라 인증서 없는 클라이언트는 버킷 하나를 나눠 쓴다.
+func peerCommonName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return ""
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+}
@@ -2,31 +2,52 @@ package server
 
 import (
 	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
 
 	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opencensus.io/metric/metricproducer"
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/sync/singleflight"
 
+	"slices"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
 
 const (
-	objectWildcard = "*"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+	truncateAction = "truncate"
+	backupAction   = "backup"
+	restoreAction  = "restore"
+	offsetAction   = "offset"
+	statsAction    = "stats"
+	defaultTopic   = "default"
 )
 
 var _ api_v1.LogServer = (*grpcServer)(nil)
@@ -34,15 +55,169 @@ var _ api_v1.LogServer = (*grpcServer)(nil)
 type Config struct {
 	CommitLog
 	Authorizer Authorizer
+	// ExposeSegmentInfo가 켜져 있으면 Produce 응답에 레코드가 들어간
+	// 세그먼트의 베이스 오프셋과 파일 이름을 담는다. CommitLog가
+	// SegmentInfoer를 구현해야 한다.
+	ExposeSegmentInfo bool
+	// RetryableCodes에 들어 있는 코드의 에러가 나면 ConsumeStream은 스트림을
+	// 끝내지 않고 ConsumeRetryBackoff만큼 기다렸다가 다시 읽는다. 기다리는
+	// 시간은 실패할 때마다 두 배로 늘어나고 최대 1초다.
+	// 비어 있으면 codes.Unavailable만 다시 시도한다.
+	RetryableCodes      []codes.Code
+	ConsumeRetryBackoff time.Duration
+	// TenantLog가 있으면 클라이언트 인증서의 OU를 테넌트로 보고 요청마다
+	// 테넌트와 토픽에 해당하는 로그를 고른다. ACL 객체도 "<tenant>/<topic>"이
+	// 되므로 클라이언트는 다른 테넌트의 로그를 가리킬 수 없다.
+	// 없으면 모든 요청이 CommitLog를 쓴다.
+	TenantLog func(tenant, topic string) (CommitLog, error)
+	// APIVersions는 서버가 받아들이는 x-api-version 헤더 값이다.
+	// 비어 있으면 api_v1.APIVersion만 받는다.
+	APIVersions []string
+	// UnobservedMethods에 있는 접두사로 시작하는 메서드는 RPC 지표와
+	// 트레이스에서 뺀다. nil이면 헬스 체크와 리플렉션을 뺀다.
+	UnobservedMethods []string
+	// Validators는 Produce가 레코드를 쓰기 전에 차례로 부른다. 에러를 리턴하면
+	// 쓰지 않고 InvalidArgument로 거부한다.
+	Validators []Validator
+	// MaxStreamsPerSubject가 0보다 크면 구독자 하나가 동시에 열 수 있는
+	// ConsumeStream 수를 제한한다. 넘으면 ResourceExhausted로 거부한다.
+	MaxStreamsPerSubject int
+	// AllowedSubjects가 있으면 구독자가 목록에 있는 클라이언트만 받고 나머지는
+	// ACL을 보기 전에 PermissionDenied로 거부한다. 구독자는 인증서의
+	// CommonName이거나 Bearer 토큰의 클레임이다. 비어 있으면 CA가 서명한
+	// 인증서는 모두 받는다.
+	AllowedSubjects []string
+	// SubjectMapper가 있으면 클라이언트 인증서의 CommonName 대신 이 함수가
+	// 리턴한 값을 구독자로 쓴다. OU나 DNS SAN을 역할로 바꾸면 ACL 정책과
+	// AllowedSubjects에 인증서마다 이름을 적지 않고 역할만 적으면 된다.
+	// RolesByOU, RolesByDNSName, RolesByCommonName으로 만들 수 있다. 테넌트는
+	// 그대로 인증서의 첫 번째 OU다.
+	SubjectMapper SubjectMapper
+	// CaughtUp이 있으면 닫힐 때까지 Consume과 ConsumeStream을 Unavailable로
+	// 거부하고 헬스 체크에 Log 서비스가 NOT_SERVING이라고 알린다. 새로 들어온
+	// 복제본이 다른 서버를 따라잡기 전에 덜 찬 로그를 보여주지 않게 한다.
+	// 보통 Replicator.CaughtUp을 넘긴다. 쓰기는 막지 않는다.
+	CaughtUp <-chan struct{}
+	// AppendTimeout이 0보다 크면 Produce가 로그에 쓰기를 그만큼만 기다리고
+	// 넘기면 DeadlineExceeded를 리턴한다. 자세한 동작은 append에 있다.
+	AppendTimeout time.Duration
+	// Standby가 있으면 승격하기 전까지 Produce를 FailedPrecondition으로
+	// 거부한다. 레코드는 Standby.Replicator로만 받는다.
+	Standby *Standby
+	// ShuttingDown이 닫히면 헬스 체크가 모든 서비스를 NOT_SERVING으로 알린다.
+	// 서버를 멈추기 전에 닫아서 로드 밸런서가 새 요청을 보내지 않게 한다.
+	ShuttingDown <-chan struct{}
+	// RateLimit이 0보다 크면 구독자마다 초당 RateLimit개, 한꺼번에
+	// RateBurst개(최소 1개)까지 요청을 받고 넘으면 ResourceExhausted로
+	// 거부한다. 스트림은 열 때 한 번 센다. 헬스 체크는 세지 않는다.
+	RateLimit float64
+	RateBurst int
+	// Registry가 있으면 RPC 지표와 log_append_bytes_total 같은 로그 지표를
+	// Prometheus로 등록한다. UnobservedMethods는 RPC 지표에서도 빠진다.
+	// 지표를 내보내는 HTTP 서버는 호출자가 promhttp.HandlerFor로 띄운다.
+	Registry *prometheus.Registry
+	// TracerProvider로 RPC마다 서버 스팬을, 그 아래에 로그를 쓰고 읽을 때마다
+	// log.Append와 log.Read 스팬을 만든다. 요청 메타데이터에 W3C traceparent가
+	// 있으면 호출자의 트레이스를 잇는다. 없으면 otel.GetTracerProvider()를 쓰므로
+	// 전역 프로바이더를 설정하지 않았으면 아무것도 남기지 않는다.
+	// UnobservedMethods는 스팬을 만들지 않는다.
+	TracerProvider oteltrace.TracerProvider
+	// JWTPublicKey가 있으면 authorization 메타데이터에 "Bearer <jwt>"를 보낸
+	// 클라이언트는 인증서 대신 토큰으로 인증한다. 토큰의 서명을 이 키로
+	// 검증하고 JWTSubjectClaim 클레임(기본값 "sub")을 구독자로 쓴다. 서명이
+	// 틀리거나 만료된 토큰은 Unauthenticated로 거부한다. 토큰이 없으면 전처럼
+	// 클라이언트 인증서의 CommonName을 쓴다. 인증서 없이 토큰만 보내는
+	// 클라이언트를 받으려면 서버 TLS 설정에 config.TLSConfig.ClientCertOptional을
+	// 켠다. *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey를 받는다.
+	JWTPublicKey    crypto.PublicKey
+	JWTSubjectClaim string
+	// IdempotencyTTL과 IdempotencyCacheSize는 Produce의 idempotency_key로 받은
+	// 오프셋을 기억하는 기간과 키 수다. 그 안에 같은 구독자가 같은 토픽에 같은
+	// 키로 다시 보내면 쓰지 않고 처음 받은 오프셋을 돌려준다. 0이면 10분,
+	// 10000개다.
+	IdempotencyTTL       time.Duration
+	IdempotencyCacheSize int
+	// MaxRecvMsgSize와 MaxSendMsgSize는 서버가 받고 보내는 메시지의 최대
+	// 크기다. 0이면 gRPC 기본값인 받기 4MiB, 보내기 math.MaxInt32를 쓴다. 4MiB보다
+	// 큰 레코드를 받으려면 MaxRecvMsgSize를 늘리고, 그런 레코드를 읽는
+	// 클라이언트도 grpc.MaxCallRecvMsgSize를 늘려야 한다.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	// KeepaliveEnforcement는 클라이언트의 keepalive 핑을 얼마나 자주 받을지다.
+	// 더 자주 핑하는 클라이언트는 연결이 끊긴다. 0인 필드는 gRPC 기본값을 쓰므로
+	// 핑은 5분에 한 번까지 받고 스트림이 없을 때의 핑은 거부한다.
+	KeepaliveEnforcement keepalive.EnforcementPolicy
+	// KeepaliveParams는 서버 쪽 keepalive다. 0인 필드는 gRPC 기본값을 쓰므로
+	// 유휴 연결도 닫지 않고 2시간마다 핑하고 20초 기다린다. MaxConnectionIdle을
+	// 두면 오래 쉬는 연결을 닫는다.
+	KeepaliveParams keepalive.ServerParameters
+	// EnableReflection이면 서버 리플렉션 서비스를 등록해서 grpcurl 같은 도구가
+	// proto 파일 없이 서비스와 메서드를 볼 수 있다. 운영에서는 끈다.
+	EnableReflection bool
 }
 
+type Validator func(*api_v1.Record) error
+
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
+type SegmentInfoer interface {
+	SegmentInfo(off uint64) (baseOffset uint64, name string, err error)
+}
+
+// Notifier는 레코드가 추가되면 닫히는 채널을 준다. CommitLog가 구현하면
+// ConsumeStream이 로그 끝에서 새 레코드를 기다릴 때 이 채널로 깨어나고,
+// 구현하지 않으면 defaultFollowInterval마다 다시 읽어 본다.
+type Notifier interface {
+	HighWatermarkChanged() <-chan struct{}
+}
+
+// DryRunner는 레코드를 쓰지 않고 검사만 해서 그 레코드가 받을 오프셋을 리턴한다.
+// CommitLog가 구현하지 않으면 Produce의 DryRun은 로그 끝 오프셋을 돌려준다.
+type DryRunner interface {
+	DryRun(*api_v1.Record) (uint64, error)
+}
+
+// TimeSeeker는 Timestamp가 since 이상인 첫 레코드의 오프셋을 찾는다. 모든
+// 레코드가 since보다 앞서면 ErrOffsetOutOfRange를 리턴한다. CommitLog가
+// 구현하지 않으면 ConsumeSince는 Unimplemented로 실패한다.
+type TimeSeeker interface {
+	OffsetForTime(since int64) (uint64, error)
+}
+
+// KeyFinder는 Key가 key인 가장 최근 레코드의 오프셋을 찾는다. 없으면
+// log.ErrKeyNotFound를 리턴한다. CommitLog가 구현하지 않으면 ConsumeByKey는
+// Unimplemented로 실패한다.
+type KeyFinder interface {
+	OffsetForKey(key []byte) (uint64, error)
+}
+
+// Flusher는 CommitLog의 버퍼를 디스크에 내린다. CommitLog가 구현하면
+// Server.Shutdown이 로그를 닫기 전에 부른다.
+type Flusher interface {
+	Flush() error
+}
+
+// ContextLog는 ctx가 끝나면 기다리지 않고 리턴하는 Read다. CommitLog가
+// 구현하면 핸들러가 요청의 컨텍스트를 넘겨서, 느린 디스크가 읽기를 기한 넘어
+// 붙잡지 않는다.
+type ContextLog interface {
+	ReadContext(context.Context, uint64) (*api_v1.Record, error)
+}
+
 type CommitLog interface {
 	Append(*api_v1.Record) (uint64, error)
+	// AppendContext는 Append와 같지만 ctx가 먼저 끝나면 기다리지 않고
+	// ctx.Err()를 리턴한다. 핸들러는 요청의 컨텍스트로 이것을 불러서 느린
+	// 디스크가 Produce를 기한 넘어 붙잡지 않는다. 시작한 쓰기를 멈출 수
+	// 없으면 ctx.Err()를 리턴한 뒤에 레코드가 쓰일 수 있다.
+	AppendContext(context.Context, *api_v1.Record) (uint64, error)
 	Read(uint64) (*api_v1.Record, error)
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+	// Truncate는 레코드가 모두 lowest 이하인 세그먼트를 지운다.
+	Truncate(lowest uint64) error
 }
 
 var _ api_v1.LogServer = (*grpcServer)(nil)
@@ -50,51 +225,538 @@ var _ api_v1.LogServer = (*grpcServer)(nil)
 type grpcServer struct {
 	api_v1.UnimplementedLogServer
 	*Config
+	streams *streamRegistry
+	// reads는 같은 로그의 같은 오프셋을 동시에 읽는 요청들을 한 번의 읽기로 묶는다.
+	reads singleflight.Group
+	// dedupe는 idempotency_key로 받은 오프셋이다.
+	dedupe *dedupeCache
+
+	health *health.Server
+	// stuckAppends는 AppendTimeout을 넘기고도 아직 끝나지 않은 쓰기 수다.
+	stuckAppends atomic.Int64
+	// limiter는 RateLimit이 있을 때만 있다.
+	limiter *rateLimiter
+	// prom은 Registry가 있을 때만 있다.
+	prom   *promMetrics
+	tracer oteltrace.Tracer
+	// jwtMethods는 JWTPublicKey로 검증할 수 있는 서명 알고리즘이다.
+	jwtMethods []string
+
+	// started는 서버를 만든 시각이고, produced와 consumed는 그 뒤로 모든
+	// 토픽에 쓰고 읽어 보낸 레코드 수다. Stats가 돌려준다.
+	started            time.Time
+	produced, consumed atomic.Uint64
 }
 
+const (
+	maxConsumeRetryBackoff = time.Second
+	defaultFollowInterval  = 10 * time.Millisecond
+)
+
 func newgrpcServer(config *Config) (srv *grpcServer, err error) {
+	if config.RetryableCodes == nil {
+		config.RetryableCodes = []codes.Code{codes.Unavailable}
+	}
+	if config.ConsumeRetryBackoff == 0 {
+		config.ConsumeRetryBackoff = 10 * time.Millisecond
+	}
+	if config.APIVersions == nil {
+		config.APIVersions = []string{api_v1.APIVersion}
+	}
+	if config.UnobservedMethods == nil {
+		config.UnobservedMethods = defaultUnobservedMethods
+	}
+	if config.IdempotencyTTL == 0 {
+		config.IdempotencyTTL = defaultIdempotencyTTL
+	}
+	if config.IdempotencyCacheSize == 0 {
+		config.IdempotencyCacheSize = defaultIdempotencyCacheSize
+	}
 	srv = &grpcServer{
-		Config: config,
+		Config:  config,
+		streams: newStreamRegistry(config.MaxStreamsPerSubject),
+		dedupe:  newDedupeCache(config.IdempotencyTTL, config.IdempotencyCacheSize),
+		started: time.Now(),
+	}
+	if config.JWTPublicKey != nil {
+		if config.JWTSubjectClaim == "" {
+			config.JWTSubjectClaim = defaultJWTSubjectClaim
+		}
+		if srv.jwtMethods, err = jwtMethods(config.JWTPublicKey); err != nil {
+			return nil, err
+		}
+	}
+	if config.RateLimit > 0 {
+		srv.limiter = newRateLimiter(config.RateLimit, config.RateBurst)
+	}
+	tp := config.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	srv.tracer = tp.Tracer(tracerName)
+	if config.Registry != nil {
+		if srv.prom, err = newPromMetrics(config.Registry); err != nil {
+			return nil, err
+		}
 	}
 	return srv, nil
 }
 
+// object는 topic에 대한 ACL 객체를 리턴한다. 객체는 토픽 이름이라 정책으로
+// 토픽마다 권한을 줄 수 있다. 테넌트 모드에서는 객체 앞에 인증서에서 얻은
+// 테넌트를 붙이므로 클라이언트가 토픽 이름으로 다른 테넌트를 가리킬 수 없다.
+func (s *grpcServer) object(ctx context.Context, topic string) (string, error) {
+	if s.TenantLog == nil {
+		return topic, nil
+	}
+	ten := tenant(ctx)
+	if err := log.ValidName(ten); err != nil {
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := log.ValidName(topic); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return ten + "/" + topic, nil
+}
+
+// authorize는 topic에 action을 해도 되는지 확인하고 요청을 처리할 로그를 리턴한다.
+// 허가받지 못한 클라이언트가 로그를 만들지 못하도록 확인한 다음에 로그를 연다.
+func (s *grpcServer) authorize(ctx context.Context, topic, action string) (CommitLog, error) {
+	if topic == "" {
+		topic = defaultTopic
+	}
+	object, err := s.object(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Authorizer.Authorize(subject(ctx), object, action); err != nil {
+		return nil, api_v1.WrapError(codes.PermissionDenied, api_v1.ReasonPermissionDenied, err, map[string]string{
+			"subject": subject(ctx),
+			"object":  object,
+			"action":  action,
+		}).Err()
+	}
+	if s.TenantLog == nil {
+		return s.CommitLog, nil
+	}
+	return s.TenantLog(tenant(ctx), topic)
+}
+
 func (s *grpcServer) Produce(ctx context.Context, req *api_v1.ProduceRequest) (*api_v1.ProduceResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx), objectWildcard, produceAction,
-	); err != nil {
+	clog, err := s.authorize(ctx, req.Topic, produceAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Standby.check(); err != nil {
+		return nil, err
+	}
+	if err := s.validate(req.Record); err != nil {
 		return nil, err
 	}
+	if err := compressRecord(req.Record); err != nil {
+		return nil, err
+	}
+	// 시각은 클라이언트가 정하지 못하고 로그가 쓸 때 넣는다.
+	req.Record.Timestamp = 0
+	if req.DryRun {
+		offset, err := dryRun(clog, req.Record)
+		if err != nil {
+			return nil, err
+		}
+		return &api_v1.ProduceResponse{Offset: offset}, nil
+	}
 
-	offset, err := s.CommitLog.Append(req.Record)
+	var offset uint64
+	if req.IdempotencyKey != "" {
+		offset, err = s.dedupe.do(dedupeKey(ctx, req), func(late *pendingWrite) (uint64, error) {
+			return s.append(ctx, clog, req.Record, late)
+		})
+	} else {
+		offset, err = s.append(ctx, clog, req.Record, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &api_v1.ProduceResponse{Offset: offset}, nil
+	res := &api_v1.ProduceResponse{Offset: offset}
+	if s.ExposeSegmentInfo {
+		if si, ok := clog.(SegmentInfoer); ok {
+			res.SegmentBaseOffset, res.SegmentName, err = si.SegmentInfo(offset)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+
+}
 
+// validate는 Validators를 차례로 불러 record를 검사한다.
+func (s *grpcServer) validate(record *api_v1.Record) error {
+	for _, validate := range s.Validators {
+		if err := validate(record); err != nil {
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+// ProduceBatch는 권한을 한 번만 확인하고 레코드를 차례로 검사해 쓴다. 한
+// 레코드라도 실패하면 거기서 멈추고, 그 레코드의 에러 코드에 실패한 레코드의
+// 위치(failed_index)와 그 레코드의 reason(failed_reason), 그때까지 받은 오프셋을
+// 붙여 리턴한다. 클라이언트는 failed_index부터 다시 보내면 된다.
+func (s *grpcServer) ProduceBatch(ctx context.Context, req *api_v1.ProduceBatchRequest) (*api_v1.ProduceBatchResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, produceAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Standby.check(); err != nil {
+		return nil, err
+	}
+
+	res := &api_v1.ProduceBatchResponse{
+		Offsets: make([]uint64, 0, len(req.Records)),
+	}
+	for i, record := range req.Records {
+		err := s.validate(record)
+		if err == nil {
+			err = compressRecord(record)
+		}
+		record.Timestamp = 0
+		var offset uint64
+		if err == nil {
+			offset, err = s.append(ctx, clog, record, nil)
+		}
+		if err != nil {
+			return nil, batchFailed(i, res, err)
+		}
+		res.Offsets = append(res.Offsets, offset)
+	}
+	return res, nil
+}
+
+// batchFailed는 i번째 레코드에서 err로 멈춘 배치의 에러를 만든다. 코드는
+// err의 것을 그대로 써서 클라이언트가 다시 시도할지 정할 수 있게 한다.
+func batchFailed(i int, written *api_v1.ProduceBatchResponse, err error) error {
+	st := status.Convert(err)
+	metadata := map[string]string{"failed_index": strconv.Itoa(i)}
+	if reason := api_v1.ErrorReason(err); reason != "" {
+		metadata["failed_reason"] = reason
+	}
+	return api_v1.NewStatus(
+		st.Code(),
+		fmt.Sprintf("record %d of the batch failed: %s", i, st.Message()),
+		api_v1.ReasonBatchFailed,
+		metadata,
+		written,
+	).Err()
+}
+
+// Truncate는 req.Lowest보다 작은 레코드만 담은 세그먼트를 지운다. 지운
+// 오프셋을 읽으면 ErrOffsetOutOfRange가 난다. 스탠바이는 복제한 로그를 그대로
+// 두어야 하므로 승격하기 전에는 거부한다.
+func (s *grpcServer) Truncate(ctx context.Context, req *api_v1.TruncateRequest) (*api_v1.TruncateResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, truncateAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Standby.check(); err != nil {
+		return nil, err
+	}
+	// CommitLog.Truncate는 lowest 이하를 지우므로 하나 앞까지 지운다.
+	if req.Lowest > 0 {
+		if err := clog.Truncate(req.Lowest - 1); err != nil {
+			return nil, err
+		}
+	}
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	return &api_v1.TruncateResponse{LowestOffset: lowest}, nil
+}
+
+// GetBounds는 로그에서 읽을 수 있는 가장 작은 오프셋과 가장 큰 오프셋을
+// 알린다. Truncate나 보존 기간으로 지운 세그먼트는 범위에 들지 않는다. 로그가
+// 비어 있으면 HighestOffset의 0과 헷갈리지 않게 Empty로 알린다.
+func (s *grpcServer) GetBounds(ctx context.Context, req *api_v1.GetBoundsRequest) (*api_v1.GetBoundsResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return nil, err
+	}
+
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	next, err := startOffset(clog, &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST})
+	if err != nil {
+		return nil, err
+	}
+	if next <= lowest {
+		return &api_v1.GetBoundsResponse{Lowest: next, Empty: true}, nil
+	}
+	return &api_v1.GetBoundsResponse{Lowest: lowest, Highest: next - 1}, nil
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *api_v1.ConsumeRequest) (*api_v1.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildcard,
-		consumeAction,
-	); err != nil {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return nil, err
+	}
+
+	offset, err := startOffset(clog, req)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.read(ctx, clog, offset)
+	if err != nil {
+		return nil, err
+	}
+	if record, err = decompressRecord(record); err != nil {
+		return nil, err
+	}
+	return &api_v1.ConsumeResponse{Record: record}, nil
+}
+
+// ConsumeSince는 Timestamp가 req.Since 이상인 첫 레코드를 읽는다. 찾은
+// 레코드가 만료되었으면 그 뒤의 읽을 수 있는 첫 레코드를 돌려준다. 그런
+// 레코드가 없으면 ErrOffsetOutOfRange로 실패한다.
+func (s *grpcServer) ConsumeSince(ctx context.Context, req *api_v1.ConsumeSinceRequest) (*api_v1.ConsumeResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return nil, err
+	}
+	seeker, ok := clog.(TimeSeeker)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "log does not support time-based reads")
+	}
+
+	off, err := seeker.OffsetForTime(req.Since)
+	if err != nil {
+		return nil, err
+	}
+	end, err := startOffset(clog, &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST})
+	if err != nil {
+		return nil, err
+	}
+	for ; off < end; off++ {
+		record, err := s.read(ctx, clog, off)
+		switch err.(type) {
+		case nil:
+		case api_v1.ErrOffsetOutOfRange, api_v1.ErrRecordExpired:
+			continue
+		default:
+			return nil, err
+		}
+		if record, err = decompressRecord(record); err != nil {
+			return nil, err
+		}
+		return &api_v1.ConsumeResponse{Record: record}, nil
+	}
+	return nil, api_v1.ErrOffsetOutOfRange{Offset: end}
+}
+
+// ConsumeByKey는 Key가 req.Key인 가장 최근 레코드를 읽는다. 그런 레코드가
+// 없거나 이미 지워졌으면 ErrKeyNotFound로, 만료되었으면 ErrRecordExpired로
+// 실패한다.
+func (s *grpcServer) ConsumeByKey(ctx context.Context, req *api_v1.ConsumeByKeyRequest) (*api_v1.ConsumeResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaughtUp(); err != nil {
 		return nil, err
 	}
+	finder, ok := clog.(KeyFinder)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "log does not support key lookups")
+	}
 
-	record, err := s.CommitLog.Read(req.Offset)
+	off, err := finder.OffsetForKey(req.Key)
+	if errors.Is(err, log.ErrKeyNotFound) {
+		return nil, api_v1.ErrKeyNotFound{Key: req.Key}
+	}
 	if err != nil {
 		return nil, err
 	}
+	record, err := s.read(ctx, clog, off)
+	if _, ok := err.(api_v1.ErrOffsetOutOfRange); ok {
+		return nil, api_v1.ErrKeyNotFound{Key: req.Key}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record, err = decompressRecord(record); err != nil {
+		return nil, err
+	}
 	return &api_v1.ConsumeResponse{Record: record}, nil
 }
 
+// ConsumeMany는 요청한 오프셋들을 한 번의 권한 확인으로 읽는다. 오프셋마다
+// 결과를 따로 돌려주므로 없거나 만료된 오프셋이 섞여 있어도 나머지는 쓸 수 있다.
+// 그 밖의 에러가 나면 전체 요청이 실패한다.
+func (s *grpcServer) ConsumeMany(ctx context.Context, req *api_v1.ConsumeManyRequest) (*api_v1.ConsumeManyResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return nil, err
+	}
+
+	res := &api_v1.ConsumeManyResponse{
+		Results: make([]*api_v1.ConsumeManyResult, len(req.Offsets)),
+	}
+	for i, offset := range req.Offsets {
+		result := &api_v1.ConsumeManyResult{Offset: offset}
+		record, err := s.read(ctx, clog, offset)
+		switch err.(type) {
+		case nil:
+			if result.Record, err = decompressRecord(record); err != nil {
+				return nil, err
+			}
+		case api_v1.ErrOffsetOutOfRange:
+			result.Status = api_v1.ReadStatus_OUT_OF_RANGE
+		case api_v1.ErrRecordExpired:
+			result.Status = api_v1.ReadStatus_EXPIRED
+		default:
+			return nil, err
+		}
+		res.Results[i] = result
+	}
+	return res, nil
+}
+
+// ConsumeRange는 req.Offset부터 이어진 레코드를 req.MaxRecords개까지 읽는다.
+// 요청을 받은 때의 로그 끝에 닿으면 에러 없이 그때까지 읽은 레코드를 돌려준다.
+// ConsumeStream처럼 만료된 레코드와 비어 있는 오프셋은 건너뛴다. 이미 지운
+// 오프셋부터 읽으면 Consume처럼 ErrOffsetOutOfRange로 실패한다.
+func (s *grpcServer) ConsumeRange(ctx context.Context, req *api_v1.ConsumeRangeRequest) (*api_v1.ConsumeRangeResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return nil, err
+	}
+
+	lowest, err := clog.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	if req.Offset < lowest {
+		return nil, api_v1.ErrOffsetOutOfRange{Offset: req.Offset}
+	}
+	end, err := startOffset(clog, &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api_v1.ConsumeRangeResponse{}
+	for off := req.Offset; off < end && uint64(len(res.Records)) < req.MaxRecords; off++ {
+		record, err := s.read(ctx, clog, off)
+		switch err.(type) {
+		case nil:
+		case api_v1.ErrOffsetOutOfRange, api_v1.ErrRecordExpired:
+			continue
+		default:
+			return nil, err
+		}
+		if record, err = decompressRecord(record); err != nil {
+			return nil, err
+		}
+		res.Records = append(res.Records, record)
+	}
+	return res, nil
+}
+
+// read는 clog에서 offset 레코드를 읽는다. 많은 컨슈머가 같은 최근 오프셋을
+// 동시에 읽을 때 로그는 한 번만 읽고 기다리던 요청들이 결과를 나눠 갖는다.
+// 나눠 가진 레코드는 고치지 말아야 한다. log.Read 스팬은 실제로 읽은 요청의
+// 트레이스에만 남는다. ctx가 끝나 읽지 못하면 DeadlineExceeded나 Canceled로
+// 실패한다.
+func (s *grpcServer) read(ctx context.Context, clog CommitLog, offset uint64) (*api_v1.Record, error) {
+	key := fmt.Sprintf("%p/%d", clog, offset)
+	v, err, shared := s.reads.Do(key, func() (interface{}, error) {
+		return s.readLog(ctx, clog, offset)
+	})
+	if shared && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		// 실제로 읽던 요청의 컨텍스트가 끝났을 뿐이므로 직접 다시 읽는다.
+		v, err = s.readLog(ctx, clog, offset)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, status.FromContextError(err).Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.prom.consumed()
+	s.consumed.Add(1)
+	return v.(*api_v1.Record), nil
+}
+
+// highWatermarkChanged는 clog에 레코드가 추가되면 닫히는 채널을 리턴한다.
+// clog가 Notifier가 아니면 nil이다.
+func highWatermarkChanged(clog CommitLog) <-chan struct{} {
+	if n, ok := clog.(Notifier); ok {
+		return n.HighWatermarkChanged()
+	}
+	return nil
+}
+
+func dryRun(clog CommitLog, record *api_v1.Record) (uint64, error) {
+	if dr, ok := clog.(DryRunner); ok {
+		off, err := dr.DryRun(record)
+		return off, rejectedRecord(err)
+	}
+	return startOffset(clog, &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST})
+}
+
+// startOffset는 req.StartPosition에 따라 읽기 시작할 오프셋을 정한다.
+func startOffset(clog CommitLog, req *api_v1.ConsumeRequest) (uint64, error) {
+	switch req.StartPosition {
+	case api_v1.StartPosition_EARLIEST:
+		return clog.LowestOffset()
+	case api_v1.StartPosition_LATEST:
+		highest, err := clog.HighestOffset()
+		if err != nil || highest != 0 {
+			return highest + 1, err
+		}
+		// 빈 로그도 HighestOffset이 0이므로 0번 레코드가 있는지 확인한다.
+		_, err = clog.Read(0)
+		switch err.(type) {
+		case nil, api_v1.ErrRecordExpired:
+			return 1, nil
+		case api_v1.ErrOffsetOutOfRange:
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+	return req.Offset, nil
+}
+
 func (s *grpcServer) ProduceStream(
 	stream api_v1.Log_ProduceStreamServer,
 ) error {
 	for {
 		req, err := stream.Recv()
+		if err == io.EOF {
+			// 클라이언트가 보내기를 마치면 스트림을 정상으로 끝낸다.
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -114,30 +776,128 @@ func (s *grpcServer) ConsumeStream(
 	req *api_v1.ConsumeRequest,
 	stream api_v1.Log_ConsumeStreamServer,
 ) error {
+	return s.consume(stream.Context(), req, stream.Send)
+}
 
+// consume은 ConsumeStream이 보낼 레코드를 차례로 send에 넘긴다. send가
+// 실패하면 그 에러를 리턴하고, ctx가 끝나면 nil을 리턴한다.
+func (s *grpcServer) consume(
+	ctx context.Context,
+	req *api_v1.ConsumeRequest,
+	send func(*api_v1.ConsumeResponse) error,
+) error {
+	clog, err := s.authorize(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCaughtUp(); err != nil {
+		return err
+	}
+	if req.Follow != nil {
+		if *req.Follow && req.StopAtHead {
+			return status.Error(codes.InvalidArgument, "follow and stop_at_head are mutually exclusive")
+		}
+		req.StopAtHead = !*req.Follow
+	}
+	// 시작 위치는 스트림을 열 때 한 번만 정하고 그 뒤로는 오프셋을 따라 읽는다.
+	if req.Offset, err = startOffset(clog, req); err != nil {
+		return err
+	}
+	req.StartPosition = api_v1.StartPosition_OFFSET
+
+	st, err := s.streams.add(subject(ctx), clog, req.Offset)
+	if err != nil {
+		return err
+	}
+	defer s.streams.remove(subject(ctx), st)
+
+	// StopAtHead면 스트림 시작 시점의 마지막 오프셋까지만 보내고 끝낸다.
+	// 그 사이에 새로 추가된 레코드는 보내지 않는다.
+	var head uint64
+	if req.StopAtHead {
+		if head, err = clog.HighestOffset(); err != nil {
+			return err
+		}
+	}
+
+	backoff := s.ConsumeRetryBackoff
 	for {
 		select {
-		case <-stream.Context().Done():
+		case <-ctx.Done():
 			return nil
 
 		default:
-			res, err := s.Consume(stream.Context(), req)
+			if req.StopAtHead && req.Offset > head {
+				return nil
+			}
+			if req.EndOffset != nil && req.Offset > *req.EndOffset {
+				return nil
+			}
+			// 읽기 전에 받아 두어야 읽은 뒤에 추가된 레코드를 놓치지 않는다.
+			appended := highWatermarkChanged(clog)
+			res, err := s.Consume(ctx, req)
 			switch err.(type) {
 			case nil:
 			case api_v1.ErrOffsetOutOfRange:
+				// 로그 끝보다 앞인데 레코드가 없으면 OffsetAllocator가 건너뛴
+				// 오프셋이니 다음 오프셋으로 넘어간다.
+				if highest, err := clog.HighestOffset(); err == nil && req.Offset < highest {
+					req.Offset++
+					st.next.Store(req.Offset)
+					continue
+				}
+				if req.StopAtHead {
+					return nil
+				}
+				var poll <-chan time.Time
+				if appended == nil {
+					poll = time.After(defaultFollowInterval)
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-appended:
+				case <-poll:
+				}
+				continue
+			case api_v1.ErrRecordExpired:
+				// 만료된 레코드는 건너뛰고 다음 레코드로 넘어간다.
+				req.Offset++
+				st.next.Store(req.Offset)
 				continue
 			default:
-				return err
+				if !s.retryable(err) {
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, maxConsumeRetryBackoff)
+				continue
 			}
-			if err = stream.Send(res); err != nil {
+			if err = send(res); err != nil {
 				return err
 			}
+			backoff = s.ConsumeRetryBackoff
 			req.Offset++
+			st.next.Store(req.Offset)
 		}
 	}
 
 }
 
+func (s *grpcServer) retryable(err error) bool {
+	code := status.Code(err)
+	for _, c := range s.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*grpc.Server, error) {
 
 	logger := zap.L().Named("server")
@@ -151,34 +911,83 @@ func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*grpc.Server,
 	}
 
 	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
-	if err := view.Register(ocgrpc.DefaultServerViews...); err != nil {
+	views := append([]*view.View{}, ocgrpc.DefaultServerViews...)
+	views = append(views, log.Views...)
+	if err := view.Register(views...); err != nil {
 		return nil, err
 	}
+	metricproducer.GlobalManager().AddProducer(Metrics)
 
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// 호출자가 넘긴 옵션이 나중에 적용되어 설정보다 우선한다.
+	grpcOpts = append(config.serverOptions(), grpcOpts...)
 	grpcOpts = append(grpcOpts, grpc.StreamInterceptor(
 		grpc_middleware.ChainStreamServer(
 			grpc_ctxtags.StreamServerInterceptor(),
+			srv.promStreamInterceptor,
+			srv.traceStreamInterceptor,
 			grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-			grpc_auth.StreamServerInterceptor(authenticate),
+			srv.versionStreamInterceptor,
+			srv.rateLimitStreamInterceptor,
+			grpc_auth.StreamServerInterceptor(srv.authenticate),
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_ctxtags.UnaryServerInterceptor(),
+			srv.promUnaryInterceptor,
+			srv.traceUnaryInterceptor,
 			grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			grpc_auth.UnaryServerInterceptor(authenticate),
+			srv.versionUnaryInterceptor,
+			srv.rateLimitUnaryInterceptor,
+			grpc_auth.UnaryServerInterceptor(srv.authenticate),
 		)),
-		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
+		grpc.StatsHandler(&filteredStatsHandler{
+			Handler:  &ocgrpc.ServerHandler{},
+			excluded: srv.UnobservedMethods,
+		}),
 	)
 
 	gsrv := grpc.NewServer(grpcOpts...)
-	srv, err := newgrpcServer(config)
-	if err != nil {
-		return nil, err
-	}
 	api_v1.RegisterLogServer(gsrv, srv)
+	srv.registerHealth(gsrv)
+	if srv.prom != nil {
+		// 아직 불리지 않은 메서드도 0으로 보이게 한다.
+		srv.prom.rpc.InitializeMetrics(gsrv)
+	}
+	if config.EnableReflection {
+		reflection.Register(gsrv)
+	}
 	return gsrv, nil
 }
 
-func authenticate(ctx context.Context) (context.Context, error) {
+// serverOptions는 메시지 크기와 keepalive 설정을 서버 옵션으로 바꾼다. 0인
+// 설정은 옵션을 넣지 않아 gRPC 기본값을 쓴다.
+func (c *Config) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if c.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(c.MaxRecvMsgSize))
+	}
+	if c.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(c.MaxSendMsgSize))
+	}
+	if c.KeepaliveEnforcement != (keepalive.EnforcementPolicy{}) {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(c.KeepaliveEnforcement))
+	}
+	if c.KeepaliveParams != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(c.KeepaliveParams))
+	}
+	return opts
+}
+
+// authenticate는 클라이언트 인증서의 CommonName을 구독자로, OU를 테넌트로
+// 컨텍스트에 담는다. Bearer 토큰이 있으면 인증서 대신 토큰의 클레임을 구독자로
+// 쓰고 테넌트는 없다. 검증한 인증서도 토큰도 없으면 Unauthenticated로
+// 거부한다. TLS 없이 띄운 서버는 구독자가 빈 문자열이다. AllowedSubjects가
+// 있으면 목록에 없는 구독자를 거부한다.
+func (s *grpcServer) authenticate(ctx context.Context) (context.Context, error) {
 	peer, ok := peer.FromContext(ctx)
 	if !ok {
 		return ctx, status.New(
@@ -187,14 +996,31 @@ func authenticate(ctx context.Context) (context.Context, error) {
 		).Err()
 	}
 
-	if peer.AuthInfo == nil {
-		return context.WithValue(ctx, subjectContextKey{}, ""), nil
+	subject, ok, err := s.bearerSubject(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	if !ok && peer.AuthInfo != nil {
+		tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
+		if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+			return ctx, status.Error(codes.Unauthenticated, "no client certificate or bearer token")
+		}
+		cert := tlsInfo.State.VerifiedChains[0][0]
+		subject = s.certSubject(cert)
+		if ou := cert.Subject.OrganizationalUnit; len(ou) > 0 {
+			ctx = context.WithValue(ctx, tenantContextKey{}, ou[0])
+		}
+	}
+	if len(s.AllowedSubjects) > 0 && !slices.Contains(s.AllowedSubjects, subject) {
+		return ctx, api_v1.NewStatus(
+			codes.PermissionDenied,
+			fmt.Sprintf("subject %q is not allowed", subject),
+			api_v1.ReasonPermissionDenied,
+			map[string]string{"subject": subject},
+		).Err()
 	}
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
 
-	return ctx, nil
+	return context.WithValue(ctx, subjectContextKey{}, subject), nil
 }
 
 func subject(ctx context.Context) string {
@@ -202,3 +1028,11 @@ func subject(ctx context.Context) string {
 }
 
 type subjectContextKey struct{}
+
+// tenant는 클라이언트 인증서의 첫 번째 OU다. 없으면 빈 문자열이다.
+func tenant(ctx context.Context) string {
+	ten, _ := ctx.Value(tenantContextKey{}).(string)
+	return ten
+}
+
+type tenantContextKey struct{}
@@ -1,11 +1,26 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
 	"flag"
-
+	"fmt"
+	"io"
+	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,14 +28,33 @@ import (
 	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/auth"
 	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/config"
 	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.opencensus.io/examples/exporter"
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 var debug = flag.Bool("debug", false, "Enable observability for debugging.")
@@ -49,6 +83,13 @@ func TestServer(t *testing.T) {
 		"produce/consume stream succeeds":                     testProduceConsumeStream,
 		"consume past log boundary fails":                     testConsumePastBoundary,
 		"unauthorized fails":                                  testUnauthorized,
+		"consume stream stops at head":                        testConsumeStreamStopAtHead,
+		"consume stream skips expired records":                testConsumeStreamSkipsExpired,
+		"consume stream ends at end offset":                   testConsumeStreamEndOffset,
+		"consume stream follows only when asked":              testConsumeStreamFollow,
+		"consume stream batch sends every record":             testConsumeStreamBatch,
+		"consume many reads each offset":                      testConsumeMany,
+		"produce batch appends every record":                  testProduceBatch,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			rootClient, nobodyClient, config, teardown := setupTest(t, nil)
@@ -58,6 +99,347 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestServerSegmentInfo(t *testing.T) {
+	for scenario, expose := range map[string]bool{
+		"segment info exposed": true,
+		"segment info hidden":  false,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client, _, _, teardown := setupTest(t, func(c *Config) {
+				c.ExposeSegmentInfo = expose
+			})
+			defer teardown()
+			testSegmentInfo(t, client, expose)
+		})
+	}
+}
+
+func TestConsumeStreamRetry(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		err       error
+		wantCode  codes.Code
+		retryable bool
+	}{
+		"transient error is retried": {
+			err:       status.Error(codes.Unavailable, "disk hiccup"),
+			retryable: true,
+		},
+		"permanent error ends the stream": {
+			err:      status.Error(codes.Internal, "disk on fire"),
+			wantCode: codes.Internal,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			flaky := &flakyLog{failures: 3, err: tc.err}
+			client, _, _, teardown := setupTest(t, func(c *Config) {
+				flaky.CommitLog = c.CommitLog
+				c.CommitLog = flaky
+				c.ConsumeRetryBackoff = time.Millisecond
+			})
+			defer teardown()
+
+			ctx := context.Background()
+			_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+
+			stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{Offset: 0})
+			require.NoError(t, err)
+			res, err := stream.Recv()
+			if tc.retryable {
+				require.NoError(t, err)
+				require.Equal(t, []byte("hello world"), res.Record.Value)
+				return
+			}
+			require.Equal(t, tc.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestErrorInfo(t *testing.T) {
+	// Reason은 클라이언트가 기대는 값이라 상수가 아닌 문자열로 고정해서 확인한다.
+	for scenario, tc := range map[string]struct {
+		err        error
+		wantCode   codes.Code
+		wantReason string
+		wantOffset string
+	}{
+		"offset out of range": {
+			err:        api_v1.ErrOffsetOutOfRange{Offset: 7},
+			wantCode:   codes.NotFound,
+			wantReason: "OFFSET_OUT_OF_RANGE",
+			wantOffset: "7",
+		},
+		"record expired": {
+			err:        api_v1.ErrRecordExpired{Offset: 7, ExpireAt: 1},
+			wantCode:   codes.NotFound,
+			wantReason: "RECORD_EXPIRED",
+			wantOffset: "7",
+		},
+		"segment rolling": {
+			err:        api_v1.ErrSegmentRolling{RetryAfter: time.Second},
+			wantCode:   codes.Unavailable,
+			wantReason: "SEGMENT_ROLLING",
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			flaky := &flakyLog{failures: 1, err: tc.err}
+			client, _, _, teardown := setupTest(t, func(c *Config) {
+				flaky.CommitLog = c.CommitLog
+				c.CommitLog = flaky
+			})
+			defer teardown()
+
+			_, err := client.Consume(context.Background(), &api_v1.ConsumeRequest{Offset: 7})
+			st := status.Convert(err)
+			require.Equal(t, tc.wantCode, st.Code())
+
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if d, ok := d.(*errdetails.ErrorInfo); ok {
+					info = d
+				}
+			}
+			require.NotNil(t, info)
+			require.Equal(t, tc.wantReason, info.Reason)
+			require.Equal(t, "log.v1", info.Domain)
+			require.Equal(t, tc.wantOffset, info.Metadata["offset"])
+		})
+	}
+}
+
+func TestErrorReasons(t *testing.T) {
+	limited := func(fn func(*log.Config)) func(*testing.T, *Config) {
+		return func(t *testing.T, c *Config) {
+			lc := log.Config{}
+			fn(&lc)
+			clog, err := log.NewLog(t.TempDir(), lc)
+			require.NoError(t, err)
+			t.Cleanup(func() { clog.Close() })
+			c.CommitLog = clog
+		}
+	}
+	// Reason과 메타데이터는 클라이언트가 기대는 값이라 문자열로 고정해서 확인한다.
+	for scenario, tc := range map[string]struct {
+		setup        func(*testing.T, *Config)
+		nobody       bool
+		value        string
+		wantCode     codes.Code
+		wantReason   string
+		wantMetadata map[string]string
+	}{
+		"permission denied": {
+			nobody:     true,
+			wantCode:   codes.PermissionDenied,
+			wantReason: "PERMISSION_DENIED",
+			wantMetadata: map[string]string{
+				"subject": "nobody",
+				"object":  "default",
+				"action":  "produce",
+			},
+		},
+		"record too large": {
+			setup:      limited(func(c *log.Config) { c.Store.MaxRecordSize = 64 }),
+			value:      strings.Repeat("x", 100),
+			wantCode:   codes.InvalidArgument,
+			wantReason: "RECORD_TOO_LARGE",
+			// size는 스토어에 쓸 크기라 값보다 조금 크다.
+			wantMetadata: map[string]string{"max_size": "64"},
+		},
+		"record size mismatch": {
+			setup:      limited(func(c *log.Config) { c.Store.FixedRecordSize = 8 }),
+			value:      "short",
+			wantCode:   codes.InvalidArgument,
+			wantReason: "RECORD_SIZE_MISMATCH",
+		},
+		"append failed": {
+			setup: func(t *testing.T, c *Config) {
+				c.CommitLog = failingLog{CommitLog: c.CommitLog}
+			},
+			value:      "hello world",
+			wantCode:   codes.Unknown,
+			wantReason: "APPEND_FAILED",
+			wantMetadata: map[string]string{
+				"subject":     "root",
+				"record_size": "13",
+			},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			root, nobody, _, teardown := setupTest(t, func(c *Config) {
+				if tc.setup != nil {
+					tc.setup(t, c)
+				}
+			})
+			defer teardown()
+			client := root
+			if tc.nobody {
+				client = nobody
+			}
+
+			_, err := client.Produce(context.Background(), &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte(tc.value)},
+			})
+			st := status.Convert(err)
+			require.Equal(t, tc.wantCode, st.Code())
+			require.Equal(t, tc.wantReason, api_v1.ErrorReason(err))
+			for _, d := range st.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					for k, v := range tc.wantMetadata {
+						require.Equal(t, v, info.Metadata[k], k)
+					}
+				}
+			}
+
+			if tc.nobody {
+				return
+			}
+			// 배치에서 실패하면 그 레코드의 reason을 failed_reason으로 알려 준다.
+			_, err = client.ProduceBatch(context.Background(), &api_v1.ProduceBatchRequest{
+				Records: []*api_v1.Record{{Value: []byte(tc.value)}},
+			})
+			require.Equal(t, "BATCH_FAILED", api_v1.ErrorReason(err))
+			for _, d := range status.Convert(err).Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					require.Equal(t, tc.wantReason, info.Metadata["failed_reason"])
+				}
+			}
+		})
+	}
+}
+
+func TestAllowedSubjects(t *testing.T) {
+	// ACL이 모두 허가해도 목록에 없는 구독자는 인증 단계에서 거부된다.
+	for scenario, tc := range map[string]struct {
+		allowed    []string
+		nobodyCode codes.Code
+	}{
+		"empty allowlist allows every subject": {
+			nobodyCode: codes.OK,
+		},
+		"unlisted subject is rejected": {
+			allowed:    []string{"root"},
+			nobodyCode: codes.PermissionDenied,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+				c.Authorizer = allowAll{}
+				c.AllowedSubjects = tc.allowed
+			})
+			defer teardown()
+
+			ctx := context.Background()
+			req := &api_v1.ProduceRequest{Record: &api_v1.Record{Value: []byte("hello world")}}
+			_, err := rootClient.Produce(ctx, req)
+			require.NoError(t, err)
+
+			_, err = nobodyClient.Produce(ctx, req)
+			require.Equal(t, tc.nobodyCode, status.Code(err))
+			stream, err := nobodyClient.ConsumeStream(ctx, &api_v1.ConsumeRequest{})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			require.Equal(t, tc.nobodyCode, status.Code(err))
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	_, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.AllowedSubjects = []string{"root"}
+	})
+	defer teardown()
+
+	// 인증을 통과하지 못하는 클라이언트도 Ping은 부를 수 있다.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer not-a-token")
+	before := time.Now()
+	res, err := nobody.Ping(ctx, &api_v1.PingRequest{})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, res.ServerTime, before.UnixNano())
+	require.LessOrEqual(t, res.ServerTime, time.Now().UnixNano())
+
+	// 로그를 읽고 쓰는 메서드는 그대로 인증한다.
+	_, err = nobody.Produce(ctx, &api_v1.ProduceRequest{Record: &api_v1.Record{Value: []byte("hello world")}})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = nobody.Produce(context.Background(), &api_v1.ProduceRequest{Record: &api_v1.Record{Value: []byte("hello world")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobody.Consume(context.Background(), &api_v1.ConsumeRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestTenantIsolation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tenant-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	namespaces := log.NewNamespaces(dir, log.Config{})
+	defer namespaces.Close()
+
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		c.TenantLog = func(tenant, topic string) (CommitLog, error) {
+			return namespaces.Log(tenant, topic)
+		}
+	})
+	defer teardown()
+
+	aliceConn, alice := newClient(t, addr, config.TenantAClientCertFile, config.TenantAClientKeyFile)
+	defer aliceConn.Close()
+	bobConn, bob := newClient(t, addr, config.TenantBClientCertFile, config.TenantBClientKeyFile)
+	defer bobConn.Close()
+
+	ctx := context.Background()
+	_, err = alice.Produce(ctx, &api_v1.ProduceRequest{
+		Topic:  "orders",
+		Record: &api_v1.Record{Value: []byte("alice's order")},
+	})
+	require.NoError(t, err)
+
+	consume, err := alice.Consume(ctx, &api_v1.ConsumeRequest{Topic: "orders", Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("alice's order"), consume.Record.Value)
+
+	// bob의 "orders"는 bob 테넌트의 로그이므로 alice의 레코드가 보이지 않는다.
+	_, err = bob.Consume(ctx, &api_v1.ConsumeRequest{Topic: "orders", Offset: 0})
+	require.Equal(t, status.Code(api_v1.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+
+	// 토픽 이름으로 다른 테넌트의 디렉터리를 가리킬 수 없다.
+	_, err = bob.Consume(ctx, &api_v1.ConsumeRequest{Topic: "../tenant-a/orders", Offset: 0})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 허가받지 않은 토픽은 만들어지지도 않는다.
+	_, err = bob.Produce(ctx, &api_v1.ProduceRequest{
+		Topic:  "payments",
+		Record: &api_v1.Record{Value: []byte("bob's payment")},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.NoDirExists(t, filepath.Join(dir, "tenant-b", "payments"))
+}
+
+func TestTopicACL(t *testing.T) {
+	rootClient, nobodyClient, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx := context.Background()
+	produce := func(client api_v1.LogClient, topic string) error {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Topic:  topic,
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		return err
+	}
+	// nobody는 topic-a에 쓰기만 허가받았다.
+	require.NoError(t, produce(nobodyClient, "topic-a"))
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(nobodyClient, "topic-b")))
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(nobodyClient, "")))
+	_, err := nobodyClient.Consume(ctx, &api_v1.ConsumeRequest{Topic: "topic-a"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// root는 * 객체로 모든 토픽에 허가받았다.
+	require.NoError(t, produce(rootClient, "topic-b"))
+	_, err = rootClient.Consume(ctx, &api_v1.ConsumeRequest{Topic: "topic-a", Offset: 0})
+	require.NoError(t, err)
+}
+
 func setupTest(t *testing.T, fn func(*Config)) (
 	rootClient api_v1.LogClient,
 	nobodyClient api_v1.LogClient,
@@ -66,41 +448,61 @@ func setupTest(t *testing.T, fn func(*Config)) (
 ) {
 	t.Helper()
 
-	l, err := net.Listen("tcp", "127.0.0.1:0")
-	require.NoError(t, err)
-
-	newClient := func(crtPath, keyPath string) (
-		*grpc.ClientConn,
-		api_v1.LogClient,
-		[]grpc.DialOption,
-	) {
-		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
-			CertFile: crtPath,
-			KeyFile:  keyPath,
-			CAFile:   config.CAFile,
-			Server:   false,
-		})
-		require.NoError(t, err)
-		tlsCreds := credentials.NewTLS(tlsConfig)
-		opts := []grpc.DialOption{grpc.WithTransportCredentials(tlsCreds)}
-		conn, err := grpc.NewClient(l.Addr().String(), opts...)
-		require.NoError(t, err)
-		client := api_v1.NewLogClient(conn)
-		return conn, client, opts
-	}
+	addr, cfg, serverTeardown := setupServer(t, fn)
 
 	var rootConn *grpc.ClientConn
-	rootConn, rootClient, _ = newClient(
+	rootConn, rootClient = newClient(
+		t,
+		addr,
 		config.RootClientCertFile,
 		config.RootClientKeyFile,
 	)
 
 	var nobodyConn *grpc.ClientConn
-	nobodyConn, nobodyClient, _ = newClient(
+	nobodyConn, nobodyClient = newClient(
+		t,
+		addr,
 		config.NobodyClientCertFile,
 		config.NobodyClientKeyFile,
 	)
 
+	return rootClient, nobodyClient, cfg, func() {
+		serverTeardown()
+		rootConn.Close()
+		nobodyConn.Close()
+	}
+}
+
+func newClient(t *testing.T, addr, crtPath, keyPath string) (
+	*grpc.ClientConn,
+	api_v1.LogClient,
+) {
+	t.Helper()
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: crtPath,
+		KeyFile:  keyPath,
+		CAFile:   config.CAFile,
+		Server:   false,
+	})
+	require.NoError(t, err)
+	tlsCreds := credentials.NewTLS(tlsConfig)
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(tlsCreds)}
+	conn, err := grpc.NewClient(addr, opts...)
+	require.NoError(t, err)
+	client := api_v1.NewLogClient(conn)
+	return conn, client
+}
+
+func setupServer(t *testing.T, fn func(*Config)) (
+	addr string,
+	cfg *Config,
+	teardown func(),
+) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
 	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
 		CertFile: config.ServerCertFile,
 		KeyFile:  config.ServerKeyFile,
@@ -112,7 +514,6 @@ func setupTest(t *testing.T, fn func(*Config)) (
 
 	dir, err := os.MkdirTemp("", "server-test")
 	require.NoError(t, err)
-	defer os.RemoveAll(dir)
 
 	clog, err := log.NewLog(dir, log.Config{})
 	require.NoError(t, err)
@@ -153,11 +554,10 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		server.Serve(l)
 	}()
 
-	return rootClient, nobodyClient, cfg, func() {
+	return l.Addr().String(), cfg, func() {
 		server.Stop()
-		rootConn.Close()
-		nobodyConn.Close()
 		l.Close()
+		clog.Remove()
 		if telemetryExporter != nil {
 			time.Sleep(1500 * time.Millisecond)
 			telemetryExporter.Stop()
@@ -165,6 +565,7 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		}
 	}
 }
+
 func testProduceConsume(t *testing.T, client, _ api_v1.LogClient, config *Config) {
 	ctx := context.Background()
 
@@ -208,10 +609,13 @@ func testConsumePastBoundary(
 	if consume != nil {
 		t.Fatal("consume not nil")
 	}
-	got := status.Code(err)
-	want := status.Code(api_v1.ErrOffsetOutOfRange{}.GRPStatus().Err())
-	if got != want {
-		t.Fatalf("got err: %v, want: %v", got, want)
+	// 클라이언트가 status.FromError로 꺼낸 코드도 NotFound여야 한다.
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.NotFound {
+		t.Fatalf("got err: %v, want: %v", err, codes.NotFound)
+	}
+	if reason := api_v1.ErrorReason(err); reason != api_v1.ReasonOffsetOutOfRange {
+		t.Fatalf("got reason: %q, want: %q", reason, api_v1.ReasonOffsetOutOfRange)
 	}
 }
 
@@ -262,10 +666,8 @@ func testProduceConsumeStream(
 		for i, record := range records {
 			res, err := stream.Recv()
 			require.NoError(t, err)
-			require.Equal(t, res.Record, &api_v1.Record{
-				Value:  record.Value,
-				Offset: uint64(i),
-			})
+			require.Equal(t, record.Value, res.Record.Value)
+			require.Equal(t, uint64(i), res.Record.Offset)
 		}
 	}
 }
@@ -302,76 +704,1359 @@ func testUnauthorized(
 	}
 }
 
-// func setupTest1(t *testing.T, fn func(*Config)) (
-// 	client api_v1.LogClient,
-// 	cfg *Config,
-// 	teardown func(),
-// ) {
-// 	t.Helper()
+func testConsumeStreamStopAtHead(
+	t *testing.T,
+	client, _ api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
 
-// 	t.Helper()
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
 
-// 	l, err := net.Listen("tcp", "127.0.0.1:0")
-// 	require.NoError(t, err)
+	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{
+		Offset:     0,
+		StopAtHead: true,
+	})
+	require.NoError(t, err)
 
-// 	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
-// 		CAFile: config.CAFile,
-// 	})
-// 	require.NoError(t, err)
+	for i := uint64(0); i < 3; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, i, res.Record.Offset)
 
-// 	clientCreds := credentials.NewTLS(clientTLSConfig)
-// 	cc, err := grpc.Dial(
-// 		l.Addr().String(),
-// 		grpc.WithTransportCredentials(clientCreds),
-// 	)
-// 	require.NoError(t, err)
+		if i == 0 {
+			// 스트림이 시작된 뒤에 추가된 레코드는 보내지 않아야 한다.
+			_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("too late")},
+			})
+			require.NoError(t, err)
+		}
+	}
 
-// 	client = api_v1.NewLogClient(cc)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
 
-// 	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
-// 		CertFile:      config.ServerCertFile,
-// 		KeyFile:       config.ServerKeyFile,
-// 		CAFile:        config.CAFile,
-// 		ServerAddress: l.Addr().String(),
-// 	})
-// 	require.NoError(t, err)
-// 	serverCreds := credentials.NewTLS(serverTLSConfig)
+func testConsumeStreamEndOffset(
+	t *testing.T,
+	client, _ api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
 
-// 	dir, err := os.MkdirTemp("", "server-test")
-// 	require.NoError(t, err)
+	produce := func(n int) {
+		for i := 0; i < n; i++ {
+			_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+		}
+	}
+	recv := func(stream api_v1.Log_ConsumeStreamClient, from, to uint64) {
+		for i := from; i <= to; i++ {
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, i, res.Record.Offset)
+		}
+		_, err := stream.Recv()
+		require.Equal(t, io.EOF, err)
+	}
+	produce(5)
 
-// 	clog, err := log.NewLog(dir, log.Config{})
-// 	require.NoError(t, err)
+	// 이미 쓰인 범위는 [Offset, EndOffset]을 그대로 보내고 끝난다.
+	end := uint64(3)
+	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{
+		Offset:    1,
+		EndOffset: &end,
+	})
+	require.NoError(t, err)
+	recv(stream, 1, 3)
 
-// 	cfg = &Config{
-// 		CommitLog: clog,
-// 	}
-// 	if fn != nil {
-// 		fn(cfg)
-// 	}
-// 	server, err := NewGRPCServer(cfg, grpc.Creds(serverCreds))
-// 	require.NoError(t, err)
+	// StopAtHead면 EndOffset이 아직 쓰이지 않았어도 끝에서 멈춘다.
+	end = 7
+	stream, err = client.ConsumeStream(ctx, &api_v1.ConsumeRequest{
+		Offset:     3,
+		EndOffset:  &end,
+		StopAtHead: true,
+	})
+	require.NoError(t, err)
+	recv(stream, 3, 4)
 
-// 	go func() {
-// 		server.Serve(l)
-// 	}()
+	// 아니면 EndOffset이 쓰일 때까지 기다린다.
+	stream, err = client.ConsumeStream(ctx, &api_v1.ConsumeRequest{
+		Offset:    3,
+		EndOffset: &end,
+	})
+	require.NoError(t, err)
+	for i := uint64(3); i < 5; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, i, res.Record.Offset)
+	}
+	produce(5)
+	recv(stream, 5, 7)
+}
 
-// 	return client, cfg, func() {
-// 		server.Stop()
-// 		cc.Close()
-// 		l.Close()
-// 	}
-// }
+func testConsumeStreamFollow(
+	t *testing.T,
+	client, _ api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
 
-// func setupTest2(t *testing.T, fn func(*Config)) (
-// 	client api_v1.LogClient,
-// 	cfg *Config,
-// 	teardown func(),
-// ) {
-// 	t.Helper()
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	recv := func(stream api_v1.Log_ConsumeStreamClient, from, to uint64) {
+		for i := from; i <= to; i++ {
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, i, res.Record.Offset)
+		}
+	}
 
-// 	l, err := net.Listen("tcp", "127.0.0.1:0")
-// 	require.NoError(t, err)
+	// follow가 false면 마지막 레코드를 보내고 끝난다.
+	follow := false
+	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{Follow: &follow})
+	require.NoError(t, err)
+	recv(stream, 0, 2)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	// true면 끝에서 기다렸다가 새로 쓴 레코드를 보낸다.
+	follow = true
+	followCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err = client.ConsumeStream(followCtx, &api_v1.ConsumeRequest{Follow: &follow})
+	require.NoError(t, err)
+	recv(stream, 0, 2)
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("appended")},
+	})
+	require.NoError(t, err)
+	recv(stream, 3, 3)
+
+	stream, err = client.ConsumeStream(ctx, &api_v1.ConsumeRequest{Follow: &follow, StopAtHead: true})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testConsumeStreamBatch(
+	t *testing.T,
+	client, _ api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
+	const total = 10000
+	for i := 0; i < total; i += 1000 {
+		req := &api_v1.ProduceBatchRequest{}
+		for j := 0; j < 1000; j++ {
+			req.Records = append(req.Records, &api_v1.Record{Value: []byte("record")})
+		}
+		_, err := client.ProduceBatch(ctx, req)
+		require.NoError(t, err)
+	}
+
+	// window가 길어도 스트림이 끝나면 남은 레코드를 바로 보낸다. batch_size가
+	// 아무리 커도 maxBatchSize개씩만 보낸다.
+	stream, err := client.ConsumeStreamBatch(ctx, &api_v1.ConsumeRequest{
+		StopAtHead:    true,
+		BatchSize:     math.MaxUint32,
+		BatchWindowMs: uint32(time.Minute / time.Millisecond),
+	})
+	require.NoError(t, err)
+	var received, batches int
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(res.Records), maxBatchSize)
+		for _, record := range res.Records {
+			require.Equal(t, uint64(received), record.Offset)
+			received++
+		}
+		batches++
+	}
+	require.Equal(t, total, received)
+	require.GreaterOrEqual(t, batches, total/maxBatchSize)
+
+	// 레코드가 모자라면 window가 지난 뒤에 모인 만큼 보낸다.
+	follow := true
+	followCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err = client.ConsumeStreamBatch(followCtx, &api_v1.ConsumeRequest{
+		Offset:        total,
+		Follow:        &follow,
+		BatchWindowMs: 20,
+	})
+	require.NoError(t, err)
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("late")},
+	})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, res.Records, 1)
+	require.Equal(t, uint64(total), res.Records[0].Offset)
+}
+
+func testConsumeMany(
+	t *testing.T,
+	client, nobody api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
+
+	records := []*api_v1.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second"), ExpireAt: time.Now().Add(-time.Minute).UnixNano()},
+		{Value: []byte("third")},
+	}
+	for _, record := range records {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: record})
+		require.NoError(t, err)
+	}
+
+	res, err := client.ConsumeMany(ctx, &api_v1.ConsumeManyRequest{
+		Offsets: []uint64{2, 7, 0, 1, 2},
+	})
+	require.NoError(t, err)
+	want := []struct {
+		offset uint64
+		status api_v1.ReadStatus
+		value  string
+	}{
+		{2, api_v1.ReadStatus_FOUND, "third"},
+		{7, api_v1.ReadStatus_OUT_OF_RANGE, ""},
+		{0, api_v1.ReadStatus_FOUND, "first"},
+		{1, api_v1.ReadStatus_EXPIRED, ""},
+		{2, api_v1.ReadStatus_FOUND, "third"},
+	}
+	require.Len(t, res.Results, len(want))
+	for i, w := range want {
+		got := res.Results[i]
+		require.Equal(t, w.offset, got.Offset)
+		require.Equal(t, w.status, got.Status)
+		if w.status == api_v1.ReadStatus_FOUND {
+			require.Equal(t, w.offset, got.Record.Offset)
+			require.Equal(t, w.value, string(got.Record.Value))
+		} else {
+			require.Nil(t, got.Record)
+		}
+	}
+
+	_, err = nobody.ConsumeMany(ctx, &api_v1.ConsumeManyRequest{Offsets: []uint64{0}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func testProduceBatch(
+	t *testing.T,
+	client, nobody api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
+
+	const n = 1000
+	req := &api_v1.ProduceBatchRequest{}
+	for i := 0; i < n; i++ {
+		req.Records = append(req.Records, &api_v1.Record{
+			Value: []byte(fmt.Sprintf("record %d", i)),
+		})
+	}
+	res, err := client.ProduceBatch(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, res.Offsets, n)
+	for i, offset := range res.Offsets {
+		require.Equal(t, uint64(i), offset)
+	}
+
+	for _, offset := range []uint64{0, 499, n - 1} {
+		consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: offset})
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", offset), string(consume.Record.Value))
+	}
+
+	_, err = nobody.ProduceBatch(ctx, req)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func testConsumeStreamSkipsExpired(
+	t *testing.T,
+	client, _ api_v1.LogClient,
+	config *Config,
+) {
+	ctx := context.Background()
+
+	records := []*api_v1.Record{
+		{Value: []byte("first"), ExpireAt: time.Now().Add(-time.Minute).UnixNano()},
+		{Value: []byte("second")},
+	}
+	for _, record := range records {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: record})
+		require.NoError(t, err)
+	}
+
+	_, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Record.Offset)
+	require.Equal(t, []byte("second"), res.Record.Value)
+}
+
+func testSegmentInfo(t *testing.T, client api_v1.LogClient, expose bool) {
+	ctx := context.Background()
+
+	// 세그먼트가 한 번 바뀔 때까지 쓴다.
+	var base uint64
+	for i := 0; i < 100; i++ {
+		res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+		if !expose {
+			require.Zero(t, res.SegmentBaseOffset)
+			require.Empty(t, res.SegmentName)
+			continue
+		}
+		if res.SegmentBaseOffset != base {
+			require.Equal(t, res.Offset, res.SegmentBaseOffset)
+			base = res.SegmentBaseOffset
+		}
+		require.Equal(t, fmt.Sprintf("%d.store", base), res.SegmentName)
+	}
+	if expose {
+		require.NotZero(t, base)
+	}
+}
+
+func TestConsumeStartPosition(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		existing int
+		req      *api_v1.ConsumeRequest
+		want     uint64
+	}{
+		"earliest reads from the start": {
+			existing: 3,
+			req:      &api_v1.ConsumeRequest{Offset: 2, StartPosition: api_v1.StartPosition_EARLIEST},
+			want:     0,
+		},
+		"latest only reads new records": {
+			existing: 3,
+			req:      &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST},
+			want:     3,
+		},
+		"latest on an empty log": {
+			req:  &api_v1.ConsumeRequest{StartPosition: api_v1.StartPosition_LATEST},
+			want: 0,
+		},
+		"offset reads from the given offset": {
+			existing: 3,
+			req:      &api_v1.ConsumeRequest{Offset: 1},
+			want:     1,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client, _, _, teardown := setupTest(t, nil)
+			defer teardown()
+
+			ctx := context.Background()
+			produce := func() {
+				_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+					Record: &api_v1.Record{Value: []byte("hello world")},
+				})
+				require.NoError(t, err)
+			}
+			for i := 0; i < tc.existing; i++ {
+				produce()
+			}
+
+			stream, err := client.ConsumeStream(ctx, tc.req)
+			require.NoError(t, err)
+			// 스트림이 시작 위치를 정한 다음에 새 레코드를 쓴다.
+			time.Sleep(50 * time.Millisecond)
+			produce()
+
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, tc.want, res.Record.Offset)
+		})
+	}
+}
+
+func TestConsumerLag(t *testing.T) {
+	paused := &pausedLog{}
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		paused.CommitLog = c.CommitLog
+		c.CommitLog = paused
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	produce := func(n int) {
+		for i := 0; i < n; i++ {
+			_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+		}
+	}
+	produce(1)
+	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return consumerLagFor(t, "root") == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// 스트림이 로그를 읽지 못하게 멈추면 쓰는 만큼 뒤처진다.
+	paused.mu.Lock()
+	produce(3)
+	require.Equal(t, int64(3), consumerLagFor(t, "root"))
+	produce(2)
+	require.Equal(t, int64(5), consumerLagFor(t, "root"))
+	paused.mu.Unlock()
+
+	for i := 0; i < 5; i++ {
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		return consumerLagFor(t, "root") == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func consumerLagFor(t *testing.T, subject string) int64 {
+	t.Helper()
+	for _, m := range Metrics.Read() {
+		if m.Descriptor.Name != "proglog/server/consumer_lag" {
+			continue
+		}
+		for _, ts := range m.TimeSeries {
+			if ts.LabelValues[0].Value == subject {
+				return ts.Points[0].Value.(int64)
+			}
+		}
+	}
+	t.Fatalf("no consumer lag for %s", subject)
+	return 0
+}
+
+// pausedLog는 mu를 잡고 있는 동안 Read를 멈춘다.
+type pausedLog struct {
+	CommitLog
+	mu sync.Mutex
+}
+
+func (p *pausedLog) Read(off uint64) (*api_v1.Record, error) {
+	p.mu.Lock()
+	p.mu.Unlock()
+	return p.CommitLog.Read(off)
+}
+
+func TestMaxStreamsPerSubject(t *testing.T) {
+	rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = allowAll{}
+		c.MaxStreamsPerSubject = 2
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	produce := func() uint64 {
+		res, err := rootClient.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+		return res.Offset
+	}
+	// 첫 레코드를 받았으면 서버가 스트림을 등록한 것이다.
+	open := func(client api_v1.LogClient) api_v1.Log_ConsumeStreamClient {
+		stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{})
+		require.NoError(t, err)
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), res.Record.Offset)
+		return stream
+	}
+
+	produce()
+	existing := []api_v1.Log_ConsumeStreamClient{open(rootClient), open(rootClient)}
+
+	stream, err := rootClient.ConsumeStream(ctx, &api_v1.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 이미 열린 스트림은 계속 새 레코드를 받는다.
+	off := produce()
+	for _, stream := range existing {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, off, res.Record.Offset)
+	}
+
+	// 다른 구독자는 제한을 따로 센다.
+	open(nobodyClient)
+}
+
+func TestBackfillOnJoin(t *testing.T) {
+	paused := &pausedLog{}
+	leaderAddr, _, leaderTeardown := setupServer(t, func(c *Config) {
+		paused.CommitLog = c.CommitLog
+		c.CommitLog = paused
+	})
+	defer leaderTeardown()
+	leaderConn, leader := newClient(t, leaderAddr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer leaderConn.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_, err := leader.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	replicator := &log.Replicator{
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		},
+	}
+	defer replicator.Close()
+	followerAddr, _, followerTeardown := setupServer(t, func(c *Config) {
+		c.CaughtUp = replicator.CaughtUp()
+	})
+	defer followerTeardown()
+	followerConn, follower := newClient(t, followerAddr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer followerConn.Close()
+	replicator.LocalServer = follower
+
+	healthStatus := func() healthpb.HealthCheckResponse_ServingStatus {
+		res, err := healthpb.NewHealthClient(followerConn).Check(ctx, &healthpb.HealthCheckRequest{
+			Service: api_v1.Log_ServiceDesc.ServiceName,
+		})
+		require.NoError(t, err)
+		return res.Status
+	}
+
+	// 리더가 레코드를 넘겨주지 못하는 동안 팔로워는 읽기를 받지 않는다.
+	paused.mu.Lock()
+	require.NoError(t, replicator.Join("leader", leaderAddr))
+	_, err = follower.Consume(ctx, &api_v1.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	stream, err := follower.ConsumeStream(ctx, &api_v1.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus())
+	paused.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		return healthStatus() == healthpb.HealthCheckResponse_SERVING
+	}, 3*time.Second, 10*time.Millisecond)
+	// 따라잡았다고 알린 때에는 리더에 있던 레코드가 모두 있다.
+	for i := uint64(0); i < 10; i++ {
+		res, err := follower.Consume(ctx, &api_v1.ConsumeRequest{Offset: i})
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("record %d", i)), res.Record.Value)
+	}
+
+	// 그 뒤로 리더에 쓴 레코드도 계속 복제된다.
+	_, err = leader.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("record 10")},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		res, err := follower.Consume(ctx, &api_v1.ConsumeRequest{Offset: 10})
+		return err == nil && string(res.Record.Value) == "record 10"
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestPromoteStandby(t *testing.T) {
+	leaderAddr, _, leaderTeardown := setupServer(t, nil)
+	defer leaderTeardown()
+	leaderConn, leader := newClient(t, leaderAddr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer leaderConn.Close()
+
+	ctx := context.Background()
+	produce := func(client api_v1.LogClient, value string) (uint64, error) {
+		res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(value)},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return res.Offset, nil
+	}
+	for i := 0; i < 5; i++ {
+		_, err := produce(leader, fmt.Sprintf("record %d", i))
+		require.NoError(t, err)
+	}
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	replicator := &log.Replicator{
+		DialOptions: []grpc.DialOption{
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		},
+	}
+	standby := &Standby{Replicator: replicator}
+	standbyAddr, _, standbyTeardown := setupServer(t, func(c *Config) {
+		replicator.LocalLog = c.CommitLog.(*log.Log)
+		standby.Log = replicator.LocalLog
+		c.Standby = standby
+	})
+	defer standbyTeardown()
+	standbyConn, follower := newClient(t, standbyAddr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer standbyConn.Close()
+	require.NoError(t, replicator.Join("leader", leaderAddr))
+
+	// 승격하기 전에는 클라이언트의 쓰기를 받지 않는다.
+	_, err = produce(follower, "too early")
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = produce(leader, "record 5")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		res, err := follower.Consume(ctx, &api_v1.ConsumeRequest{Offset: 5})
+		return err == nil && string(res.Record.Value) == "record 5"
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, standby.Promote())
+	require.True(t, standby.Promoted())
+
+	// 승격한 뒤에는 마지막으로 복제한 레코드 바로 다음에 쓴다.
+	off, err := produce(follower, "record 6")
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+
+	// 옛 리더에 쓴 레코드는 더 이상 복제되지 않는다.
+	_, err = produce(leader, "stale")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	for i := uint64(0); i <= 6; i++ {
+		res, err := follower.Consume(ctx, &api_v1.ConsumeRequest{Offset: i})
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", i), string(res.Record.Value))
+	}
+	_, err = follower.Consume(ctx, &api_v1.ConsumeRequest{Offset: 7})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestConsumeCoalescesReads(t *testing.T) {
+	const consumers = 20
+	counting := &countingLog{release: make(chan struct{})}
+	authorizer := &countingAuthorizer{Authorizer: allowAll{}}
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		counting.CommitLog = c.CommitLog
+		c.CommitLog = counting
+		c.Authorizer = authorizer
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	authorizer.calls.Store(0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, consumers)
+	for i := 0; i < consumers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 0})
+			if err == nil && string(res.Record.Value) != "hello world" {
+				err = fmt.Errorf("got %q", res.Record.Value)
+			}
+			errs <- err
+		}()
+	}
+	// 모든 요청이 권한 확인을 지나 읽기를 기다릴 때까지 첫 읽기를 붙잡아 둔다.
+	require.Eventually(t, func() bool {
+		return authorizer.calls.Load() == consumers
+	}, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(counting.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(1), counting.reads.Load())
+}
+
+// countingLog는 Read 횟수를 세고 release가 닫힐 때까지 Read를 멈춘다.
+type countingLog struct {
+	CommitLog
+	reads   atomic.Int64
+	release chan struct{}
+}
+
+func (c *countingLog) Read(off uint64) (*api_v1.Record, error) {
+	c.reads.Add(1)
+	<-c.release
+	return c.CommitLog.Read(off)
+}
+
+type countingAuthorizer struct {
+	Authorizer
+	calls atomic.Int64
+}
+
+func (c *countingAuthorizer) Authorize(subject, object, action string) error {
+	c.calls.Add(1)
+	return c.Authorizer.Authorize(subject, object, action)
+}
+
+func TestConsumeStreamBatchLimitsBytes(t *testing.T) {
+	addr, _, teardown := setupServer(t, nil)
+	defer teardown()
+	conn, client := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer conn.Close()
+
+	ctx := context.Background()
+	const total = 10
+	value := bytes.Repeat([]byte("x"), 300<<10)
+	for i := 0; i < total; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: &api_v1.Record{Value: value}})
+		require.NoError(t, err)
+	}
+
+	// 레코드 수로는 한 배치지만 1MiB를 넘지 않게 나눠 보낸다.
+	stream, err := client.ConsumeStreamBatch(ctx, &api_v1.ConsumeRequest{
+		StopAtHead:    true,
+		BatchSize:     total,
+		BatchWindowMs: uint32(time.Minute / time.Millisecond),
+	})
+	require.NoError(t, err)
+	var received, batches int
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.LessOrEqual(t, proto.Size(res), maxBatchBytes)
+		received += len(res.Records)
+		batches++
+	}
+	require.Equal(t, total, received)
+	require.GreaterOrEqual(t, batches, 4)
+}
+
+func TestAppendTimeout(t *testing.T) {
+	blocking := &blockingLog{}
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		blocking.CommitLog = c.CommitLog
+		c.CommitLog = blocking
+		c.AppendTimeout = 50 * time.Millisecond
+	})
+	defer teardown()
+	conn, client := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer conn.Close()
+
+	ctx := context.Background()
+	healthStatus := func() healthpb.HealthCheckResponse_ServingStatus {
+		res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+			Service: api_v1.Log_ServiceDesc.ServiceName,
+		})
+		require.NoError(t, err)
+		return res.Status
+	}
+	produce := func(value string) error {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(value)},
+		})
+		return err
+	}
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus())
+
+	// 디스크가 멈추면 Produce는 기다리지 않고 실패하고 서버는 망가진 상태가 된다.
+	blocking.mu.Lock()
+	require.Equal(t, codes.DeadlineExceeded, status.Code(produce("stuck")))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus())
+	require.Equal(t, codes.Unavailable, status.Code(produce("rejected")))
+	// Produce가 리턴해 요청의 ctx가 취소돼도 쓰기가 끝나기 전에는 풀리지 않는다.
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus())
+	require.Equal(t, codes.Unavailable, status.Code(produce("rejected")))
+	blocking.mu.Unlock()
+
+	// 멈췄던 쓰기가 끝나면 다시 쓰기를 받는다. 시간을 넘긴 레코드도 쓰여 있다.
+	require.Eventually(t, func() bool {
+		return healthStatus() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+	res, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("stuck"), res.Record.Value)
+	require.NoError(t, produce("after"))
+}
+
+func TestAppendTimeoutIdempotencyKey(t *testing.T) {
+	blocking := &blockingLog{}
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		blocking.CommitLog = c.CommitLog
+		c.CommitLog = blocking
+		c.AppendTimeout = 50 * time.Millisecond
+	})
+	defer teardown()
+	conn, client := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer conn.Close()
+
+	ctx := context.Background()
+	produce := func(key string) (uint64, error) {
+		res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record:         &api_v1.Record{Value: []byte("hello world")},
+			IdempotencyKey: key,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return res.Offset, nil
+	}
+
+	// 시간을 넘긴 쓰기가 끝나기 전에 같은 키로 다시 보내면 쓰지 않고 거부한다.
+	blocking.mu.Lock()
+	_, err := produce("a")
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	_, err = produce("a")
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, api_v1.ReasonAppendPending, api_v1.ErrorReason(err))
+	blocking.mu.Unlock()
+
+	// 쓰기가 끝나면 다시 보낸 요청은 그때 쓰인 오프셋을 받는다.
+	require.Eventually(t, func() bool {
+		off, err := produce("a")
+		return err == nil && off == 0
+	}, time.Second, 10*time.Millisecond)
+	off, err := produce("b")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
+
+// blockingLog는 mu를 잡고 있는 동안 Append를 멈춘다.
+type blockingLog struct {
+	CommitLog
+	mu sync.Mutex
+}
+
+func (b *blockingLog) Append(record *api_v1.Record) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.CommitLog.Append(record)
+}
+
+// AppendContext는 *log.Log처럼 ctx가 끝나면 기다리지 않고 ctx.Err()를 리턴하고,
+// 쓰기는 뒤에서 계속된다.
+func (b *blockingLog) AppendContext(ctx context.Context, record *api_v1.Record) (uint64, error) {
+	type result struct {
+		off uint64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		off, err := b.Append(record)
+		done <- result{off, err}
+	}()
+	select {
+	case r := <-done:
+		return r.off, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func TestHandlersHonorContext(t *testing.T) {
+	storage := &stallingStorage{StoreStorage: log.NewMemoryStorage()}
+	c := log.Config{}
+	c.Store.Storage = storage
+	c.Store.Sync = log.SyncEveryWrite
+	clog, err := log.NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+	defer clog.Close()
+	srv, err := newgrpcServer(&Config{CommitLog: clog, Authorizer: allowAll{}})
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), subjectContextKey{}, "root")
+	_, err = srv.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	// 디스크가 멈춰도 핸들러는 요청의 기한이 지나면 바로 돌아온다.
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	for name, call := range map[string]func(context.Context) error{
+		"produce": func(ctx context.Context) error {
+			_, err := srv.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("stuck")},
+			})
+			return err
+		},
+		"consume": func(ctx context.Context) error {
+			_, err := srv.Consume(ctx, &api_v1.ConsumeRequest{Offset: 0})
+			return err
+		},
+	} {
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		start := time.Now()
+		err := call(ctx)
+		cancel()
+		require.Equal(t, codes.DeadlineExceeded, status.Code(err), name)
+		require.Less(t, time.Since(start), time.Second, name)
+	}
+}
+
+// stallingStorage는 mu를 잡고 있는 동안 스토어 읽기와 쓰기를 멈춘다.
+type stallingStorage struct {
+	log.StoreStorage
+	mu sync.Mutex
+}
+
+func (s *stallingStorage) Open(name string) (log.StoreBackend, error) {
+	b, err := s.StoreStorage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return stallingBackend{StoreBackend: b, mu: &s.mu}, nil
+}
+
+type stallingBackend struct {
+	log.StoreBackend
+	mu *sync.Mutex
+}
+
+func (b stallingBackend) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.StoreBackend.WriteAt(p, off)
+}
+
+func (b stallingBackend) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.StoreBackend.ReadAt(p, off)
+}
+
+func TestProduceDeadline(t *testing.T) {
+	newLog := func(t *testing.T, storage log.StoreStorage) *log.Log {
+		c := log.Config{}
+		c.Store.Storage = storage
+		c.Store.Sync = log.SyncEveryWrite
+		clog, err := log.NewLog(t.TempDir(), c)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		return clog
+	}
+	slow := func() log.StoreStorage {
+		return &slowStorage{StoreStorage: log.NewMemoryStorage(), delay: 200 * time.Millisecond}
+	}
+
+	for scenario, clog := range map[string]CommitLog{
+		"slow log": newLog(t, slow()),
+		"slow tee secondary": &TeeCommitLog{
+			CommitLog: newLog(t, log.NewMemoryStorage()),
+			Secondary: newLog(t, slow()),
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			srv, err := newgrpcServer(&Config{CommitLog: clog, Authorizer: allowAll{}})
+			require.NoError(t, err)
+
+			// 디스크를 기다리지 않고 요청의 기한이 지나면 바로 DeadlineExceeded로 실패한다.
+			ctx := context.WithValue(context.Background(), subjectContextKey{}, "root")
+			ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = srv.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			})
+			require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+			require.Less(t, time.Since(start), 100*time.Millisecond)
+		})
+	}
+}
+
+// slowStorage는 스토어에 쓸 때마다 delay만큼 늦춘다.
+type slowStorage struct {
+	log.StoreStorage
+	delay time.Duration
+}
+
+func (s *slowStorage) Open(name string) (log.StoreBackend, error) {
+	b, err := s.StoreStorage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return slowBackend{StoreBackend: b, delay: s.delay}, nil
+}
+
+type slowBackend struct {
+	log.StoreBackend
+	delay time.Duration
+}
+
+func (b slowBackend) WriteAt(p []byte, off int64) (int, error) {
+	time.Sleep(b.delay)
+	return b.StoreBackend.WriteAt(p, off)
+}
+
+func TestProduceStreamReadYourWrites(t *testing.T) {
+	addr, _, teardown := setupServer(t, nil)
+	defer teardown()
+	producerConn, producer := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer producerConn.Close()
+	consumerConn, consumer := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer consumerConn.Close()
+
+	ctx := context.Background()
+	stream, err := producer.ProduceStream(ctx)
+	require.NoError(t, err)
+	defer stream.CloseSend()
+
+	// 스트림이 열려 있는 동안에도 응답받은 오프셋은 다른 클라이언트가 바로 읽을 수 있다.
+	for i := 0; i < 100; i++ {
+		value := []byte(fmt.Sprintf("record %d", i))
+		require.NoError(t, stream.Send(&api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: value},
+		}))
+		res, err := stream.Recv()
+		require.NoError(t, err)
+
+		got, err := consumer.Consume(ctx, &api_v1.ConsumeRequest{Offset: res.Offset})
+		require.NoError(t, err)
+		require.Equal(t, value, got.Record.Value)
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		version  string
+		wantCode codes.Code
+	}{
+		"missing header assumes the default": {wantCode: codes.OK},
+		"supported version passes":           {version: api_v1.APIVersion, wantCode: codes.OK},
+		"unsupported version is rejected":    {version: "99", wantCode: codes.FailedPrecondition},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			client, _, _, teardown := setupTest(t, nil)
+			defer teardown()
+
+			ctx := context.Background()
+			if tc.version != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, api_v1.APIVersionHeader, tc.version)
+			}
+			_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			})
+			require.Equal(t, tc.wantCode, status.Code(err))
+
+			stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{StopAtHead: true})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			if tc.wantCode == codes.OK {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, tc.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestUnobservedMethods(t *testing.T) {
+	spans := &spanRecorder{}
+	trace.RegisterExporter(spans)
+	defer trace.UnregisterExporter(spans)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dir, err := os.MkdirTemp("", "unobserved-test")
+	require.NoError(t, err)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+
+	gsrv, err := NewGRPCServer(&Config{CommitLog: clog, Authorizer: allowAll{}})
+	require.NoError(t, err)
+	go gsrv.Serve(l)
+	defer gsrv.Stop()
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api_v1.NewLogClient(conn)
+
+	ctx := context.Background()
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return completedRPCs(t, "log.v1.Log/Produce") > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Zero(t, completedRPCs(t, "grpc.health.v1.Health/Check"))
+
+	spans.mu.Lock()
+	defer spans.mu.Unlock()
+	var produce bool
+	for _, name := range spans.names {
+		require.NotContains(t, name, "Health")
+		produce = produce || strings.Contains(name, "Produce")
+	}
+	require.True(t, produce)
+}
+
+func completedRPCs(t *testing.T, method string) int64 {
+	t.Helper()
+	rows, err := view.RetrieveData(ocgrpc.ServerCompletedRPCsView.Name)
+	require.NoError(t, err)
+	var n int64
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == ocgrpc.KeyServerMethod && tag.Value == method {
+				n += row.Data.(*view.CountData).Value
+			}
+		}
+	}
+	return n
+}
+
+// allowAll은 모든 요청을 허가한다.
+type allowAll struct{}
+
+func (allowAll) Authorize(subject, object, action string) error {
+	return nil
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = append(r.names, s.Name)
+}
+
+func TestProduceDryRun(t *testing.T) {
+	client, nobody, cfg, teardown := setupTest(t, func(c *Config) {
+		c.Validators = []Validator{func(record *api_v1.Record) error {
+			if len(record.Value) == 0 {
+				return errors.New("empty record")
+			}
+			return nil
+		}}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	record := &api_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 2; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: record})
+		require.NoError(t, err)
+	}
+
+	res, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: record, DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.Offset)
+	highest, err := cfg.CommitLog.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), highest)
+
+	_, err = nobody.Produce(ctx, &api_v1.ProduceRequest{Record: record, DryRun: true})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{Record: &api_v1.Record{}, DryRun: true})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 실제로 쓰면 미리 받은 오프셋을 받는다.
+	res, err = client.Produce(ctx, &api_v1.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.Offset)
+}
+
+func TestTeeCommitLog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tee-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	secondary, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	for scenario, tc := range map[string]struct {
+		secondary CommitLog
+		policy    TeePolicy
+		wantErr   bool
+	}{
+		"records land in both logs": {secondary: secondary, policy: TeeStrict},
+		"strict secondary failure":  {secondary: failingLog{}, policy: TeeStrict, wantErr: true},
+		"lenient secondary failure": {secondary: failingLog{}, policy: TeeLogAndContinue},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			var primary CommitLog
+			client, _, _, teardown := setupTest(t, func(c *Config) {
+				primary = c.CommitLog
+				c.CommitLog = &TeeCommitLog{
+					CommitLog: c.CommitLog,
+					Secondary: tc.secondary,
+					Policy:    tc.policy,
+				}
+			})
+			defer teardown()
+
+			record := &api_v1.Record{Value: []byte("hello world")}
+			res, err := client.Produce(context.Background(), &api_v1.ProduceRequest{Record: record})
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			got, err := primary.Read(res.Offset)
+			require.NoError(t, err)
+			require.Equal(t, record.Value, got.Value)
+			if tc.secondary == secondary {
+				got, err = secondary.Read(0)
+				require.NoError(t, err)
+				require.Equal(t, record.Value, got.Value)
+			}
+		})
+	}
+}
+
+// failingLog는 모든 Append를 실패시킨다.
+type failingLog struct {
+	CommitLog
+}
+
+func (failingLog) Append(*api_v1.Record) (uint64, error) {
+	return 0, errors.New("secondary unavailable")
+}
+
+func (f failingLog) AppendContext(_ context.Context, record *api_v1.Record) (uint64, error) {
+	return f.Append(record)
+}
+
+// flakyLog는 처음 몇 번의 Read를 err로 실패시킨다.
+type flakyLog struct {
+	CommitLog
+	mu       sync.Mutex
+	failures int
+	err      error
+}
+
+func (f *flakyLog) Read(off uint64) (*api_v1.Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return nil, f.err
+	}
+	return f.CommitLog.Read(off)
+}
+
+// func setupTest1(t *testing.T, fn func(*Config)) (
+// 	client api_v1.LogClient,
+// 	cfg *Config,
+// 	teardown func(),
+// ) {
+// 	t.Helper()
+
+// 	t.Helper()
+
+// 	l, err := net.Listen("tcp", "127.0.0.1:0")
+// 	require.NoError(t, err)
+
+// 	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+// 		CAFile: config.CAFile,
+// 	})
+// 	require.NoError(t, err)
+
+// 	clientCreds := credentials.NewTLS(clientTLSConfig)
+// 	cc, err := grpc.Dial(
+// 		l.Addr().String(),
+// 		grpc.WithTransportCredentials(clientCreds),
+// 	)
+// 	require.NoError(t, err)
+
+// 	client = api_v1.NewLogClient(cc)
+
+// 	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+// 		CertFile:      config.ServerCertFile,
+// 		KeyFile:       config.ServerKeyFile,
+// 		CAFile:        config.CAFile,
+// 		ServerAddress: l.Addr().String(),
+// 	})
+// 	require.NoError(t, err)
+// 	serverCreds := credentials.NewTLS(serverTLSConfig)
+
+// 	dir, err := os.MkdirTemp("", "server-test")
+// 	require.NoError(t, err)
+
+// 	clog, err := log.NewLog(dir, log.Config{})
+// 	require.NoError(t, err)
+
+// 	cfg = &Config{
+// 		CommitLog: clog,
+// 	}
+// 	if fn != nil {
+// 		fn(cfg)
+// 	}
+// 	server, err := NewGRPCServer(cfg, grpc.Creds(serverCreds))
+// 	require.NoError(t, err)
+
+// 	go func() {
+// 		server.Serve(l)
+// 	}()
+
+// 	return client, cfg, func() {
+// 		server.Stop()
+// 		cc.Close()
+// 		l.Close()
+// 	}
+// }
+
+// func setupTest2(t *testing.T, fn func(*Config)) (
+// 	client api_v1.LogClient,
+// 	cfg *Config,
+// 	teardown func(),
+// ) {
+// 	t.Helper()
+
+// 	l, err := net.Listen("tcp", "127.0.0.1:0")
+// 	require.NoError(t, err)
 
 // 	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
 // 		CertFile: config.ClientCertFile,
@@ -380,47 +2065,1402 @@ func testUnauthorized(
 // 	})
 // 	require.NoError(t, err)
 
-// 	clientCreds := credentials.NewTLS(clientTLSConfig)
-// 	cc, err := grpc.NewClient(
-// 		l.Addr().String(),
-// 		grpc.WithTransportCredentials(clientCreds),
-// 	)
-// 	require.NoError(t, err)
+// 	clientCreds := credentials.NewTLS(clientTLSConfig)
+// 	cc, err := grpc.NewClient(
+// 		l.Addr().String(),
+// 		grpc.WithTransportCredentials(clientCreds),
+// 	)
+// 	require.NoError(t, err)
+
+// 	client = api_v1.NewLogClient(cc)
+
+// 	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+// 		CertFile:      config.ServerCertFile,
+// 		KeyFile:       config.ServerKeyFile,
+// 		CAFile:        config.CAFile,
+// 		ServerAddress: l.Addr().String(),
+// 		Server:        true,
+// 	})
+// 	require.NoError(t, err)
+// 	serverCreds := credentials.NewTLS(serverTLSConfig)
+
+// 	dir, err := os.MkdirTemp("", "server-test")
+// 	require.NoError(t, err)
+
+// 	clog, err := log.NewLog(dir, log.Config{})
+// 	require.NoError(t, err)
+
+// 	cfg = &Config{
+// 		CommitLog: clog,
+// 	}
+// 	if fn != nil {
+// 		fn(cfg)
+// 	}
+// 	server, err := NewGRPCServer(cfg, grpc.Creds(serverCreds))
+// 	require.NoError(t, err)
+
+// 	go func() {
+// 		server.Serve(l)
+// 	}()
+
+// 	return client, cfg, func() {
+// 		server.Stop()
+// 		cc.Close()
+// 		l.Close()
+// 	}
+// }
+
+func TestProduceBatchPartialFailure(t *testing.T) {
+	client, _, cfg, teardown := setupTest(t, func(c *Config) {
+		c.Validators = []Validator{func(record *api_v1.Record) error {
+			if len(record.Value) == 0 {
+				return errors.New("empty record")
+			}
+			return nil
+		}}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.ProduceBatch(ctx, &api_v1.ProduceBatchRequest{
+		Records: []*api_v1.Record{
+			{Value: []byte("first")},
+			{Value: []byte("second")},
+			{},
+			{Value: []byte("fourth")},
+		},
+	})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	// 실패한 위치와 그 앞까지 받은 오프셋으로 이어서 보낼 수 있다.
+	var info *errdetails.ErrorInfo
+	var written *api_v1.ProduceBatchResponse
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *api_v1.ProduceBatchResponse:
+			written = d
+		}
+	}
+	require.NotNil(t, info)
+	require.Equal(t, "BATCH_FAILED", info.Reason)
+	require.Equal(t, "2", info.Metadata["failed_index"])
+	require.NotNil(t, written)
+	require.Equal(t, []uint64{0, 1}, written.Offsets)
+
+	highest, err := cfg.CommitLog.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), highest)
+}
+
+func TestTruncate(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		// 세그먼트마다 레코드 세 개가 들어가도록 인덱스를 작게 잡는다.
+		lc := log.Config{}
+		lc.Segment.MaxIndexBytes = 3 * 12
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	produce := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+		}
+	}
+	// 세그먼트는 [0, 2], [3, 5], [6, 8], [9]이다.
+	produce(10)
+
+	for _, tc := range []struct {
+		lowest     uint64
+		wantLowest uint64
+	}{
+		// 5보다 작은 레코드만 담은 세그먼트는 [0, 2]뿐이다.
+		{lowest: 5, wantLowest: 3},
+		{lowest: 9, wantLowest: 9},
+		// 로그 끝을 넘어도 활성 세그먼트는 남는다.
+		{lowest: 100, wantLowest: 9},
+	} {
+		res, err := client.Truncate(ctx, &api_v1.TruncateRequest{Lowest: tc.lowest})
+		require.NoError(t, err)
+		require.Equal(t, tc.wantLowest, res.LowestOffset)
+
+		_, err = client.Consume(ctx, &api_v1.ConsumeRequest{Offset: tc.wantLowest - 1})
+		got := status.Convert(err)
+		require.Equal(t, api_v1.ErrOffsetOutOfRange{}.GRPCStatus().Code(), got.Code())
+		require.Contains(t, got.Message(), "offset out of range")
+
+		consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: tc.wantLowest})
+		require.NoError(t, err)
+		require.Equal(t, tc.wantLowest, consume.Record.Offset)
+	}
+
+	// 지운 뒤에도 이어서 쓸 수 있다.
+	res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("after truncate")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), res.Offset)
+
+	_, err = nobody.Truncate(ctx, &api_v1.TruncateRequest{Lowest: 10})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// probeLog는 failing이 켜져 있으면 프로브에 실패하는 로그다.
+type probeLog struct {
+	CommitLog
+	failing atomic.Bool
+}
+
+func (p *probeLog) Probe() error {
+	if p.failing.Load() {
+		return errors.New("disk gone")
+	}
+	return nil
+}
+
+func TestHealth(t *testing.T) {
+	probe := &probeLog{}
+	shuttingDown := make(chan struct{})
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		probe.CommitLog = c.CommitLog
+		c.CommitLog = probe
+		c.ShuttingDown = shuttingDown
+	})
+	defer teardown()
+	conn, _ := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer conn.Close()
+
+	ctx := context.Background()
+	healthStatus := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+			Service: service,
+		})
+		require.NoError(t, err)
+		return res.Status
+	}
+	logService := api_v1.Log_ServiceDesc.ServiceName
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(logService))
+
+	// 로그를 쓸 수 없으면 Log 서비스만 NOT_SERVING이고 되살아나면 돌아온다.
+	probe.failing.Store(true)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(logService))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(""))
+	probe.failing.Store(false)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, healthStatus(logService))
+
+	// 종료를 시작하면 로그가 멀쩡해도 모두 NOT_SERVING이다.
+	close(shuttingDown)
+	require.Eventually(t, func() bool {
+		return healthStatus("") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, healthStatus(logService))
+}
+
+func TestReflection(t *testing.T) {
+	for scenario, enabled := range map[string]bool{
+		"reflection enabled":  true,
+		"reflection disabled": false,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			addr, _, teardown := setupServer(t, func(c *Config) {
+				c.EnableReflection = enabled
+			})
+			defer teardown()
+			conn, _ := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+			defer conn.Close()
+
+			stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+			}))
+			res, err := stream.Recv()
+			if !enabled {
+				require.Equal(t, codes.Unimplemented, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			var services []string
+			for _, s := range res.GetListServicesResponse().Service {
+				services = append(services, s.Name)
+			}
+			require.Contains(t, services, api_v1.Log_ServiceDesc.ServiceName)
+			require.NoError(t, stream.CloseSend())
+		})
+	}
+}
+
+// readCountingLog는 Read와 ReadContext가 불린 횟수를 센다. *log.Log를 품고
+// 있어서 HighWatermarkChanged도 그대로 있다.
+type readCountingLog struct {
+	*log.Log
+	reads atomic.Int64
+}
+
+func (r *readCountingLog) Read(off uint64) (*api_v1.Record, error) {
+	r.reads.Add(1)
+	return r.Log.Read(off)
+}
+
+func (r *readCountingLog) ReadContext(ctx context.Context, off uint64) (*api_v1.Record, error) {
+	r.reads.Add(1)
+	return r.Log.ReadContext(ctx, off)
+}
+
+func TestConsumeStreamFollowsWithoutSpinning(t *testing.T) {
+	counting := &readCountingLog{}
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		counting.Log = c.CommitLog.(*log.Log)
+		c.CommitLog = counting
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.ConsumeStream(ctx, &api_v1.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	received := make(chan *api_v1.ConsumeResponse)
+	go func() {
+		res, err := stream.Recv()
+		if err == nil {
+			received <- res
+		}
+	}()
+
+	// 레코드가 없는 동안 스트림은 로그를 계속 읽지 않고 기다린다.
+	time.Sleep(100 * time.Millisecond)
+	require.LessOrEqual(t, counting.reads.Load(), int64(2))
+
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	select {
+	case res := <-received:
+		require.Equal(t, []byte("hello world"), res.Record.Value)
+	case <-time.After(time.Second):
+		t.Fatal("stream did not receive the record")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		// 테스트 중에 토큰이 다시 차지 않도록 아주 느리게 채운다.
+		c.RateLimit = 0.001
+		c.RateBurst = 2
+	})
+	defer teardown()
+	rootConn, rootClient := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer rootConn.Close()
+	nobodyConn, nobodyClient := newClient(t, addr, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	defer nobodyConn.Close()
+
+	ctx := context.Background()
+	produce := func(client api_v1.LogClient) error {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Topic:  "topic-a",
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		return err
+	}
+	require.NoError(t, produce(rootClient))
+	require.NoError(t, produce(rootClient))
+	err := produce(rootClient)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 스트림도 열 때 토큰을 쓴다.
+	stream, err := rootClient.ConsumeStream(ctx, &api_v1.ConsumeRequest{Topic: "topic-a"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 버킷은 구독자마다 따로다.
+	require.NoError(t, produce(nobodyClient))
+
+	// 헬스 체크는 세지 않는다.
+	for i := 0; i < 3; i++ {
+		_, err = healthpb.NewHealthClient(rootConn).Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	addr, _, teardown := setupServer(t, func(c *Config) {
+		c.Registry = reg
+	})
+	defer teardown()
+	conn, client := newClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile)
+	defer conn.Close()
+	metrics := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer metrics.Close()
+
+	// value는 /metrics에서 긁어 온 name 지표의 값이다.
+	value := func(name string) float64 {
+		res, err := http.Get(metrics.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		families, err := new(expfmt.TextParser).TextToMetricFamilies(res.Body)
+		require.NoError(t, err)
+		family, ok := families[name]
+		if !ok {
+			return 0
+		}
+		var sum float64
+		for _, m := range family.Metric {
+			switch {
+			case m.Counter != nil:
+				sum += m.Counter.GetValue()
+			case m.Histogram != nil:
+				sum += float64(m.Histogram.GetSampleCount())
+			}
+		}
+		return sum
+	}
+
+	ctx := context.Background()
+	record := &api_v1.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: record})
+		require.NoError(t, err)
+		require.Equal(t, float64((i+1)*proto.Size(record)), value("log_append_bytes_total"))
+	}
+	_, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, float64(1), value("log_consumed_records_total"))
+	require.Equal(t, float64(4), value("log_operation_duration_seconds"))
+	require.Equal(t, float64(4), value("grpc_server_handled_total"))
+
+	// 헬스 체크는 RPC 지표에 넣지 않는다.
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, float64(4), value("grpc_server_handled_total"))
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(context.Background())
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.TracerProvider = tp
+	})
+	defer teardown()
+
+	// 호출자의 트레이스를 traceparent로 넘긴다.
+	traceID, err := oteltrace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	_, err = client.Consume(ctx, &api_v1.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	produces := spans["/log.v1.Log/Produce"]
+	require.Len(t, produces, 3)
+	appends := spans["log.Append"]
+	require.Len(t, appends, 3)
+	for i, span := range appends {
+		// log.Append는 Produce 스팬 아래에 있고 Produce는 호출자의 트레이스를 잇는다.
+		require.Equal(t, traceID, span.SpanContext().TraceID())
+		require.Equal(t, produces[i].SpanContext().SpanID(), span.Parent().SpanID())
+		require.Equal(t, "00f067aa0ba902b7", produces[i].Parent().SpanID().String())
+		require.Contains(t, span.Attributes(), attribute.Int64("log.offset", int64(i)))
+		require.Contains(t, span.Attributes(), attribute.Int64("log.segment_base_offset", 0))
+	}
+
+	require.Len(t, spans["/log.v1.Log/Consume"], 1)
+	reads := spans["log.Read"]
+	require.Len(t, reads, 1)
+	require.Contains(t, reads[0].Attributes(), attribute.Int64("log.offset", 1))
+	require.Equal(t, spans["/log.v1.Log/Consume"][0].SpanContext().SpanID(), reads[0].Parent().SpanID())
+}
+
+func TestBackupRestore(t *testing.T) {
+	src, nobody, _, teardown := setupTest(t, nil)
+	defer teardown()
+	dst, _, dstCfg, dstTeardown := setupTest(t, nil)
+	defer dstTeardown()
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		_, err := src.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i)), Key: []byte{byte(i)}},
+		})
+		require.NoError(t, err)
+	}
+
+	// backup은 src의 레코드를 모두 받아 온다.
+	backup := func(client api_v1.LogClient) ([]*api_v1.Record, error) {
+		stream, err := client.Backup(ctx, &api_v1.BackupRequest{})
+		require.NoError(t, err)
+		var records []*api_v1.Record
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return records, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, res.Record)
+		}
+	}
+	_, err := backup(nobody)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	records, err := backup(src)
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+
+	restore := func(records []*api_v1.Record) (*api_v1.RestoreResponse, error) {
+		stream, err := dst.Restore(ctx)
+		require.NoError(t, err)
+		for _, record := range records {
+			if err := stream.Send(&api_v1.RestoreRequest{Record: record}); err != nil {
+				break
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+	res, err := restore(records)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), res.Restored)
+	for _, want := range records {
+		got, err := dstCfg.CommitLog.Read(want.Offset)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(want, got))
+	}
+
+	// 빈 로그에만 복원할 수 있다.
+	_, err = restore(records)
+	st := status.Convert(err)
+	require.Equal(t, codes.FailedPrecondition, st.Code())
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if d, ok := d.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	require.NotNil(t, info)
+	require.Equal(t, "RESTORE_CONFLICT", info.Reason)
+	require.Equal(t, "5", info.Metadata["next_offset"])
+	highest, err := dstCfg.CommitLog.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), highest)
+}
+
+func TestRestoreCanceled(t *testing.T) {
+	client, _, cfg, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Restore(ctx)
+	require.NoError(t, err)
+	for i := uint64(0); i < 2; i++ {
+		require.NoError(t, stream.Send(&api_v1.RestoreRequest{
+			Record: &api_v1.Record{Value: []byte("hello world"), Offset: i},
+		}))
+	}
+	require.Eventually(t, func() bool {
+		highest, err := cfg.CommitLog.HighestOffset()
+		return err == nil && highest == 1
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+
+	// 끊기 전에 받은 레코드는 온전히 남고 로그는 이어서 쓸 수 있다.
+	for off := uint64(0); off < 2; off++ {
+		record, err := cfg.CommitLog.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello world"), record.Value)
+	}
+	res, err := client.Produce(context.Background(), &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("after restore")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.Offset)
+}
+
+func TestRestoreWithGaps(t *testing.T) {
+	client, _, cfg, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx := context.Background()
+	restore := func(offsets ...uint64) (*api_v1.RestoreResponse, error) {
+		stream, err := client.Restore(ctx)
+		require.NoError(t, err)
+		for _, off := range offsets {
+			err := stream.Send(&api_v1.RestoreRequest{
+				Record: &api_v1.Record{Value: []byte(fmt.Sprintf("record %d", off)), Offset: off},
+			})
+			if err != nil {
+				break
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+
+	// 잘렸거나 압축된 로그의 백업은 오프셋이 3부터 시작하고 중간이 비어 있다.
+	res, err := restore(3, 4, 7, 9)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), res.Restored)
+	for _, off := range []uint64{3, 4, 7, 9} {
+		record, err := cfg.CommitLog.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", off), string(record.Value))
+	}
+	for _, off := range []uint64{0, 5, 8} {
+		_, err := cfg.CommitLog.Read(off)
+		require.IsType(t, api_v1.ErrOffsetOutOfRange{}, err)
+	}
+	produced, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("after restore")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), produced.Offset)
+}
+
+func TestRestoreOffsetGoesBack(t *testing.T) {
+	client, _, cfg, teardown := setupTest(t, nil)
+	defer teardown()
+
+	stream, err := client.Restore(context.Background())
+	require.NoError(t, err)
+	for _, off := range []uint64{0, 2, 1} {
+		err := stream.Send(&api_v1.RestoreRequest{
+			Record: &api_v1.Record{Value: []byte("hello world"), Offset: off},
+		})
+		if err != nil {
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Equal(t, api_v1.ReasonRestoreConflict, api_v1.ErrorReason(err))
+	highest, err := cfg.CommitLog.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+}
+
+func TestRecordCodec(t *testing.T) {
+	client, _, cfg, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx := context.Background()
+	value := bytes.Repeat([]byte("hello world "), 1000)
+	for _, codec := range []api_v1.Codec{api_v1.Codec_NONE, api_v1.Codec_GZIP, api_v1.Codec_ZSTD} {
+		t.Run(codec.String(), func(t *testing.T) {
+			produce, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record: &api_v1.Record{Value: value, Codec: codec},
+			})
+			require.NoError(t, err)
+
+			// 저장된 레코드는 코덱과 압축한 값을 갖는다.
+			stored, err := cfg.CommitLog.Read(produce.Offset)
+			require.NoError(t, err)
+			require.Equal(t, codec, stored.Codec)
+			if codec == api_v1.Codec_NONE {
+				require.Equal(t, value, stored.Value)
+			} else {
+				require.Less(t, len(stored.Value), len(value)/10)
+			}
+
+			consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: produce.Offset})
+			require.NoError(t, err)
+			require.Equal(t, value, consume.Record.Value)
+			require.Equal(t, api_v1.Codec_NONE, consume.Record.Codec)
+
+			many, err := client.ConsumeMany(ctx, &api_v1.ConsumeManyRequest{Offsets: []uint64{produce.Offset}})
+			require.NoError(t, err)
+			require.Equal(t, value, many.Results[0].Record.Value)
+		})
+	}
+
+	_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: value, Codec: api_v1.Codec(42)},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "unknown compression codec")
+}
+
+func TestConsumeRange(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		// 세그먼트마다 레코드 세 개가 들어가도록 인덱스를 작게 잡는다.
+		lc := log.Config{}
+		lc.Segment.MaxIndexBytes = 3 * 12
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	// 세그먼트는 [0, 2], [3, 5], [6]이다.
+	for i := 0; i < 7; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	for _, tc := range []struct {
+		offset, max uint64
+		want        []uint64
+	}{
+		{offset: 1, max: 4, want: []uint64{1, 2, 3, 4}},
+		{offset: 4, max: 10, want: []uint64{4, 5, 6}},
+		{offset: 6, max: 1, want: []uint64{6}},
+		{offset: 7, max: 10, want: nil},
+		{offset: 0, max: 0, want: nil},
+	} {
+		res, err := client.ConsumeRange(ctx, &api_v1.ConsumeRangeRequest{Offset: tc.offset, MaxRecords: tc.max})
+		require.NoError(t, err)
+		var got []uint64
+		for _, record := range res.Records {
+			require.Equal(t, fmt.Sprintf("record %d", record.Offset), string(record.Value))
+			got = append(got, record.Offset)
+		}
+		require.Equal(t, tc.want, got, "offset %d, max %d", tc.offset, tc.max)
+	}
+
+	_, err := nobody.ConsumeRange(ctx, &api_v1.ConsumeRangeRequest{MaxRecords: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGetBounds(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		// 세그먼트마다 레코드 세 개가 들어가도록 인덱스를 작게 잡는다.
+		lc := log.Config{}
+		lc.Segment.MaxIndexBytes = 3 * 12
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	bounds := func() *api_v1.GetBoundsResponse {
+		t.Helper()
+		res, err := client.GetBounds(ctx, &api_v1.GetBoundsRequest{})
+		require.NoError(t, err)
+		return res
+	}
+	require.True(t, proto.Equal(&api_v1.GetBoundsResponse{Empty: true}, bounds()))
+
+	_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(&api_v1.GetBoundsResponse{Lowest: 0, Highest: 0}, bounds()))
+
+	// 세그먼트는 [0, 2], [3, 5], [6, 8], [9]이다.
+	for i := 0; i < 9; i++ {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	require.True(t, proto.Equal(&api_v1.GetBoundsResponse{Lowest: 0, Highest: 9}, bounds()))
+
+	_, err = client.Truncate(ctx, &api_v1.TruncateRequest{Lowest: 6})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(&api_v1.GetBoundsResponse{Lowest: 6, Highest: 9}, bounds()))
+
+	_, err = nobody.GetBounds(ctx, &api_v1.GetBoundsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.JWTPublicKey = pub
+		c.JWTSubjectClaim = "client_id"
+	})
+	defer teardown()
+
+	sign := func(key ed25519.PrivateKey, exp time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"client_id": "root",
+			"exp":       exp.Unix(),
+		}).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	produce := func(client api_v1.LogClient, token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		return err
+	}
+
+	// nobody의 인증서로 연결해도 토큰의 구독자 root로 인증한다.
+	require.NoError(t, produce(nobody, sign(priv, time.Now().Add(time.Hour))))
+
+	err = produce(nobody, sign(priv, time.Now().Add(-time.Hour)))
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "expired")
+
+	err = produce(root, sign(otherPriv, time.Now().Add(time.Hour)))
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// 토큰이 없으면 인증서의 CommonName을 쓴다.
+	require.NoError(t, produce(root, ""))
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(nobody, "")))
+}
+
+func TestJWTWithoutClientCert(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:           config.ServerCertFile,
+		KeyFile:            config.ServerKeyFile,
+		CAFile:             config.CAFile,
+		Server:             true,
+		ClientCertOptional: true,
+	})
+	require.NoError(t, err)
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	srv, err := NewGRPCServer(&Config{
+		CommitLog:    clog,
+		Authorizer:   auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		JWTPublicKey: pub,
+	}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	// 클라이언트 인증서 없이 서버만 확인하고 연결한다.
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{CAFile: config.CAFile})
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api_v1.NewLogClient(conn)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"sub": "root",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(priv)
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
 
-// 	client = api_v1.NewLogClient(cc)
+	// 인증서도 토큰도 없으면 서버가 죽지 않고 거부한다.
+	_, err = client.Produce(context.Background(), &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
 
-// 	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
-// 		CertFile:      config.ServerCertFile,
-// 		KeyFile:       config.ServerKeyFile,
-// 		CAFile:        config.CAFile,
-// 		ServerAddress: l.Addr().String(),
-// 		Server:        true,
-// 	})
-// 	require.NoError(t, err)
-// 	serverCreds := credentials.NewTLS(serverTLSConfig)
+func TestConsumeSince(t *testing.T) {
+	client, nobody, cfg, teardown := setupTest(t, func(c *Config) {
+		// 세그먼트마다 레코드 세 개가 들어가도록 인덱스를 작게 잡는다.
+		lc := log.Config{}
+		lc.Segment.MaxIndexBytes = 3 * 12
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer teardown()
 
-// 	dir, err := os.MkdirTemp("", "server-test")
-// 	require.NoError(t, err)
+	// 시각을 정해 두려고 로그에 직접 쓴다. i번째 레코드의 시각은 base에서 i초 뒤다.
+	// 시각이 0이면 로그가 지금 시각을 넣으므로 0에서 시작하지 않는다.
+	base := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		_, err := cfg.CommitLog.Append(&api_v1.Record{
+			Value:     []byte(fmt.Sprintf("record %d", i)),
+			Timestamp: base.Add(time.Duration(i) * time.Second).UnixNano(),
+		})
+		require.NoError(t, err)
+	}
 
-// 	clog, err := log.NewLog(dir, log.Config{})
-// 	require.NoError(t, err)
+	ctx := context.Background()
+	res, err := client.ConsumeSince(ctx, &api_v1.ConsumeSinceRequest{
+		Since: base.Add(4500 * time.Millisecond).UnixNano(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), res.Record.Offset)
+	require.Equal(t, "record 5", string(res.Record.Value))
 
-// 	cfg = &Config{
-// 		CommitLog: clog,
-// 	}
-// 	if fn != nil {
-// 		fn(cfg)
-// 	}
-// 	server, err := NewGRPCServer(cfg, grpc.Creds(serverCreds))
-// 	require.NoError(t, err)
+	_, err = client.ConsumeSince(ctx, &api_v1.ConsumeSinceRequest{
+		Since: base.Add(time.Minute).UnixNano(),
+	})
+	require.Equal(t, status.Code(api_v1.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
 
-// 	go func() {
-// 		server.Serve(l)
-// 	}()
+	// Produce로 쓴 레코드는 클라이언트가 보낸 시각 대신 서버가 쓴 시각을 갖는다.
+	before := time.Now()
+	produce, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("now"), Timestamp: 1},
+	})
+	require.NoError(t, err)
+	res, err = client.ConsumeSince(ctx, &api_v1.ConsumeSinceRequest{Since: before.UnixNano()})
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset, res.Record.Offset)
+	require.GreaterOrEqual(t, res.Record.Timestamp, before.UnixNano())
 
-// 	return client, cfg, func() {
-// 		server.Stop()
-// 		cc.Close()
-// 		l.Close()
-// 	}
-// }
+	_, err = nobody.ConsumeSince(ctx, &api_v1.ConsumeSinceRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestMaxRecordSize(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		lc := log.Config{}
+		lc.Store.MaxRecordSize = 64
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	large := &api_v1.Record{Value: bytes.Repeat([]byte("x"), 100)}
+	_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: large})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{Record: large, DryRun: true})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Offset)
+
+	// 고정 크기 모드에서 크기가 다른 레코드도 레코드 때문에 거부한 것이다.
+	fixed, _, _, fixedTeardown := setupTest(t, func(c *Config) {
+		lc := log.Config{}
+		lc.Store.FixedRecordSize = 8
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer fixedTeardown()
+	_, err = fixed.Produce(ctx, &api_v1.ProduceRequest{Record: &api_v1.Record{Value: []byte("short")}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMaxRecvMsgSize(t *testing.T) {
+	// gRPC 기본 한도인 4MiB보다 큰 레코드다.
+	req := &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: bytes.Repeat([]byte("x"), 5<<20)},
+	}
+	ctx := context.Background()
+
+	client, _, _, teardown := setupTest(t, nil)
+	_, err := client.Produce(ctx, req)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	teardown()
+
+	client, _, _, teardown = setupTest(t, func(c *Config) {
+		c.MaxRecvMsgSize = 8 << 20
+		c.KeepaliveParams = keepalive.ServerParameters{MaxConnectionIdle: time.Minute}
+		c.KeepaliveEnforcement = keepalive.EnforcementPolicy{MinTime: time.Second, PermitWithoutStream: true}
+	})
+	defer teardown()
+	res, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: res.Offset}, grpc.MaxCallRecvMsgSize(8<<20))
+	require.NoError(t, err)
+	require.Equal(t, req.Record.Value, consume.Record.Value)
+}
+
+func TestHTTPGateway(t *testing.T) {
+	addr, _, teardown := setupServer(t, nil)
+	defer teardown()
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.RootClientCertFile,
+		KeyFile:  config.RootClientKeyFile,
+		CAFile:   config.CAFile,
+	})
+	require.NoError(t, err)
+	gateway, err := NewHTTPGateway(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	require.NoError(t, err)
+	srv := httptest.NewServer(gateway.Handler)
+	defer srv.Close()
+	defer gateway.Shutdown(context.Background())
+
+	res, err := http.Post(srv.URL+"/v1/records", "application/json",
+		strings.NewReader(`{"record": {"value": "aGVsbG8gd29ybGQ="}}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var produced struct {
+		Offset string `json:"offset"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&produced))
+	require.Equal(t, "0", produced.Offset)
+
+	res, err = http.Get(srv.URL + "/v1/records/0")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	var consumed struct {
+		Record struct {
+			Value  []byte `json:"value"`
+			Offset string `json:"offset"`
+		} `json:"record"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&consumed))
+	require.Equal(t, []byte("hello world"), consumed.Record.Value)
+	require.Equal(t, "0", consumed.Record.Offset)
+
+	res, err = http.Get(srv.URL + "/v1/records/1")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestProduceIdempotencyKey(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.IdempotencyCacheSize = 2
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	produce := func(key string) uint64 {
+		t.Helper()
+		res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record:         &api_v1.Record{Value: []byte("hello world")},
+			IdempotencyKey: key,
+		})
+		require.NoError(t, err)
+		return res.Offset
+	}
+
+	// 응답을 잃고 다시 보낸 요청은 쓰지 않고 처음 받은 오프셋을 받는다.
+	first := produce("a")
+	require.Equal(t, first, produce("a"))
+	bounds, err := client.GetBounds(ctx, &api_v1.GetBoundsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, first, bounds.Highest)
+
+	// 동시에 다시 보내도 한 번만 쓴다.
+	var wg sync.WaitGroup
+	offsets := make([]uint64, 8)
+	for i := range offsets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Produce(ctx, &api_v1.ProduceRequest{
+				Record:         &api_v1.Record{Value: []byte("hello world")},
+				IdempotencyKey: "b",
+			})
+			if assert.NoError(t, err) {
+				offsets[i] = res.Offset
+			}
+		}()
+	}
+	wg.Wait()
+	for _, off := range offsets {
+		require.Equal(t, first+1, off)
+	}
+	bounds, err = client.GetBounds(ctx, &api_v1.GetBoundsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, first+1, bounds.Highest)
+
+	// 키 없이 보내면 매번 쓴다.
+	require.Equal(t, first+2, produce(""))
+	require.Equal(t, first+3, produce(""))
+
+	// 키를 IdempotencyCacheSize개 넘게 쓰면 가장 오래된 키부터 잊는다.
+	require.Equal(t, first+4, produce("c"))
+	require.Equal(t, first+5, produce("a"))
+
+	// 권한은 그대로 확인한다.
+	_, err = nobody.Produce(ctx, &api_v1.ProduceRequest{
+		Record:         &api_v1.Record{Value: []byte("hello world")},
+		IdempotencyKey: "c",
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestInProcessClient(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	client, teardown, err := NewInProcessClient(
+		&Config{CommitLog: clog, Authorizer: allowAll{}},
+		grpc.MaxRecvMsgSize(1024),
+	)
+	require.NoError(t, err)
+	defer teardown()
+
+	ctx := context.Background()
+	produce, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+
+	// 서버 옵션이 적용되어 너무 큰 요청은 거부된다.
+	_, err = client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: bytes.Repeat([]byte("x"), 2048)},
+	})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	srv, err := NewServer(&Config{CommitLog: clog, Authorizer: allowAll{}})
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api_v1.NewLogClient(conn)
+
+	ctx := context.Background()
+	stream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&api_v1.ProduceRequest{Record: &api_v1.Record{Value: []byte("first")}}))
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Offset)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown() }()
+
+	// 새 RPC는 거부하지만 열려 있는 스트림은 끝날 때까지 받는다.
+	require.Eventually(t, func() bool {
+		_, err := client.GetBounds(ctx, &api_v1.GetBoundsRequest{})
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stream.Send(&api_v1.ProduceRequest{Record: &api_v1.Record{Value: []byte("second")}}))
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Offset)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before the stream finished: %v", err)
+	default:
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, <-shutdown)
+
+	// 닫힌 로그를 다시 열어도 스트림으로 쓴 레코드가 모두 있다.
+	clog, err = log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	record, err := clog.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), record.Value)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+
+	srv, err := NewServer(&Config{CommitLog: clog, Authorizer: allowAll{}})
+	require.NoError(t, err)
+	srv.ShutdownTimeout = 50 * time.Millisecond
+	go srv.Serve(l)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := api_v1.NewLogClient(conn)
+
+	// 로그 끝에서 기다리는 스트림은 스스로 끝나지 않으므로 타임아웃 뒤에 끊긴다.
+	stream, err := client.ConsumeStream(context.Background(), &api_v1.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = client.Produce(context.Background(), &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	require.ErrorIs(t, srv.Shutdown(), ErrShutdownTimeout)
+	_, err = stream.Recv()
+	require.Error(t, err)
+}
+
+func TestConsumeByKey(t *testing.T) {
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		lc := log.Config{}
+		lc.Segment.MaxIndexBytes = 3 * 12
+		lc.KeyMap.Enabled = true
+		clog, err := log.NewLog(t.TempDir(), lc)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Close() })
+		c.CommitLog = clog
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		for _, key := range []string{"alpha", "beta"} {
+			_, err := client.Produce(ctx, &api_v1.ProduceRequest{Record: &api_v1.Record{
+				Key:   []byte(key),
+				Value: []byte(fmt.Sprintf("%s %d", key, i)),
+			}})
+			require.NoError(t, err)
+		}
+	}
+
+	res, err := client.ConsumeByKey(ctx, &api_v1.ConsumeByKeyRequest{Key: []byte("alpha")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("alpha 4"), res.Record.Value)
+	require.Equal(t, uint64(8), res.Record.Offset)
+	res, err = client.ConsumeByKey(ctx, &api_v1.ConsumeByKeyRequest{Key: []byte("beta")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("beta 4"), res.Record.Value)
+
+	_, err = client.ConsumeByKey(ctx, &api_v1.ConsumeByKeyRequest{Key: []byte("gamma")})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, api_v1.ReasonKeyNotFound, api_v1.ErrorReason(err))
+
+	_, err = nobody.ConsumeByKey(ctx, &api_v1.ConsumeByKeyRequest{Key: []byte("alpha")})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestConsumerGroupOffsets(t *testing.T) {
+	dir := t.TempDir()
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.CommitLog = clog
+	})
+	ctx := context.Background()
+
+	fetch, err := root.FetchOffset(ctx, &api_v1.FetchOffsetRequest{Group: "billing"})
+	require.NoError(t, err)
+	require.False(t, fetch.Found)
+
+	_, err = root.CommitOffset(ctx, &api_v1.CommitOffsetRequest{Group: "billing", Offset: 7})
+	require.NoError(t, err)
+	_, err = root.CommitOffset(ctx, &api_v1.CommitOffsetRequest{Group: "billing", Offset: 9})
+	require.NoError(t, err)
+	_, err = root.CommitOffset(ctx, &api_v1.CommitOffsetRequest{Group: "audit", Offset: 3})
+	require.NoError(t, err)
+
+	_, err = root.CommitOffset(ctx, &api_v1.CommitOffsetRequest{Offset: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = nobody.CommitOffset(ctx, &api_v1.CommitOffsetRequest{Group: "billing", Offset: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobody.FetchOffset(ctx, &api_v1.FetchOffsetRequest{Group: "billing"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	teardown()
+	require.NoError(t, clog.Close())
+
+	// 같은 디렉터리로 로그와 서버를 다시 띄워도 커밋한 오프셋이 남는다.
+	clog, err = log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	root, _, _, teardown = setupTest(t, func(c *Config) {
+		c.CommitLog = clog
+	})
+	defer teardown()
+
+	for group, want := range map[string]uint64{"billing": 9, "audit": 3} {
+		fetch, err = root.FetchOffset(ctx, &api_v1.FetchOffsetRequest{Group: group})
+		require.NoError(t, err)
+		require.True(t, fetch.Found)
+		require.Equal(t, want, fetch.Offset)
+	}
+	fetch, err = root.FetchOffset(ctx, &api_v1.FetchOffsetRequest{Group: "unknown"})
+	require.NoError(t, err)
+	require.False(t, fetch.Found)
+}
+
+func TestStats(t *testing.T) {
+	root, nobody, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := root.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	for off := uint64(0); off < 2; off++ {
+		_, err := root.Consume(ctx, &api_v1.ConsumeRequest{Offset: off})
+		require.NoError(t, err)
+	}
+
+	stats, err := root.Stats(ctx, &api_v1.StatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), stats.Records)
+	require.Equal(t, uint64(1), stats.Segments)
+	require.Equal(t, uint64(0), stats.LowestOffset)
+	require.Equal(t, uint64(4), stats.HighestOffset)
+	require.Greater(t, stats.Bytes, uint64(5*len("hello world")))
+	require.Positive(t, stats.Uptime)
+	require.Equal(t, uint64(5), stats.Produced)
+	require.Equal(t, uint64(2), stats.Consumed)
+
+	_, err = nobody.Stats(ctx, &api_v1.StatsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestSubjectMapper(t *testing.T) {
+	// root와 nobody는 CommonName은 다르지만 OU가 같아 같은 역할이 된다.
+	root, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.SubjectMapper = RolesByOU(map[string]string{"Distributed Services": "operator"})
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	for name, client := range map[string]api_v1.LogClient{"root": root, "nobody": nobody} {
+		_, err := client.Produce(ctx, &api_v1.ProduceRequest{
+			Record: &api_v1.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err, name)
+
+		// root 이름으로 준 권한은 더 이상 받지 않는다.
+		_, err = client.Truncate(ctx, &api_v1.TruncateRequest{Lowest: 0})
+		require.Equal(t, codes.PermissionDenied, status.Code(err), name)
+		var subject string
+		for _, d := range status.Convert(err).Details() {
+			if info, ok := d.(*errdetails.ErrorInfo); ok {
+				subject = info.Metadata["subject"]
+			}
+		}
+		require.Equal(t, "operator", subject, name)
+	}
+}
+
+func TestSubjectMappers(t *testing.T) {
+	cert := func(cn string, ous []string, dnsNames ...string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:  pkix.Name{CommonName: cn, OrganizationalUnit: ous},
+			DNSNames: dnsNames,
+		}
+	}
+	mapper := FirstSubject(
+		RolesByOU(map[string]string{"ops": "operator"}),
+		RolesByDNSName(map[string]string{"billing.internal": "billing"}),
+		RolesByCommonName(CommonNameRole{Pattern: regexp.MustCompile(`^reader-\d+$`), Role: "reader"}),
+	)
+	for _, tc := range []struct {
+		cert *x509.Certificate
+		want string
+	}{
+		{cert("alice", []string{"dev", "ops"}), "operator"},
+		{cert("bob", nil, "api.internal", "billing.internal"), "billing"},
+		{cert("reader-7", nil), "reader"},
+		{cert("reader-x", []string{"dev"}), ""},
+	} {
+		require.Equal(t, tc.want, mapper(tc.cert), tc.cert.Subject.CommonName)
+	}
+
+	// 매퍼가 빈 문자열을 리턴하면 CommonName을 쓴다.
+	srv := &grpcServer{Config: &Config{SubjectMapper: mapper}}
+	require.Equal(t, "operator", srv.certSubject(cert("alice", []string{"ops"})))
+	require.Equal(t, "reader-x", srv.certSubject(cert("reader-x", nil)))
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.sock")
+
+	// 죽은 서버가 남긴 소켓 파일이 있어도 연다.
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	l, err := Listen("unix", path)
+	require.NoError(t, err)
+	defer l.Close()
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	server, err := NewGRPCServer(&Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	go server.Serve(l)
+	defer server.Stop()
+
+	// 받고 있는 소켓은 지우지 않는다.
+	_, err = Listen("unix", path)
+	require.ErrorIs(t, err, ErrSocketInUse)
+
+	// 유닉스 소켓에서도 mTLS로 구독자를 확인한다.
+	conn, client := newClient(t, "unix://"+path, config.RootClientCertFile, config.RootClientKeyFile)
+	defer conn.Close()
+	ctx := context.Background()
+	produce, err := client.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api_v1.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+
+	nobodyConn, nobody := newClient(t, "unix://"+path, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	defer nobodyConn.Close()
+	_, err = nobody.Produce(ctx, &api_v1.ProduceRequest{
+		Record: &api_v1.Record{Value: []byte("hello world")},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
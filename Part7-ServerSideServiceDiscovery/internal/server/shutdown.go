@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+var ErrShutdownTimeout = errors.New("in-flight RPCs did not finish before the shutdown timeout")
+
+// Server는 gRPC 서버와 그 서버가 쓰는 CommitLog를 묶어 함께 멈춘다.
+type Server struct {
+	*grpc.Server
+	CommitLog CommitLog
+	// ShutdownTimeout은 Shutdown이 진행 중인 RPC를 기다리는 시간이다.
+	// 0이면 defaultShutdownTimeout이다.
+	ShutdownTimeout time.Duration
+}
+
+// NewServer는 NewGRPCServer로 서버를 만들고 config.CommitLog와 묶는다.
+func NewServer(config *Config, grpcOpts ...grpc.ServerOption) (*Server, error) {
+	gsrv, err := NewGRPCServer(config, grpcOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{Server: gsrv, CommitLog: config.CommitLog}, nil
+}
+
+// Shutdown은 새 RPC를 받지 않고 진행 중인 Produce, Consume과 스트림이 끝나기를
+// ShutdownTimeout까지 기다린다. 그 안에 끝나지 않으면 남은 RPC를 끊고
+// ErrShutdownTimeout을 리턴한다. 어느 쪽이든 마지막에 CommitLog를 디스크에
+// 내리고 닫는다. 로그 끝에서 새 레코드를 기다리는 ConsumeStream은 클라이언트가
+// 끊기 전까지 끝나지 않으므로 타임아웃까지 기다리게 된다. TenantLog가 여는
+// 로그는 호출자가 닫는다.
+func (s *Server) Shutdown() error {
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	var err error
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		s.Stop()
+		<-stopped
+		err = ErrShutdownTimeout
+	}
+	return errors.Join(err, closeCommitLog(s.CommitLog))
+}
+
+// closeCommitLog는 clog가 Flusher와 io.Closer를 구현하면 차례로 부른다.
+func closeCommitLog(clog CommitLog) error {
+	if f, ok := clog.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	if c, ok := clog.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
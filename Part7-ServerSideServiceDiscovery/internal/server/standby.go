@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// Standby는 리더의 레코드를 복제로만 받다가 Promote로 리더가 되는 웜
+// 스탠바이다. Replicator는 LocalLog에 리더와 같은 오프셋으로 쓰고 레코드마다
+// 동기화하도록 설정해야 바로 승격할 수 있다.
+type Standby struct {
+	// Replicator는 리더에서 레코드를 받아 온다. Promote가 닫는다.
+	Replicator io.Closer
+	// Log는 복제한 레코드를 쓰는 로그다. Promote가 디스크에 동기화한다.
+	Log interface{ Sync() error }
+
+	mu       sync.Mutex
+	promoted atomic.Bool
+}
+
+// Promote는 복제를 멈추고 받은 레코드를 디스크에 동기화한 뒤 Produce를
+// 받기 시작한다. 새 레코드는 마지막으로 복제한 레코드 바로 다음 오프셋에
+// 쓰인다. 복제가 멈추기 전에 리더가 보내지 못한 레코드는 받지 않는다.
+// 이미 승격했으면 아무것도 하지 않는다.
+func (s *Standby) Promote() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.promoted.Load() {
+		return nil
+	}
+	// Close는 쓰고 있던 레코드를 마저 쓸 때까지 기다리므로 그 뒤로는
+	// 복제된 레코드와 새 레코드가 섞이지 않는다.
+	if err := s.Replicator.Close(); err != nil {
+		return err
+	}
+	if err := s.Log.Sync(); err != nil {
+		return err
+	}
+	s.promoted.Store(true)
+	return nil
+}
+
+// Promoted는 Promote가 끝났는지 리턴한다.
+func (s *Standby) Promoted() bool {
+	return s.promoted.Load()
+}
+
+// check는 승격하지 않은 스탠바이면 쓰기를 거부한다. s가 nil이면 스탠바이가 아니다.
+func (s *Standby) check() error {
+	if s == nil || s.Promoted() {
+		return nil
+	}
+	return api_v1.NewStatus(
+		codes.FailedPrecondition,
+		"server is a standby and does not accept writes until promoted",
+		api_v1.ReasonStandby,
+		nil,
+	).Err()
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatsReporter는 로그를 훑지 않고 세어 둔 통계를 준다. CommitLog가 구현하지
+// 않으면 Stats는 Unimplemented로 실패한다.
+type StatsReporter interface {
+	Stats() (log.LogStats, error)
+}
+
+// Stats는 토픽 로그의 통계와 서버가 뜬 뒤의 시간, 쓰고 읽은 레코드 수를
+// 돌려준다. 모두 세어 둔 값이라 로그 크기와 상관없이 싸다.
+func (s *grpcServer) Stats(ctx context.Context, req *api_v1.StatsRequest) (*api_v1.StatsResponse, error) {
+	clog, err := s.authorize(ctx, req.Topic, statsAction)
+	if err != nil {
+		return nil, err
+	}
+	reporter, ok := clog.(StatsReporter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "log does not support stats")
+	}
+	stats, err := reporter.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return &api_v1.StatsResponse{
+		Records:       stats.Records,
+		Bytes:         stats.Bytes,
+		Segments:      uint64(stats.Segments),
+		LowestOffset:  stats.LowestOffset,
+		HighestOffset: stats.HighestOffset,
+		Uptime:        int64(time.Since(s.started)),
+		Produced:      s.produced.Load(),
+		Consumed:      s.consumed.Load(),
+	}, nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"go.opencensus.io/metric"
+	"go.opencensus.io/metric/metricdata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics는 서버가 직접 계산해서 내보내는 지표다. NewGRPCServer가
+// OpenCensus의 전역 프로듀서로 등록한다.
+var Metrics = metric.NewRegistry()
+
+// consumerLag는 구독자(subject)별로 ConsumeStream이 로그 끝에서 몇 레코드
+// 뒤처져 있는지다. 같은 구독자의 스트림이 여럿이면 가장 뒤처진 스트림의 값이다.
+// 읽을 때마다 로그 끝을 다시 보므로 멈춘 스트림도 뒤처지는 만큼 늘어난다.
+var consumerLag, _ = Metrics.AddInt64DerivedGauge(
+	"proglog/server/consumer_lag",
+	metric.WithDescription("Records between the log end and a consumer stream's next offset"),
+	metric.WithUnit(metricdata.UnitDimensionless),
+	metric.WithLabelKeys("subject"),
+)
+
+// streamRegistry는 서버에 지금 열려 있는 ConsumeStream들을 구독자별로 모아 둔다.
+type streamRegistry struct {
+	mu        sync.Mutex
+	bySubject map[string]map[*stream]struct{}
+	// limit이 0보다 크면 구독자 하나가 동시에 열 수 있는 스트림 수다.
+	limit int
+}
+
+func newStreamRegistry(limit int) *streamRegistry {
+	return &streamRegistry{
+		bySubject: make(map[string]map[*stream]struct{}),
+		limit:     limit,
+	}
+}
+
+// stream은 ConsumeStream 하나가 읽는 로그와 다음에 보낼 오프셋이다.
+type stream struct {
+	clog CommitLog
+	next atomic.Uint64
+}
+
+// add는 스트림을 등록한다. 구독자가 이미 limit만큼 스트림을 열었으면
+// ResourceExhausted로 거부한다.
+func (r *streamRegistry) add(subject string, clog CommitLog, next uint64) (*stream, error) {
+	st := &stream{clog: clog}
+	st.next.Store(next)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limit > 0 && len(r.bySubject[subject]) >= r.limit {
+		return nil, status.Errorf(
+			codes.ResourceExhausted,
+			"%s already has %d open consume streams", subject, r.limit,
+		)
+	}
+	if r.bySubject[subject] == nil {
+		r.bySubject[subject] = make(map[*stream]struct{})
+		consumerLag.UpsertEntry(func() int64 {
+			return r.lag(subject)
+		}, metricdata.NewLabelValue(subject))
+	}
+	r.bySubject[subject][st] = struct{}{}
+	return st, nil
+}
+
+func (r *streamRegistry) remove(subject string, st *stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.bySubject[subject], st)
+}
+
+func (r *streamRegistry) lag(subject string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var lag int64
+	for st := range r.bySubject[subject] {
+		end, err := startOffset(st.clog, &api_v1.ConsumeRequest{
+			StartPosition: api_v1.StartPosition_LATEST,
+		})
+		if err != nil {
+			continue
+		}
+		if next := st.next.Load(); end > next {
+			lag = max(lag, int64(end-next))
+		}
+	}
+	return lag
+}
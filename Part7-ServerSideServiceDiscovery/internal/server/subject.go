@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/x509"
+	"regexp"
+)
+
+// SubjectMapper는 클라이언트 인증서로 구독자를 정한다. ACL은 이 구독자로
+// 권한을 확인하므로 인증서 이름 대신 역할을 리턴하면 정책에 역할만 적으면
+// 된다. 빈 문자열을 리턴하면 인증서의 CommonName을 쓴다.
+type SubjectMapper func(*x509.Certificate) string
+
+// RolesByOU는 인증서의 OU 중 roles에 있는 첫 OU의 역할을 리턴한다.
+func RolesByOU(roles map[string]string) SubjectMapper {
+	return func(cert *x509.Certificate) string {
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if role, ok := roles[ou]; ok {
+				return role
+			}
+		}
+		return ""
+	}
+}
+
+// RolesByDNSName은 인증서의 DNS SAN 중 roles에 있는 첫 이름의 역할을 리턴한다.
+func RolesByDNSName(roles map[string]string) SubjectMapper {
+	return func(cert *x509.Certificate) string {
+		for _, name := range cert.DNSNames {
+			if role, ok := roles[name]; ok {
+				return role
+			}
+		}
+		return ""
+	}
+}
+
+// CommonNameRole은 CommonName이 Pattern에 맞는 인증서에 Role을 준다.
+type CommonNameRole struct {
+	Pattern *regexp.Regexp
+	Role    string
+}
+
+// RolesByCommonName은 CommonName이 맞는 첫 규칙의 역할을 리턴한다.
+func RolesByCommonName(rules ...CommonNameRole) SubjectMapper {
+	return func(cert *x509.Certificate) string {
+		for _, r := range rules {
+			if r.Pattern.MatchString(cert.Subject.CommonName) {
+				return r.Role
+			}
+		}
+		return ""
+	}
+}
+
+// FirstSubject는 mappers를 차례로 불러 처음으로 빈 문자열이 아닌 구독자를
+// 리턴한다. 예를 들어 OU로 역할을 정하고 OU가 없으면 CommonName으로 정할 때 쓴다.
+func FirstSubject(mappers ...SubjectMapper) SubjectMapper {
+	return func(cert *x509.Certificate) string {
+		for _, m := range mappers {
+			if subject := m(cert); subject != "" {
+				return subject
+			}
+		}
+		return ""
+	}
+}
+
+// certSubject는 cert의 구독자다. SubjectMapper가 없거나 빈 문자열을 리턴하면
+// CommonName이다.
+func (s *grpcServer) certSubject(cert *x509.Certificate) string {
+	if s.SubjectMapper != nil {
+		if subject := s.SubjectMapper(cert); subject != "" {
+			return subject
+		}
+	}
+	return cert.Subject.CommonName
+}
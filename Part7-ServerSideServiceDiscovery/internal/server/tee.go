@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// TeePolicy는 TeeCommitLog의 보조 로그 쓰기가 실패했을 때 어떻게 할지 정한다.
+type TeePolicy int
+
+const (
+	// TeeStrict이면 보조 로그 쓰기가 실패할 때 Append도 실패한다.
+	// 이미 주 로그에 쓴 레코드는 되돌리지 않는다.
+	TeeStrict TeePolicy = iota
+	// TeeLogAndContinue이면 실패를 로그로 남기고 주 로그의 결과를 리턴한다.
+	TeeLogAndContinue
+)
+
+var _ CommitLog = (*TeeCommitLog)(nil)
+
+// TeeCommitLog는 주 로그에 추가한 레코드를 Produce가 리턴하기 전에 보조 로그에도
+// 동기적으로 추가한다. 읽기와 오프셋은 주 로그를 따른다.
+type TeeCommitLog struct {
+	CommitLog
+	Secondary CommitLog
+	Policy    TeePolicy
+}
+
+func (t *TeeCommitLog) Append(record *api_v1.Record) (uint64, error) {
+	return t.AppendContext(context.Background(), record)
+}
+
+// AppendContext는 두 로그에 모두 ctx를 넘긴다. 보조 로그 쓰기가 ctx 때문에
+// 끝나도 Policy를 따른다.
+func (t *TeeCommitLog) AppendContext(ctx context.Context, record *api_v1.Record) (uint64, error) {
+	off, err := t.CommitLog.AppendContext(ctx, record)
+	if err != nil {
+		return 0, err
+	}
+	// 보조 로그가 레코드의 오프셋을 바꾸지 않도록 복사본을 넘긴다.
+	if _, err := t.Secondary.AppendContext(ctx, proto.Clone(record).(*api_v1.Record)); err != nil {
+		if t.Policy == TeeStrict {
+			return 0, fmt.Errorf("tee to secondary log: %w", err)
+		}
+		zap.L().Named("tee").Error(
+			"failed to append to secondary log",
+			zap.Uint64("offset", off),
+			zap.Error(err),
+		)
+	}
+	return off, nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const tracerName = "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/internal/server"
+
+// traceContext는 요청 메타데이터의 W3C traceparent 헤더에서 호출자의 트레이스를 읽는다.
+var traceContext = propagation.TraceContext{}
+
+// metadataCarrier는 gRPC 메타데이터를 OpenTelemetry 전파기가 읽을 수 있게 한다.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if vs := metadata.MD(c).Get(key); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startRPC는 호출자의 트레이스를 이어 method의 서버 스팬을 시작한다.
+func (s *grpcServer) startRPC(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = traceContext.Extract(ctx, metadataCarrier(md))
+	return s.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.method", method),
+		),
+	)
+}
+
+// endSpan은 err를 스팬에 남기고 스팬을 끝낸다.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+func (s *grpcServer) traceUnaryInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	if !s.observed(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	ctx, span := s.startRPC(ctx, info.FullMethod)
+	res, err := handler(ctx, req)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(status.Code(err))))
+	endSpan(span, err)
+	return res, err
+}
+
+func (s *grpcServer) traceStreamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if !s.observed(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	ctx, span := s.startRPC(ss.Context(), info.FullMethod)
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+	err := handler(srv, wrapped)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(status.Code(err))))
+	endSpan(span, err)
+	return err
+}
+
+// appendLog는 record를 clog에 쓰면서 log.Append 스팬을 남기고 Registry가
+// 있으면 걸린 시간과 쓴 바이트를 센다. 로그가 레코드를 거부한 에러는
+// rejectedRecord로, 나머지 실패는 appendFailed로 바꾼다.
+func (s *grpcServer) appendLog(ctx context.Context, clog CommitLog, record *api_v1.Record) (uint64, error) {
+	// Append가 레코드에 오프셋을 넣으므로 클라이언트가 보낸 크기를 먼저 잰다.
+	size := proto.Size(record)
+	_, span := s.tracer.Start(ctx, "log.Append",
+		trace.WithAttributes(attribute.Int("log.record_size", size)))
+	start := time.Now()
+	off, err := clog.AppendContext(ctx, record)
+	err = appendFailed(ctx, rejectedRecord(err), size)
+	s.prom.observe("append", start)
+	if err == nil {
+		s.prom.appended(size)
+		s.produced.Add(1)
+		traceOffset(span, clog, off)
+	}
+	endSpan(span, err)
+	return off, err
+}
+
+// readLog는 clog에서 offset 레코드를 읽으면서 log.Read 스팬을 남기고
+// Registry가 있으면 걸린 시간을 센다.
+func (s *grpcServer) readLog(ctx context.Context, clog CommitLog, offset uint64) (*api_v1.Record, error) {
+	_, span := s.tracer.Start(ctx, "log.Read")
+	start := time.Now()
+	var record *api_v1.Record
+	var err error
+	if cl, ok := clog.(ContextLog); ok {
+		record, err = cl.ReadContext(ctx, offset)
+	} else {
+		record, err = clog.Read(offset)
+	}
+	s.prom.observe("read", start)
+	traceOffset(span, clog, offset)
+	if err == nil {
+		span.SetAttributes(attribute.Int("log.record_size", proto.Size(record)))
+	}
+	endSpan(span, err)
+	return record, err
+}
+
+// traceOffset은 스팬에 오프셋과, clog가 SegmentInfoer면 오프셋이 든 세그먼트의
+// 베이스 오프셋을 남긴다. 기록하지 않는 스팬이면 세그먼트를 찾지 않는다.
+func traceOffset(span trace.Span, clog CommitLog, offset uint64) {
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(attribute.Int64("log.offset", int64(offset)))
+	if si, ok := clog.(SegmentInfoer); ok {
+		if base, _, err := si.SegmentInfo(offset); err == nil {
+			span.SetAttributes(attribute.Int64("log.segment_base_offset", int64(base)))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"slices"
+
+	api_v1 "github.com/distributed_service_go/Part7-ServerSideServiceDiscovery/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// checkAPIVersion은 요청의 x-api-version 헤더가 서버가 지원하는 버전인지
+// 확인한다. 헤더가 없으면 api_v1.APIVersion으로 본다.
+func (s *grpcServer) checkAPIVersion(ctx context.Context) error {
+	version := api_v1.APIVersion
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(api_v1.APIVersionHeader); len(v) > 0 {
+			version = v[0]
+		}
+	}
+	if !slices.Contains(s.APIVersions, version) {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"unsupported API version %q, supported versions: %v",
+			version, s.APIVersions,
+		)
+	}
+	return nil
+}
+
+func (s *grpcServer) versionUnaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := s.checkAPIVersion(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) versionStreamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := s.checkAPIVersion(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}